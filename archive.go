@@ -0,0 +1,363 @@
+/*
+ * Copyright 2024 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/0xEggTart/badger/y"
+)
+
+const (
+	// archiveManifestName is the name of the last entry of an archive. It lists the SHA-256
+	// checksum, the size and the name of every other entry, one per line.
+	archiveManifestName = "ARCHIVE"
+	// archiveBlobDir is the directory holding the blob log, in an archive and in the DB directory
+	// restored from it.
+	archiveBlobDir = "blob"
+)
+
+// archiveFile is a file of the DB which goes into an archive.
+type archiveFile struct {
+	name string // Name of the entry in the archive.
+	path string
+	size int64 // Number of bytes to copy, -1 for the whole file.
+}
+
+// ExportArchive writes a consistent snapshot of the files of the DB (tables, value logs, memtable
+// logs, manifest, key registry and discard stats) to w, as a single tar archive. ImportArchive
+// turns the archive back into a DB directory, with the exact same files, so all the versions of
+// the keys are preserved, unlike with Backup.
+//
+// Writes, memtable flushes, compactions and value log GC are paused while the archive is written.
+// Writes block until ExportArchive returns, and RunValueLogGC returns ErrRejected meanwhile.
+func (db *DB) ExportArchive(w io.Writer) error {
+	if db.opt.InMemory {
+		return errors.New("Cannot export an archive in InMemory mode")
+	}
+	if db.IsClosed() {
+		return ErrDBClosed
+	}
+	if !db.opt.ReadOnly {
+		resume, err := db.pauseForArchive()
+		if err != nil {
+			return err
+		}
+		defer resume()
+	}
+	files, err := db.archiveFiles()
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	var manifest bytes.Buffer
+	for _, f := range files {
+		sum, size, err := writeArchiveFile(tw, f)
+		if os.IsNotExist(err) {
+			// The file was deleted after being listed, so it isn't part of the DB.
+			continue
+		}
+		if err != nil {
+			return y.Wrapf(err, "while archiving %s", f.path)
+		}
+		fmt.Fprintf(&manifest, "%x %d %s\n", sum, size, f.name)
+	}
+	hdr := &tar.Header{
+		Name: archiveManifestName,
+		Mode: 0644,
+		Size: int64(manifest.Len()),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := tw.Write(manifest.Bytes()); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// pauseForArchive waits for the running value log GC and the pending writes and memtable flushes
+// to finish, and stops compactions. The returned function resumes them.
+func (db *DB) pauseForArchive() (func(), error) {
+	db.vlog.garbageCh <- struct{}{}
+	if db.blob != nil {
+		db.blob.garbageCh <- struct{}{}
+	}
+	resumeGC := func() {
+		if db.blob != nil {
+			<-db.blob.garbageCh
+		}
+		<-db.vlog.garbageCh
+	}
+	resumeWrites, err := db.prepareToDrop()
+	if err != nil {
+		resumeGC()
+		return nil, err
+	}
+	db.stopCompactions()
+	return func() {
+		db.startCompactions()
+		resumeWrites()
+		resumeGC()
+	}, nil
+}
+
+// archiveFiles lists the files to archive. Tables, value logs and memtable logs are taken from the
+// DB, since their directories may also hold files that are waiting to be deleted. The value log
+// and the memtable log being written to are cut at the end of their data, as they are
+// preallocated.
+func (db *DB) archiveFiles() ([]archiveFile, error) {
+	live := make(map[string]int64)
+	for _, l := range db.lc.levels {
+		l.RLock()
+		for _, t := range l.tables {
+			live[t.Filename()] = -1
+		}
+		l.RUnlock()
+	}
+	db.lock.RLock()
+	mts := append([]*memTable{db.mt}, db.imm...)
+	for _, mt := range mts {
+		if mt == nil || mt.wal == nil {
+			continue
+		}
+		live[mt.wal.path] = -1
+		if mt == db.mt && !db.opt.ReadOnly {
+			live[mt.wal.path] = int64(mt.wal.writeAt)
+		}
+	}
+	db.lock.RUnlock()
+	vlogs := []*valueLog{&db.vlog}
+	if db.blob != nil {
+		vlogs = append(vlogs, db.blob)
+	}
+	for _, vlog := range vlogs {
+		vlog.filesLock.RLock()
+		for fid, lf := range vlog.filesMap {
+			live[lf.path] = -1
+			if fid == vlog.maxFid && !db.opt.ReadOnly {
+				live[lf.path] = int64(vlog.woffset())
+			}
+		}
+		vlog.filesLock.RUnlock()
+	}
+
+	var files []archiveFile
+	addDir := func(dir, prefix string) error {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if !e.Type().IsRegular() {
+				continue
+			}
+			name := e.Name()
+			p := filepath.Join(dir, name)
+			size := int64(-1)
+			switch filepath.Ext(name) {
+			case ".sst", ".vlog", memFileExt:
+				var ok bool
+				if size, ok = live[p]; !ok {
+					continue
+				}
+			default:
+				switch name {
+				case lockFile, manifestRewriteFilename, KeyRegistryRewriteFileName:
+					continue
+				}
+			}
+			files = append(files, archiveFile{name: path.Join(prefix, name), path: p, size: size})
+		}
+		return nil
+	}
+	if err := addDir(db.opt.Dir, ""); err != nil {
+		return nil, err
+	}
+	if db.opt.ValueDir != db.opt.Dir {
+		if err := addDir(db.opt.ValueDir, ""); err != nil {
+			return nil, err
+		}
+	}
+	if db.blob != nil {
+		if err := addDir(db.opt.BlobDir, archiveBlobDir); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// writeArchiveFile writes f to tw, and returns its checksum and size.
+func writeArchiveFile(tw *tar.Writer, f archiveFile) ([]byte, int64, error) {
+	fd, err := os.Open(f.path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer fd.Close()
+	fi, err := fd.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	size := fi.Size()
+	if f.size >= 0 && f.size < size {
+		size = f.size
+	}
+	hdr := &tar.Header{
+		Name:    f.name,
+		Mode:    0644,
+		Size:    size,
+		ModTime: fi.ModTime(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return nil, 0, err
+	}
+	h := sha256.New()
+	if _, err := io.CopyN(io.MultiWriter(tw, h), fd, size); err != nil {
+		return nil, 0, err
+	}
+	return h.Sum(nil), size, nil
+}
+
+// ImportArchive restores the archive written by DB.ExportArchive into dir, which must be empty or
+// not exist. The checksums of the archive are verified, and ErrInvalidArchive is returned if they
+// don't match, in which case the restored files are removed. The restored DB is opened with dir as
+// both Dir and ValueDir. If the exported DB had a blob log, it is restored into the "blob"
+// subdirectory of dir, which must then be passed to Options.WithBlobDir.
+func ImportArchive(r io.Reader, dir string) (rerr error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return errors.Errorf("Cannot import an archive into %s, which is not empty", dir)
+	}
+	defer func() {
+		if rerr == nil {
+			return
+		}
+		// dir was empty, so everything in it comes from the archive.
+		entries, _ := os.ReadDir(dir)
+		for _, e := range entries {
+			_ = os.RemoveAll(filepath.Join(dir, e.Name()))
+		}
+	}()
+
+	sums := make(map[string]string)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return errors.Wrapf(ErrInvalidArchive, "no %s entry", archiveManifestName)
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Name == archiveManifestName {
+			return verifyArchive(tr, sums)
+		}
+		if _, ok := sums[hdr.Name]; ok || !validArchiveName(hdr.Name) ||
+			hdr.Typeflag != tar.TypeReg {
+			return errors.Wrapf(ErrInvalidArchive, "unexpected entry %q", hdr.Name)
+		}
+		sum, err := extractArchiveFile(tr, hdr, dir)
+		if err != nil {
+			return err
+		}
+		sums[hdr.Name] = fmt.Sprintf("%x %d", sum, hdr.Size)
+	}
+}
+
+// validArchiveName tells whether name is a file of the DB directory, or of its blob directory.
+func validArchiveName(name string) bool {
+	dir, file := path.Split(name)
+	if dir != "" && dir != archiveBlobDir+"/" {
+		return false
+	}
+	return file != "" && file != "." && file != ".." && !strings.ContainsRune(file, '\\')
+}
+
+// extractArchiveFile writes the entry of tr described by hdr to dir, and returns its checksum.
+func extractArchiveFile(tr *tar.Reader, hdr *tar.Header, dir string) ([]byte, error) {
+	p := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return nil, err
+	}
+	fd, err := os.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(fd, h), tr); err != nil {
+		fd.Close()
+		return nil, err
+	}
+	if err := fd.Sync(); err != nil {
+		fd.Close()
+		return nil, err
+	}
+	return h.Sum(nil), fd.Close()
+}
+
+// verifyArchive checks that the manifest read from r lists exactly the entries in sums, with the
+// same checksums and sizes. The entries are removed from sums as they are checked.
+func verifyArchive(r io.Reader, sums map[string]string) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), " ", 3)
+		if len(parts) != 3 {
+			return errors.Wrapf(ErrInvalidArchive, "bad manifest line %q", scanner.Text())
+		}
+		if _, err := hex.DecodeString(parts[0]); err != nil {
+			return errors.Wrapf(ErrInvalidArchive, "bad checksum for %s", parts[2])
+		}
+		if _, err := strconv.ParseInt(parts[1], 10, 64); err != nil {
+			return errors.Wrapf(ErrInvalidArchive, "bad size for %s", parts[2])
+		}
+		sum, ok := sums[parts[2]]
+		if !ok {
+			return errors.Wrapf(ErrInvalidArchive, "missing entry %s", parts[2])
+		}
+		if sum != parts[0]+" "+parts[1] {
+			return errors.Wrapf(ErrInvalidArchive, "checksum mismatch for %s", parts[2])
+		}
+		delete(sums, parts[2])
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(sums) > 0 {
+		return errors.Wrapf(ErrInvalidArchive, "%d entries aren't in the manifest", len(sums))
+	}
+	return nil
+}
@@ -20,6 +20,9 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
 	"encoding/binary"
 	"io"
 
@@ -37,6 +40,45 @@ import (
 // than the maxBatchSize.
 const flushThreshold = 100 << 20
 
+// BackupOptions configures how a backup is written by BackupWithOptions and read back by
+// LoadWithOptions.
+type BackupOptions struct {
+	// EncryptionKey, if set, encrypts every record of the backup stream with AES-GCM. It is
+	// independent of the key the database is encrypted with, and the same key must be passed to
+	// LoadWithOptions to restore the backup. Its length must be 16, 24 or 32 bytes, selecting
+	// AES-128, AES-192 or AES-256. Without a key the backup is written in plain text.
+	//
+	// An encrypted backup starts with a header holding its format version, and ends with an
+	// authenticated end record, so LoadWithOptions rejects a backup which was cut short.
+	EncryptionKey []byte
+}
+
+// encryptedBackupHeader starts the encrypted backups: a magic, the format version, and the nonce
+// scheme, which is a random nonce stored in front of every record. It is authenticated along with
+// every record.
+var encryptedBackupHeader = []byte{'B', 'D', 'G', 'B', 'A', 'K', 'E',
+	1, // Format version.
+	1, // Nonce scheme: random, prepended.
+}
+
+// aead returns the cipher used to seal backup records, or nil if no key is set.
+func (opt BackupOptions) aead() (cipher.AEAD, error) {
+	if len(opt.EncryptionKey) == 0 {
+		return nil, nil
+	}
+	switch len(opt.EncryptionKey) {
+	case 16, 24, 32:
+	default:
+		return nil, errors.Wrapf(ErrInvalidEncryptionKey, "backup key of length %d",
+			len(opt.EncryptionKey))
+	}
+	block, err := aes.NewCipher(opt.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
 // Backup dumps a protobuf-encoded list of all entries in the database into the
 // given writer, that are newer than or equal to the specified version. It
 // returns a timestamp (version) indicating the version of last entry that is
@@ -48,10 +90,15 @@ const flushThreshold = 100 << 20
 // used to generate the backup, or if you wish to backup only a certain range
 // of keys, use Stream.Backup directly.
 func (db *DB) Backup(w io.Writer, since uint64) (uint64, error) {
+	return db.BackupWithOptions(w, since, BackupOptions{})
+}
+
+// BackupWithOptions is like DB.Backup, but writes the backup as configured by opt.
+func (db *DB) BackupWithOptions(w io.Writer, since uint64, opt BackupOptions) (uint64, error) {
 	stream := db.NewStream()
 	stream.LogPrefix = "DB.Backup"
 	stream.SinceTs = since
-	return stream.Backup(w, since)
+	return stream.BackupWithOptions(w, since, opt)
 }
 
 // Backup dumps a protobuf-encoded list of all entries in the database into the
@@ -63,6 +110,17 @@ func (db *DB) Backup(w io.Writer, since uint64) (uint64, error) {
 //
 // This can be used to backup the data in a database at a given point in time.
 func (stream *Stream) Backup(w io.Writer, since uint64) (uint64, error) {
+	return stream.BackupWithOptions(w, since, BackupOptions{})
+}
+
+// BackupWithOptions is like Stream.Backup, but writes the backup as configured by opt.
+func (stream *Stream) BackupWithOptions(w io.Writer, since uint64, opt BackupOptions) (
+	uint64, error) {
+	aead, err := opt.aead()
+	if err != nil {
+		return 0, err
+	}
+
 	stream.KeyToList = func(key []byte, itr *Iterator) (*pb.KVList, error) {
 		list := &pb.KVList{}
 		a := itr.Alloc
@@ -96,7 +154,7 @@ func (stream *Stream) Backup(w io.Writer, since uint64) (uint64, error) {
 			*kv = pb.KV{
 				Key:       a.Copy(item.Key()),
 				Value:     valCopy,
-				UserMeta:  a.Copy([]byte{item.UserMeta()}),
+				UserMeta:  a.Copy(encodeUserMeta(item.userMeta, item.userMetaHigh)),
 				Version:   item.Version(),
 				ExpiresAt: item.ExpiresAt(),
 				Meta:      a.Copy([]byte{meta}),
@@ -121,7 +179,7 @@ func (stream *Stream) Backup(w io.Writer, since uint64) (uint64, error) {
 		return list, nil
 	}
 
-	var maxVersion uint64
+	var maxVersion, seq uint64
 	stream.Send = func(buf *z.Buffer) error {
 		list, err := BufferToKVList(buf)
 		if err != nil {
@@ -138,27 +196,65 @@ func (stream *Stream) Backup(w io.Writer, since uint64) (uint64, error) {
 			}
 		}
 		list.Kv = out
-		return writeTo(list, w)
+		seq++
+		return writeTo(list, w, aead, seq)
 	}
 
+	if aead != nil {
+		if _, err := w.Write(encryptedBackupHeader); err != nil {
+			return 0, err
+		}
+	}
 	if err := stream.Orchestrate(context.Background()); err != nil {
 		return 0, err
 	}
+	if aead != nil {
+		// The end record tells the loader that nothing was cut off.
+		seq++
+		if err := writeRecord(nil, w, aead, seq, true); err != nil {
+			return 0, err
+		}
+	}
 	return maxVersion, nil
 }
 
-func writeTo(list *pb.KVList, w io.Writer) error {
-	if err := binary.Write(w, binary.LittleEndian, uint64(proto.Size(list))); err != nil {
-		return err
-	}
+// writeTo writes list to w, prefixed with its length. If aead is set, the record is sealed with
+// a random nonce, which is stored in front of the ciphertext. The sequence number of the record
+// is authenticated too, so that records can't be reordered or dropped from within a backup.
+func writeTo(list *pb.KVList, w io.Writer, aead cipher.AEAD, seq uint64) error {
 	buf, err := proto.Marshal(list)
 	if err != nil {
 		return err
 	}
-	_, err = w.Write(buf)
+	return writeRecord(buf, w, aead, seq, false)
+}
+
+// writeRecord writes buf to w like writeTo. The end record of an encrypted backup has last set.
+func writeRecord(buf []byte, w io.Writer, aead cipher.AEAD, seq uint64, last bool) error {
+	if aead != nil {
+		nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(buf)+aead.Overhead())
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+		buf = aead.Seal(nonce, nonce, buf, backupRecordAD(seq, last))
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint64(len(buf))); err != nil {
+		return err
+	}
+	_, err := w.Write(buf)
 	return err
 }
 
+func backupRecordAD(seq uint64, last bool) []byte {
+	ad := make([]byte, len(encryptedBackupHeader)+9)
+	n := copy(ad, encryptedBackupHeader)
+	binary.BigEndian.PutUint64(ad[n:], seq)
+	if last {
+		ad[n+8] = 1
+	}
+	return ad
+}
+
 // KVLoader is used to write KVList objects in to badger. It can be used to restore a backup.
 type KVLoader struct {
 	db          *DB
@@ -179,9 +275,10 @@ func (db *DB) NewKVLoader(maxPendingWrites int) *KVLoader {
 
 // Set writes the key-value pair to the database.
 func (l *KVLoader) Set(kv *pb.KV) error {
-	var userMeta, meta byte
-	if len(kv.UserMeta) > 0 {
-		userMeta = kv.UserMeta[0]
+	var meta byte
+	userMeta, userMetaHigh := decodeUserMeta(kv.UserMeta)
+	if userMetaHigh != 0 && !l.db.opt.WideUserMeta {
+		return errors.Wrapf(ErrWideUserMeta, "key %q", kv.Key)
 	}
 	if len(kv.Meta) > 0 {
 		meta = kv.Meta[0]
@@ -192,8 +289,10 @@ func (l *KVLoader) Set(kv *pb.KV) error {
 		UserMeta:  userMeta,
 		ExpiresAt: kv.ExpiresAt,
 		meta:      meta,
+
+		userMetaHigh: userMetaHigh,
 	}
-	estimatedSize := e.estimateSizeAndSetThreshold(l.db.valueThreshold())
+	estimatedSize := e.estimateSizeAndSetThreshold(l.db.entryValueThreshold(e.Key))
 	// Flush entries if inserting the next entry would overflow the transactional limits.
 	if int64(len(l.entries))+1 >= l.db.opt.maxBatchCount ||
 		l.entriesSize+estimatedSize >= l.db.opt.maxBatchSize ||
@@ -242,18 +341,50 @@ func (l *KVLoader) Finish() error {
 // DB.Load() should be called on a database that is not running any other
 // concurrent transactions while it is running.
 func (db *DB) Load(r io.Reader, maxPendingWrites int) error {
+	return db.LoadWithOptions(r, maxPendingWrites, BackupOptions{})
+}
+
+// LoadWithOptions is like DB.Load, but reads a backup written with the given options. An
+// encrypted backup can only be loaded with the key it was written with.
+func (db *DB) LoadWithOptions(r io.Reader, maxPendingWrites int, opt BackupOptions) error {
+	aead, err := opt.aead()
+	if err != nil {
+		return err
+	}
 	br := bufio.NewReaderSize(r, 16<<10)
 	unmarshalBuf := make([]byte, 1<<10)
 
+	if aead != nil {
+		header := make([]byte, len(encryptedBackupHeader))
+		if _, err := io.ReadFull(br, header); err != nil {
+			return errors.Wrapf(err, "while reading the backup header")
+		}
+		if !bytes.Equal(header, encryptedBackupHeader) {
+			return errors.Errorf("Not an encrypted backup, or of an unsupported format: "+
+				"header %x", header)
+		}
+	} else if header, _ := br.Peek(len(encryptedBackupHeader)); bytes.Equal(header,
+		encryptedBackupHeader) {
+		return errors.Errorf("Backup is encrypted, but no key was given")
+	}
+
 	ldr := db.NewKVLoader(maxPendingWrites)
+	var seq uint64
+	var ended bool
 	for {
 		var sz uint64
 		err := binary.Read(br, binary.LittleEndian, &sz)
 		if err == io.EOF {
+			if aead != nil && !ended {
+				return errors.Errorf("Backup is truncated after record %d", seq)
+			}
 			break
 		} else if err != nil {
 			return err
 		}
+		if ended {
+			return errors.Errorf("Backup has data after its end record")
+		}
 
 		if cap(unmarshalBuf) < int(sz) {
 			unmarshalBuf = make([]byte, sz)
@@ -262,9 +393,27 @@ func (db *DB) Load(r io.Reader, maxPendingWrites int) error {
 		if _, err = io.ReadFull(br, unmarshalBuf[:sz]); err != nil {
 			return err
 		}
+		buf := unmarshalBuf[:sz]
+		seq++
+		if aead != nil {
+			if len(buf) < aead.NonceSize() {
+				return errors.Errorf("Backup record %d is too short to be encrypted", seq)
+			}
+			nonce, sealed := buf[:aead.NonceSize()], buf[aead.NonceSize():]
+			opened, err := aead.Open(nil, nonce, sealed, backupRecordAD(seq, false))
+			if err != nil {
+				// It might be the end record.
+				if _, lastErr := aead.Open(nil, nonce, sealed, backupRecordAD(seq, true)); lastErr != nil {
+					return errors.Wrapf(err, "while decrypting backup record %d", seq)
+				}
+				ended = true
+				continue
+			}
+			buf = opened
+		}
 
 		list := &pb.KVList{}
-		if err := proto.Unmarshal(unmarshalBuf[:sz], list); err != nil {
+		if err := proto.Unmarshal(buf, list); err != nil {
 			return err
 		}
 
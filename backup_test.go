@@ -19,6 +19,7 @@ package badger
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"math/rand"
 	"os"
 	"path/filepath"
@@ -563,3 +564,138 @@ func TestBackupBitClear(t *testing.T) {
 		return nil
 	}))
 }
+
+func TestBackupEncrypted(t *testing.T) {
+	dbKey := make([]byte, 32)
+	backupKey := make([]byte, 16)
+	_, err := rand.Read(dbKey)
+	require.NoError(t, err)
+	_, err = rand.Read(backupKey)
+	require.NoError(t, err)
+
+	opt := getTestOptions("").WithEncryptionKey(dbKey).WithIndexCacheSize(1 << 20)
+	var buf bytes.Buffer
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			for i := 0; i < 100; i++ {
+				if err := txn.Set([]byte(key("secret", i)), []byte(key("value", i))); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+		_, err := db.BackupWithOptions(&buf, 0, BackupOptions{EncryptionKey: backupKey})
+		require.NoError(t, err)
+		_, err = db.BackupWithOptions(io.Discard, 0, BackupOptions{EncryptionKey: []byte("short")})
+		require.ErrorIs(t, err, ErrInvalidEncryptionKey)
+	})
+	require.False(t, bytes.Contains(buf.Bytes(), []byte("secret")))
+
+	load := func(k []byte) error {
+		var err error
+		opt := getTestOptions("")
+		runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+			if err = db.LoadWithOptions(bytes.NewReader(buf.Bytes()), 16,
+				BackupOptions{EncryptionKey: k}); err != nil {
+				return
+			}
+			require.NoError(t, db.View(func(txn *Txn) error {
+				for i := 0; i < 100; i++ {
+					item, err := txn.Get([]byte(key("secret", i)))
+					require.NoError(t, err)
+					require.Equal(t, []byte(key("value", i)), getItemValue(t, item))
+				}
+				return nil
+			}))
+		})
+		return err
+	}
+	require.NoError(t, load(backupKey))
+	require.Error(t, load(dbKey[:16]))
+	require.Error(t, load(nil))
+
+	// A backup cut at a record boundary, here before its end record, is rejected.
+	full := append([]byte{}, buf.Bytes()...)
+	endLen := 8 + 12 + 16 // Length, nonce and tag of the empty end record.
+	buf.Truncate(len(full) - endLen)
+	require.ErrorContains(t, load(backupKey), "truncated")
+	buf.Reset()
+	buf.Write(full[:len(encryptedBackupHeader)])
+	require.ErrorContains(t, load(backupKey), "truncated")
+	// So is data after the end record.
+	buf.Reset()
+	buf.Write(full)
+	buf.Write(full[len(encryptedBackupHeader):])
+	require.Error(t, load(backupKey))
+}
+
+func TestExportImportArchive(t *testing.T) {
+	dir, err := os.MkdirTemp("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opt := getTestOptions(dir).WithValueThreshold(16)
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	// Write two versions of each key, with some values in the value log, and flush the first
+	// version to a table.
+	write := func(version int) {
+		for i := 0; i < 50; i++ {
+			require.NoError(t, db.Update(func(txn *Txn) error {
+				val := fmt.Sprintf("%d-%s", version, bytes.Repeat([]byte{'v'}, i))
+				return txn.Set([]byte(key("key", i)), []byte(val))
+			}))
+		}
+	}
+	write(1)
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	write(2)
+
+	var archive bytes.Buffer
+	require.NoError(t, db.ExportArchive(&archive))
+	// Writes resume after the export.
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		return txn.Set([]byte("after"), []byte("export"))
+	}))
+	require.NoError(t, db.Close())
+
+	// A corrupted archive is rejected, and leaves nothing behind.
+	corrupted := bytes.Clone(archive.Bytes())
+	corrupted[1024] ^= 0xff
+	restoreDir := filepath.Join(dir, "restore")
+	err = ImportArchive(bytes.NewReader(corrupted), restoreDir)
+	require.ErrorIs(t, err, ErrInvalidArchive)
+	entries, err := os.ReadDir(restoreDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	require.NoError(t, ImportArchive(bytes.NewReader(archive.Bytes()), restoreDir))
+	require.Error(t, ImportArchive(bytes.NewReader(archive.Bytes()), restoreDir))
+
+	db, err = Open(getTestOptions(restoreDir))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+	require.NoError(t, db.View(func(txn *Txn) error {
+		_, err := txn.Get([]byte("after"))
+		require.Equal(t, ErrKeyNotFound, err)
+
+		iopt := DefaultIteratorOptions
+		iopt.AllVersions = true
+		it := txn.NewIterator(iopt)
+		defer it.Close()
+		var count int
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			version := 2 - count%2
+			i := count / 2
+			require.Equal(t, key("key", i), string(item.Key()))
+			val := fmt.Sprintf("%d-%s", version, bytes.Repeat([]byte{'v'}, i))
+			require.Equal(t, val, string(getItemValue(t, item)))
+			count++
+		}
+		require.Equal(t, 100, count)
+		return nil
+	}))
+}
@@ -29,6 +29,7 @@ import (
 var bo = struct {
 	backupFile  string
 	numVersions int
+	keyPath     string
 }{}
 
 // backupCmd represents the backup command
@@ -50,6 +51,8 @@ func init() {
 		"badger.bak", "File to backup to")
 	backupCmd.Flags().IntVarP(&bo.numVersions, "num-versions", "n",
 		0, "Number of versions to keep. A value <= 0 means keep all versions.")
+	backupCmd.Flags().StringVar(&bo.keyPath, "backup-key-file", "",
+		"Path of the key file to encrypt the backup with.")
 }
 
 func doBackup(cmd *cobra.Command, args []string) error {
@@ -57,6 +60,10 @@ func doBackup(cmd *cobra.Command, args []string) error {
 		WithValueDir(vlogDir).
 		WithNumVersionsToKeep(math.MaxInt32)
 
+	backupKey, err := getKey(bo.keyPath)
+	if err != nil {
+		return err
+	}
 	if bo.numVersions > 0 {
 		opt.NumVersionsToKeep = bo.numVersions
 	}
@@ -75,7 +82,8 @@ func doBackup(cmd *cobra.Command, args []string) error {
 	}
 
 	bw := bufio.NewWriterSize(f, 64<<20)
-	if _, err = db.Backup(bw, 0); err != nil {
+	backupOpt := badger.BackupOptions{EncryptionKey: backupKey}
+	if _, err = db.BackupWithOptions(bw, 0, backupOpt); err != nil {
 		return err
 	}
 
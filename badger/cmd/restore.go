@@ -29,6 +29,7 @@ import (
 
 var restoreFile string
 var maxPendingWrites int
+var restoreKeyPath string
 
 // restoreCmd represents the restore command
 var restoreCmd = &cobra.Command{
@@ -53,6 +54,8 @@ func init() {
 	// and overall finish time.
 	restoreCmd.Flags().IntVarP(&maxPendingWrites, "max-pending-writes", "w",
 		256, "Max number of pending writes at any time while restore")
+	restoreCmd.Flags().StringVar(&restoreKeyPath, "backup-key-file", "",
+		"Path of the key file the backup was encrypted with.")
 }
 
 func doRestore(cmd *cobra.Command, args []string) error {
@@ -66,6 +69,11 @@ func doRestore(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	backupKey, err := getKey(restoreKeyPath)
+	if err != nil {
+		return err
+	}
+
 	// Open DB
 	db, err := badger.Open(badger.DefaultOptions(sstDir).
 		WithValueDir(vlogDir).
@@ -83,5 +91,5 @@ func doRestore(cmd *cobra.Command, args []string) error {
 	defer f.Close()
 
 	// Run restore
-	return db.Load(f, maxPendingWrites)
+	return db.LoadWithOptions(f, maxPendingWrites, badger.BackupOptions{EncryptionKey: backupKey})
 }
@@ -39,6 +39,7 @@ type WriteBatch struct {
 	isManaged bool
 	commitTs  uint64
 	finished  bool
+	dedup     bool
 }
 
 // NewWriteBatch creates a new WriteBatch. This provides a way to conveniently do a lot of writes,
@@ -69,6 +70,21 @@ func (wb *WriteBatch) SetMaxPendingTxns(max int) {
 	wb.throttle = y.NewThrottle(max)
 }
 
+// SetDedup sets whether duplicate writes of a key within the batch should be collapsed, so that
+// only the last write of the key is committed. The order of the calls to the batch decides which
+// write wins, so a Delete followed by a Set commits the Set, and vice versa. This function should
+// be called before using WriteBatch.
+//
+// WriteBatch commits internally whenever the pending writes grow too big for a single
+// transaction. Writes are only collapsed within such a transaction, so a key written again after
+// the batch committed would still get a new version.
+func (wb *WriteBatch) SetDedup(dedup bool) {
+	wb.Lock()
+	defer wb.Unlock()
+	wb.dedup = dedup
+	wb.txn.dedupWrites = dedup
+}
+
 // Cancel function must be called if there's a chance that Flush might not get
 // called. If neither Flush or Cancel is called, the transaction oracle would
 // never get a chance to clear out the row commit timestamp map, thus causing an
@@ -100,9 +116,7 @@ func (wb *WriteBatch) callback(err error) {
 
 func (wb *WriteBatch) writeKV(kv *pb.KV) error {
 	e := Entry{Key: kv.Key, Value: kv.Value}
-	if len(kv.UserMeta) > 0 {
-		e.UserMeta = kv.UserMeta[0]
-	}
+	e.UserMeta, e.userMetaHigh = decodeUserMeta(kv.UserMeta)
 	y.AssertTrue(kv.Version != 0)
 	e.version = kv.Version
 	return wb.handleEntry(&e)
@@ -145,7 +159,7 @@ func (wb *WriteBatch) SetEntryAt(e *Entry, ts uint64) error {
 
 // Should be called with lock acquired.
 func (wb *WriteBatch) handleEntry(e *Entry) error {
-	if err := wb.txn.SetEntry(e); err != ErrTxnTooBig {
+	if err := wb.txn.SetEntry(e); !errors.Is(err, ErrTxnTooBig) {
 		return err
 	}
 	// Txn has reached it's zenith. Commit now.
@@ -185,7 +199,7 @@ func (wb *WriteBatch) Delete(k []byte) error {
 	wb.Lock()
 	defer wb.Unlock()
 
-	if err := wb.txn.Delete(k); err != ErrTxnTooBig {
+	if err := wb.txn.Delete(k); !errors.Is(err, ErrTxnTooBig) {
 		return err
 	}
 	if err := wb.commit(); err != nil {
@@ -198,6 +212,26 @@ func (wb *WriteBatch) Delete(k []byte) error {
 	return nil
 }
 
+// ValidateChunk checks the writes of the chunk pending in the batch as they would be checked when
+// committed: the sizes of the keys and values, the number and total size of the writes, the banned
+// namespaces and the version to commit at. Writes which have already expired fail the check as
+// well. The batch commits a chunk internally each time its writes don't fit into one transaction,
+// and only the writes added since are checked, as the earlier ones are written already. It returns
+// an *InvalidEntryError for the first write which fails a check, a *TxnTooBigError if the writes
+// don't fit into one transaction, or any error stored by the batch. The chunk isn't committed and
+// the batch can be used further.
+func (wb *WriteBatch) ValidateChunk() error {
+	wb.Lock()
+	defer wb.Unlock()
+	if err := wb.Error(); err != nil {
+		return err
+	}
+	if wb.finished {
+		return y.ErrCommitAfterFinish
+	}
+	return wb.txn.validate()
+}
+
 // Caller to commit must hold a write lock.
 func (wb *WriteBatch) commit() error {
 	if err := wb.Error(); err != nil {
@@ -213,6 +247,7 @@ func (wb *WriteBatch) commit() error {
 	wb.txn.CommitWith(wb.callback)
 	wb.txn = wb.db.newTransaction(true, wb.isManaged)
 	wb.txn.commitTs = wb.commitTs
+	wb.txn.dedupWrites = wb.dedup
 	return wb.Error()
 }
 
@@ -18,6 +18,7 @@ package badger
 
 import (
 	"fmt"
+	"math"
 	"os"
 	"testing"
 	"time"
@@ -168,3 +169,100 @@ func TestBatchErrDeadlock(t *testing.T) {
 	require.Error(t, wb.Flush())
 	require.NoError(t, db.Close())
 }
+
+func TestWriteBatchDedup(t *testing.T) {
+	dir, err := os.MkdirTemp("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	db, err := OpenManaged(getTestOptions(dir))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+
+	versions := func(key []byte) []uint64 {
+		txn := db.NewTransactionAt(math.MaxUint64, false)
+		defer txn.Discard()
+		opt := DefaultIteratorOptions
+		opt.AllVersions = true
+		itr := txn.NewKeyIterator(key, opt)
+		defer itr.Close()
+
+		var vs []uint64
+		for itr.Rewind(); itr.Valid(); itr.Next() {
+			vs = append(vs, itr.Item().Version())
+		}
+		return vs
+	}
+
+	wb := db.NewManagedWriteBatch()
+	require.NoError(t, wb.SetEntryAt(NewEntry([]byte("plain"), []byte("v1")), 1))
+	require.NoError(t, wb.SetEntryAt(NewEntry([]byte("plain"), []byte("v2")), 2))
+	require.NoError(t, wb.Flush())
+	require.Equal(t, []uint64{2, 1}, versions([]byte("plain")))
+
+	wb = db.NewManagedWriteBatch()
+	wb.SetDedup(true)
+	require.NoError(t, wb.SetEntryAt(NewEntry([]byte("set"), []byte("v1")), 1))
+	require.NoError(t, wb.SetEntryAt(NewEntry([]byte("set"), []byte("v2")), 2))
+	require.NoError(t, wb.DeleteAt([]byte("del"), 1))
+	require.NoError(t, wb.SetEntryAt(NewEntry([]byte("del"), []byte("v1")), 2))
+	require.NoError(t, wb.DeleteAt([]byte("del"), 3))
+	require.NoError(t, wb.Flush())
+
+	require.Equal(t, []uint64{2}, versions([]byte("set")))
+	require.Equal(t, []uint64{3}, versions([]byte("del")))
+
+	txn := db.NewTransactionAt(math.MaxUint64, false)
+	defer txn.Discard()
+	item, err := txn.Get([]byte("set"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("v2"), getItemValue(t, item))
+	_, err = txn.Get([]byte("del"))
+	require.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestWriteBatchValidateChunk(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		wb := db.NewWriteBatch()
+		defer wb.Cancel()
+		require.NoError(t, wb.ValidateChunk())
+		for i := 0; i < 10; i++ {
+			require.NoError(t, wb.Set([]byte(key("key", i)), []byte("v")))
+		}
+		require.NoError(t, wb.SetEntry(NewEntry([]byte("ttl"), []byte("v")).WithTTL(time.Hour)))
+		require.NoError(t, wb.ValidateChunk())
+
+		e := NewEntry([]byte("expired"), []byte("v"))
+		e.ExpiresAt = 1
+		require.NoError(t, wb.SetEntry(e))
+		err := wb.ValidateChunk()
+		require.ErrorIs(t, err, ErrExpiredEntry)
+		var invalid *InvalidEntryError
+		require.ErrorAs(t, err, &invalid)
+		require.Equal(t, "expired", string(invalid.Key))
+
+		// ValidateChunk doesn't commit anything, and the batch can still be used.
+		require.NoError(t, db.View(func(txn *Txn) error {
+			_, err := txn.Get([]byte(key("key", 0)))
+			require.ErrorIs(t, err, ErrKeyNotFound)
+			return nil
+		}))
+		e.ExpiresAt = 0
+		require.NoError(t, wb.ValidateChunk())
+
+		// The sizes are told even without Options.DetailedErrors.
+		maxCount := db.opt.maxBatchCount
+		db.opt.maxBatchCount = 5
+		var tooBig *TxnTooBigError
+		require.ErrorAs(t, wb.ValidateChunk(), &tooBig)
+		require.Equal(t, int64(12), tooBig.Count)
+		db.opt.maxBatchCount = maxCount
+		require.NoError(t, wb.Flush())
+		require.ErrorIs(t, wb.ValidateChunk(), y.ErrCommitAfterFinish)
+
+		require.NoError(t, db.View(func(txn *Txn) error {
+			_, err := txn.Get([]byte(key("key", 0)))
+			return err
+		}))
+	})
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright 2024 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"github.com/0xEggTart/badger/y"
+)
+
+// Values of at least Options.BlobThreshold bytes are written to a second value log, the blob log,
+// which lives in Options.BlobDir. Its files are named like value log files, but their IDs have
+// blobFidBit set in memory, so that value pointers tell which log they point into.
+const blobFidBit uint32 = 1 << 31
+
+// isBlob tells whether vlog is the blob log.
+func (vlog *valueLog) isBlob() bool {
+	return vlog.fidBase == blobFidBit
+}
+
+// isBlob tells whether the value of e goes to the blob log, provided it doesn't stay in the LSM
+// tree.
+func (db *DB) isBlob(e *Entry) bool {
+	return db.blob != nil && int64(len(e.Value)) >= db.opt.BlobThreshold
+}
+
+// valueLogFor returns the value log which vp points into.
+func (db *DB) valueLogFor(vp valuePointer) *valueLog {
+	if vp.Fid&blobFidBit != 0 && db.blob != nil {
+		return db.blob
+	}
+	return &db.vlog
+}
+
+// writeValueLogs writes the values of the requests which don't stay in the LSM tree to the value
+// log or the blob log, and sets the value pointers of the requests.
+func (db *DB) writeValueLogs(reqs []*request) error {
+	for _, b := range reqs {
+		b.Ptrs = append(b.Ptrs[:0], make([]valuePointer, len(b.Entries))...)
+	}
+	if db.blob != nil {
+		if err := db.blob.write(reqs); err != nil {
+			return y.Wrapf(err, "while writing to blob log")
+		}
+	}
+	return db.vlog.write(reqs)
+}
+
+// updateDiscardStats passes the discard stats gathered by a compaction to the value logs.
+func (db *DB) updateDiscardStats(stats map[uint32]int64) {
+	if db.blob != nil {
+		blobStats := make(map[uint32]int64)
+		for fid, discard := range stats {
+			if fid&blobFidBit != 0 {
+				blobStats[fid] = discard
+				delete(stats, fid)
+			}
+		}
+		db.blob.updateDiscardStats(blobStats)
+	}
+	db.vlog.updateDiscardStats(stats)
+}
+
+// runValueLogGC runs a GC on the value log, or on the blob log if the value log has nothing to
+// rewrite.
+func (db *DB) runValueLogGC(discardRatio float64) error {
+	err := db.vlog.runGC(discardRatio)
+	if err == ErrNoRewrite && db.blob != nil {
+		return db.blob.runGC(discardRatio)
+	}
+	return err
+}
@@ -0,0 +1,163 @@
+/*
+ * Copyright 2024 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"container/heap"
+	"sort"
+	"unsafe"
+)
+
+// Change is a write of a key, as returned by DB.ChangesSince.
+type Change struct {
+	Key       []byte
+	Value     []byte // Nil for deletes.
+	Version   uint64
+	UserMeta  uint16 // The 16 bit user meta, see Item.UserMeta16.
+	ExpiresAt uint64
+	IsDelete  bool
+}
+
+// changesBatchSize is the size of the changes ChangesSince holds in memory at once, unless a
+// single version holds more.
+const changesBatchSize = 64 << 20
+
+// ChangesSince calls fn with every change of a key committed after version, including deletes, in
+// commit order. The changes of the same version are passed in increasing key order. All the
+// versions of each key are considered, and tables holding only older versions are skipped. It
+// returns the last version all of whose changes were passed to fn, or version if there were none,
+// so the changes can be resumed from there with another call. The first error returned by fn stops
+// the iteration and is returned.
+//
+// The changes are read from a snapshot in batches, as they have to be sorted by version before
+// being passed to fn: each batch scans the keys again, and holds the changes of the lowest versions
+// not passed yet, up to 64 MB of them, or all the changes of a single version if these take more.
+// Changes which were already compacted away, such as versions below the discard timestamp
+// overwritten by later ones, are not returned.
+func (db *DB) ChangesSince(version uint64, fn func(Change) error) (uint64, error) {
+	return db.changesSince(version, changesBatchSize, fn)
+}
+
+func (db *DB) changesSince(version uint64, batchSize int64, fn func(Change) error) (uint64, error) {
+	last := version
+	err := db.View(func(txn *Txn) error {
+		for {
+			changes, upTo, err := txn.changesBatch(last, batchSize)
+			if err != nil {
+				return err
+			}
+			for i, c := range changes {
+				if err := fn(c); err != nil {
+					return err
+				}
+				if i+1 == len(changes) || changes[i+1].Version != c.Version {
+					last = c.Version
+				}
+			}
+			if upTo == 0 {
+				return nil
+			}
+			// All the changes up to upTo have been passed, even if there are none at upTo.
+			last = upTo
+		}
+	})
+	return last, err
+}
+
+// changesBatch returns the changes committed after since, sorted in commit order, of the lowest
+// versions whose changes fit in batchSize bytes, and at least of one version. If some changes were
+// left out, it returns the version up to which the changes were returned, otherwise zero.
+func (txn *Txn) changesBatch(since uint64, batchSize int64) ([]Change, uint64, error) {
+	opt := DefaultIteratorOptions
+	opt.AllVersions = true
+	opt.SinceTs = since
+	opt.internal = true
+	it := txn.NewIterator(opt)
+	defer it.Close()
+
+	// The changes by version, and the versions in a max-heap, so that the changes of the highest
+	// version can be dropped once they take more than batchSize.
+	byVersion := make(map[uint64][]Change)
+	var versions versionHeap
+	var size int64
+	var upTo uint64
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		if upTo > 0 && item.Version() > upTo {
+			continue
+		}
+		c := Change{
+			Key:       item.KeyCopy(nil),
+			Version:   item.Version(),
+			UserMeta:  item.UserMeta16(),
+			ExpiresAt: item.ExpiresAt(),
+			IsDelete:  item.meta&bitDelete > 0,
+		}
+		if !c.IsDelete {
+			var err error
+			if c.Value, err = item.ValueCopy(nil); err != nil {
+				return nil, 0, err
+			}
+		}
+		if _, ok := byVersion[c.Version]; !ok {
+			heap.Push(&versions, c.Version)
+		}
+		byVersion[c.Version] = append(byVersion[c.Version], c)
+		size += changeSize(c)
+		for size > batchSize && len(versions) > 1 {
+			v := heap.Pop(&versions).(uint64)
+			for _, c := range byVersion[v] {
+				size -= changeSize(c)
+			}
+			delete(byVersion, v)
+			upTo = v - 1
+		}
+	}
+
+	var changes []Change
+	for _, cs := range byVersion {
+		changes = append(changes, cs...)
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Version != changes[j].Version {
+			return changes[i].Version < changes[j].Version
+		}
+		return bytes.Compare(changes[i].Key, changes[j].Key) < 0
+	})
+	return changes, upTo, nil
+}
+
+// changeSize estimates the memory taken by a change.
+func changeSize(c Change) int64 {
+	return int64(unsafe.Sizeof(c)) + int64(len(c.Key)+len(c.Value))
+}
+
+// versionHeap is a max-heap of versions.
+type versionHeap []uint64
+
+func (h versionHeap) Len() int            { return len(h) }
+func (h versionHeap) Less(i, j int) bool  { return h[i] > h[j] }
+func (h versionHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *versionHeap) Push(x interface{}) { *h = append(*h, x.(uint64)) }
+func (h *versionHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[0 : n-1]
+	return x
+}
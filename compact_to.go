@@ -0,0 +1,151 @@
+/*
+ * Copyright 2024 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/0xEggTart/badger/pb"
+	"github.com/0xEggTart/badger/y"
+)
+
+// CompactToOptions holds the options of CompactTo.
+type CompactToOptions struct {
+	// Version is the version as of which the snapshot is taken, so the writes committed after it
+	// are left out. Zero takes the snapshot at the latest version.
+	Version uint64
+	// Options are the options the DB in the directory is created with. Their Dir and ValueDir
+	// are set to the directory. If nil, DefaultOptions are used, with the table, compression,
+	// encryption and WideUserMeta options of the source DB.
+	Options *Options
+}
+
+// CompactTo writes a snapshot of the DB to a new DB in dir, keeping only the latest version of
+// every key as of the snapshot version, and leaving out the deleted and expired keys. The keys are
+// written in order by a StreamWriter, so all the tables of the new DB end up in the last level and
+// don't overlap, which is the shape a full compaction of the DB would give it. The DB itself is
+// left as it is and keeps taking reads and writes meanwhile.
+//
+// dir must not hold any files. opts.Version should be set to a version at or above the discard
+// timestamp, see DB.GetDiscardTs, as the older versions may be gone. Outside managed mode, where
+// the versions are dropped by compactions as soon as no transaction reads them, a version below it
+// is rejected.
+func (db *DB) CompactTo(dir string, opts CompactToOptions) error {
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return errors.Errorf("Cannot compact to %q, the directory is not empty", dir)
+	} else if err != nil && !os.IsNotExist(err) {
+		return y.Wrapf(err, "while reading directory %q", dir)
+	}
+
+	var stream *Stream
+	if db.opt.managedTxns {
+		readTs := opts.Version
+		if readTs == 0 {
+			readTs = math.MaxUint64
+		}
+		stream = db.NewStreamAt(readTs)
+	} else {
+		// The snapshot transaction holds the read mark of readTs, so that compactions keep the
+		// versions the stream reads. An older version has to be pinned instead.
+		snap := db.NewTransaction(false)
+		defer snap.Discard()
+		stream = db.NewStream()
+		stream.readTs = snap.readTs
+		if opts.Version > 0 && opts.Version < snap.readTs {
+			if !db.orc.pinVersion(opts.Version) {
+				return errors.Errorf("Cannot compact to version %d, below the discard timestamp %d",
+					opts.Version, db.orc.discardAtOrBelow())
+			}
+			defer db.orc.unpinVersion(opts.Version)
+			stream.readTs = opts.Version
+		}
+	}
+
+	var outOpt Options
+	if opts.Options != nil {
+		outOpt = *opts.Options
+	} else {
+		outOpt = db.compactToOptions()
+	}
+	outOpt.Dir, outOpt.ValueDir = dir, dir
+	outDB, err := OpenManaged(outOpt)
+	if err != nil {
+		return y.Wrapf(err, "cannot open out DB at %s", dir)
+	}
+	defer outDB.Close()
+
+	stream.LogPrefix = fmt.Sprintf("Compacting DB to %s", dir)
+	stream.KeyToList = func(key []byte, itr *Iterator) (*pb.KVList, error) {
+		item := itr.Item()
+		if item.IsDeletedOrExpired() {
+			return nil, nil
+		}
+		a := itr.Alloc
+		kv := y.NewKV(a)
+		kv.Key = a.Copy(key)
+		if err := item.Value(func(val []byte) error {
+			kv.Value = a.Copy(val)
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		kv.Version = item.Version()
+		kv.ExpiresAt = item.ExpiresAt()
+		kv.UserMeta = a.Copy(encodeUserMeta(item.userMeta, item.userMetaHigh))
+		return &pb.KVList{Kv: []*pb.KV{kv}}, nil
+	}
+
+	writer := outDB.NewStreamWriter()
+	if err := writer.Prepare(); err != nil {
+		return y.Wrapf(err, "cannot create stream writer in out DB at %s", dir)
+	}
+	stream.Send = writer.Write
+	if err := stream.Orchestrate(context.Background()); err != nil {
+		writer.Cancel()
+		return y.Wrapf(err, "cannot compact DB to out DB at %s", dir)
+	}
+	if err := writer.Flush(); err != nil {
+		return y.Wrapf(err, "cannot flush writer")
+	}
+	return outDB.Close()
+}
+
+// compactToOptions returns the options CompactTo creates the new DB with when none are given.
+func (db *DB) compactToOptions() Options {
+	opt := DefaultOptions("")
+	opt.Logger = db.opt.Logger
+	opt.BaseTableSize = db.opt.BaseTableSize
+	opt.BaseLevelSize = db.opt.BaseLevelSize
+	opt.LevelSizeMultiplier = db.opt.LevelSizeMultiplier
+	opt.MaxLevels = db.opt.MaxLevels
+	opt.BlockSize = db.opt.BlockSize
+	opt.BloomFalsePositive = db.opt.BloomFalsePositive
+	opt.Compression = db.opt.Compression
+	opt.ZSTDCompressionLevel = db.opt.ZSTDCompressionLevel
+	opt.ValueThreshold = db.opt.ValueThreshold
+	opt.ValueLogFileSize = db.opt.ValueLogFileSize
+	opt.EncryptionKey = db.opt.EncryptionKey
+	opt.EncryptionKeyRotationDuration = db.opt.EncryptionKeyRotationDuration
+	opt.IndexCacheSize = db.opt.IndexCacheSize
+	opt.WideUserMeta = db.opt.WideUserMeta
+	return opt
+}
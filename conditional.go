@@ -0,0 +1,137 @@
+/*
+ * Copyright 2024 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"errors"
+)
+
+// ConditionType denotes what a Condition checks about its key.
+type ConditionType uint8
+
+const (
+	// KeyExists holds if the key has a value, which isn't deleted or expired.
+	KeyExists ConditionType = iota + 1
+	// KeyAbsent holds if the key has no value, or if it's deleted or expired.
+	KeyAbsent
+	// KeyEquals holds if the key has the value of the condition.
+	KeyEquals
+)
+
+func (c ConditionType) String() string {
+	switch c {
+	case KeyExists:
+		return "Exists"
+	case KeyAbsent:
+		return "Absent"
+	case KeyEquals:
+		return "Equals"
+	}
+	return "Unknown"
+}
+
+// Condition is checked by DB.ApplyConditional before it applies its mutations.
+type Condition struct {
+	Key   []byte
+	Type  ConditionType
+	Value []byte // The value the key must have, for KeyEquals.
+}
+
+// Mutation is a write applied by DB.ApplyConditional.
+type Mutation struct {
+	Key    []byte
+	Value  []byte
+	Delete bool // Delete the key instead of setting it to Value.
+}
+
+// maxConditionalRetries is the number of times ApplyConditional checks the conditions again after
+// its transaction conflicted, before it gives up.
+const maxConditionalRetries = 100
+
+// ApplyConditional applies all the mutations atomically, if all the conditions hold, or else none
+// of them and returns a *ConditionFailedError naming the first condition which doesn't hold. It
+// saves writing the loop which reads the keys, checks them, writes, and retries on conflicts.
+//
+// The conditions and the mutations are applied in a single transaction. The keys of the conditions
+// are read by the transaction, so if any of them is written by a concurrent transaction before it
+// commits, the transaction conflicts, and the conditions are checked again in a new transaction,
+// up to 100 times. ErrConflict is returned if the last transaction still conflicts. That relies on
+// conflict detection, so with Options.DetectConflicts off, the conditions are checked but can be
+// invalidated by a concurrent write. Like Update, it can't be used in managed mode.
+func (db *DB) ApplyConditional(conds []Condition, muts []Mutation) error {
+	for retries := 0; ; retries++ {
+		err := db.Update(func(txn *Txn) error {
+			for i, c := range conds {
+				if err := txn.checkCondition(c); err != nil {
+					if errors.Is(err, ErrConditionFailed) {
+						return &ConditionFailedError{Index: i, Condition: c}
+					}
+					return err
+				}
+			}
+			for _, m := range muts {
+				var err error
+				if m.Delete {
+					err = txn.Delete(m.Key)
+				} else {
+					err = txn.Set(m.Key, m.Value)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if errors.Is(err, ErrConflict) && retries < maxConditionalRetries {
+			continue
+		}
+		return err
+	}
+}
+
+// checkCondition returns ErrConditionFailed if c doesn't hold in txn.
+func (txn *Txn) checkCondition(c Condition) error {
+	item, err := txn.Get(c.Key)
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return err
+	}
+	exists := err == nil
+	var holds bool
+	switch c.Type {
+	case KeyExists:
+		holds = exists
+	case KeyAbsent:
+		holds = !exists
+	case KeyEquals:
+		if exists {
+			err = item.Value(func(val []byte) error {
+				holds = bytes.Equal(val, c.Value)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return ErrInvalidRequest
+	}
+	if !holds {
+		return ErrConditionFailed
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+/*
+ * Copyright 2024 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyConditional(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		get := func(k string) string {
+			var val []byte
+			err := db.View(func(txn *Txn) error {
+				item, err := txn.Get([]byte(k))
+				if err != nil {
+					return err
+				}
+				val, err = item.ValueCopy(nil)
+				return err
+			})
+			if errors.Is(err, ErrKeyNotFound) {
+				return ""
+			}
+			require.NoError(t, err)
+			return string(val)
+		}
+		txnSet(t, db, []byte("a"), []byte("1"), 0)
+		txnSet(t, db, []byte("b"), []byte("2"), 0)
+
+		// A condition which doesn't hold keeps all the mutations from being applied.
+		err := db.ApplyConditional([]Condition{
+			{Key: []byte("a"), Type: KeyExists},
+			{Key: []byte("b"), Type: KeyEquals, Value: []byte("3")},
+		}, []Mutation{{Key: []byte("a"), Value: []byte("x")}})
+		require.ErrorIs(t, err, ErrConditionFailed)
+		var condErr *ConditionFailedError
+		require.ErrorAs(t, err, &condErr)
+		require.Equal(t, 1, condErr.Index)
+		require.Equal(t, []byte("b"), condErr.Condition.Key)
+		require.Equal(t, "1", get("a"))
+
+		require.NoError(t, db.ApplyConditional([]Condition{
+			{Key: []byte("a"), Type: KeyEquals, Value: []byte("1")},
+			{Key: []byte("c"), Type: KeyAbsent},
+		}, []Mutation{
+			{Key: []byte("a"), Delete: true},
+			{Key: []byte("c"), Value: []byte("3")},
+		}))
+		require.Equal(t, "", get("a"))
+		require.Equal(t, "3", get("c"))
+
+		// Concurrent compare-and-set increments don't lose any update.
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 10; j++ {
+					for {
+						cur := get("n")
+						cond := Condition{Key: []byte("n"), Type: KeyEquals, Value: []byte(cur)}
+						if cur == "" {
+							cond = Condition{Key: []byte("n"), Type: KeyAbsent}
+						}
+						next := []byte(cur + "x")
+						err := db.ApplyConditional([]Condition{cond},
+							[]Mutation{{Key: []byte("n"), Value: next}})
+						if errors.Is(err, ErrConditionFailed) || err == ErrConflict {
+							continue
+						}
+						require.NoError(t, err)
+						break
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		require.Len(t, get("n"), 80)
+	})
+}
+
+func TestApplyConditionalRetries(t *testing.T) {
+	// The middleware writes the key of the condition before every commit, so that it conflicts.
+	attempts := 0
+	var db *DB
+	interfere := func(next WriteFunc) WriteFunc {
+		return func(entries []*Entry) error {
+			if string(entries[0].Key) == "target" {
+				attempts++
+				if err := db.Update(func(txn *Txn) error {
+					return txn.Set([]byte("cond"), []byte("x"))
+				}); err != nil {
+					return err
+				}
+			}
+			return next(entries)
+		}
+	}
+	opt := getTestOptions("").WithWriteMiddleware(interfere)
+	runBadgerTest(t, &opt, func(t *testing.T, d *DB) {
+		db = d
+		txnSet(t, db, []byte("cond"), []byte("x"), 0)
+		err := db.ApplyConditional([]Condition{{Key: []byte("cond"), Type: KeyEquals,
+			Value: []byte("x")}}, []Mutation{{Key: []byte("target"), Value: []byte("v")}})
+		require.Equal(t, ErrConflict, err)
+		require.Equal(t, maxConditionalRetries+1, attempts)
+	})
+}
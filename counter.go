@@ -0,0 +1,180 @@
+/*
+ * Copyright 2024 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"encoding/binary"
+
+	"github.com/0xEggTart/badger/y"
+)
+
+// Counters are stored as 8 byte big-endian signed integers. Increment writes the delta as a merge
+// entry, instead of reading the counter and writing back the sum. As such, concurrent increments
+// don't conflict with each other. The value of a counter is the sum of its latest versions, down to
+// the first version which isn't a merge entry, i.e. a value set by Txn.Set or a deletion.
+
+// counterCompactThreshold is the number of increments after which the versions of a counter get
+// summed up into a single one.
+const counterCompactThreshold = 64
+
+// EncodeCounter returns the encoding of a counter value, as used by Increment and GetCounter. It
+// can be used to set the value of a counter with Txn.Set.
+func EncodeCounter(v int64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(v))
+	return buf[:]
+}
+
+// DecodeCounter returns the value of an encoded counter, see EncodeCounter.
+func DecodeCounter(buf []byte) (int64, error) {
+	if len(buf) != 8 {
+		return 0, ErrInvalidCounter
+	}
+	return int64(binary.BigEndian.Uint64(buf)), nil
+}
+
+// Increment adds delta to the counter stored at key, and returns its new value as seen by this
+// transaction. A missing key counts as zero.
+//
+// Unlike a Get followed by a Set, Increment doesn't make the transaction depend on the value of the
+// counter, so it doesn't cause conflicts with concurrent transactions incrementing the same key.
+// All their increments get applied on commit. The returned value doesn't include the increments
+// of transactions which committed after this one started. Use GetCounter to read the value of
+// a counter; Get only returns its latest version.
+func (txn *Txn) Increment(key []byte, delta int64) (int64, error) {
+	switch {
+	case !txn.update:
+		return 0, ErrReadOnlyTxn
+	case txn.discarded:
+		return 0, ErrDiscardedTxn
+	}
+
+	key = txn.db.storedKey(key)
+	v, pending, err := txn.counterValue(key)
+	if err != nil {
+		return 0, err
+	}
+	if pending != nil && pending.meta&bitMergeEntry == 0 {
+		// The counter has been set or deleted by this transaction. Increments from other
+		// transactions won't apply, so just update the value.
+		return v + delta, txn.modify(NewEntry(key, EncodeCounter(v+delta)))
+	}
+	d := delta
+	if pending != nil {
+		// Merge with the increments done earlier by this transaction.
+		p, _ := DecodeCounter(pending.Value)
+		d += p
+	}
+	return v + delta, txn.modify(NewEntry(key, EncodeCounter(d)).withMergeBit())
+}
+
+// GetCounter returns the value of the counter stored at key, as seen by this transaction. A missing
+// key counts as zero. See Increment.
+func (txn *Txn) GetCounter(key []byte) (int64, error) {
+	if txn.discarded {
+		return 0, ErrDiscardedTxn
+	}
+	key = txn.db.storedKey(key)
+	txn.addReadKey(key)
+	v, _, err := txn.counterValue(key)
+	return v, err
+}
+
+// counterValue returns the value of the counter at key as seen by txn, along with the pending write
+// of txn for key, if any. It doesn't register the key as read.
+func (txn *Txn) counterValue(key []byte) (int64, *Entry, error) {
+	pending := txn.pendingWrites[string(key)]
+	var base int64
+	switch {
+	case pending != nil && pending.meta&bitDelete > 0:
+		return 0, pending, nil
+	case pending != nil:
+		v, err := DecodeCounter(pending.Value)
+		if err != nil || pending.meta&bitMergeEntry == 0 {
+			return v, pending, err
+		}
+		base = v
+	}
+
+	// The pending writes iterator would shadow the latest committed version, so read the
+	// committed versions from a snapshot at the same read timestamp.
+	snap := &Txn{db: txn.db, readTs: txn.readTs, doneRead: true}
+	defer snap.Discard()
+	sum, deltas, latest, err := sumCounter(snap, key)
+	if err != nil {
+		return 0, nil, err
+	}
+	if deltas >= counterCompactThreshold {
+		txn.db.compactCounter(key, sum, latest)
+	}
+	return base + sum, pending, nil
+}
+
+// sumCounter adds up the versions of the counter stored at key, as seen by txn. It also returns the
+// number of merge entries found, and the latest version. txn must not have pending writes for key.
+func sumCounter(txn *Txn, key []byte) (sum int64, deltas int, latest uint64, err error) {
+	opt := DefaultIteratorOptions
+	opt.AllVersions = true
+	opt.PrefetchValues = false
+	opt.rawKeys = true
+	opt.internal = true
+	it := txn.NewKeyIterator(key, opt)
+	defer it.Close()
+
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		if item.IsDeletedOrExpired() {
+			break
+		}
+		if latest == 0 {
+			latest = item.Version()
+		}
+		var v int64
+		if err := item.Value(func(val []byte) (err error) {
+			v, err = DecodeCounter(val)
+			return err
+		}); err != nil {
+			return 0, 0, 0, err
+		}
+		sum += v
+		if item.meta&bitMergeEntry == 0 || item.DiscardEarlierVersions() {
+			break
+		}
+		deltas++
+	}
+	return sum, deltas, latest, nil
+}
+
+// compactCounter replaces the given version of the counter at key with its value, as a regular
+// entry, so that older versions are no longer read and can be discarded by compactions. It's done
+// asynchronously, like the compactions of MergeOperator.
+func (db *DB) compactCounter(key []byte, sum int64, version uint64) {
+	entries := []*Entry{
+		{
+			Key:   y.KeyWithTs(key, version),
+			Value: EncodeCounter(sum),
+			meta:  bitDiscardEarlierVersions,
+		},
+	}
+	if err := db.batchSetAsync(entries, func(err error) {
+		if err != nil {
+			db.opt.Errorf("failed to compact counter: %s", err)
+		}
+	}); err != nil {
+		db.opt.Errorf("failed to compact counter: %s", err)
+	}
+}
@@ -0,0 +1,128 @@
+/*
+ * Copyright 2024 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIncrement(t *testing.T) {
+	key := []byte("counter")
+	getCounter := func(t *testing.T, db *DB) int64 {
+		var v int64
+		require.NoError(t, db.View(func(txn *Txn) (err error) {
+			v, err = txn.GetCounter(key)
+			return err
+		}))
+		return v
+	}
+
+	t.Run("increment", func(t *testing.T) {
+		runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+			require.Zero(t, getCounter(t, db))
+			for i := int64(1); i <= 10; i++ {
+				require.NoError(t, db.Update(func(txn *Txn) error {
+					v, err := txn.Increment(key, i)
+					require.Equal(t, i*(i+1)/2, v)
+					return err
+				}))
+			}
+			require.Equal(t, int64(55), getCounter(t, db))
+
+			// Multiple increments within a transaction.
+			require.NoError(t, db.Update(func(txn *Txn) error {
+				_, err := txn.Increment(key, 5)
+				require.NoError(t, err)
+				v, err := txn.Increment(key, -10)
+				require.Equal(t, int64(50), v)
+				require.NoError(t, err)
+				v, err = txn.GetCounter(key)
+				require.Equal(t, int64(50), v)
+				return err
+			}))
+			require.Equal(t, int64(50), getCounter(t, db))
+		})
+	})
+	t.Run("concurrent", func(t *testing.T) {
+		runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+			txns := make([]*Txn, 5)
+			for i := range txns {
+				txns[i] = db.NewTransaction(true)
+				v, err := txns[i].Increment(key, 2)
+				require.NoError(t, err)
+				require.Equal(t, int64(2), v)
+			}
+			for _, txn := range txns {
+				require.NoError(t, txn.Commit())
+			}
+			require.Equal(t, int64(10), getCounter(t, db))
+		})
+	})
+	t.Run("set and delete", func(t *testing.T) {
+		runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+			require.NoError(t, db.Update(func(txn *Txn) error {
+				_, err := txn.Increment(key, 3)
+				return err
+			}))
+			require.NoError(t, db.Update(func(txn *Txn) error {
+				return txn.Set(key, EncodeCounter(100))
+			}))
+			require.NoError(t, db.Update(func(txn *Txn) error {
+				v, err := txn.Increment(key, 1)
+				require.Equal(t, int64(101), v)
+				return err
+			}))
+			require.Equal(t, int64(101), getCounter(t, db))
+
+			require.NoError(t, db.Update(func(txn *Txn) error {
+				require.NoError(t, txn.Delete(key))
+				v, err := txn.Increment(key, 7)
+				require.Equal(t, int64(7), v)
+				return err
+			}))
+			require.Equal(t, int64(7), getCounter(t, db))
+
+			require.NoError(t, db.Update(func(txn *Txn) error {
+				return txn.Set(key, []byte("foo"))
+			}))
+			require.NoError(t, db.Update(func(txn *Txn) error {
+				_, err := txn.Increment(key, 1)
+				require.Equal(t, ErrInvalidCounter, err)
+				return nil
+			}))
+		})
+	})
+	t.Run("compaction", func(t *testing.T) {
+		runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+			n := 3 * counterCompactThreshold
+			for i := 0; i < n; i++ {
+				require.NoError(t, db.Update(func(txn *Txn) error {
+					_, err := txn.Increment(key, 1)
+					return err
+				}))
+				require.Equal(t, int64(i+1), getCounter(t, db))
+			}
+			require.NoError(t, db.View(func(txn *Txn) error {
+				_, deltas, _, err := sumCounter(txn, key)
+				require.Less(t, deltas, n)
+				return err
+			}))
+		})
+	})
+}
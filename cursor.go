@@ -0,0 +1,134 @@
+/*
+ * Copyright 2024 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+const (
+	cursorFormat = 1
+
+	cursorReverse     = 1 << 0
+	cursorAllVersions = 1 << 1
+
+	// format(1) + flags(1) + read ts(8) + version(8)
+	cursorHeaderSize = 18
+)
+
+// Cursor returns an opaque token recording the position of the iterator, i.e. the current item,
+// and its read timestamp. The token can be persisted and passed to Txn.NewIteratorFromCursor,
+// possibly after the DB got reopened, to resume iteration right after the current item. So it
+// should be taken once the current item has been processed, before calling Next. Cursor returns
+// nil if the iterator isn't valid, or comes from NewMultiPrefixIterator.
+func (it *Iterator) Cursor() []byte {
+	if !it.Valid() || it.multi != nil {
+		return nil
+	}
+	item := it.Item()
+	var flags byte
+	if it.opt.Reverse {
+		flags |= cursorReverse
+	}
+	if it.opt.AllVersions {
+		flags |= cursorAllVersions
+	}
+	key := it.seekKey(item)
+	buf := make([]byte, cursorHeaderSize+len(key))
+	buf[0] = cursorFormat
+	buf[1] = flags
+	binary.BigEndian.PutUint64(buf[2:10], it.readTs)
+	binary.BigEndian.PutUint64(buf[10:18], item.version)
+	copy(buf[cursorHeaderSize:], key)
+	return buf
+}
+
+// seekKey returns the key to pass to Seek to get back to item.
+func (it *Iterator) seekKey(item *Item) []byte {
+	if it.keyTransform {
+		return it.txn.db.opt.KeyReadTransform(item.key)
+	}
+	return item.key
+}
+
+type iteratorCursor struct {
+	flags   byte
+	readTs  uint64
+	version uint64
+	key     []byte
+}
+
+func parseCursor(cursor []byte) (iteratorCursor, error) {
+	if len(cursor) < cursorHeaderSize || cursor[0] != cursorFormat {
+		return iteratorCursor{}, ErrInvalidCursor
+	}
+	return iteratorCursor{
+		flags:   cursor[1],
+		readTs:  binary.BigEndian.Uint64(cursor[2:10]),
+		version: binary.BigEndian.Uint64(cursor[10:18]),
+		key:     cursor[cursorHeaderSize:],
+	}, nil
+}
+
+// CursorReadTs returns the read timestamp of the iterator which created the cursor. In managed
+// mode, it can be used to create a transaction at the same timestamp with NewTransactionAt, so
+// that the resumed iteration sees the same snapshot, provided that the versions haven't been
+// discarded in the meantime.
+func CursorReadTs(cursor []byte) (uint64, error) {
+	c, err := parseCursor(cursor)
+	if err != nil {
+		return 0, err
+	}
+	return c.readTs, nil
+}
+
+// NewIteratorFromCursor is just like NewIterator, but the returned iterator is positioned right
+// after the item the cursor was taken at, see Iterator.Cursor. The iteration continues at the read
+// timestamp of this transaction, which need not be the one of the cursor; see CursorReadTs to
+// resume on the same snapshot. The Reverse and AllVersions options must be the same as the ones of
+// the iterator which created the cursor, else ErrInvalidCursor is returned.
+func (txn *Txn) NewIteratorFromCursor(cursor []byte, opt IteratorOptions) (*Iterator, error) {
+	c, err := parseCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+	if (c.flags&cursorReverse != 0) != opt.Reverse ||
+		(c.flags&cursorAllVersions != 0) != opt.AllVersions {
+		return nil, ErrInvalidCursor
+	}
+
+	it := txn.NewIterator(opt)
+	it.Seek(c.key)
+	// Skip the cursor item and the ones before it. For the same key, versions are iterated in
+	// decreasing order going forward, and increasing order going backwards.
+	for ; it.Valid(); it.Next() {
+		item := it.Item()
+		if !bytes.Equal(it.seekKey(item), c.key) {
+			break
+		}
+		if opt.AllVersions {
+			if !opt.Reverse && item.version < c.version {
+				break
+			}
+			if opt.Reverse && item.version > c.version {
+				break
+			}
+		}
+	}
+	return it, nil
+}
@@ -24,9 +24,11 @@ import (
 	"expvar"
 	"fmt"
 	"math"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -59,6 +61,10 @@ type closers struct {
 	valueGC     *z.Closer
 	pub         *z.Closer
 	cacheHealth *z.Closer
+	dropHook    *z.Closer
+	gcConflict  *z.Closer
+	manifest    *z.Closer
+	readRepair  *z.Closer
 }
 
 type lockedKeys struct {
@@ -99,11 +105,15 @@ type DB struct {
 	dirLockGuard *directoryLockGuard
 	// nil if Dir and ValueDir are the same
 	valueDirGuard *directoryLockGuard
+	// nil if BlobDir isn't set, or is the same as Dir
+	blobDirGuard *directoryLockGuard
 
 	closers closers
 
 	mt  *memTable   // Our latest (actively written) in-memory table
 	imm []*memTable // Add here only AFTER pushing to flushChan.
+	// Length of imm, which can be read without the lock. See FlushQueueDepth.
+	flushQueueDepth expvar.Int
 
 	// Initialized via openMemTables.
 	nextMemFid int
@@ -112,6 +122,7 @@ type DB struct {
 	manifest  *manifestFile
 	lc        *levelsController
 	vlog      valueLog
+	blob      *valueLog // Nil unless Options.BlobDir is set.
 	writeCh   chan *request
 	flushChan chan *memTable // For flushing memtables.
 	closeOnce sync.Once      // For closing DB only once.
@@ -124,10 +135,34 @@ type DB struct {
 	threshold        *vlogThreshold
 
 	pub        *publisher
-	registry   *KeyRegistry
+	dropHookCh chan droppedKey // Keys dropped by compactions, for Options.CompactionDropHook.
+	latency    *latencyStats   // Nil unless Options.LatencyTracking is set.
+	// Keys skipped by value log GC, for Options.ValueLogGCConflictHook.
+	gcConflictCh chan []byte
+	// Wakes up fullValueLogGC. Nil unless Options.MaxValueLogSize is set.
+	vlogFullCh chan struct{}
+	// Holds a token per open iterator. Nil unless Options.MaxConcurrentIterators is set.
+	iteratorSlots chan struct{}
+	// Limits the bytes read and written by compactions. See Options.CompactionRateLimit.
+	compactionLimiter *rateLimiter
+	hotspots          *hotspots   // Nil unless Options.HotspotKeys is set.
+	pointCache        *pointCache // Nil unless Options.PointReadCacheSize is set.
+	readRepair        *readRepair // Nil unless Options.ReadRepair is set.
+	registry          *KeyRegistry
+	allocPool         *z.AllocatorPool
+
+	// The highest version of the memtables flushed to tables. See FlushedVersion.
+	flushedVersion atomic.Uint64
+	// The max versions of the memtables flushed before older ones. Guarded by lock.
+	flushedAhead []uint64
+
+	cacheLock  sync.Mutex // Serializes the calls to ClearCache.
 	blockCache *ristretto.Cache[[]byte, *table.Block]
 	indexCache *ristretto.Cache[uint64, *fb.TableIndex]
-	allocPool  *z.AllocatorPool
+	blockPins  *table.BlockPins // Nil unless Options.PinnedCacheSize is set.
+
+	randLock sync.Mutex
+	rand     *rand.Rand // Nil unless Options.RandSource is set.
 }
 
 const (
@@ -145,8 +180,40 @@ func checkAndSetOptions(opt *Options) error {
 	if opt.InMemory && (opt.Dir != "" || opt.ValueDir != "") {
 		return errors.New("Cannot use badger in Disk-less mode with Dir or ValueDir set")
 	}
+	if opt.PinnedCacheSize < 0 {
+		return errors.Errorf("Invalid PinnedCacheSize %d, must not be negative",
+			opt.PinnedCacheSize)
+	}
+	if len(opt.CustomLevelSizes) > 0 {
+		if len(opt.CustomLevelSizes) != opt.MaxLevels-1 {
+			return errors.Errorf("Invalid CustomLevelSizes, must hold %d sizes for the levels "+
+				"below L0, got %d", opt.MaxLevels-1, len(opt.CustomLevelSizes))
+		}
+		for i, sz := range opt.CustomLevelSizes {
+			if sz <= 0 || (i > 0 && sz <= opt.CustomLevelSizes[i-1]) {
+				return errors.Errorf("Invalid CustomLevelSizes %v, must be positive and "+
+					"increasing", opt.CustomLevelSizes)
+			}
+		}
+	}
+	if opt.LockTimeout < 0 {
+		return errors.Errorf("Invalid LockTimeout %s, must not be negative", opt.LockTimeout)
+	}
+	if opt.MaxOracleMemory < 0 {
+		return errors.Errorf("Invalid MaxOracleMemory %d, must not be negative",
+			opt.MaxOracleMemory)
+	}
+	if opt.SkiplistMaxHeight < 1 || opt.SkiplistMaxHeight > skl.MaxHeight {
+		return errors.Errorf("Invalid SkiplistMaxHeight %d, must be between 1 and %d",
+			opt.SkiplistMaxHeight, skl.MaxHeight)
+	}
+	if opt.SkiplistProbability <= 0 || opt.SkiplistProbability >= 1 {
+		return errors.Errorf("Invalid SkiplistProbability %f, must be greater than 0 and less than 1",
+			opt.SkiplistProbability)
+	}
 	opt.maxBatchSize = (15 * opt.MemTableSize) / 100
-	opt.maxBatchCount = opt.maxBatchSize / int64(skl.MaxNodeSize)
+	// Each entry of a batch may need a node as tall as the skiplist allows.
+	opt.maxBatchCount = opt.maxBatchSize / int64(skl.NodeSize(opt.SkiplistMaxHeight))
 
 	// This is the maximum value, vlogThreshold can have if dynamic thresholding is enabled.
 	opt.maxValueThreshold = math.Min(maxValueThreshold, float64(opt.maxBatchSize))
@@ -172,6 +239,98 @@ func checkAndSetOptions(opt *Options) error {
 	if !(opt.ValueLogFileSize < 2<<30 && opt.ValueLogFileSize >= 1<<20) {
 		return ErrValueLogSize
 	}
+	if opt.EntrySpillThreshold < 0 {
+		return errors.Errorf("Invalid EntrySpillThreshold %d, must not be negative",
+			opt.EntrySpillThreshold)
+	}
+	if opt.CommitPipelineDepth < 0 {
+		return errors.Errorf("Invalid CommitPipelineDepth %d, must not be negative",
+			opt.CommitPipelineDepth)
+	}
+
+	if opt.BlobDir != "" {
+		if opt.InMemory {
+			return errors.New("Cannot use BlobDir in InMemory mode")
+		}
+		if opt.BlobThreshold <= 0 {
+			return errors.Errorf("Invalid BlobThreshold %d, must be positive", opt.BlobThreshold)
+		}
+		absBlobDir, err := filepath.Abs(opt.BlobDir)
+		if err != nil {
+			return err
+		}
+		absValueDir, err := filepath.Abs(opt.ValueDir)
+		if err != nil {
+			return err
+		}
+		if absBlobDir == absValueDir {
+			return errors.New("BlobDir must be different from ValueDir")
+		}
+	}
+
+	if opt.MinCompressionSize < 0 {
+		return errors.Errorf("Invalid MinCompressionSize %d, must not be negative",
+			opt.MinCompressionSize)
+	}
+
+	if opt.MaxVersionsScannedPerKey < 0 {
+		return errors.Errorf("Invalid MaxVersionsScannedPerKey %d, must not be negative",
+			opt.MaxVersionsScannedPerKey)
+	}
+
+	if opt.HotspotKeys < 0 {
+		return errors.Errorf("Invalid HotspotKeys %d, must not be negative", opt.HotspotKeys)
+	}
+	if opt.MaxValueLogSize < 0 {
+		return errors.Errorf("Invalid MaxValueLogSize %d, must not be negative", opt.MaxValueLogSize)
+	}
+	if opt.LargeReadSetThreshold < 0 {
+		return errors.Errorf("Invalid LargeReadSetThreshold %d, must not be negative",
+			opt.LargeReadSetThreshold)
+	}
+	if opt.AutoValueLogGCInterval < 0 {
+		return errors.Errorf("Invalid AutoValueLogGCInterval %v, must not be negative",
+			opt.AutoValueLogGCInterval)
+	}
+	if opt.AutoValueLogGCInterval > 0 &&
+		(opt.AutoValueLogGCRatio <= 0 || opt.AutoValueLogGCRatio >= 1) {
+		return errors.Errorf("Invalid AutoValueLogGCRatio %v, must be between 0 and 1",
+			opt.AutoValueLogGCRatio)
+	}
+	if opt.ValueLogMaxAge < 0 {
+		return errors.Errorf("Invalid ValueLogMaxAge %v, must not be negative", opt.ValueLogMaxAge)
+	}
+	if opt.PointReadCacheSize < 0 {
+		return errors.Errorf("Invalid PointReadCacheSize %d, must not be negative",
+			opt.PointReadCacheSize)
+	}
+	if opt.PointReadCacheSize > 0 && opt.PointReadCacheTTL <= 0 {
+		return errors.Errorf("Invalid PointReadCacheTTL %v, must be positive",
+			opt.PointReadCacheTTL)
+	}
+	if opt.CompactionRateLimit < 0 {
+		return errors.Errorf("Invalid CompactionRateLimit %d, must not be negative",
+			opt.CompactionRateLimit)
+	}
+
+	if opt.MaxConcurrentIterators < 0 {
+		return errors.Errorf("Invalid MaxConcurrentIterators %d, must not be negative",
+			opt.MaxConcurrentIterators)
+	}
+
+	if (opt.KeyWriteTransform == nil) != (opt.KeyReadTransform == nil) {
+		return errors.New("KeyWriteTransform and KeyReadTransform must be set together")
+	}
+
+	if opt.ReadThroughLoader != nil && (opt.ReadOnly || opt.managedTxns) {
+		return errors.New("Cannot use ReadThroughLoader with ReadOnly or managed mode")
+	}
+	if opt.ReadRepair < 0 {
+		return errors.Errorf("Invalid ReadRepair %d, must not be negative", opt.ReadRepair)
+	}
+	if opt.ReadRepair > 0 && (opt.ReadOnly || opt.managedTxns) {
+		return errors.New("Cannot use ReadRepair with ReadOnly or managed mode")
+	}
 
 	if opt.ReadOnly {
 		// Do not perform compaction in read only mode.
@@ -190,7 +349,7 @@ func Open(opt Options) (*DB, error) {
 	if err := checkAndSetOptions(&opt); err != nil {
 		return nil, err
 	}
-	var dirLockGuard, valueDirLockGuard *directoryLockGuard
+	var dirLockGuard, valueDirLockGuard, blobDirLockGuard *directoryLockGuard
 
 	// Create directories and acquire lock on it only if badger is not running in InMemory mode.
 	// We don't have any directories/files in InMemory mode so we don't need to acquire
@@ -201,7 +360,7 @@ func Open(opt Options) (*DB, error) {
 		}
 		var err error
 		if !opt.BypassLockGuard {
-			dirLockGuard, err = acquireDirectoryLock(opt.Dir, lockFile, opt.ReadOnly)
+			dirLockGuard, err = lockDirectory(opt, opt.Dir)
 			if err != nil {
 				return nil, err
 			}
@@ -219,7 +378,7 @@ func Open(opt Options) (*DB, error) {
 				return nil, err
 			}
 			if absValueDir != absDir {
-				valueDirLockGuard, err = acquireDirectoryLock(opt.ValueDir, lockFile, opt.ReadOnly)
+				valueDirLockGuard, err = lockDirectory(opt, opt.ValueDir)
 				if err != nil {
 					return nil, err
 				}
@@ -229,6 +388,21 @@ func Open(opt Options) (*DB, error) {
 					}
 				}()
 			}
+			absBlobDir, err := filepath.Abs(opt.BlobDir)
+			if err != nil {
+				return nil, err
+			}
+			if opt.BlobDir != "" && absBlobDir != absDir {
+				blobDirLockGuard, err = lockDirectory(opt, opt.BlobDir)
+				if err != nil {
+					return nil, err
+				}
+				defer func() {
+					if blobDirLockGuard != nil {
+						_ = blobDirLockGuard.release()
+					}
+				}()
+			}
 		}
 	}
 
@@ -243,21 +417,29 @@ func Open(opt Options) (*DB, error) {
 	}()
 
 	db := &DB{
-		imm:              make([]*memTable, 0, opt.NumMemtables),
-		flushChan:        make(chan *memTable, opt.NumMemtables),
-		writeCh:          make(chan *request, kvWriteChCapacity),
-		opt:              opt,
-		manifest:         manifestFile,
-		dirLockGuard:     dirLockGuard,
-		valueDirGuard:    valueDirLockGuard,
-		orc:              newOracle(opt),
-		pub:              newPublisher(),
-		allocPool:        z.NewAllocatorPool(8),
-		bannedNamespaces: &lockedKeys{keys: make(map[uint64]struct{})},
-		threshold:        initVlogThreshold(&opt),
+		imm:               make([]*memTable, 0, opt.NumMemtables),
+		flushChan:         make(chan *memTable, opt.NumMemtables),
+		writeCh:           make(chan *request, kvWriteChCapacity),
+		opt:               opt,
+		manifest:          manifestFile,
+		dirLockGuard:      dirLockGuard,
+		valueDirGuard:     valueDirLockGuard,
+		blobDirGuard:      blobDirLockGuard,
+		orc:               newOracle(opt),
+		pub:               newPublisher(opt.KeyReadTransform),
+		compactionLimiter: newRateLimiter(opt.CompactionRateLimit),
+		allocPool:         z.NewAllocatorPool(8),
+		bannedNamespaces:  &lockedKeys{keys: make(map[uint64]struct{})},
+		threshold:         initVlogThreshold(&opt),
 	}
 
 	db.syncChan = opt.syncChan
+	// Keep honouring DeleteBelowVersion calls made before the DB was last closed.
+	db.orc.setDeleteTs(manifest.DeleteTs)
+	y.PendingFlushesSet(opt.MetricsEnabled, opt.Dir, &db.flushQueueDepth)
+	if opt.RandSource != nil {
+		db.rand = rand.New(opt.RandSource)
+	}
 
 	// Cleanup all the goroutines started by badger in case of an error.
 	defer func() {
@@ -289,6 +471,10 @@ func Open(opt Options) (*DB, error) {
 		}
 	}
 
+	if opt.PinnedCacheSize > 0 {
+		db.blockPins = table.NewBlockPins(opt.PinnedCacheSize)
+	}
+
 	if opt.IndexCacheSize > 0 {
 		// Index size is around 5% of the table size.
 		indexSz := int64(float64(opt.MemTableSize) * 0.05)
@@ -314,10 +500,24 @@ func Open(opt Options) (*DB, error) {
 	db.closers.cacheHealth = z.NewCloser(1)
 	go db.monitorCache(db.closers.cacheHealth)
 
+	if opt.MaxConcurrentIterators > 0 {
+		db.iteratorSlots = make(chan struct{}, opt.MaxConcurrentIterators)
+	}
+	if opt.LatencyTracking {
+		db.latency = newLatencyStats()
+	}
+	if opt.HotspotKeys > 0 {
+		db.hotspots = newHotspots(opt.HotspotKeys)
+	}
+	if opt.PointReadCacheSize > 0 {
+		db.pointCache = newPointCache(opt.PointReadCacheSize, opt.PointReadCacheTTL)
+	}
+
 	if db.opt.InMemory {
 		db.opt.SyncWrites = false
 		// If badger is running in memory mode, push everything into the LSM Tree.
 		db.opt.ValueThreshold = math.MaxInt32
+		db.opt.EntrySpillThreshold = 0
 	}
 	krOpt := KeyRegistryOptions{
 		ReadOnly:                      opt.ReadOnly,
@@ -348,9 +548,38 @@ func Open(opt Options) (*DB, error) {
 	if db.lc, err = newLevelsController(db, &manifest); err != nil {
 		return db, err
 	}
+	for _, ti := range db.Tables() {
+		db.setFlushedVersion(ti.MaxVersion)
+	}
 
 	// Initialize vlog struct.
 	db.vlog.init(db)
+	if opt.BlobDir != "" {
+		db.blob = &valueLog{fidBase: blobFidBit}
+		db.blob.init(db)
+	}
+
+	if !opt.ReadOnly && opt.CompactionDropHook != nil {
+		db.dropHookCh = make(chan droppedKey, dropHookChCapacity)
+		db.closers.dropHook = z.NewCloser(1)
+		go db.runDropHook(db.closers.dropHook)
+	}
+	if !opt.ReadOnly && opt.ValueLogGCConflictHook != nil {
+		db.gcConflictCh = make(chan []byte, gcConflictChCapacity)
+		db.closers.gcConflict = z.NewCloser(1)
+		go db.runGCConflictHook(db.closers.gcConflict)
+	}
+
+	if opt.ReadRepair > 0 {
+		db.readRepair = newReadRepair(db)
+		db.closers.readRepair = z.NewCloser(1)
+		go db.readRepair.run(db.closers.readRepair)
+	}
+
+	if !opt.ReadOnly && !opt.InMemory && !opt.SyncManifest {
+		db.closers.manifest = z.NewCloser(1)
+		go db.syncManifest(db.closers.manifest)
+	}
 
 	if !opt.ReadOnly {
 		db.closers.compactors = z.NewCloser(1)
@@ -372,6 +601,11 @@ func Open(opt Options) (*DB, error) {
 	if err = db.vlog.open(db); err != nil {
 		return db, y.Wrapf(err, "During db.vlog.open")
 	}
+	if db.blob != nil {
+		if err = db.blob.open(db); err != nil {
+			return db, y.Wrapf(err, "During db.blob.open")
+		}
+	}
 
 	// Let's advance nextTxnTs to one more than whatever we observed via
 	// replaying the logs.
@@ -393,12 +627,26 @@ func Open(opt Options) (*DB, error) {
 	if !db.opt.InMemory {
 		db.closers.valueGC = z.NewCloser(1)
 		go db.vlog.waitOnGC(db.closers.valueGC)
+		if db.blob != nil {
+			db.closers.valueGC.AddRunning(1)
+			go db.blob.waitOnGC(db.closers.valueGC)
+		}
+		if !db.opt.ReadOnly && db.opt.AutoValueLogGCInterval > 0 {
+			db.closers.valueGC.AddRunning(1)
+			go db.autoValueLogGC(db.closers.valueGC)
+		}
+		if !db.opt.ReadOnly && db.opt.MaxValueLogSize > 0 {
+			db.vlogFullCh = make(chan struct{}, 1)
+			db.closers.valueGC.AddRunning(1)
+			go db.fullValueLogGC(db.closers.valueGC)
+		}
 	}
 
 	db.closers.pub = z.NewCloser(1)
 	go db.pub.listenForUpdates(db.closers.pub)
 
 	valueDirLockGuard = nil
+	blobDirLockGuard = nil
 	dirLockGuard = nil
 	manifestFile = nil
 	return db, nil
@@ -414,6 +662,8 @@ func (db *DB) initBannedNamespaces() error {
 		iopts.Prefix = bannedNsKey
 		iopts.PrefetchValues = false
 		iopts.InternalAccess = true
+		iopts.rawKeys = true
+		iopts.internal = true
 		itr := txn.NewIterator(iopts)
 		defer itr.Close()
 		for itr.Rewind(); itr.Valid(); itr.Next() {
@@ -490,6 +740,7 @@ func (db *DB) cleanup() {
 
 	db.blockCache.Close()
 	db.indexCache.Close()
+	db.blockPins.Close()
 	if db.closers.updateSize != nil {
 		db.closers.updateSize.Signal()
 	}
@@ -502,6 +753,18 @@ func (db *DB) cleanup() {
 	if db.closers.pub != nil {
 		db.closers.pub.Signal()
 	}
+	if db.closers.dropHook != nil {
+		db.closers.dropHook.Signal()
+	}
+	if db.closers.gcConflict != nil {
+		db.closers.gcConflict.Signal()
+	}
+	if db.closers.manifest != nil {
+		db.closers.manifest.Signal()
+	}
+	if db.closers.readRepair != nil {
+		db.closers.readRepair.Signal()
+	}
 
 	db.orc.Stop()
 
@@ -525,6 +788,19 @@ func (db *DB) IndexCacheMetrics() *ristretto.Metrics {
 	return nil
 }
 
+// ClearCache evicts all the entries of the block and index caches, for example to measure cold
+// cache read performance, or after a bulk load which filled the caches with blocks that won't be
+// read again. It's safe to call concurrently with reads, which miss and populate the caches again.
+func (db *DB) ClearCache() {
+	if db.IsClosed() {
+		return
+	}
+	db.cacheLock.Lock()
+	defer db.cacheLock.Unlock()
+	db.blockCache.Clear()
+	db.indexCache.Clear()
+}
+
 // Close closes a DB. It's crucial to call it to ensure all the pending updates make their way to
 // disk. Calling DB.Close() multiple times would still only close the DB once.
 func (db *DB) Close() error {
@@ -549,11 +825,17 @@ func (db *DB) close() (err error) {
 
 	db.blockWrites.Store(1)
 	db.isClosed.Store(1)
+	// Let the compactions waiting on the rate limit finish quickly.
+	db.compactionLimiter.close()
 
 	if !db.opt.InMemory {
 		// Stop value GC first.
 		db.closers.valueGC.SignalAndWait()
 	}
+	if db.closers.readRepair != nil {
+		// Read repair writes too.
+		db.closers.readRepair.SignalAndWait()
+	}
 
 	// Stop writes next.
 	db.closers.writes.SignalAndWait()
@@ -582,8 +864,8 @@ func (db *DB) close() (err error) {
 					y.AssertTrue(db.mt != nil)
 					select {
 					case db.flushChan <- db.mt:
-						db.imm = append(db.imm, db.mt) // Flusher will attempt to remove this from s.imm.
-						db.mt = nil                    // Will segfault if we try writing!
+						db.setImm(append(db.imm, db.mt)) // Flusher will attempt to remove this from s.imm.
+						db.mt = nil                      // Will segfault if we try writing!
 						db.opt.Debugf("pushed to flush chan\n")
 						return true
 					default:
@@ -618,11 +900,18 @@ func (db *DB) close() (err error) {
 			db.opt.Warningf("While forcing compaction on level 0: %v", err)
 		}
 	}
+	if db.closers.manifest != nil {
+		// Compactions are done, so sync the last of their manifest changes.
+		db.closers.manifest.SignalAndWait()
+	}
 
 	// Now close the value log.
 	if vlogErr := db.vlog.Close(); vlogErr != nil {
 		err = y.Wrap(vlogErr, "DB.Close")
 	}
+	if blobErr := db.blob.Close(); blobErr != nil && err == nil {
+		err = y.Wrap(blobErr, "DB.Close")
+	}
 
 	db.opt.Infof(db.LevelsToString())
 	if lcErr := db.lc.close(); err == nil {
@@ -630,11 +919,20 @@ func (db *DB) close() (err error) {
 	}
 	db.opt.Debugf("Waiting for closer")
 	db.closers.updateSize.SignalAndWait()
+	if db.closers.dropHook != nil {
+		// Compactions are done, so deliver the last of the dropped keys.
+		db.closers.dropHook.SignalAndWait()
+	}
+	if db.closers.gcConflict != nil {
+		db.closers.gcConflict.SignalAndWait()
+	}
 	db.orc.Stop()
 	db.blockCache.Close()
 	db.indexCache.Close()
+	db.blockPins.Close()
 
 	db.threshold.close()
+	y.PendingFlushesDelete(db.opt.MetricsEnabled, db.opt.Dir, &db.flushQueueDepth)
 
 	if db.opt.InMemory {
 		return
@@ -650,6 +948,11 @@ func (db *DB) close() (err error) {
 			err = y.Wrap(guardErr, "DB.Close")
 		}
 	}
+	if db.blobDirGuard != nil {
+		if guardErr := db.blobDirGuard.release(); err == nil {
+			err = y.Wrap(guardErr, "DB.Close")
+		}
+	}
 	if manifestErr := db.manifest.close(); err == nil {
 		err = y.Wrap(manifestErr, "DB.Close")
 	}
@@ -678,8 +981,38 @@ func (db *DB) VerifyChecksum() error {
 
 const (
 	lockFile = "LOCK"
+	// lockRetryInterval is how often Open retries to acquire a directory lock within
+	// Options.LockTimeout.
+	lockRetryInterval = 100 * time.Millisecond
 )
 
+// lockDirectory acquires the lock on dir with acquireDirectoryLock, retrying for up to
+// opt.LockTimeout while another process holds it.
+func lockDirectory(opt Options, dir string) (*directoryLockGuard, error) {
+	deadline := time.Now().Add(opt.LockTimeout)
+	for {
+		guard, err := acquireDirectoryLock(dir, lockFile, opt.ReadOnly)
+		if !errors.Is(err, ErrDirectoryLocked) || !time.Now().Before(deadline) {
+			return guard, err
+		}
+		time.Sleep(min(lockRetryInterval, time.Until(deadline)))
+	}
+}
+
+// readPidFile returns the pid written to the pid file at path by the process holding the lock,
+// or 0 if there's none.
+func readPidFile(path string) int {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(buf)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
 // Sync syncs database content to disk. This function provides
 // more control to user to sync data whenever required.
 func (db *DB) Sync() error {
@@ -714,6 +1047,9 @@ func (db *DB) Sync() error {
 	db.lock.RUnlock()
 
 	vLogSyncError := db.vlog.sync()
+	if db.blob != nil {
+		vLogSyncError = y.CombineErrors(vLogSyncError, db.blob.sync())
+	}
 	return y.CombineErrors(memtableSyncError, vLogSyncError)
 }
 
@@ -721,7 +1057,11 @@ func (db *DB) Sync() error {
 func (db *DB) getMemTables() ([]*memTable, func()) {
 	db.lock.RLock()
 	defer db.lock.RUnlock()
+	return db.getMemTablesRLocked()
+}
 
+// getMemTablesRLocked is like getMemTables, for callers which hold db.lock for reading already.
+func (db *DB) getMemTablesRLocked() ([]*memTable, func()) {
 	var tables []*memTable
 
 	// Mutable memtable does not exist in read-only mode.
@@ -759,6 +1099,11 @@ func (db *DB) getMemTables() ([]*memTable, func()) {
 // for "fooX" in all the levels of the LSM tree. This is expensive but it
 // removes the overhead of handling move keys completely.
 func (db *DB) get(key []byte) (y.ValueStruct, error) {
+	return db.getWithStats(key, nil)
+}
+
+// getWithStats is like get, and adds the tables and blocks consulted to stats, if it's not nil.
+func (db *DB) getWithStats(key []byte, stats *ReadStats) (y.ValueStruct, error) {
 	if db.IsClosed() {
 		return y.ValueStruct{}, ErrDBClosed
 	}
@@ -778,13 +1123,115 @@ func (db *DB) get(key []byte) (y.ValueStruct, error) {
 		// Found the required version of the key, return immediately.
 		if vs.Version == version {
 			y.NumGetsWithResultsAdd(db.opt.MetricsEnabled, 1)
-			return vs, nil
+			return db.hideDeleted(key, vs), nil
 		}
 		if maxVs.Version < vs.Version {
 			maxVs = vs
 		}
 	}
-	return db.lc.get(key, maxVs, 0)
+	vs, err := db.lc.get(key, maxVs, 0, stats)
+	return db.hideDeleted(key, vs), err
+}
+
+// versionsOf returns an iterator over all the versions of key in the memtables and the tables,
+// starting at the latest one, and the function to call once done with it. The iterator is nil if
+// there are no tables. Unlike the iterators of transactions, it doesn't hide any version; it
+// serves the internal reads of several versions of a key, which would take a get each otherwise.
+func (db *DB) versionsOf(key []byte) (y.Iterator, func()) {
+	opt := IteratorOptions{Prefix: key, prefixIsKey: true}
+	db.lock.RLock()
+	tables, decr := db.getMemTablesRLocked()
+	var iters []y.Iterator
+	for _, mt := range tables {
+		iters = append(iters, mt.sl.NewUniIterator(false))
+	}
+	iters = db.lc.appendIterators(iters, &opt)
+	db.lock.RUnlock()
+	itr := table.NewMergeIterator(iters, false)
+	if itr == nil {
+		return nil, decr
+	}
+	itr.Seek(y.KeyWithTs(key, math.MaxUint64))
+	return itr, func() {
+		_ = itr.Close()
+		decr()
+	}
+}
+
+// hideDeleted returns an empty value instead of vs, the value of key, if vs is at or below the
+// timestamp of DB.DeleteBelowVersion. Such versions may still be found until compactions drop
+// them, but reads must not see them. Internal keys are never deleted.
+func (db *DB) hideDeleted(key []byte, vs y.ValueStruct) y.ValueStruct {
+	if vs.Version > 0 && vs.Version <= db.orc.deleteAtOrBelow() &&
+		!bytes.HasPrefix(key, badgerPrefix) {
+		return y.ValueStruct{}
+	}
+	return vs
+}
+
+// getCached returns the latest version of key visible at readTs like getLatest, but only if that
+// can be done from memory: from the point read cache, the memtables, and the tables whose index
+// and blocks are cached. It returns false if a table would have to be read.
+func (db *DB) getCached(key []byte, readTs uint64) (y.ValueStruct, bool, error) {
+	if db.IsClosed() {
+		return y.ValueStruct{}, false, ErrDBClosed
+	}
+	if db.pointCache != nil {
+		if vs, _, ok := db.pointCache.get(key, readTs); ok {
+			return db.hideDeleted(key, vs), true, nil
+		}
+	}
+	seek := y.KeyWithTs(key, readTs)
+	tables, decr := db.getMemTables() // Lock should be released.
+	defer decr()
+
+	var maxVs y.ValueStruct
+	for _, mt := range tables {
+		vs := mt.sl.Get(seek)
+		if vs.Meta == 0 && vs.Value == nil {
+			continue
+		}
+		if vs.Version == readTs {
+			return db.hideDeleted(key, vs), true, nil
+		}
+		if maxVs.Version < vs.Version {
+			maxVs = vs
+		}
+	}
+	vs, cached, err := db.lc.getCached(seek, maxVs)
+	return db.hideDeleted(key, vs), cached, err
+}
+
+// getMany is the batch version of get. It returns the value for each of the keys, which must be
+// sorted, in the same order.
+func (db *DB) getMany(keys [][]byte) ([]y.ValueStruct, error) {
+	if db.IsClosed() {
+		return nil, ErrDBClosed
+	}
+	tables, decr := db.getMemTables() // Lock should be released.
+	defer decr()
+
+	vs := make([]y.ValueStruct, len(keys))
+	y.NumGetsAdd(db.opt.MetricsEnabled, int64(len(keys)))
+	for i, key := range keys {
+		for _, mt := range tables {
+			v := mt.sl.Get(key)
+			y.NumMemtableGetsAdd(db.opt.MetricsEnabled, 1)
+			if v.Meta == 0 && v.Value == nil {
+				continue
+			}
+			if vs[i].Version < v.Version {
+				vs[i] = v
+			}
+		}
+	}
+	if err := db.lc.getMany(keys, vs); err != nil {
+		return nil, err
+	}
+	for i := range vs {
+		vs[i] = db.hideDeleted(keys[i], vs[i])
+	}
+	return vs, nil
 }
 
 var requestPool = sync.Pool{
@@ -803,7 +1250,18 @@ func (db *DB) writeToLSM(b *request) error {
 
 	for i, entry := range b.Entries {
 		var err error
-		if entry.skipVlogAndSetThreshold(db.valueThreshold()) {
+		if entry.vptr != nil {
+			// The value is already in the value log.
+			err = db.mt.Put(entry.Key,
+				y.ValueStruct{
+					Value:     entry.vptr,
+					Meta:      entry.meta | bitValuePointer,
+					UserMeta:  entry.UserMeta,
+					ExpiresAt: entry.ExpiresAt,
+
+					UserMetaHigh: entry.userMetaHigh,
+				})
+		} else if entry.skipVlogAndSetThreshold(db.entryValueThreshold(entry.Key)) {
 			// Will include deletion / tombstone case.
 			err = db.mt.Put(entry.Key,
 				y.ValueStruct{
@@ -815,6 +1273,8 @@ func (db *DB) writeToLSM(b *request) error {
 					Meta:      entry.meta &^ bitValuePointer,
 					UserMeta:  entry.UserMeta,
 					ExpiresAt: entry.ExpiresAt,
+
+					UserMetaHigh: entry.userMetaHigh,
 				})
 		} else {
 			// Write pointer to Memtable.
@@ -824,11 +1284,16 @@ func (db *DB) writeToLSM(b *request) error {
 					Meta:      entry.meta | bitValuePointer,
 					UserMeta:  entry.UserMeta,
 					ExpiresAt: entry.ExpiresAt,
+
+					UserMetaHigh: entry.userMetaHigh,
 				})
 		}
 		if err != nil {
 			return y.Wrapf(err, "while writing to memTable")
 		}
+		if db.pointCache != nil {
+			db.pointCache.invalidate(y.ParseKey(entry.Key))
+		}
 	}
 	if db.opt.SyncWrites {
 		return db.mt.SyncWAL()
@@ -838,6 +1303,42 @@ func (db *DB) writeToLSM(b *request) error {
 
 // writeRequests is called serially by only one goroutine.
 func (db *DB) writeRequests(reqs []*request) error {
+	return db.writeRequestsInPipeline(reqs, nil, nil)
+}
+
+// writeStages tracks the progress of a batch of requests through the write pipeline. See
+// Options.CommitPipelineDepth.
+type writeStages struct {
+	vlogDone chan struct{} // Closed once the batch is written to the value log.
+	lsmDone  chan struct{} // Closed once the batch is written to the LSM tree.
+}
+
+func newWriteStages() *writeStages {
+	return &writeStages{vlogDone: make(chan struct{}), lsmDone: make(chan struct{})}
+}
+
+// writeRequestsInPipeline writes reqs like writeRequests, with the batch before it in the
+// pipeline, prev, going through each stage first, so that the value log and the LSM tree get the
+// batches in order. It reports the progress of reqs on cur. Both are nil outside of the pipeline.
+func (db *DB) writeRequestsInPipeline(reqs []*request, prev, cur *writeStages) error {
+	if cur != nil {
+		defer close(cur.lsmDone)
+	}
+	if prev != nil {
+		<-prev.vlogDone
+	}
+	var err error
+	if len(reqs) > 0 {
+		db.opt.Debugf("writeRequests called. Writing to value log")
+		err = db.writeValueLogs(reqs)
+	}
+	if cur != nil {
+		close(cur.vlogDone)
+	}
+	// The next batches may write to the value log from now on, but not to the LSM tree yet.
+	if prev != nil {
+		<-prev.lsmDone
+	}
 	if len(reqs) == 0 {
 		return nil
 	}
@@ -848,23 +1349,15 @@ func (db *DB) writeRequests(reqs []*request) error {
 			r.Wg.Done()
 		}
 	}
-	db.opt.Debugf("writeRequests called. Writing to value log")
-	err := db.vlog.write(reqs)
 	if err != nil {
 		done(err)
 		return err
 	}
 
-	db.opt.Debugf("Writing to memtable")
-	var count int
-	for _, b := range reqs {
-		if len(b.Entries) == 0 {
-			continue
-		}
-		count += len(b.Entries)
+	makeRoom := func(seal bool) error {
 		var i uint64
 		var err error
-		for err = db.ensureRoomForWrite(); err == errNoRoom; err = db.ensureRoomForWrite() {
+		for err = db.ensureRoomForWrite(seal); err == errNoRoom; err = db.ensureRoomForWrite(seal) {
 			i++
 			if i%100 == 0 {
 				db.opt.Debugf("Making room for writes")
@@ -874,7 +1367,24 @@ func (db *DB) writeRequests(reqs []*request) error {
 			// you will get a deadlock.
 			time.Sleep(10 * time.Millisecond)
 		}
-		if err != nil {
+		return err
+	}
+
+	db.opt.Debugf("Writing to memtable")
+	var count int
+	for _, b := range reqs {
+		if b.rotate {
+			if err := makeRoom(true); err != nil {
+				done(err)
+				return y.Wrap(err, "writeRequests")
+			}
+			continue
+		}
+		if len(b.Entries) == 0 {
+			continue
+		}
+		count += len(b.Entries)
+		if err := makeRoom(false); err != nil {
 			done(err)
 			return y.Wrap(err, "writeRequests")
 		}
@@ -898,12 +1408,12 @@ func (db *DB) sendToWriteCh(entries []*Entry) (*request, error) {
 	}
 	var count, size int64
 	for _, e := range entries {
-		size += e.estimateSizeAndSetThreshold(db.valueThreshold())
+		size += e.estimateSizeAndSetThreshold(db.entryValueThreshold(e.Key))
 		count++
 	}
 	y.NumBytesWrittenUserAdd(db.opt.MetricsEnabled, size)
 	if count >= db.opt.maxBatchCount || size >= db.opt.maxBatchSize {
-		return nil, ErrTxnTooBig
+		return nil, db.txnTooBig(size, count)
 	}
 
 	// We can only service one request because we need each txn to be stored in a contiguous section.
@@ -921,10 +1431,13 @@ func (db *DB) sendToWriteCh(entries []*Entry) (*request, error) {
 
 func (db *DB) doWrites(lc *z.Closer) {
 	defer lc.Done()
-	pendingCh := make(chan struct{}, 1)
+	// Up to CommitPipelineDepth batches are written at once, each one going through the stages of
+	// writeRequestsInPipeline after the one before it.
+	pendingCh := make(chan struct{}, max(db.opt.CommitPipelineDepth, 1))
+	var prev *writeStages
 
-	writeRequests := func(reqs []*request) {
-		if err := db.writeRequests(reqs); err != nil {
+	writeRequests := func(reqs []*request, prev, cur *writeStages) {
+		if err := db.writeRequestsInPipeline(reqs, prev, cur); err != nil {
 			db.opt.Errorf("writeRequests: %v", err)
 		}
 		<-pendingCh
@@ -971,13 +1484,16 @@ func (db *DB) doWrites(lc *z.Closer) {
 				reqs = append(reqs, r)
 			default:
 				pendingCh <- struct{}{} // Push to pending before doing a write.
-				writeRequests(reqs)
+				// This waits for the batches in flight.
+				writeRequests(reqs, prev, newWriteStages())
 				return
 			}
 		}
 
 	writeCase:
-		go writeRequests(reqs)
+		cur := newWriteStages()
+		go writeRequests(reqs, prev, cur)
+		prev = cur
 		reqs = make([]*request, 0, 10)
 		reqLen.Set(0)
 	}
@@ -1018,14 +1534,15 @@ func (db *DB) batchSetAsync(entries []*Entry, f func(error)) error {
 
 var errNoRoom = stderrors.New("No room for write")
 
-// ensureRoomForWrite is always called serially.
-func (db *DB) ensureRoomForWrite() error {
+// ensureRoomForWrite is always called serially. With seal set, the active memtable gets swapped
+// out as long as it isn't empty, even if it isn't full.
+func (db *DB) ensureRoomForWrite(seal bool) error {
 	var err error
 	db.lock.Lock()
 	defer db.lock.Unlock()
 
 	y.AssertTrue(db.mt != nil) // A nil mt indicates that DB is being closed.
-	if !db.mt.isFull() {
+	if !db.mt.isFull() && (!seal || db.mt.sl.Empty()) {
 		return nil
 	}
 
@@ -1034,7 +1551,7 @@ func (db *DB) ensureRoomForWrite() error {
 		db.opt.Debugf("Flushing memtable, mt.size=%d size of flushChan: %d\n",
 			db.mt.sl.MemSize(), len(db.flushChan))
 		// We manage to push this task. Let's modify imm.
-		db.imm = append(db.imm, db.mt)
+		db.setImm(append(db.imm, db.mt))
 		db.mt, err = db.newMemTable()
 		if err != nil {
 			return y.Wrapf(err, "cannot create new mem table")
@@ -1048,11 +1565,77 @@ func (db *DB) ensureRoomForWrite() error {
 }
 
 func arenaSize(opt Options) int64 {
-	return opt.MemTableSize + opt.maxBatchSize + opt.maxBatchCount*int64(skl.MaxNodeSize)
+	nodeSize := int64(skl.NodeSize(opt.SkiplistMaxHeight))
+	return opt.MemTableSize + opt.maxBatchSize + opt.maxBatchCount*nodeSize
+}
+
+// versionPruner drops the versions of the keys of a memtable being flushed which a compaction
+// would drop. See Options.PruneVersionsOnFlush. As there may be older versions of the keys in the
+// tables, the deletion markers are kept, like in a compaction into a level which overlaps the
+// levels below.
+type versionPruner struct {
+	db        *DB
+	discardTs uint64
+	// The key being flushed, the number of its versions at or below discardTs so far, and whether
+	// its remaining versions are dropped, for the given reason.
+	key      []byte
+	versions int
+	skip     bool
+	reason   DropReason
+	// Discard stats of the dropped values, for the value log.
+	discardStats map[uint32]int64
+}
+
+func (db *DB) newVersionPruner() *versionPruner {
+	return &versionPruner{
+		db:           db,
+		discardTs:    db.orc.discardAtOrBelow(),
+		discardStats: make(map[uint32]int64),
+	}
+}
+
+// drop tells whether the given version of a key is to be left out of the table. The versions of a
+// key must come from the latest to the oldest.
+func (p *versionPruner) drop(key []byte, vs y.ValueStruct) bool {
+	if !y.SameKey(key, p.key) {
+		p.key = y.SafeCopy(p.key, key)
+		p.versions = 0
+		p.skip = false
+	}
+	if p.skip {
+		if vs.Meta&bitValuePointer > 0 && !p.db.opt.InMemory {
+			var vp valuePointer
+			vp.Decode(vs.Value)
+			p.discardStats[vp.Fid] += int64(vp.Len)
+		}
+		if p.db.opt.CompactionDropHook != nil {
+			p.db.notifyDrop(key, p.reason)
+		}
+		return true
+	}
+	// Merge entries are only dropped once they are merged.
+	if y.ParseTs(key) > p.discardTs || vs.Meta&bitMergeEntry > 0 {
+		return false
+	}
+	p.versions++
+	isExpired := isDeletedOrExpired(vs.Meta, vs.ExpiresAt)
+	switch {
+	case vs.Meta&bitDelete > 0:
+		p.reason = DropTombstone
+	case isExpired:
+		p.reason = DropExpired
+	case vs.Meta&bitDiscardEarlierVersions > 0 || p.versions == p.db.opt.NumVersionsToKeep:
+		p.reason = DropVersionLimit
+	default:
+		return false
+	}
+	p.skip = true
+	return false
 }
 
 // buildL0Table builds a new table from the memtable.
-func buildL0Table(iter y.Iterator, dropPrefixes [][]byte, bopts table.Options) *table.Builder {
+func buildL0Table(iter y.Iterator, dropPrefixes [][]byte, prune *versionPruner,
+	bopts table.Options) *table.Builder {
 	defer iter.Close()
 
 	b := table.NewTableBuilder(bopts)
@@ -1060,6 +1643,13 @@ func buildL0Table(iter y.Iterator, dropPrefixes [][]byte, bopts table.Options) *
 		if len(dropPrefixes) > 0 && hasAnyPrefixes(iter.Key(), dropPrefixes) {
 			continue
 		}
+		if prune != nil && prune.drop(iter.Key(), iter.Value()) {
+			if merge := prune.db.opt.UserMetaMerge; merge != nil && prune.reason == DropVersionLimit {
+				// Like in a compaction, the last key added is the oldest version kept.
+				b.SetLastUserMeta(merge(b.LastUserMeta(), iter.Value().UserMeta))
+			}
+			continue
+		}
 		vs := iter.Value()
 		var vp valuePointer
 		if vs.Meta&bitValuePointer > 0 {
@@ -1075,7 +1665,11 @@ func buildL0Table(iter y.Iterator, dropPrefixes [][]byte, bopts table.Options) *
 func (db *DB) handleMemTableFlush(mt *memTable, dropPrefixes [][]byte) error {
 	bopts := buildTableOptions(db)
 	itr := mt.sl.NewUniIterator(false)
-	builder := buildL0Table(itr, nil, bopts)
+	var prune *versionPruner
+	if db.opt.PruneVersionsOnFlush {
+		prune = db.newVersionPruner()
+	}
+	builder := buildL0Table(itr, nil, prune, bopts)
 	defer builder.Close()
 
 	// buildL0Table can return nil if the none of the items in the skiplist are
@@ -1096,14 +1690,38 @@ func (db *DB) handleMemTableFlush(mt *memTable, dropPrefixes [][]byte) error {
 		tbl, err = table.CreateTable(table.NewFilename(fileID, db.opt.Dir), builder)
 	}
 	if err != nil {
+		db.manifest.forget(fileID)
 		return y.Wrap(err, "error while creating table")
 	}
 	// We own a ref on tbl.
 	err = db.lc.addLevel0Table(tbl) // This will incrRef
 	_ = tbl.DecrRef()               // Releases our ref.
+	if err == nil && prune != nil && len(prune.discardStats) > 0 {
+		db.updateDiscardStats(prune.discardStats)
+	}
 	return err
 }
 
+// setFlushedVersion raises the version returned by FlushedVersion to version.
+func (db *DB) setFlushedVersion(version uint64) {
+	for {
+		cur := db.flushedVersion.Load()
+		if version <= cur || db.flushedVersion.CompareAndSwap(cur, version) {
+			return
+		}
+	}
+}
+
+// FlushedVersion returns the highest version of the writes which made it from the memtables to
+// the tables. Unlike the writes still in memtables, which only survive a crash thanks to their
+// WAL, and don't survive the DB being dropped in InMemory mode, the writes at or below this version
+// are all in tables. This holds as long as the versions are written in increasing order, which is
+// always the case unless in managed mode. With FlushLargestFirst, the memtables flushed before
+// older ones only count once the older ones are flushed.
+func (db *DB) FlushedVersion() uint64 {
+	return db.flushedVersion.Load()
+}
+
 // flushMemtable must keep running until we send it an empty memtable. If there
 // are errors during handling the memtable flush, we'll retry indefinitely.
 func (db *DB) flushMemtable(lc *z.Closer) {
@@ -1114,6 +1732,9 @@ func (db *DB) flushMemtable(lc *z.Closer) {
 			continue
 		}
 
+		// There is one memtable in flushChan per memtable in db.imm, but it's not necessarily the
+		// one flushed. See Options.FlushPriority.
+		mt = db.pickMemtableToFlush()
 		for {
 			if err := db.handleMemTableFlush(mt, nil); err != nil {
 				// Encountered error. Retry indefinitely.
@@ -1124,13 +1745,19 @@ func (db *DB) flushMemtable(lc *z.Closer) {
 
 			// Update s.imm. Need a lock.
 			db.lock.Lock()
-			// This is a single-threaded operation. mt corresponds to the head of
-			// db.imm list. Once we flush it, we advance db.imm. The next mt
-			// which would arrive here would match db.imm[0], because we acquire a
-			// lock over DB when pushing to flushChan.
+			// This is a single-threaded operation. mt is the head of db.imm list, unless
+			// FlushLargestFirst picked another one. Once we flush it, we take it out of db.imm.
 			// TODO: This logic is dirty AF. Any change and this could easily break.
-			y.AssertTrue(mt == db.imm[0])
-			db.imm = db.imm[1:]
+			idx := -1
+			for i, m := range db.imm {
+				if m == mt {
+					idx = i
+					break
+				}
+			}
+			y.AssertTrue(idx == 0 || (idx > 0 && db.opt.FlushPriority == FlushLargestFirst))
+			db.setImm(append(db.imm[:idx], db.imm[idx+1:]...))
+			db.memtableFlushed(mt)
 			mt.DecrRef() // Return memory.
 			// unlock
 			db.lock.Unlock()
@@ -1139,6 +1766,63 @@ func (db *DB) flushMemtable(lc *z.Closer) {
 	}
 }
 
+// pickMemtableToFlush returns the memtable of db.imm to flush next. That's the oldest one, unless
+// FlushLargestFirst is set, in which case it's the largest one not overlapping older ones.
+func (db *DB) pickMemtableToFlush() *memTable {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	if db.opt.FlushPriority != FlushLargestFirst || len(db.imm) < 2 {
+		return db.imm[0]
+	}
+	ranges := make([]keyRange, len(db.imm))
+	for i, mt := range db.imm {
+		it := mt.sl.NewIterator()
+		it.SeekToFirst()
+		if it.Valid() {
+			ranges[i].left = y.ParseKey(it.Key())
+			it.SeekToLast()
+			ranges[i].right = y.ParseKey(it.Key())
+		}
+		_ = it.Close()
+	}
+	overlapsOlder := func(i int) bool {
+		for j := 0; j < i; j++ {
+			if len(ranges[i].left) == 0 || len(ranges[j].left) == 0 {
+				continue
+			}
+			if bytes.Compare(ranges[i].left, ranges[j].right) <= 0 &&
+				bytes.Compare(ranges[j].left, ranges[i].right) <= 0 {
+				return true
+			}
+		}
+		return false
+	}
+	best := 0
+	for i := 1; i < len(db.imm); i++ {
+		if db.imm[i].sl.MemSize() > db.imm[best].sl.MemSize() && !overlapsOlder(i) {
+			best = i
+		}
+	}
+	return db.imm[best]
+}
+
+// memtableFlushed raises the flushed version once mt has been flushed and taken out of db.imm. If
+// older memtables are still waiting to be flushed, mt is remembered until they are. Must be called
+// with db.lock held.
+func (db *DB) memtableFlushed(mt *memTable) {
+	ahead := append(db.flushedAhead, mt.maxVersion)
+	db.flushedAhead = nil
+	for _, version := range ahead {
+		// The versions of the memtables increase with their age, so the memtables below the
+		// oldest one waiting have all been flushed.
+		if len(db.imm) == 0 || version < db.imm[0].maxVersion {
+			db.setFlushedVersion(version)
+		} else {
+			db.flushedAhead = append(db.flushedAhead, version)
+		}
+	}
+}
+
 func exists(path string) (bool, error) {
 	_, err := os.Stat(path)
 	if err == nil {
@@ -1189,6 +1873,11 @@ func (db *DB) calculateSize() {
 	if db.opt.ValueDir != db.opt.Dir {
 		_, vlogSize = totalSize(db.opt.ValueDir)
 	}
+	if db.opt.BlobDir != "" {
+		// Blob log files are accounted as value log files.
+		_, blobSize := totalSize(db.opt.BlobDir)
+		vlogSize += blobSize
+	}
 	y.VlogSizeSet(db.opt.MetricsEnabled, db.opt.ValueDir, newInt(vlogSize))
 }
 
@@ -1211,6 +1900,31 @@ func (db *DB) updateSize(lc *z.Closer) {
 	}
 }
 
+// manifestSyncInterval is how often the manifest changes of compactions are synced, when
+// Options.SyncManifest isn't set.
+const manifestSyncInterval = time.Second
+
+// syncManifest periodically syncs the manifest changes of compactions, see Options.SyncManifest.
+func (db *DB) syncManifest(lc *z.Closer) {
+	defer lc.Done()
+
+	ticker := time.NewTicker(manifestSyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-lc.HasBeenClosed():
+			if err := db.manifest.sync(); err != nil {
+				db.opt.Errorf("While syncing manifest: %v", err)
+			}
+			return
+		}
+		if err := db.manifest.sync(); err != nil {
+			db.opt.Errorf("While syncing manifest: %v", err)
+		}
+	}
+}
+
 // RunValueLogGC triggers a value log garbage collection.
 //
 // It picks value log files to perform GC based on statistics that are collected
@@ -1247,7 +1961,146 @@ func (db *DB) RunValueLogGC(discardRatio float64) error {
 	}
 
 	// Pick a log file and run GC
-	return db.vlog.runGC(discardRatio)
+	return db.runValueLogGC(discardRatio)
+}
+
+// autoValueLogGC runs value log GC every Options.AutoValueLogGCInterval, for as long as there are
+// files with enough to discard, until lc is closed.
+func (db *DB) autoValueLogGC(lc *z.Closer) {
+	defer lc.Done()
+
+	ticker := time.NewTicker(db.opt.AutoValueLogGCInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-lc.HasBeenClosed():
+			return
+		case <-ticker.C:
+		}
+		for {
+			select {
+			case <-lc.HasBeenClosed():
+				return
+			default:
+			}
+			err := db.runValueLogGC(db.opt.AutoValueLogGCRatio)
+			if err == nil {
+				continue
+			}
+			if err != ErrNoRewrite && err != ErrRejected {
+				db.opt.Warningf("Automatic value log GC failed: %v", err)
+			}
+			break
+		}
+	}
+}
+
+// fullValueLogDiscardRatio is the discard ratio of the GC run when the value log reaches
+// Options.MaxValueLogSize. It is low, as freeing up any space beats failing the write.
+const fullValueLogDiscardRatio = 0.1
+
+// checkValueLogSpace returns ErrValueLogFull if a value written by txn would go to the value log
+// while it is at Options.MaxValueLogSize, and wakes up fullValueLogGC to free up space. The commit
+// doesn't wait for GC, which can take long, and needs the writes to go through. The value log is
+// only measured once per commit, and only if one of its values goes there.
+func (db *DB) checkValueLogSpace(txn *Txn) error {
+	limit := db.opt.MaxValueLogSize
+	if limit == 0 || db.opt.InMemory {
+		return nil
+	}
+	toVlog := func() bool {
+		for _, e := range txn.pendingWrites {
+			if int64(len(e.Value)) >= db.entryValueThreshold(e.Key) {
+				return true
+			}
+		}
+		for _, e := range txn.duplicateWrites {
+			if int64(len(e.Value)) >= db.entryValueThreshold(e.Key) {
+				return true
+			}
+		}
+		return false
+	}
+	if !toVlog() || db.valueLogSize() < limit {
+		return nil
+	}
+	select {
+	case db.vlogFullCh <- struct{}{}:
+	default:
+	}
+	return ErrValueLogFull
+}
+
+// fullValueLogGC runs value log GC each time a write finds the value log at
+// Options.MaxValueLogSize, until the value log is below the limit or there is nothing left to
+// rewrite, until lc is closed. The rewrites of GC don't go through checkValueLogSpace, so they
+// can't be rejected by it.
+func (db *DB) fullValueLogGC(lc *z.Closer) {
+	defer lc.Done()
+
+	for {
+		select {
+		case <-lc.HasBeenClosed():
+			return
+		case <-db.vlogFullCh:
+		}
+		for db.valueLogSize() >= db.opt.MaxValueLogSize {
+			select {
+			case <-lc.HasBeenClosed():
+				return
+			default:
+			}
+			err := db.runValueLogGC(fullValueLogDiscardRatio)
+			if err == nil {
+				continue
+			}
+			if err != ErrNoRewrite && err != ErrRejected {
+				db.opt.Warningf("Value log GC at MaxValueLogSize failed: %v", err)
+			}
+			break
+		}
+	}
+}
+
+// valueLogSize returns the number of bytes written to the value log and the blob log.
+func (db *DB) valueLogSize() int64 {
+	sz := db.vlog.size()
+	if db.blob != nil {
+		sz += db.blob.size()
+	}
+	return sz
+}
+
+// KeysInVlogFile calls fn for the latest version of every live key whose value is stored in the
+// value log file with the given ID, in key order. Files of the blob log are identified by their ID
+// with the high bit (1 << 31) set. This tells which keys would be lost if that file got corrupted,
+// so they can be rewritten ahead of time. The scan reads the LSM tree only, at the latest read
+// timestamp. The item passed to fn is only valid until fn returns. If fn returns an error, the
+// scan stops and that error is returned.
+func (db *DB) KeysInVlogFile(fid uint32, fn func(item *Item) error) error {
+	return db.View(func(txn *Txn) error {
+		opt := DefaultIteratorOptions
+		opt.PrefetchValues = false
+		opt.internal = true
+		it := txn.NewIterator(opt)
+		defer it.Close()
+
+		var vp valuePointer
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			if item.meta&bitValuePointer == 0 {
+				continue
+			}
+			vp.Decode(item.vptr)
+			if vp.Fid != fid {
+				continue
+			}
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
 // Size returns the size of lsm and value log files in bytes. It can be used to decide how often to
@@ -1326,7 +2179,7 @@ func (seq *Sequence) updateLease() error {
 	return seq.db.Update(func(txn *Txn) error {
 		item, err := txn.Get(seq.key)
 		switch {
-		case err == ErrKeyNotFound:
+		case errors.Is(err, ErrKeyNotFound):
 			seq.next = 0
 		case err != nil:
 			return err
@@ -1391,6 +2244,111 @@ func (db *DB) Levels() []LevelInfo {
 	return db.lc.getLevelInfo()
 }
 
+// RotateMemtable makes the active memtable immutable, and starts a new one, so that the writes
+// committed before it was called are all in immutable memtables or tables, and the writes committed
+// after it returns all go to the new memtable. It goes through the write path like a commit, so it
+// waits for the writes queued before it, but not for the memtable to be flushed, which happens in
+// the background like for a full memtable. It waits for room if as many memtables as
+// Options.NumMemtables are already waiting to be flushed. If the active memtable is empty, it's
+// kept.
+func (db *DB) RotateMemtable() error {
+	if db.opt.ReadOnly {
+		return errors.New("Cannot rotate the memtable in read-only mode")
+	}
+	if db.IsClosed() {
+		return ErrDBClosed
+	}
+	if db.blockWrites.Load() == 1 {
+		return ErrBlockedWrites
+	}
+	req := requestPool.Get().(*request)
+	req.reset()
+	req.rotate = true
+	req.Wg.Add(1)
+	req.IncrRef()     // for db write
+	db.writeCh <- req // Handled in doWrites.
+	return req.Wait()
+}
+
+// FlushQueueDepth returns the number of memtables which are full and wait to be flushed to level
+// 0, including the one being flushed. Writes stall once Options.NumMemtables memtables wait, so a
+// depth which keeps rising warns that writes outpace the flushes. It is exported as the
+// badger_flush_pending_num_memtable metric too.
+func (db *DB) FlushQueueDepth() int {
+	return int(db.flushQueueDepth.Value())
+}
+
+// setImm sets the memtables waiting to be flushed. Must be called with the lock held.
+func (db *DB) setImm(imm []*memTable) {
+	db.imm = imm
+	db.flushQueueDepth.Set(int64(len(imm)))
+}
+
+// CompactionDebt returns an estimate of the bytes that compactions have to rewrite to bring all
+// the levels under their target sizes. A debt which keeps growing means that writes outpace the
+// compactions, and more compactors, or fewer writes, are needed.
+func (db *DB) CompactionDebt() int64 {
+	return db.lc.compactionDebt()
+}
+
+// CompressionStats returns the size of the data blocks of all the tables before compression, their
+// size on disk, and the compression ratio, which is the former divided by the latter. The ratio is
+// zero if there are no tables. The memtables, the table indexes and the value log are not counted.
+// See Levels and Tables for the sizes per level and per table.
+func (db *DB) CompressionStats() (ratio float64, uncompressedBytes, compressedBytes int64) {
+	for _, l := range db.Levels() {
+		uncompressedBytes += l.UncompressedSize
+		compressedBytes += l.BlocksSize
+	}
+	if compressedBytes > 0 {
+		ratio = float64(uncompressedBytes) / float64(compressedBytes)
+	}
+	return ratio, uncompressedBytes, compressedBytes
+}
+
+// KeyLevel returns the level of the LSM tree which holds the newest version of key. Keys in the
+// memtables are reported at level 0, like the keys in the tables of L0. A key found deep in the
+// tree takes longer to read, as every level above it is searched first. found is false if the key
+// doesn't exist, or if its newest version is deleted or expired, as for Txn.Get; level is then the
+// level of that newest version, if there's one.
+func (db *DB) KeyLevel(key []byte) (level int, found bool, err error) {
+	if len(key) == 0 {
+		return 0, false, ErrEmptyKey
+	}
+	if db.IsClosed() {
+		return 0, false, ErrDBClosed
+	}
+	seek := y.KeyWithTs(db.storedKey(key), math.MaxUint64)
+	tables, decr := db.getMemTables()
+	defer decr()
+
+	var newest y.ValueStruct
+	var exists bool
+	for _, mt := range tables {
+		vs := mt.sl.Get(seek)
+		if vs.Meta == 0 && vs.Value == nil {
+			continue
+		}
+		if !exists || vs.Version > newest.Version {
+			newest, exists = vs, true
+		}
+	}
+	// Levels are searched from the top, like in levelsController.get.
+	for _, h := range db.lc.levels {
+		vs, err := h.get(seek, nil)
+		if err != nil {
+			return 0, false, y.Wrapf(err, "get key: %q", key)
+		}
+		if vs.Meta == 0 && vs.Value == nil {
+			continue
+		}
+		if !exists || vs.Version > newest.Version {
+			newest, exists, level = vs, true, h.level
+		}
+	}
+	return level, exists && !isDeletedOrExpired(newest.Meta, newest.ExpiresAt), nil
+}
+
 // EstimateSize can be used to get rough estimate of data size for a given prefix.
 func (db *DB) EstimateSize(prefix []byte) (uint64, uint64) {
 	var onDiskSize, uncompressedSize uint64
@@ -1404,6 +2362,107 @@ func (db *DB) EstimateSize(prefix []byte) (uint64, uint64) {
 	return onDiskSize, uncompressedSize
 }
 
+// RangeSize can be used to get a rough estimate of the bytes on disk occupied by the keys in the
+// range [start, end), without iterating over the range. An empty end means that the range has no
+// upper bound.
+//
+// The estimate sums up the table blocks overlapping with the range. Each table also records the
+// total size of the value log entries its keys point to, and the share of that which is
+// proportional to the overlapping blocks gets added too. Data which hasn't been flushed from the
+// memtables yet is not counted.
+func (db *DB) RangeSize(start, end []byte) (int64, error) {
+	if db.IsClosed() {
+		return 0, ErrDBClosed
+	}
+	if len(end) > 0 && bytes.Compare(start, end) >= 0 {
+		return 0, ErrInvalidRequest
+	}
+	var endKey []byte
+	if len(end) > 0 {
+		endKey = y.KeyWithTs(end, math.MaxUint64)
+	}
+	lsm, vlog := db.lc.rangeSize(y.KeyWithTs(start, math.MaxUint64), endKey)
+	return lsm + vlog, nil
+}
+
+// WarmCache reads the table blocks holding the keys in the range [start, end) into the block
+// cache, and the table indices with their bloom filters into the index cache, so that the reads
+// which follow don't have to go to disk. It's meant to be called after Open for the key ranges
+// known to be hot, to avoid the latency spike of reading them with cold caches. An empty end means
+// that the range has no upper bound.
+//
+// The levels are warmed from the top, as they hold the latest versions of the keys. WarmCache
+// stops once it loaded as many bytes as fit into the block cache, since loading more would only
+// evict the blocks it loaded before. It returns ctx.Err() if ctx is done before it finishes. Data
+// which hasn't been flushed from the memtables is already in memory and isn't affected.
+func (db *DB) WarmCache(ctx context.Context, start, end []byte) error {
+	if db.IsClosed() {
+		return ErrDBClosed
+	}
+	if len(end) > 0 && bytes.Compare(start, end) >= 0 {
+		return ErrInvalidRequest
+	}
+	var endKey []byte
+	if len(end) > 0 {
+		endKey = y.KeyWithTs(end, math.MaxUint64)
+	}
+	warmed, err := db.lc.warmCache(ctx, y.KeyWithTs(start, math.MaxUint64), endKey,
+		db.opt.BlockCacheSize)
+	db.opt.Infof("Warmed up the block cache with %s for the range [%q, %q)",
+		humanize.IBytes(uint64(warmed)), start, end)
+	return err
+}
+
+// PinRange keeps the table blocks holding the keys in the range [start, end) in memory, so that
+// reads of the range never miss the cache, even while big scans churn through the block cache.
+// The blocks are read right away. Blocks of the range written later, for example by compactions,
+// get pinned once they are read. An empty end means that the range has no upper bound.
+//
+// The pinned blocks don't count against the block cache, but against Options.PinnedCacheSize. If
+// the blocks of the range don't fit into what's left of it, the range isn't pinned and
+// table.ErrPinBudgetExceeded is returned. Blocks written later which don't fit are cached as
+// usual. Ranges stay pinned until UnpinRange is called or the DB is closed.
+func (db *DB) PinRange(start, end []byte) error {
+	if db.IsClosed() {
+		return ErrDBClosed
+	}
+	if db.blockPins == nil {
+		return errors.New("Cannot pin a range with PinnedCacheSize set to zero")
+	}
+	if len(end) > 0 && bytes.Compare(start, end) >= 0 {
+		return ErrInvalidRequest
+	}
+	startKey, endKey := pinRangeKeys(start, end)
+	db.blockPins.AddRange(startKey, endKey)
+	if err := db.lc.pinRange(startKey, endKey); err != nil {
+		db.blockPins.RemoveRange(startKey, endKey)
+		return err
+	}
+	return nil
+}
+
+// UnpinRange releases the blocks pinned by PinRange for the range [start, end), unless they hold
+// keys of another pinned range. It returns ErrInvalidRequest if the range wasn't pinned with the
+// same start and end.
+func (db *DB) UnpinRange(start, end []byte) error {
+	if db.blockPins == nil {
+		return ErrInvalidRequest
+	}
+	if !db.blockPins.RemoveRange(pinRangeKeys(start, end)) {
+		return ErrInvalidRequest
+	}
+	return nil
+}
+
+// pinRangeKeys returns the keys with timestamps for the range [start, end) of PinRange.
+func pinRangeKeys(start, end []byte) ([]byte, []byte) {
+	var endKey []byte
+	if len(end) > 0 {
+		endKey = y.KeyWithTs(end, math.MaxUint64)
+	}
+	return y.KeyWithTs(start, math.MaxUint64), endKey
+}
+
 // Ranges can be used to get rough key ranges to divide up iteration over the DB. The ranges here
 // would consider the prefix, but would not necessarily start or end with the prefix. In fact, the
 // first range would have nil as left key, and the last range would have nil as the right key.
@@ -1564,6 +2623,15 @@ func (db *DB) startMemoryFlush() {
 	}
 }
 
+// SetCompactionRateLimit changes the limit of bytes per second read and written by compactions,
+// see Options.WithCompactionRateLimit. Zero removes the limit. Negative values are ignored.
+func (db *DB) SetCompactionRateLimit(bytesPerSec int64) {
+	if bytesPerSec < 0 || db.IsClosed() {
+		return
+	}
+	db.compactionLimiter.setRate(bytesPerSec)
+}
+
 // Flatten can be used to force compactions on the LSM tree so all the tables fall on the same
 // level. This ensures that all the versions of keys are colocated and not split across multiple
 // levels, which is necessary after a restore from backup. During Flatten, live compactions are
@@ -1637,6 +2705,67 @@ func (db *DB) Flatten(workers int) error {
 	}
 }
 
+// GCCompact rewrites the tables whose fraction of stale data (older versions, tombstones and
+// expired entries that can be discarded) exceeds staleFraction, without merging them with the
+// level below. This reclaims space with far less write amplification than a regular compaction,
+// which also rewrites the live data of the overlapping tables. Only versions at or below the
+// discard timestamp are dropped. Live compactions are stopped while GCCompact runs. It returns
+// the number of bytes reclaimed.
+func (db *DB) GCCompact(staleFraction float64) (int64, error) {
+	if staleFraction < 0 || staleFraction >= 1 {
+		return 0, errors.Errorf("Invalid staleFraction %v, must be in range [0, 1)", staleFraction)
+	}
+	if db.opt.ReadOnly {
+		return 0, errors.New("Cannot run GCCompact in read-only mode")
+	}
+
+	db.stopCompactions()
+	defer db.startCompactions()
+
+	reclaimed, err := db.lc.gcCompact(staleFraction)
+	if err != nil {
+		return reclaimed, y.Wrapf(err, "while running GCCompact")
+	}
+	db.opt.Infof("GCCompact reclaimed %s\n", humanize.IBytes(uint64(max(reclaimed, 0))))
+	return reclaimed, nil
+}
+
+// PurgeTombstones physically removes the tombstones of the keys with the given prefix whose
+// version is below beforeVersion, along with the older versions of the keys they delete. Once the
+// consumers of the deletes, such as the subscribers of DB.Subscribe, have seen all the versions
+// below beforeVersion, the tombstones are no longer needed, but compactions only drop them once
+// they reach the last level. PurgeTombstones compacts the tables holding keys with the prefix
+// down to the last level right away, like a regular compaction does level by level, so that their
+// tombstones get dropped. Tombstones at or above beforeVersion are kept, and so are the versions
+// which are still visible to running transactions. Tombstones still in the memtables aren't
+// purged. Live compactions are stopped while PurgeTombstones runs.
+func (db *DB) PurgeTombstones(prefix []byte, beforeVersion uint64) error {
+	if db.opt.ReadOnly {
+		return errors.New("Cannot run PurgeTombstones in read-only mode")
+	}
+	if db.IsClosed() {
+		return ErrDBClosed
+	}
+	if beforeVersion <= 1 {
+		// No version is below 1.
+		return nil
+	}
+
+	kr := infRange
+	if len(prefix) > 0 {
+		kr = keyRange{left: y.KeyWithTs(prefix, math.MaxUint64)}
+		if end := prefixSuccessor(prefix); end != nil {
+			kr.right = y.KeyWithTs(end, math.MaxUint64)
+		} else {
+			kr.inf = true
+		}
+	}
+
+	db.stopCompactions()
+	defer db.startCompactions()
+	return y.Wrapf(db.lc.purgeTombstones(kr, beforeVersion-1), "while purging tombstones")
+}
+
 func (db *DB) blockWrite() error {
 	// Stop accepting new writes.
 	if !db.blockWrites.CompareAndSwap(0, 1) {
@@ -1728,7 +2857,7 @@ func (db *DB) dropAll() (func(), error) {
 	for _, mt := range db.imm {
 		mt.DecrRef()
 	}
-	db.imm = db.imm[:0]
+	db.setImm(db.imm[:0])
 	db.mt, err = db.newMemTable() // Set it up for future writes.
 	if err != nil {
 		return resume, y.Wrapf(err, "cannot open new memtable")
@@ -1741,6 +2870,11 @@ func (db *DB) dropAll() (func(), error) {
 	db.opt.Infof("Deleted %d SSTables. Now deleting value logs...\n", num)
 
 	num, err = db.vlog.dropAll()
+	if err == nil && db.blob != nil {
+		var n int
+		n, err = db.blob.dropAll()
+		num += n
+	}
 	if err != nil {
 		return resume, err
 	}
@@ -1749,6 +2883,9 @@ func (db *DB) dropAll() (func(), error) {
 	db.blockCache.Clear()
 	db.indexCache.Clear()
 	db.threshold.Clear(db.opt)
+	if db.pointCache != nil {
+		db.pointCache.clear()
+	}
 	return resume, nil
 }
 
@@ -1763,6 +2900,10 @@ func (db *DB) dropAll() (func(), error) {
 //   - Compact L0->L1, skipping over Kp.
 //   - Compact rest of the levels, Li->Li, picking tables which have Kp.
 //   - Resume memtable flushes, compactions and writes.
+//
+// Dropping isn't versioned, so it takes effect at every read timestamp. Iterators created before
+// DropPrefix keep returning the dropped keys, with their values, until they are closed. Lookups
+// and iterators created afterwards don't find them, even in transactions started before.
 func (db *DB) DropPrefix(prefixes ...[]byte) error {
 	if len(prefixes) == 0 {
 		return nil
@@ -1787,7 +2928,7 @@ func (db *DB) DropPrefix(prefixes ...[]byte) error {
 	db.lock.Lock()
 	defer db.lock.Unlock()
 
-	db.imm = append(db.imm, db.mt)
+	db.setImm(append(db.imm, db.mt))
 	for _, memtable := range db.imm {
 		if memtable.sl.Empty() {
 			memtable.DecrRef()
@@ -1798,11 +2939,12 @@ func (db *DB) DropPrefix(prefixes ...[]byte) error {
 			db.opt.Errorf("While trying to flush memtable: %v", err)
 			return err
 		}
+		db.setFlushedVersion(memtable.maxVersion)
 		memtable.DecrRef()
 	}
 	db.stopCompactions()
 	defer db.startCompactions()
-	db.imm = db.imm[:0]
+	db.setImm(db.imm[:0])
 	db.mt, err = db.newMemTable()
 	if err != nil {
 		return y.Wrapf(err, "cannot create new mem table")
@@ -1812,10 +2954,53 @@ func (db *DB) DropPrefix(prefixes ...[]byte) error {
 	if err := db.lc.dropPrefixes(filtered); err != nil {
 		return err
 	}
+	if db.pointCache != nil {
+		db.pointCache.clear()
+	}
 	db.opt.Infof("DropPrefix done")
 	return nil
 }
 
+// DropPrefixOptions holds the options of DropPrefixWithOptions.
+type DropPrefixOptions struct {
+	// CompactAfter makes DropPrefixWithOptions also reclaim the space held by the dropped values
+	// in the value log, by rewriting the value log files in which they made up at least
+	// DiscardRatio of the file size. The tables holding the prefixes are always rewritten by
+	// DropPrefix.
+	CompactAfter bool
+	// DiscardRatio is the discard ratio passed to value log GC when CompactAfter is set. See
+	// RunValueLogGC. The default value is 0.5.
+	DiscardRatio float64
+}
+
+// DropPrefixWithOptions is like DropPrefix, but with options. With CompactAfter set, the value log
+// files holding dropped values get rewritten once the prefixes are dropped. That happens after
+// writes and compactions are resumed, so it doesn't block operations on other keys. The rewrite is
+// skipped in InMemory mode; ErrRejected is returned if another value log GC is already running.
+func (db *DB) DropPrefixWithOptions(opt DropPrefixOptions, prefixes ...[]byte) error {
+	if opt.DiscardRatio == 0 {
+		opt.DiscardRatio = 0.5
+	}
+	if opt.DiscardRatio >= 1.0 || opt.DiscardRatio < 0.0 {
+		return ErrInvalidRequest
+	}
+	if err := db.DropPrefix(prefixes...); err != nil {
+		return err
+	}
+	if !opt.CompactAfter || db.opt.InMemory {
+		return nil
+	}
+	for {
+		switch err := db.runValueLogGC(opt.DiscardRatio); err {
+		case nil:
+		case ErrNoRewrite:
+			return nil
+		default:
+			return err
+		}
+	}
+}
+
 func (db *DB) filterPrefixesToDrop(prefixes [][]byte) ([][]byte, error) {
 	var filtered [][]byte
 	for _, prefix := range prefixes {
@@ -1823,6 +3008,8 @@ func (db *DB) filterPrefixesToDrop(prefixes [][]byte) ([][]byte, error) {
 			iopts := DefaultIteratorOptions
 			iopts.Prefix = prefix
 			iopts.PrefetchValues = false
+			iopts.rawKeys = true
+			iopts.internal = true
 			itr := txn.NewIterator(iopts)
 			defer itr.Close()
 			itr.Rewind()
@@ -1897,6 +3084,15 @@ func (db *DB) Subscribe(ctx context.Context, cb func(kv *KVList) error, matches
 		return ErrNilCallback
 	}
 
+	if db.opt.KeyWriteTransform != nil {
+		stored := make([]pb.Match, len(matches))
+		for i := range matches {
+			stored[i].Prefix = db.opt.KeyWriteTransform(matches[i].Prefix)
+			stored[i].IgnoreBytes = matches[i].IgnoreBytes
+		}
+		matches = stored
+	}
+
 	c := z.NewCloser(1)
 	s, err := db.pub.newSubscriber(c, matches)
 	if err != nil {
@@ -1967,7 +3163,11 @@ func (db *DB) syncDir(dir string) error {
 }
 
 func createDirs(opt Options) error {
-	for _, path := range []string{opt.Dir, opt.ValueDir} {
+	paths := []string{opt.Dir, opt.ValueDir}
+	if opt.BlobDir != "" {
+		paths = append(paths, opt.BlobDir)
+	}
+	for _, path := range paths {
 		dirExists, err := exists(path)
 		if err != nil {
 			return y.Wrapf(err, "Invalid Dir: %q", path)
@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/binary"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -29,6 +30,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -1135,3 +1137,91 @@ func TestAssertValueLogIsNotWrittenToOnStartup(t *testing.T) {
 	assertOnReadDb(db)
 	require.Equal(t, latestVLogFileSize(db, db.vlog.maxFid), vLogFileSize)
 }
+
+func TestLatencyStats(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		require.Nil(t, db.LatencyStats())
+	})
+
+	opt := getTestOptions("").WithLatencyTracking(true).WithValueThreshold(16)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		for i := 0; i < 10; i++ {
+			require.NoError(t, db.Update(func(txn *Txn) error {
+				return txn.Set([]byte(fmt.Sprintf("key%d", i)), bytes.Repeat([]byte("v"), 32))
+			}))
+		}
+		require.NoError(t, db.View(func(txn *Txn) error {
+			item, err := txn.Get([]byte("key1"))
+			require.NoError(t, err)
+			_, err = item.ValueCopy(nil)
+			require.NoError(t, err)
+
+			it := txn.NewIterator(IteratorOptions{})
+			defer it.Close()
+			for it.Rewind(); it.Valid(); it.Next() {
+			}
+			return nil
+		}))
+
+		stats := db.LatencyStats()
+		require.Len(t, stats, 4)
+		for name, count := range map[string]int64{
+			LatencyGet: 1, LatencyCommit: 10, LatencyIteratorNext: 10, LatencyVlogRead: 1,
+		} {
+			h := stats[name]
+			require.Equal(t, count, h.Count, name)
+			var sum int64
+			for _, c := range h.Counts {
+				sum += c
+			}
+			require.Equal(t, count, sum, name)
+			require.LessOrEqual(t, h.Min, h.Mean(), name)
+			require.LessOrEqual(t, h.Mean(), h.Max, name)
+			require.LessOrEqual(t, h.Percentile(0.5), h.Percentile(0.99), name)
+			require.LessOrEqual(t, h.Percentile(0.99), h.Max, name)
+		}
+	})
+}
+
+func TestWritePrometheusMetrics(t *testing.T) {
+	opt := getTestOptions("").WithMetricsEnabled(true).WithLatencyTracking(true)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set([]byte("key"), []byte("val"))
+		}))
+
+		var buf bytes.Buffer
+		require.NoError(t, db.WritePrometheusMetrics(&buf))
+
+		sampleRe := regexp.MustCompile(`^(badger_[a-z0-9_]+)(\{[^}]*\})? [-+0-9.e]+$`)
+		typed := make(map[string]bool)
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			if strings.HasPrefix(line, "# TYPE ") {
+				fields := strings.Fields(line)
+				require.Len(t, fields, 4, line)
+				typed[fields[2]] = true
+				continue
+			}
+			if strings.HasPrefix(line, "# HELP ") {
+				continue
+			}
+			m := sampleRe.FindStringSubmatch(line)
+			require.NotNil(t, m, "malformed line: %q", line)
+			name := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(m[1],
+				"_bucket"), "_sum"), "_count")
+			require.True(t, typed[name], "no TYPE for %q", line)
+		}
+
+		out := buf.String()
+		require.Contains(t, out, "badger_put_num_user ")
+		require.Contains(t, out, `badger_size_bytes_level{level="0"} `)
+		require.Contains(t, out, `badger_latency_seconds_bucket{op="commit",le="+Inf"} 1`)
+		require.Contains(t, out, `badger_latency_seconds_count{op="commit"} 1`)
+
+		require.Error(t, db.WritePrometheusMetrics(errWriter{}))
+	})
+}
+
+type errWriter struct{}
+
+func (errWriter) Write([]byte) (int, error) { return 0, errors.New("write failed") }
@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/binary"
+	"errors"
 	"flag"
 	"fmt"
 	"math"
@@ -29,6 +30,7 @@ import (
 	"runtime"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -36,6 +38,7 @@ import (
 
 	"github.com/0xEggTart/badger/options"
 	"github.com/0xEggTart/badger/pb"
+	"github.com/0xEggTart/badger/table"
 	"github.com/0xEggTart/badger/y"
 	"github.com/dgraph-io/ristretto/v2/z"
 )
@@ -493,6 +496,84 @@ func TestStreamDB(t *testing.T) {
 	check(outDB)
 }
 
+func TestCompactTo(t *testing.T) {
+	opt := getTestOptions("")
+	opt.ValueThreshold = 32
+	opt.WideUserMeta = true
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		set := func(from, to int, val string) {
+			require.NoError(t, db.Update(func(txn *Txn) error {
+				for i := from; i < to; i++ {
+					v := []byte(fmt.Sprintf("%s-%064d", val, i))
+					if err := txn.Set([]byte(key("key", i)), v); err != nil {
+						return err
+					}
+				}
+				return nil
+			}))
+		}
+		set(0, 100, "v1")
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.SetEntry(NewEntry([]byte("meta"), []byte("v1")).WithUserMeta16(0xab01))
+		}))
+		set(0, 50, "v2")
+		for i := 90; i < 100; i++ {
+			txnDelete(t, db, []byte(key("key", i)))
+		}
+		// The reader keeps the discard timestamp at or below version.
+		reader := db.NewTransaction(false)
+		version := reader.readTs
+		set(0, 10, "v3")
+
+		dir, err := os.MkdirTemp("", "badger-test")
+		require.NoError(t, err)
+		defer removeDir(dir)
+		require.Error(t, db.CompactTo(filepath.Dir(dir), CompactToOptions{}))
+		// The versions below the discard timestamp may be gone.
+		require.NoError(t, db.orc.readMark.WaitForMark(context.Background(), version-1))
+		require.Error(t, db.CompactTo(dir, CompactToOptions{Version: 1}))
+		require.NoError(t, db.CompactTo(dir, CompactToOptions{Version: version}))
+		reader.Discard()
+
+		out, err := Open(getTestOptions(dir))
+		require.NoError(t, err)
+		defer func() { require.NoError(t, out.Close()) }()
+		for _, tbl := range out.Tables() {
+			require.Equal(t, out.opt.MaxLevels-1, tbl.Level)
+		}
+		require.NoError(t, out.View(func(txn *Txn) error {
+			opt := DefaultIteratorOptions
+			opt.AllVersions = true
+			it := txn.NewIterator(opt)
+			defer it.Close()
+			i := 0
+			for it.Rewind(); it.Valid() && it.ValidForPrefix([]byte("key")); it.Next() {
+				require.Equal(t, key("key", i), string(it.Item().Key()))
+				expected := "v1"
+				if i < 50 {
+					expected = "v2"
+				}
+				require.Equal(t, expected, string(getItemValue(t, it.Item())[:2]))
+				i++
+			}
+			require.Equal(t, 90, i)
+			// The user meta is copied, all 16 bits of it.
+			require.True(t, it.Valid())
+			require.Equal(t, "meta", string(it.Item().Key()))
+			require.Equal(t, uint16(0xab01), it.Item().UserMeta16())
+			return nil
+		}))
+
+		// The source DB is left as it is.
+		require.NoError(t, db.View(func(txn *Txn) error {
+			item, err := txn.Get([]byte(key("key", 0)))
+			require.NoError(t, err)
+			require.Equal(t, "v3", string(getItemValue(t, item)[:2]))
+			return nil
+		}))
+	})
+}
+
 func dirSize(path string) (int64, error) {
 	var size int64
 	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
@@ -1824,6 +1905,167 @@ func TestReadOnly(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestLockTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip("directory locking reports no owner pid on this platform")
+	}
+	dir, err := os.MkdirTemp("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	db, err := Open(getTestOptions(dir))
+	require.NoError(t, err)
+
+	// A second open gives up once the timeout expires and names the owner.
+	start := time.Now()
+	_, err = Open(getTestOptions(dir).WithLockTimeout(200 * time.Millisecond))
+	require.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+	require.True(t, errors.Is(err, ErrDirectoryLocked))
+	var lockErr *DirectoryLockedError
+	require.True(t, errors.As(err, &lockErr))
+	require.Equal(t, os.Getpid(), lockErr.PID)
+
+	// It succeeds if the owner releases the directory before the timeout.
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		require.NoError(t, db.Close())
+	}()
+	db2, err := Open(getTestOptions(dir).WithLockTimeout(5 * time.Second))
+	require.NoError(t, err)
+	require.NoError(t, db2.Close())
+}
+
+func TestFlushQueueDepth(t *testing.T) {
+	dir, err := os.MkdirTemp("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	db, err := Open(getTestOptions(dir))
+	require.NoError(t, err)
+	require.Equal(t, 0, db.FlushQueueDepth())
+	metric := y.PendingFlushesGet(true, dir)
+	require.Equal(t, "0", metric.String())
+
+	// Queue the memtables by hand so that the flush goroutine can't drain them.
+	db.lock.Lock()
+	imm := db.imm
+	db.setImm(append(db.imm, &memTable{}, &memTable{}))
+	db.lock.Unlock()
+	require.Equal(t, 2, db.FlushQueueDepth())
+	require.Equal(t, "2", metric.String())
+
+	db.lock.Lock()
+	db.setImm(imm)
+	db.lock.Unlock()
+	require.Equal(t, 0, db.FlushQueueDepth())
+
+	// The metric doesn't keep the DB once closed.
+	require.NoError(t, db.Close())
+	require.Nil(t, y.PendingFlushesGet(true, dir))
+}
+
+func TestWideUserMeta(t *testing.T) {
+	dir, err := os.MkdirTemp("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opt := getTestOptions(dir)
+	opt.ValueThreshold = 32
+
+	manifestVersion := func() uint16 {
+		buf, err := os.ReadFile(filepath.Join(dir, ManifestFilename))
+		require.NoError(t, err)
+		return binary.BigEndian.Uint16(buf[6:8])
+	}
+	small, big := []byte("small"), bytes.Repeat([]byte("big"), 30)
+	check := func(db *DB) {
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for k, want := range map[string]uint16{"old": 7, "small": 0x1234, "big": 0xab01} {
+				item, err := txn.Get([]byte(k))
+				require.NoError(t, err)
+				require.Equal(t, want, item.UserMeta16(), k)
+				require.Equal(t, byte(want), item.UserMeta(), k)
+			}
+			item, err := txn.Get([]byte("big"))
+			require.NoError(t, err)
+			require.Equal(t, big, getItemValue(t, item))
+			return nil
+		}))
+		_, err := db.ChangesSince(0, func(c Change) error {
+			if string(c.Key) == "big" {
+				require.Equal(t, uint16(0xab01), c.UserMeta)
+			}
+			return nil
+		})
+		require.NoError(t, err)
+	}
+
+	db, err := Open(opt)
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		return txn.SetEntry(NewEntry([]byte("old"), []byte("v")).WithMeta(7))
+	}))
+	err = db.Update(func(txn *Txn) error {
+		return txn.SetEntry(NewEntry([]byte("k"), []byte("v")).WithUserMeta16(0x1234))
+	})
+	require.ErrorIs(t, err, ErrWideUserMeta)
+	require.NoError(t, db.Close())
+	require.Equal(t, uint16(badgerMagicVersion), manifestVersion())
+
+	opt = opt.WithWideUserMeta(true)
+	db, err = Open(opt)
+	require.NoError(t, err)
+	require.Equal(t, uint16(badgerMagicVersionWideUserMeta), manifestVersion())
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		require.NoError(t, txn.SetEntry(NewEntry(small, []byte("v")).WithUserMeta16(0x1234)))
+		return txn.SetEntry(NewEntry([]byte("big"), big).WithUserMeta16(0xab01))
+	}))
+	// Read from the memtable, then from the tables after a reopen.
+	check(db)
+	require.NoError(t, db.Close())
+	db, err = Open(opt.WithWideUserMeta(false))
+	require.NoError(t, err)
+	require.Equal(t, uint16(badgerMagicVersionWideUserMeta), manifestVersion())
+	check(db)
+
+	var bb bytes.Buffer
+	_, err = db.Backup(&bb, 0)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		require.ErrorIs(t, db.Load(bytes.NewReader(bb.Bytes()), 16), ErrWideUserMeta)
+	})
+	opt = getTestOptions("").WithWideUserMeta(true)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Load(bytes.NewReader(bb.Bytes()), 16))
+		check(db)
+	})
+}
+
+func TestRotateMemtable(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		memFid := func() int {
+			db.lock.RLock()
+			defer db.lock.RUnlock()
+			require.True(t, db.mt.sl.Empty())
+			return db.nextMemFid
+		}
+		// An empty memtable is kept.
+		fid := memFid()
+		require.NoError(t, db.RotateMemtable())
+		require.Equal(t, fid, memFid())
+
+		txnSet(t, db, []byte("foo"), []byte("bar"), 0)
+		require.NoError(t, db.RotateMemtable())
+		require.Equal(t, fid+1, memFid())
+		require.NoError(t, db.View(func(txn *Txn) error {
+			item, err := txn.Get([]byte("foo"))
+			require.NoError(t, err)
+			require.Equal(t, []byte("bar"), getItemValue(t, item))
+			return nil
+		}))
+	})
+}
+
 func TestLSMOnly(t *testing.T) {
 	dir, err := os.MkdirTemp("", "badger-test")
 	require.NoError(t, err)
@@ -2172,6 +2414,50 @@ func TestSyncForReadingTheEntriesThatWereSynced(t *testing.T) {
 	}
 }
 
+func TestPruneVersionsOnFlush(t *testing.T) {
+	// keyCount returns the number of entries in the table the memtable got flushed to.
+	keyCount := func(opt Options) uint32 {
+		dir, err := os.MkdirTemp("", "badger-test")
+		require.NoError(t, err)
+		defer removeDir(dir)
+		opt.Dir, opt.ValueDir = dir, dir
+		opt.managedTxns = true
+
+		db, err := Open(opt)
+		require.NoError(t, err)
+		set := func(k string, version uint64, del bool) {
+			txn := db.NewTransactionAt(version, true)
+			if del {
+				require.NoError(t, txn.Delete([]byte(k)))
+			} else {
+				require.NoError(t, txn.Set([]byte(k), []byte(k)))
+			}
+			require.NoError(t, txn.CommitAt(version, nil))
+		}
+		for version := uint64(1); version <= 5; version++ {
+			set("hot", version, false)
+		}
+		set("gone", 1, false)
+		set("gone", 2, true)
+		set("kept", 1, false)
+		db.SetDiscardTs(3)
+		require.NoError(t, db.Close())
+
+		db, err = Open(opt)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, db.Close()) }()
+		tables := db.Tables()
+		require.Len(t, tables, 1)
+		return tables[0].KeyCount
+	}
+
+	opt := getTestOptions("").WithNumCompactors(0)
+	require.Equal(t, uint32(8), keyCount(opt))
+	// The versions of hot above the discard ts are kept, along with the latest one at or below it.
+	// Only the delete marker of gone is kept.
+	require.Equal(t, uint32(5), keyCount(opt.WithPruneVersionsOnFlush(true)))
+}
+
 func TestForceFlushMemtable(t *testing.T) {
 	dir, err := os.MkdirTemp("", "badger-test")
 	require.NoError(t, err, "temp dir for badger could not be created")
@@ -2343,6 +2629,154 @@ func TestUpdateMaxCost(t *testing.T) {
 	require.Equal(t, int64(4<<20), cost)
 }
 
+func TestClearCache(t *testing.T) {
+	dir, err := os.MkdirTemp("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	opt := getTestOptions(dir).WithBlockCacheSize(10 << 20)
+	db, err := Open(opt)
+	require.NoError(t, err)
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set([]byte(key("key", i)), val(false))
+		}))
+	}
+	require.NoError(t, db.Close())
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+
+	var wg sync.WaitGroup
+	read := func() {
+		defer wg.Done()
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for i := 0; i < 1000; i++ {
+				if _, err := txn.Get([]byte(key("key", i))); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+	}
+	wg.Add(1)
+	read()
+	db.blockCache.Wait()
+	require.Greater(t, db.BlockCacheMetrics().KeysAdded(), uint64(0))
+
+	db.ClearCache()
+	require.Zero(t, db.BlockCacheMetrics().KeysAdded())
+
+	// Reads running concurrently with ClearCache just miss.
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go read()
+	}
+	db.ClearCache()
+	wg.Wait()
+}
+
+func TestWarmCache(t *testing.T) {
+	dir, err := os.MkdirTemp("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	opt := getTestOptions(dir).WithBlockCacheSize(10 << 20)
+	db, err := Open(opt)
+	require.NoError(t, err)
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set([]byte(key("key", i)), val(false))
+		}))
+	}
+	require.NoError(t, db.Close())
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, db.WarmCache(ctx, []byte(key("key", 100)), []byte(key("key", 200))),
+		context.Canceled)
+	require.ErrorIs(t, db.WarmCache(context.Background(), []byte("b"), []byte("a")),
+		ErrInvalidRequest)
+
+	require.NoError(t, db.WarmCache(context.Background(), []byte(key("key", 100)),
+		[]byte(key("key", 200))))
+	db.blockCache.Wait()
+	metrics := db.BlockCacheMetrics()
+	added := metrics.KeysAdded()
+	require.Greater(t, added, uint64(0))
+
+	// The warmed range is read from the cache.
+	misses := metrics.Misses()
+	require.NoError(t, db.View(func(txn *Txn) error {
+		for i := 100; i < 200; i++ {
+			if _, err := txn.Get([]byte(key("key", i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	require.Equal(t, misses, metrics.Misses())
+	require.Equal(t, added, metrics.KeysAdded())
+}
+
+func TestPinRange(t *testing.T) {
+	dir, err := os.MkdirTemp("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	opt := getTestOptions(dir).WithBlockCacheSize(10 << 20).WithPinnedCacheSize(16 << 10)
+	db, err := Open(opt)
+	require.NoError(t, err)
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set([]byte(key("key", i)), val(false))
+		}))
+	}
+	require.NoError(t, db.Close())
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+
+	start, end := []byte(key("key", 100)), []byte(key("key", 200))
+	require.NoError(t, db.PinRange(start, end))
+	pinned := db.blockPins.Size()
+	require.Greater(t, pinned, int64(0))
+
+	read := func() {
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for i := 100; i < 200; i++ {
+				if _, err := txn.Get([]byte(key("key", i))); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+	}
+	// The pinned blocks are read even with an empty block cache.
+	db.ClearCache()
+	metrics := db.BlockCacheMetrics()
+	read()
+	require.Zero(t, metrics.Misses())
+
+	// Pinning more than fits into PinnedCacheSize fails, and keeps the earlier pins.
+	require.ErrorIs(t, db.PinRange([]byte("key"), nil), table.ErrPinBudgetExceeded)
+	require.Equal(t, pinned, db.blockPins.Size())
+
+	require.ErrorIs(t, db.UnpinRange(start, []byte("other")), ErrInvalidRequest)
+	require.NoError(t, db.UnpinRange(start, end))
+	require.Zero(t, db.blockPins.Size())
+	read()
+	require.Greater(t, metrics.Misses(), uint64(0))
+
+	require.ErrorIs(t, db.PinRange(end, start), ErrInvalidRequest)
+}
+
 func TestOpenDBReadOnly(t *testing.T) {
 	dir, err := os.MkdirTemp("", "badger-test")
 	require.NoError(t, err)
@@ -2707,3 +3141,422 @@ func TestCloseDBWhileReading(t *testing.T) {
 	require.NoError(t, db.Close())
 	wg.Wait()
 }
+
+func TestRandSource(t *testing.T) {
+	open := func() *DB {
+		// Without compactors, nothing else draws from the source concurrently.
+		opt := DefaultOptions("").WithInMemory(true).WithLogger(nil).WithNumCompactors(0).
+			WithRandSource(rand.NewSource(7))
+		db, err := Open(opt)
+		require.NoError(t, err)
+		return db
+	}
+	memSize := func(db *DB) int64 {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			for i := 0; i < 1000; i++ {
+				if err := txn.Set([]byte(key("key", i)), val(false)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+		db.lock.RLock()
+		defer db.lock.RUnlock()
+		return db.mt.sl.MemSize()
+	}
+
+	db1, db2 := open(), open()
+	defer func() { require.NoError(t, db1.Close()) }()
+	defer func() { require.NoError(t, db2.Close()) }()
+	// The skiplist node heights come from the same seeded source, so the memtables match.
+	require.Equal(t, memSize(db1), memSize(db2))
+	for i := 0; i < 10; i++ {
+		require.Equal(t, db1.randInt63n(1000), db2.randInt63n(1000))
+	}
+	// So do the reads sampled by the read repair.
+	rr1, rr2 := newReadRepair(db1), newReadRepair(db2)
+	rr1.threshold, rr2.threshold = 1000, 1000
+	for i := 0; i < 100; i++ {
+		rr1.record([]byte(key("key", i)))
+		rr2.record([]byte(key("key", i)))
+	}
+	require.NotEmpty(t, rr1.counts)
+	require.Equal(t, rr1.counts, rr2.counts)
+}
+
+func TestCompressionStats(t *testing.T) {
+	stats := func(c options.CompressionType) (float64, int64, int64) {
+		dir, err := os.MkdirTemp("", "badger-test")
+		require.NoError(t, err)
+		defer removeDir(dir)
+		opt := getTestOptions(dir).WithCompression(c)
+		db, err := Open(opt)
+		require.NoError(t, err)
+		ratio, _, _ := db.CompressionStats()
+		require.Zero(t, ratio)
+
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			for i := 0; i < 1000; i++ {
+				if err := txn.Set([]byte(key("key", i)), bytes.Repeat([]byte("a"), 100)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+		// Reopening flushes the memtable to L0.
+		require.NoError(t, db.Close())
+		db, err = Open(opt)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, db.Close()) }()
+
+		ratio, uncompressed, compressed := db.CompressionStats()
+		var levelUncompressed, levelCompressed int64
+		for _, l := range db.Levels() {
+			levelUncompressed += l.UncompressedSize
+			levelCompressed += l.BlocksSize
+		}
+		require.Equal(t, levelUncompressed, uncompressed)
+		require.Equal(t, levelCompressed, compressed)
+		return ratio, uncompressed, compressed
+	}
+
+	ratio, uncompressed, compressed := stats(options.None)
+	require.Greater(t, uncompressed, int64(0))
+	require.Equal(t, uncompressed, compressed)
+	require.Equal(t, 1.0, ratio)
+
+	ratio, uncompressed, compressed = stats(options.ZSTD)
+	require.Greater(t, uncompressed, 2*compressed)
+	require.Greater(t, ratio, 2.0)
+}
+
+func TestScopedView(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			for _, k := range []string{"a", "tenant1/x", "tenant1/y", "tenant10/z", "tenant2/x"} {
+				if err := txn.Set([]byte(k), []byte("val-"+k)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+
+		scoped := db.ScopedView([]byte("tenant1/"))
+		require.NoError(t, scoped.View(func(txn *ScopedTxn) error {
+			item, err := txn.Get([]byte("tenant1/x"))
+			require.NoError(t, err)
+			require.Equal(t, []byte("val-tenant1/x"), getItemValue(t, item))
+			for _, k := range []string{"a", "tenant2/x", "tenant10/z"} {
+				_, err = txn.Get([]byte(k))
+				require.Equal(t, ErrKeyNotFound, err)
+			}
+
+			keys := func(opt IteratorOptions, seek string) []string {
+				it, err := txn.NewIterator(opt)
+				require.NoError(t, err)
+				defer it.Close()
+				var res []string
+				for it.Seek([]byte(seek)); it.Valid(); it.Next() {
+					res = append(res, string(it.Item().Key()))
+				}
+				return res
+			}
+			require.Equal(t, []string{"tenant1/x", "tenant1/y"}, keys(DefaultIteratorOptions, ""))
+			require.Empty(t, keys(DefaultIteratorOptions, "tenant2/"))
+
+			opt := DefaultIteratorOptions
+			opt.Prefix = []byte("tenant")
+			opt.StripPrefix = true
+			require.Equal(t, []string{"1/x", "1/y"}, keys(opt, ""))
+			opt.Prefix = []byte("tenant1/y")
+			require.Equal(t, []string{""}, keys(opt, ""))
+			opt.Prefix = []byte("tenant2/")
+			_, err = txn.NewIterator(opt)
+			require.ErrorIs(t, err, ErrOutOfScope)
+			_, err = txn.NewKeyIterator([]byte("tenant2/x"), DefaultIteratorOptions)
+			require.ErrorIs(t, err, ErrOutOfScope)
+			it, err := txn.NewKeyIterator([]byte("tenant1/x"), DefaultIteratorOptions)
+			require.NoError(t, err)
+			it.Close()
+			return nil
+		}))
+	})
+}
+
+func TestFlushedVersion(t *testing.T) {
+	dir, err := os.MkdirTemp("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	// The memtable fills up every ten writes or so.
+	opt := getTestOptions(dir).WithMemTableSize(1 << 20).WithValueThreshold(128 << 10)
+	db, err := Open(opt)
+	require.NoError(t, err)
+	require.Zero(t, db.FlushedVersion())
+
+	val := make([]byte, 100<<10)
+	for i := 0; i < 25; i++ {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set([]byte(key("key", i)), val)
+		}))
+	}
+	maxTableVersion := func() uint64 {
+		var max uint64
+		for _, ti := range db.Tables() {
+			if ti.MaxVersion > max {
+				max = ti.MaxVersion
+			}
+		}
+		return max
+	}
+	require.Eventually(t, func() bool {
+		fv := db.FlushedVersion()
+		return fv > 0 && fv == maxTableVersion()
+	}, 5*time.Second, 10*time.Millisecond)
+	// The last writes are still in the memtable.
+	require.Less(t, db.FlushedVersion(), db.MaxVersion())
+	require.NoError(t, db.Close())
+
+	// Close flushes the memtable, and the version is picked up from the tables on Open.
+	db, err = Open(opt)
+	require.NoError(t, err)
+	require.Equal(t, uint64(25), db.FlushedVersion())
+	require.NoError(t, db.Close())
+}
+
+func TestFlushPriority(t *testing.T) {
+	opt := DefaultOptions("").WithInMemory(true).WithFlushPriority(FlushLargestFirst)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		newMt := func(prefix string, n int, version uint64) *memTable {
+			mt, err := db.newMemTable()
+			require.NoError(t, err)
+			for i := 0; i < n; i++ {
+				k := y.KeyWithTs([]byte(key(prefix, i)), version)
+				require.NoError(t, mt.Put(k, y.ValueStruct{Value: []byte("val")}))
+			}
+			return mt
+		}
+		// small and large have distinct key ranges. larger overlaps small, so it can't go first.
+		small := newMt("a", 1, 1)
+		large := newMt("b", 100, 2)
+		larger := newMt("a", 200, 3)
+
+		db.lock.Lock()
+		imm := db.imm
+		db.imm = []*memTable{small, large, larger}
+		db.lock.Unlock()
+		defer func() {
+			db.lock.Lock()
+			db.imm = imm
+			db.lock.Unlock()
+			for _, mt := range []*memTable{small, large, larger} {
+				mt.DecrRef()
+			}
+		}()
+
+		require.Equal(t, large, db.pickMemtableToFlush())
+		db.opt.FlushPriority = FlushFIFO
+		require.Equal(t, small, db.pickMemtableToFlush())
+
+		// The flushed version only moves once the older memtable is flushed too.
+		db.lock.Lock()
+		db.imm = []*memTable{small, larger}
+		db.memtableFlushed(large)
+		require.Zero(t, db.FlushedVersion())
+		db.imm = []*memTable{larger}
+		db.memtableFlushed(small)
+		require.Equal(t, uint64(2), db.FlushedVersion())
+		db.lock.Unlock()
+	})
+}
+
+func TestCommitPipelineDepth(t *testing.T) {
+	// Half the values go to the value log.
+	opt := getTestOptions("").WithCommitPipelineDepth(4).WithValueThreshold(64)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		const writers, writes = 64, 50
+		var wg sync.WaitGroup
+		for w := 0; w < writers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < writes; i++ {
+					val := bytes.Repeat([]byte{byte(i)}, 32+64*(i%2))
+					require.NoError(t, db.Update(func(txn *Txn) error {
+						if err := txn.Set([]byte(key(fmt.Sprintf("w%02d-", w), i)), val); err != nil {
+							return err
+						}
+						// Every writer also overwrites a shared key, so the versions tell the order of
+						// the commits.
+						return txn.Set([]byte("shared"), []byte(fmt.Sprintf("%d-%d", w, i)))
+					}))
+				}
+			}(w)
+		}
+		wg.Wait()
+
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for w := 0; w < writers; w++ {
+				for i := 0; i < writes; i++ {
+					item, err := txn.Get([]byte(key(fmt.Sprintf("w%02d-", w), i)))
+					require.NoError(t, err)
+					require.Equal(t, bytes.Repeat([]byte{byte(i)}, 32+64*(i%2)),
+						getItemValue(t, item))
+				}
+			}
+			iopt := DefaultIteratorOptions
+			iopt.AllVersions = true
+			it := txn.NewKeyIterator([]byte("shared"), iopt)
+			defer it.Close()
+			var versions int
+			last := uint64(math.MaxUint64)
+			for it.Rewind(); it.Valid(); it.Next() {
+				require.Less(t, it.Item().Version(), last)
+				last = it.Item().Version()
+				versions++
+			}
+			require.Equal(t, writers*writes, versions)
+			return nil
+		}))
+	})
+	_, err := Open(DefaultOptions(t.TempDir()).WithCommitPipelineDepth(-1))
+	require.Error(t, err)
+}
+
+func BenchmarkCommitPipelineDepth(b *testing.B) {
+	for _, depth := range []int{1, 2, 4} {
+		b.Run(fmt.Sprintf("depth=%d", depth), func(b *testing.B) {
+			dir, err := os.MkdirTemp("", "badger-test")
+			require.NoError(b, err)
+			defer removeDir(dir)
+			db, err := Open(getTestOptions(dir).WithCommitPipelineDepth(depth).
+				WithValueThreshold(64))
+			require.NoError(b, err)
+			defer func() { require.NoError(b, db.Close()) }()
+
+			val := make([]byte, 128)
+			var n atomic.Int64
+			// At least 64 concurrent writers.
+			b.SetParallelism(64)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					k := []byte(fmt.Sprintf("key%d", n.Add(1)))
+					require.NoError(b, db.Update(func(txn *Txn) error {
+						return txn.Set(k, val)
+					}))
+				}
+			})
+		})
+	}
+}
+
+func TestPointReadCache(t *testing.T) {
+	opt := getTestOptions("").WithPointReadCache(100, 50*time.Millisecond)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		set := func(val string) {
+			require.NoError(t, db.Update(func(txn *Txn) error {
+				return txn.Set([]byte("key"), []byte(val))
+			}))
+		}
+		get := func(txn *Txn) string {
+			item, err := txn.Get([]byte("key"))
+			require.NoError(t, err)
+			return string(getItemValue(t, item))
+		}
+		cached := func() (uint64, bool) {
+			vs, _, ok := db.pointCache.get([]byte("key"), math.MaxUint64)
+			return vs.Version, ok
+		}
+
+		set("v1")
+		old := db.NewTransaction(false)
+		defer old.Discard()
+		_, ok := cached()
+		require.False(t, ok)
+		require.NoError(t, db.View(func(txn *Txn) error {
+			require.Equal(t, "v1", get(txn))
+			return nil
+		}))
+		version, ok := cached()
+		require.True(t, ok)
+		require.Equal(t, old.readTs, version)
+
+		// A write evicts the key, and reads at older timestamps don't fill the cache.
+		set("v2")
+		_, ok = cached()
+		require.False(t, ok)
+		require.Equal(t, "v1", get(old))
+		_, ok = cached()
+		require.False(t, ok)
+		require.NoError(t, db.View(func(txn *Txn) error {
+			require.Equal(t, "v2", get(txn))
+			return nil
+		}))
+		version, ok = cached()
+		require.True(t, ok)
+		require.Greater(t, version, old.readTs)
+		// The cached version is newer than the snapshot of the old transaction.
+		require.Equal(t, "v1", get(old))
+
+		time.Sleep(100 * time.Millisecond)
+		_, ok = cached()
+		require.False(t, ok)
+
+		require.NoError(t, db.View(func(txn *Txn) error {
+			get(txn)
+			return nil
+		}))
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Delete([]byte("key"))
+		}))
+		require.NoError(t, db.View(func(txn *Txn) error {
+			_, err := txn.Get([]byte("key"))
+			require.Equal(t, ErrKeyNotFound, err)
+			return nil
+		}))
+
+		set("v3")
+		require.NoError(t, db.View(func(txn *Txn) error {
+			require.Equal(t, "v3", get(txn))
+			return nil
+		}))
+		require.NoError(t, db.DropAll())
+		_, ok = cached()
+		require.False(t, ok)
+	})
+
+	_, err := Open(DefaultOptions(t.TempDir()).WithPointReadCache(100, 0))
+	require.Error(t, err)
+}
+
+func TestSkiplistOptions(t *testing.T) {
+	opt := getTestOptions("").WithSkiplistMaxHeight(32).WithSkiplistProbability(0.5)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			for i := 0; i < 1000; i++ {
+				if err := txn.Set([]byte(key("key", i)), []byte(key("val", i))); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for i := 0; i < 1000; i++ {
+				item, err := txn.Get([]byte(key("key", i)))
+				require.NoError(t, err)
+				require.Equal(t, key("val", i), string(getItemValue(t, item)))
+			}
+			return nil
+		}))
+	})
+
+	for _, opt := range []Options{
+		DefaultOptions(t.TempDir()).WithSkiplistMaxHeight(0),
+		DefaultOptions(t.TempDir()).WithSkiplistMaxHeight(33),
+		DefaultOptions(t.TempDir()).WithSkiplistProbability(0),
+		DefaultOptions(t.TempDir()).WithSkiplistProbability(1),
+	} {
+		_, err := Open(opt)
+		require.Error(t, err)
+	}
+}
@@ -61,6 +61,10 @@ func acquireDirectoryLock(dirPath string, pidFileName string, readOnly bool) (
 	}
 
 	err = unix.Flock(int(f.Fd()), opts)
+	if err == unix.EWOULDBLOCK {
+		f.Close()
+		return nil, &DirectoryLockedError{Dir: dirPath, PID: readPidFile(absPidFilePath)}
+	}
 	if err != nil {
 		f.Close()
 		return nil, y.Wrapf(err,
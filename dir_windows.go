@@ -38,6 +38,10 @@ const (
 	FILE_FLAG_DELETE_ON_CLOSE = 0x04000000
 )
 
+// errSharingViolation is ERROR_SHARING_VIOLATION, returned when another process has the lock file
+// open.
+const errSharingViolation = syscall.Errno(32)
+
 func openDir(path string) (*os.File, error) {
 	fd, err := openDirWin(path)
 	if err != nil {
@@ -91,6 +95,9 @@ func acquireDirectoryLock(dirPath string, pidFileName string, readOnly bool) (*d
 		syscall.OPEN_ALWAYS,
 		uint32(FILE_ATTRIBUTE_TEMPORARY|FILE_FLAG_DELETE_ON_CLOSE),
 		0)
+	if err == errSharingViolation {
+		return nil, &DirectoryLockedError{Dir: dirPath}
+	}
 	if err != nil {
 		return nil, y.Wrapf(err,
 			"Cannot create lock file %q.  Another process is using this Badger database",
@@ -0,0 +1,103 @@
+/*
+ * Copyright 2024 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+
+	"github.com/0xEggTart/badger/y"
+	"github.com/dgraph-io/ristretto/v2/z"
+)
+
+// DropReason denotes why a compaction permanently dropped an entry.
+type DropReason uint8
+
+const (
+	// DropExpired is used for entries whose TTL had passed.
+	DropExpired DropReason = iota + 1
+	// DropTombstone is used for deletion markers, and for the older versions hidden by them.
+	DropTombstone
+	// DropVersionLimit is used for versions beyond NumVersionsToKeep, or older than a version
+	// which was written with discard earlier versions set.
+	DropVersionLimit
+	// DropPrefixed is used for entries removed by DropPrefix.
+	DropPrefixed
+	// DropBelowVersion is used for versions removed by DeleteBelowVersion.
+	DropBelowVersion
+)
+
+func (r DropReason) String() string {
+	switch r {
+	case DropExpired:
+		return "Expired"
+	case DropTombstone:
+		return "Tombstone"
+	case DropVersionLimit:
+		return "VersionLimit"
+	case DropPrefixed:
+		return "Prefixed"
+	case DropBelowVersion:
+		return "BelowVersion"
+	}
+	return "Unknown"
+}
+
+// dropHookChCapacity is the number of dropped entries which can be queued up for the
+// CompactionDropHook. Compactions don't wait for the hook, so the entries dropped while the
+// queue is full are not reported.
+const dropHookChCapacity = 10000
+
+type droppedKey struct {
+	key    []byte
+	reason DropReason
+}
+
+// notifyDrop queues up the key for the CompactionDropHook. It returns false if the queue was full.
+// Internal keys are not reported.
+func (db *DB) notifyDrop(key []byte, reason DropReason) bool {
+	key = y.ParseKey(key)
+	if bytes.HasPrefix(key, badgerPrefix) {
+		return true
+	}
+	select {
+	case db.dropHookCh <- droppedKey{key: y.SafeCopy(nil, key), reason: reason}:
+		return true
+	default:
+		return false
+	}
+}
+
+// runDropHook calls the CompactionDropHook for the dropped keys until the closer is signalled,
+// after which the keys left in the queue are delivered before returning.
+func (db *DB) runDropHook(lc *z.Closer) {
+	defer lc.Done()
+	for {
+		select {
+		case d := <-db.dropHookCh:
+			db.opt.CompactionDropHook(d.key, d.reason)
+		case <-lc.HasBeenClosed():
+			for {
+				select {
+				case d := <-db.dropHookCh:
+					db.opt.CompactionDropHook(d.key, d.reason)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
@@ -18,6 +18,7 @@ package badger
 
 import (
 	stderrors "errors"
+	"fmt"
 	"math"
 )
 
@@ -86,6 +87,13 @@ var (
 	// ErrInvalidDump if a data dump made previously cannot be loaded into the database.
 	ErrInvalidDump = stderrors.New("Data dump cannot be read")
 
+	// ErrDuplicateKey is returned by StreamWriter when a stream has the same key at the same
+	// version twice, and StreamWriter.OnDuplicate is DuplicateError.
+	ErrDuplicateKey = stderrors.New("Duplicate key in stream")
+
+	// ErrInvalidArchive is returned by ImportArchive if the archive is malformed or corrupted.
+	ErrInvalidArchive = stderrors.New("Archive is invalid or corrupted")
+
 	// ErrZeroBandwidth is returned if the user passes in zero bandwidth for sequence.
 	ErrZeroBandwidth = stderrors.New("Bandwidth must be greater than zero")
 
@@ -122,4 +130,166 @@ var (
 
 	// ErrDBClosed is returned when a get operation is performed after closing the DB.
 	ErrDBClosed = stderrors.New("DB Closed")
+
+	// ErrInvalidCursor is returned when an iterator cursor is malformed, or doesn't match the
+	// iterator options it's used with.
+	ErrInvalidCursor = stderrors.New("Invalid iterator cursor")
+
+	// ErrInvalidCounter is returned when a counter operation finds a value which isn't an 8 byte
+	// big-endian integer.
+	ErrInvalidCounter = stderrors.New("Value is not a valid counter")
+
+	// ErrTooManyIterators is the panic value of NewIterator when Options.MaxConcurrentIterators
+	// iterators are already open, and Options.BlockOnMaxIterators isn't set. Txn.TryNewIterator
+	// returns it as an error.
+	ErrTooManyIterators = stderrors.New("Too many concurrent iterators")
+
+	// ErrInvalidPrefixes is returned by NewMultiPrefixIterator if no prefix is given, or if one of
+	// the prefixes is empty or starts with another one.
+	ErrInvalidPrefixes = stderrors.New("Prefixes must be non-empty and must not overlap")
+
+	// ErrDirectoryLocked is returned by Open if another process holds the lock on a directory of
+	// the DB.
+	ErrDirectoryLocked = stderrors.New("Another process is using this Badger database")
+
+	// ErrValueLogFull is returned when committing a write which would add a value to the value log
+	// while it is at Options.MaxValueLogSize. The write can be retried once value log GC, which runs
+	// in the background then, has freed up space.
+	ErrValueLogFull = stderrors.New("Value log is full")
+
+	// ErrValueTooLarge is returned when a value is larger than a value log file, unless
+	// Options.AllowLargeValues is set.
+	ErrValueTooLarge = stderrors.New("Value too large")
+
+	// ErrConditionFailed is returned by DB.ApplyConditional when one of its conditions doesn't hold.
+	ErrConditionFailed = stderrors.New("Condition failed")
+
+	// ErrDuplicateVersion is returned when a key is written at a version it already has, if
+	// Options.RejectDuplicateVersions is set.
+	ErrDuplicateVersion = stderrors.New("Key already has an entry at this version")
+
+	// ErrOutOfScope is returned by the iterators of a ScopedTxn for prefixes and keys outside the
+	// prefix of its ScopedDB.
+	ErrOutOfScope = stderrors.New("Key is outside the prefix of the ScopedDB")
+
+	// ErrWideUserMeta is returned when an entry has a 16 bit user meta, but
+	// Options.WideUserMeta isn't set.
+	ErrWideUserMeta = stderrors.New("16 bit user metas need Options.WideUserMeta")
+
+	// ErrExpiredEntry is returned by WriteBatch.ValidateChunk for an entry which has already
+	// expired.
+	ErrExpiredEntry = stderrors.New("Entry has already expired")
 )
+
+// KeyNotFoundError is returned instead of ErrKeyNotFound by Txn.Get, or another lookup of a single
+// key, if Options.DetailedErrors is set. It wraps ErrKeyNotFound.
+type KeyNotFoundError struct {
+	Key []byte // The key which was looked up.
+}
+
+func (e *KeyNotFoundError) Error() string { return ErrKeyNotFound.Error() }
+
+// Unwrap returns ErrKeyNotFound.
+func (e *KeyNotFoundError) Unwrap() error { return ErrKeyNotFound }
+
+// TxnTooBigError is returned instead of ErrTxnTooBig by a write which would take the transaction
+// past the size or count of entries that fit into one request, if Options.DetailedErrors is set.
+// It wraps ErrTxnTooBig.
+type TxnTooBigError struct {
+	Size, Max       int64 // The estimated size in bytes including the write, and the limit.
+	Count, MaxCount int64 // The number of entries including the write, and the limit.
+}
+
+func (e *TxnTooBigError) Error() string { return ErrTxnTooBig.Error() }
+
+// Unwrap returns ErrTxnTooBig.
+func (e *TxnTooBigError) Unwrap() error { return ErrTxnTooBig }
+
+// InvalidEntryError is returned by WriteBatch.ValidateChunk for a write which would fail when
+// committed. It wraps the error the write would fail with, such as ErrEmptyKey, ErrBannedKey or
+// ErrExpiredEntry.
+type InvalidEntryError struct {
+	Key []byte // The key of the write.
+	Err error  // The error the write would fail with.
+}
+
+func (e *InvalidEntryError) Error() string {
+	return fmt.Sprintf("Invalid entry for key %q: %v", e.Key, e.Err)
+}
+
+// Unwrap returns the error the write would fail with.
+func (e *InvalidEntryError) Unwrap() error { return e.Err }
+
+// DirectoryLockedError is returned by Open when another process holds the lock on a directory of
+// the DB, even after retrying for Options.LockTimeout. It wraps ErrDirectoryLocked, so
+// errors.Is(err, ErrDirectoryLocked) holds for it.
+type DirectoryLockedError struct {
+	Dir string // The locked directory.
+	PID int    // The process holding the lock, as read from the pid file, or 0 if unknown.
+}
+
+func (e *DirectoryLockedError) Error() string {
+	msg := fmt.Sprintf("Cannot acquire directory lock on %q. %s", e.Dir, ErrDirectoryLocked)
+	if e.PID > 0 {
+		msg += fmt.Sprintf(" (pid %d)", e.PID)
+	}
+	return msg
+}
+
+// Unwrap returns ErrDirectoryLocked.
+func (e *DirectoryLockedError) Unwrap() error { return ErrDirectoryLocked }
+
+// ValueTooLargeError is returned when a value is larger than a value log file can hold. It wraps
+// ErrValueTooLarge, so errors.Is(err, ErrValueTooLarge) holds for it.
+type ValueTooLargeError struct {
+	Size, Max int64 // The size of the value in bytes, and the limit.
+}
+
+func (e *ValueTooLargeError) Error() string {
+	return fmt.Sprintf("%s, size %d exceeded %d limit", ErrValueTooLarge, e.Size, e.Max)
+}
+
+// Unwrap returns ErrValueTooLarge.
+func (e *ValueTooLargeError) Unwrap() error { return ErrValueTooLarge }
+
+// ConflictError is returned instead of ErrConflict by the commit of a transaction which read keys
+// written by transactions committed since it started, if Options.DetailedErrors is set. It wraps
+// ErrConflict.
+type ConflictError struct {
+	Keys [][]byte // The keys read by the transaction which were written concurrently.
+}
+
+func (e *ConflictError) Error() string { return ErrConflict.Error() }
+
+// Unwrap returns ErrConflict.
+func (e *ConflictError) Unwrap() error { return ErrConflict }
+
+// ConditionFailedError is returned by DB.ApplyConditional when one of its conditions doesn't hold.
+// It wraps ErrConditionFailed, so errors.Is(err, ErrConditionFailed) holds for it.
+type ConditionFailedError struct {
+	Index     int       // The index of the condition in the conditions given.
+	Condition Condition // The condition which doesn't hold.
+}
+
+func (e *ConditionFailedError) Error() string {
+	return fmt.Sprintf("%s: condition %d, key %q %s", ErrConditionFailed, e.Index,
+		e.Condition.Key, e.Condition.Type)
+}
+
+// Unwrap returns ErrConditionFailed.
+func (e *ConditionFailedError) Unwrap() error { return ErrConditionFailed }
+
+// DuplicateVersionError is returned when a commit writes a key at a version it already has, if
+// Options.RejectDuplicateVersions is set. It wraps ErrDuplicateVersion, so
+// errors.Is(err, ErrDuplicateVersion) holds for it.
+type DuplicateVersionError struct {
+	Key     []byte // The key written.
+	Version uint64 // The version the key already has an entry at.
+}
+
+func (e *DuplicateVersionError) Error() string {
+	return fmt.Sprintf("%s: key %q, version %d", ErrDuplicateVersion, e.Key, e.Version)
+}
+
+// Unwrap returns ErrDuplicateVersion.
+func (e *DuplicateVersionError) Unwrap() error { return ErrDuplicateVersion }
@@ -120,7 +120,9 @@ func (db *DB) buildHistogram(keyPrefix []byte) *sizeHistogram {
 	txn := db.NewTransaction(false)
 	defer txn.Discard()
 
-	itr := txn.NewIterator(DefaultIteratorOptions)
+	opt := DefaultIteratorOptions
+	opt.internal = true
+	itr := txn.NewIterator(opt)
 	defer itr.Close()
 
 	badgerHistogram := newSizeHistogram()
@@ -134,6 +136,114 @@ func (db *DB) buildHistogram(keyPrefix []byte) *sizeHistogram {
 	return badgerHistogram
 }
 
+// SizeHistogram is a snapshot of a histogram of sizes in bytes. Counts[i] is the number of sizes
+// which are less than Bounds[i], and at least Bounds[i-1]. The last bucket has no upper bound, and
+// its Bounds entry is set to math.MaxInt64.
+type SizeHistogram struct {
+	Bounds []int64
+	Counts []int64
+	Count  int64
+	Sum    int64
+	Min    int64
+	Max    int64
+}
+
+// Mean returns the average size.
+func (h *SizeHistogram) Mean() float64 {
+	if h.Count == 0 {
+		return 0
+	}
+	return float64(h.Sum) / float64(h.Count)
+}
+
+// Percentile returns the upper bound of the bucket holding the p-th percentile, where p is
+// within [0, 1]. The bound is capped at Max, so the result is never above the largest size.
+func (h *SizeHistogram) Percentile(p float64) int64 {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(p * float64(h.Count)))
+	var seen int64
+	for i, c := range h.Counts {
+		seen += c
+		if seen >= rank && c > 0 {
+			return min(h.Bounds[i], h.Max)
+		}
+	}
+	return h.Max
+}
+
+func (histogram *histogramData) snapshot() SizeHistogram {
+	h := SizeHistogram{
+		Bounds: append(append([]int64{}, histogram.bins...), math.MaxInt64),
+		Counts: append([]int64{}, histogram.countPerBin...),
+		Count:  histogram.totalCount,
+		Sum:    histogram.sum,
+	}
+	if h.Count > 0 {
+		h.Min, h.Max = histogram.min, histogram.max
+	}
+	return h
+}
+
+// EntryStats holds the distributions of the sizes of the keys and of the values of a DB, as
+// returned by DB.EntrySizeStats.
+type EntryStats struct {
+	Keys   SizeHistogram
+	Values SizeHistogram
+	// Correlation is the Pearson correlation coefficient of the key and value sizes of the entries,
+	// within [-1, 1]. Values tend to be larger under larger keys if it is positive. It is zero if
+	// all the keys, or all the values, have the same size.
+	Correlation float64
+}
+
+// EntrySizeStats returns the distributions of the sizes of the keys and of the values in the DB,
+// which help with tuning ValueThreshold and with finding out how much the encoding of the keys
+// costs. The latest version of every key visible to a read-only transaction is counted. The sizes
+// of the values stored in the value log are estimated from their value pointers, so the value log
+// isn't read.
+//
+// EntrySizeStats iterates over every key of the LSM tree, so its cost grows with the number of
+// keys in the DB, and it reads every block of every table, which can push hot blocks out of the
+// block cache. It isn't meant to be called often, or on the path of requests. The transaction it
+// runs in also holds back the discarding of older versions by compactions until it returns.
+func (db *DB) EntrySizeStats() *EntryStats {
+	txn := db.NewTransaction(false)
+	defer txn.Discard()
+
+	opt := DefaultIteratorOptions
+	opt.PrefetchValues = false
+	opt.internal = true
+	itr := txn.NewIterator(opt)
+	defer itr.Close()
+
+	h := newSizeHistogram()
+	// The running means, and sums of squared deviations and of co-deviations, of the sizes.
+	var n, meanK, meanV, m2k, m2v, ckv float64
+	for itr.Rewind(); itr.Valid(); itr.Next() {
+		item := itr.Item()
+		k, v := item.KeySize(), item.ValueSize()
+		h.keySizeHistogram.Update(k)
+		h.valueSizeHistogram.Update(v)
+
+		n++
+		dk, dv := float64(k)-meanK, float64(v)-meanV
+		meanK += dk / n
+		meanV += dv / n
+		m2k += dk * (float64(k) - meanK)
+		m2v += dv * (float64(v) - meanV)
+		ckv += dk * (float64(v) - meanV)
+	}
+	stats := &EntryStats{
+		Keys:   h.keySizeHistogram.snapshot(),
+		Values: h.valueSizeHistogram.snapshot(),
+	}
+	if m2k > 0 && m2v > 0 {
+		stats.Correlation = max(-1, min(1, ckv/math.Sqrt(m2k*m2v)))
+	}
+	return stats
+}
+
 // printHistogram prints the histogram data in a human-readable format.
 func (histogram histogramData) printHistogram() {
 	fmt.Printf("Total count: %d\n", histogram.totalCount)
@@ -102,3 +102,48 @@ func TestBuildKeyValueSizeHistogram(t *testing.T) {
 		})
 	})
 }
+
+func TestEntrySizeStats(t *testing.T) {
+	opt := getTestOptions("")
+	opt.ValueThreshold = 64
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		stats := db.EntrySizeStats()
+		require.Zero(t, stats.Keys.Count)
+		require.Zero(t, stats.Values.Percentile(0.5))
+
+		// Larger values go under longer keys, some of them in the value log.
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			for i := 1; i <= 100; i++ {
+				k := append([]byte("key"), make([]byte, i)...)
+				k[3] = byte(i)
+				if err := txn.Set(k, make([]byte, 10*i)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+		stats = db.EntrySizeStats()
+		require.Equal(t, int64(100), stats.Keys.Count)
+		require.Equal(t, int64(100), stats.Values.Count)
+		require.Equal(t, int64(4), stats.Keys.Min)
+		require.Equal(t, int64(103), stats.Keys.Max)
+		require.InDelta(t, 53.5, stats.Keys.Mean(), 0.01)
+		require.Equal(t, int64(64), stats.Keys.Percentile(0.5))
+		require.Equal(t, int64(103), stats.Keys.Percentile(1))
+		require.Equal(t, int64(10), stats.Values.Min)
+		require.InDelta(t, 1000, stats.Values.Max, 10)
+		require.Greater(t, stats.Correlation, 0.99)
+
+		// Sizes which don't vary aren't correlated with anything.
+		require.NoError(t, db.DropAll())
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			for i := 0; i < 10; i++ {
+				if err := txn.Set([]byte(key("key", i)), make([]byte, i)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+		require.Zero(t, db.EntrySizeStats().Correlation)
+	})
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright 2024 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+)
+
+// KeyStat is a key along with the number of its versions dropped by compactions. See
+// DB.WriteAmplificationHotspots.
+type KeyStat struct {
+	Key             []byte
+	VersionsDropped uint64
+}
+
+// hotspotBatchSize is the number of keys a compaction gathers before passing them to the
+// hotspots, so that it doesn't take their lock for every key.
+const hotspotBatchSize = 256
+
+// hotspots keeps the keys with the most versions dropped by compactions, with the space-saving
+// algorithm: once full, a new key replaces the key with the lowest count, and starts from that
+// count. So the counts are upper bounds, but the keys dropping the most versions stay in.
+type hotspots struct {
+	sync.Mutex
+	capacity int
+	keys     keyStatHeap
+	index    map[string]int // Position of the keys in keys.
+}
+
+func newHotspots(capacity int) *hotspots {
+	h := &hotspots{capacity: capacity, index: make(map[string]int, capacity)}
+	h.keys.index = h.index
+	return h
+}
+
+// add counts the versions dropped for the keys in stats.
+func (h *hotspots) add(stats []KeyStat) {
+	h.Lock()
+	defer h.Unlock()
+	for _, ks := range stats {
+		if i, ok := h.index[string(ks.Key)]; ok {
+			h.keys.stats[i].VersionsDropped += ks.VersionsDropped
+			heap.Fix(&h.keys, i)
+			continue
+		}
+		if len(h.keys.stats) < h.capacity {
+			heap.Push(&h.keys, KeyStat{Key: ks.Key, VersionsDropped: ks.VersionsDropped})
+			continue
+		}
+		min := &h.keys.stats[0]
+		delete(h.index, string(min.Key))
+		min.Key = ks.Key
+		min.VersionsDropped += ks.VersionsDropped
+		h.index[string(ks.Key)] = 0
+		heap.Fix(&h.keys, 0)
+	}
+}
+
+// top returns the keys, the most dropped versions first.
+func (h *hotspots) top() []KeyStat {
+	h.Lock()
+	res := make([]KeyStat, len(h.keys.stats))
+	copy(res, h.keys.stats)
+	h.Unlock()
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].VersionsDropped > res[j].VersionsDropped
+	})
+	return res
+}
+
+// keyStatHeap is a min-heap of key stats, which keeps index up to date.
+type keyStatHeap struct {
+	stats []KeyStat
+	index map[string]int
+}
+
+func (h *keyStatHeap) Len() int { return len(h.stats) }
+func (h *keyStatHeap) Less(i, j int) bool {
+	return h.stats[i].VersionsDropped < h.stats[j].VersionsDropped
+}
+func (h *keyStatHeap) Swap(i, j int) {
+	h.stats[i], h.stats[j] = h.stats[j], h.stats[i]
+	h.index[string(h.stats[i].Key)] = i
+	h.index[string(h.stats[j].Key)] = j
+}
+func (h *keyStatHeap) Push(x interface{}) {
+	ks := x.(KeyStat)
+	h.index[string(ks.Key)] = len(h.stats)
+	h.stats = append(h.stats, ks)
+}
+func (h *keyStatHeap) Pop() interface{} {
+	ks := h.stats[len(h.stats)-1]
+	h.stats = h.stats[:len(h.stats)-1]
+	delete(h.index, string(ks.Key))
+	return ks
+}
+
+// WriteAmplificationHotspots returns the keys which had the most versions dropped by compactions,
+// the most dropped first, up to Options.HotspotKeys of them. Such keys are rewritten a lot, and
+// each of their versions costs compaction work, so they often point to a bug in the application,
+// like a key updated in a loop. Only the keys having at least two versions dropped by a single
+// compaction are counted, as dropping the previous version of a key is the normal cost of an
+// update. It returns nil unless Options.HotspotKeys is set.
+func (db *DB) WriteAmplificationHotspots() []KeyStat {
+	if db.hotspots == nil {
+		return nil
+	}
+	return db.hotspots.top()
+}
@@ -21,10 +21,14 @@ import (
 	"fmt"
 	"hash/crc32"
 	"math"
+	"slices"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/cespare/xxhash/v2"
+
 	"github.com/0xEggTart/badger/table"
 	"github.com/0xEggTart/badger/y"
 	"github.com/dgraph-io/ristretto/v2/z"
@@ -49,11 +53,20 @@ type Item struct {
 	next  *Item
 	txn   *Txn
 
+	readStats *ReadStats    // Stats of the Get which returned the item. Nil for other items.
+	bytesRead *atomic.Int64 // Points to Iterator.bytesRead. Nil for items not from an iterator.
+	stripLen  int           // Length of the prefix that Key omits. See IteratorOptions.StripPrefix.
+	// Key returned by FullKey, if it isn't key itself: the key passed through
+	// Options.KeyReadTransform, or the internal key with IteratorOptions.RawInternalKeys.
+	fullKey []byte
+
 	err      error
 	wg       sync.WaitGroup
 	status   prefetchStatus
 	meta     byte // We need to store meta to know about bitValuePointer.
 	userMeta byte
+	// High byte of a 16 bit user meta. See UserMeta16.
+	userMetaHigh byte
 }
 
 // String returns a string representation of Item
@@ -61,19 +74,29 @@ func (item *Item) String() string {
 	return fmt.Sprintf("key=%q, version=%d, meta=%x", item.Key(), item.Version(), item.meta)
 }
 
-// Key returns the key.
+// Key returns the key. If the item comes from an iterator with IteratorOptions.StripPrefix set,
+// the iteration prefix is left out. Use FullKey to get the key including the prefix.
 //
 // Key is only valid as long as item is valid, or transaction is valid.  If you need to use it
 // outside its validity, please use KeyCopy.
 func (item *Item) Key() []byte {
+	return item.FullKey()[item.stripLen:]
+}
+
+// FullKey returns the complete key of the item, even if IteratorOptions.StripPrefix is set. It has
+// the same validity as Key.
+func (item *Item) FullKey() []byte {
+	if item.fullKey != nil {
+		return item.fullKey
+	}
 	return item.key
 }
 
-// KeyCopy returns a copy of the key of the item, writing it to dst slice.
+// KeyCopy returns a copy of the key of the item, as returned by Key, writing it to dst slice.
 // If nil is passed, or capacity of dst isn't sufficient, a new slice would be allocated and
 // returned.
 func (item *Item) KeyCopy(dst []byte) []byte {
-	return y.SafeCopy(dst, item.key)
+	return y.SafeCopy(dst, item.Key())
 }
 
 // Version returns the commit timestamp of the item.
@@ -95,6 +118,7 @@ func (item *Item) Value(fn func(val []byte) error) error {
 	item.wg.Wait()
 	if item.status == prefetched {
 		if item.err == nil && fn != nil {
+			item.addBytesRead(len(item.val))
 			if err := fn(item.val); err != nil {
 				return err
 			}
@@ -107,6 +131,7 @@ func (item *Item) Value(fn func(val []byte) error) error {
 		return err
 	}
 	if fn != nil {
+		item.addBytesRead(len(buf))
 		return fn(buf)
 	}
 	return nil
@@ -121,13 +146,25 @@ func (item *Item) Value(fn func(val []byte) error) error {
 func (item *Item) ValueCopy(dst []byte) ([]byte, error) {
 	item.wg.Wait()
 	if item.status == prefetched {
+		if item.err == nil {
+			item.addBytesRead(len(item.val))
+		}
 		return y.SafeCopy(dst, item.val), item.err
 	}
 	buf, cb, err := item.yieldItemValue()
 	defer runCallback(cb)
+	if err == nil {
+		item.addBytesRead(len(buf))
+	}
 	return y.SafeCopy(dst, buf), err
 }
 
+func (item *Item) addBytesRead(n int) {
+	if item.bytesRead != nil {
+		item.bytesRead.Add(int64(n))
+	}
+}
+
 func (item *Item) hasValue() bool {
 	if item.meta == 0 && item.vptr == nil {
 		// key not found
@@ -148,7 +185,7 @@ func (item *Item) DiscardEarlierVersions() bool {
 }
 
 func (item *Item) yieldItemValue() ([]byte, func(), error) {
-	key := item.Key() // No need to copy.
+	key := item.key // No need to copy.
 	if !item.hasValue() {
 		return nil, nil, nil
 	}
@@ -166,7 +203,12 @@ func (item *Item) yieldItemValue() ([]byte, func(), error) {
 	var vp valuePointer
 	vp.Decode(item.vptr)
 	db := item.txn.db
-	result, cb, err := db.vlog.Read(vp, item.slice)
+	result, cb, err := db.valueLogFor(vp).Read(vp, item.slice)
+	if item.readStats != nil {
+		item.txn.readsLock.Lock()
+		item.readStats.VlogReads++
+		item.txn.readsLock.Unlock()
+	}
 	if err != nil {
 		db.opt.Errorf("Unable to read: Key: %v, Version : %v, meta: %v, userMeta: %v"+
 			" Error: %v", key, item.version, item.meta, item.userMeta, err)
@@ -182,8 +224,11 @@ func (item *Item) yieldItemValue() ([]byte, func(), error) {
 		iopt.AllVersions = true
 		iopt.InternalAccess = true
 		iopt.PrefetchValues = false
+		// item.key is the stored key, which must not be passed through KeyWriteTransform again.
+		iopt.rawKeys = true
+		iopt.internal = true
 
-		it := txn.NewKeyIterator(item.Key(), iopt)
+		it := txn.NewKeyIterator(item.key, iopt)
 		defer it.Close()
 		for it.Rewind(); it.Valid(); it.Next() {
 			item := it.Item()
@@ -268,6 +313,12 @@ func (item *Item) UserMeta() byte {
 	return item.userMeta
 }
 
+// UserMeta16 returns the 16 bit user meta set by the user with Entry.WithUserMeta16. Its low byte
+// is UserMeta. The high byte is zero for entries written with a single byte user meta.
+func (item *Item) UserMeta16() uint16 {
+	return uint16(item.userMetaHigh)<<8 | uint16(item.userMeta)
+}
+
 // ExpiresAt returns a Unix time value indicating when the item will be
 // considered expired. 0 indicates that the item will never expire.
 func (item *Item) ExpiresAt() uint64 {
@@ -320,15 +371,69 @@ type IteratorOptions struct {
 	// iteration and store them.
 	PrefetchValues bool
 	Reverse        bool // Direction of iteration. False is forward, true is backward.
-	AllVersions    bool // Fetch all valid versions of the same key.
+	// Fetch all valid versions of the same key, newest first. A key holds at most one entry per
+	// version: writing it again at an existing version replaces the entry written before, in the
+	// order of the commits. See Options.RejectDuplicateVersions.
+	AllVersions    bool
 	InternalAccess bool // Used to allow internal access to badger keys.
 
+	// PrefetchWorkers, if positive, is the number of goroutines fetching the values of upcoming
+	// items concurrently, when PrefetchValues is set. On storage with high latency, more workers
+	// keep more reads in flight. Values are still handed out in iteration order. Zero fetches
+	// each value in a goroutine of its own.
+	PrefetchWorkers int
+
 	// The following option is used to narrow down the SSTables that iterator
 	// picks up. If Prefix is specified, only tables which could have this
 	// prefix are picked based on their range of keys.
 	prefixIsKey bool   // If set, use the prefix for bloom filter lookup.
+	withDeletes bool   // If set, deleted or expired latest versions are returned. Used by Diff.
+	rawKeys     bool   // If set, Options.KeyWriteTransform and KeyReadTransform are not applied.
+	internal    bool   // If set, the iterator doesn't count against Options.MaxConcurrentIterators.
 	Prefix      []byte // Only iterate over this given prefix.
 	SinceTs     uint64 // Only read data that has version > SinceTs.
+
+	// DeletesOnly makes the iterator return only the keys deleted as of the read timestamp, as
+	// items holding their delete markers. Along with AllVersions, every delete marker is returned
+	// instead, so that with SinceTs the iterator gives the deletions made after a version. The
+	// values of the keys which are set are never read. Keys which have expired aren't returned,
+	// unless IncludeExpired is set too.
+	DeletesOnly    bool
+	IncludeExpired bool
+
+	// Sample, if set, makes the iterator return only a deterministic sample of the keys.
+	Sample *IteratorSample
+
+	// ValueReadAhead, if positive, is the number of bytes of the value log to read ahead once the
+	// values are found to be read sequentially, i.e. each value pointer is a little further in
+	// the same value log file than the previous one. This is the case when values were written in
+	// about the same order as the keys. The OS is then asked to load the next ValueReadAhead bytes
+	// of the file at once, instead of a page at a time as the values get read. Values read in
+	// random order don't trigger any read-ahead. Reverse iteration doesn't benefit from it.
+	ValueReadAhead int
+
+	// StripPrefix makes Item.Key and Item.KeyCopy leave out Prefix from the keys they return, so
+	// a key equal to Prefix is returned as an empty key. Item.FullKey still returns the whole key.
+	StripPrefix bool
+
+	// RawInternalKeys makes Item.Key, KeyCopy and FullKey return the internal keys exactly as they
+	// are stored in the memtables and tables: the key followed by 8 bytes holding math.MaxUint64
+	// minus the version, in big-endian order. Options.KeyReadTransform isn't applied. This is meant
+	// for debugging tools matching the iteration with the contents of the table files. The
+	// returned keys can't be passed to Txn.Get or Iterator.Seek; use y.ParseKey and y.ParseTs to
+	// take them apart.
+	RawInternalKeys bool
+}
+
+// IteratorSample configures sampling of the keys returned by an Iterator. Whether a key is part
+// of the sample depends only on Seed and the key bytes, so running the same scan with the same
+// seed always yields the same sample.
+type IteratorSample struct {
+	// Rate is the probability with which each key is returned. A Rate of 1.0 or more returns all
+	// the keys, while a Rate of 0.0 or less returns none of them without scanning anything.
+	Rate float64
+	// Seed is mixed with the key bytes to decide whether the key is part of the sample.
+	Seed int64
 }
 
 func (opt *IteratorOptions) compareToPrefix(key []byte) int {
@@ -438,6 +543,8 @@ type Iterator struct {
 	iitr   y.Iterator
 	txn    *Txn
 	readTs uint64
+	// Versions at or below deleteTs are skipped, see DB.DeleteBelowVersion.
+	deleteTs uint64
 
 	opt   IteratorOptions
 	item  *Item
@@ -446,8 +553,25 @@ type Iterator struct {
 
 	lastKey []byte // Used to skip over multiple versions of the same key.
 
-	closed  bool
-	scanned int // Used to estimate the size of data scanned by iterator.
+	sampler *xxhash.Digest // Used to decide if a key is sampled. Nil if opt.Sample is not set.
+
+	closed       bool
+	slotted      bool // Set if the iterator holds an Options.MaxConcurrentIterators slot.
+	discardTxn   bool // Set if the iterator owns txn. See DB.Diff.
+	keyTransform bool // Set if the keys go through Options.KeyWriteTransform and KeyReadTransform.
+	stripLen     int  // Length of the prefix given by the user. See IteratorOptions.StripPrefix.
+	scanned      int  // Used to estimate the size of data scanned by iterator.
+
+	multi *multiPrefixIterator // Set for the iterators returned by NewMultiPrefixIterator.
+
+	// The last value pointer seen, and the window read ahead. See IteratorOptions.ValueReadAhead.
+	lastVp  valuePointer
+	aheadVp valuePointer
+
+	bytesRead atomic.Int64 // Value bytes handed out by the items. See BytesRead.
+
+	// Feeds the items to prefetch to the workers. Nil unless IteratorOptions.PrefetchWorkers is set.
+	prefetchCh chan *Item
 
 	// ThreadId is an optional value that can be set to identify which goroutine created
 	// the iterator. It can be used, for example, to uniquely identify each of the
@@ -475,15 +599,81 @@ func (txn *Txn) NewIterator(opt IteratorOptions) *Iterator {
 		panic(ErrDBClosed)
 	}
 
+	if opt.internal {
+		return txn.newIterator(opt)
+	}
+	if err := txn.db.acquireIteratorSlot(txn.db.opt.BlockOnMaxIterators); err != nil {
+		panic(err)
+	}
+	it := txn.newIterator(opt)
+	it.slotted = txn.db.iteratorSlots != nil
+	return it
+}
+
+// TryNewIterator is like NewIterator, but returns an error instead of panicking. It fails with
+// ErrTooManyIterators once Options.MaxConcurrentIterators iterators are open, without blocking
+// even if Options.BlockOnMaxIterators is set, so that callers can back off under load.
+func (txn *Txn) TryNewIterator(opt IteratorOptions) (*Iterator, error) {
+	if txn.discarded {
+		return nil, ErrDiscardedTxn
+	}
+	if txn.db.IsClosed() {
+		return nil, ErrDBClosed
+	}
+	if err := txn.db.acquireIteratorSlot(false); err != nil {
+		return nil, err
+	}
+	it := txn.newIterator(opt)
+	it.slotted = txn.db.iteratorSlots != nil
+	return it, nil
+}
+
+// acquireIteratorSlot takes one of the Options.MaxConcurrentIterators slots, which Iterator.Close
+// gives back. If wait is set, it blocks until a slot is free, otherwise it fails with
+// ErrTooManyIterators. Only the iterators created by the user take a slot: the ones Badger opens
+// for itself, e.g. to merge values or stream the DB, must not block or fail when the user's
+// iterators use up the slots.
+func (db *DB) acquireIteratorSlot(wait bool) error {
+	slots := db.iteratorSlots
+	switch {
+	case slots == nil:
+	case wait:
+		slots <- struct{}{}
+	default:
+		select {
+		case slots <- struct{}{}:
+		default:
+			return ErrTooManyIterators
+		}
+	}
+	return nil
+}
+
+// newIterator creates the iterator of NewIterator, once the iterator slot is taken.
+func (txn *Txn) newIterator(opt IteratorOptions) *Iterator {
 	y.NumIteratorsCreatedAdd(txn.db.opt.MetricsEnabled, 1)
 
 	// Keep track of the number of active iterators.
 	txn.numIterators.Add(1)
 
+	// The prefix must be transformed before picking the tables.
+	stripLen := len(opt.Prefix)
+	keyTransform := !opt.rawKeys && txn.db.opt.KeyWriteTransform != nil
+	if keyTransform {
+		opt.Prefix = txn.db.opt.KeyWriteTransform(opt.Prefix)
+	}
+
+	// The memtables and the tables are picked under db.lock, which DropPrefix holds while it
+	// flushes the memtables and rewrites the tables. So the iterator sees either all of the keys
+	// dropped, or none of them, and keeps seeing them until it is closed.
+	txn.db.lock.RLock()
 	// TODO: If Prefix is set, only pick those memtables which have keys with the prefix.
-	tables, decr := txn.db.getMemTables()
+	tables, decr := txn.db.getMemTablesRLocked()
 	defer decr()
 	txn.db.vlog.incrIteratorCount()
+	if txn.db.blob != nil {
+		txn.db.blob.incrIteratorCount()
+	}
 	var iters []y.Iterator
 	if itr := txn.newPendingWritesIterator(opt.Reverse); itr != nil {
 		iters = append(iters, itr)
@@ -492,11 +682,34 @@ func (txn *Txn) NewIterator(opt IteratorOptions) *Iterator {
 		iters = append(iters, tables[i].sl.NewUniIterator(opt.Reverse))
 	}
 	iters = txn.db.lc.appendIterators(iters, &opt) // This will increment references.
+	txn.db.lock.RUnlock()
+	iitr := table.NewMergeIterator(iters, opt.Reverse)
+	if txn.db.opt.ValidateKeyOrder && iitr != nil {
+		iitr = &orderCheckIterator{Iterator: iitr, reverse: opt.Reverse}
+	}
 	res := &Iterator{
 		txn:    txn,
-		iitr:   table.NewMergeIterator(iters, opt.Reverse),
+		iitr:   iitr,
 		opt:    opt,
 		readTs: txn.readTs,
+
+		deleteTs:     txn.db.orc.deleteAtOrBelow(),
+		keyTransform: keyTransform,
+		stripLen:     stripLen,
+	}
+	if opt.Sample != nil {
+		res.sampler = xxhash.NewWithSeed(uint64(opt.Sample.Seed))
+	}
+	if opt.PrefetchValues && opt.PrefetchWorkers > 0 {
+		res.prefetchCh = make(chan *Item, max(opt.PrefetchSize, opt.PrefetchWorkers))
+		for i := 0; i < opt.PrefetchWorkers; i++ {
+			go func() {
+				for item := range res.prefetchCh {
+					item.prefetchValue()
+					item.wg.Done()
+				}
+			}()
+		}
 	}
 	return res
 }
@@ -514,37 +727,234 @@ func (txn *Txn) NewKeyIterator(key []byte, opt IteratorOptions) *Iterator {
 	return txn.NewIterator(opt)
 }
 
+// Diff returns an iterator over the keys with the given prefix whose latest version as of toTs was
+// written after fromTs, i.e. the keys which changed between the snapshots at fromTs and toTs. For
+// each key, the iterator yields that latest version. Like with AllVersions, it can be a deletion:
+// Item.IsDeletedOrExpired tells whether the key was set or deleted. Tables which don't have
+// versions above fromTs are skipped.
+//
+// The iterator holds a read-only transaction, which is discarded by Iterator.Close. In managed
+// mode, it reads at toTs. Otherwise, toTs must not be above the current read timestamp, and the
+// versions written at or before toTs might already have been discarded by compactions if they
+// were overwritten, so toTs should be recent.
+func (db *DB) Diff(fromTs, toTs uint64, prefix []byte) (*Iterator, error) {
+	if db.IsClosed() {
+		return nil, ErrDBClosed
+	}
+	if fromTs >= toTs {
+		return nil, ErrInvalidRequest
+	}
+	var txn *Txn
+	if db.opt.managedTxns {
+		txn = db.NewTransactionAt(toTs, false)
+	} else {
+		txn = db.NewTransaction(false)
+		if toTs > txn.readTs {
+			txn.Discard()
+			return nil, ErrInvalidRequest
+		}
+	}
+	opt := DefaultIteratorOptions
+	opt.Prefix = prefix
+	opt.SinceTs = fromTs
+	opt.withDeletes = true
+	it := txn.NewIterator(opt)
+	it.readTs = toTs
+	it.discardTxn = true
+	it.Rewind()
+	return it, nil
+}
+
+// FirstKey returns the smallest live key with the given prefix, or ErrKeyNotFound if there is
+// none. An empty prefix returns the smallest key in the DB. Only the tables which could hold the
+// prefix are consulted, so this does not scan.
+func (db *DB) FirstKey(prefix []byte) ([]byte, error) {
+	var key []byte
+	err := db.View(func(txn *Txn) error {
+		opt := DefaultIteratorOptions
+		opt.PrefetchValues = false
+		opt.Prefix = prefix
+		opt.internal = true
+		it := txn.NewIterator(opt)
+		defer it.Close()
+		it.Rewind()
+		if !it.Valid() {
+			return ErrKeyNotFound
+		}
+		key = it.Item().KeyCopy(nil)
+		return nil
+	})
+	return key, err
+}
+
+// LastKey returns the largest live key with the given prefix, or ErrKeyNotFound if there is
+// none. An empty prefix returns the largest key in the DB.
+func (db *DB) LastKey(prefix []byte) ([]byte, error) {
+	var key []byte
+	err := db.View(func(txn *Txn) error {
+		opt := DefaultIteratorOptions
+		opt.PrefetchValues = false
+		opt.Reverse = true
+		opt.internal = true
+		opt.Prefix = prefix
+		it := txn.NewIterator(opt)
+		defer it.Close()
+		it.seekPrefixEnd()
+		if !it.Valid() {
+			return ErrKeyNotFound
+		}
+		key = it.Item().KeyCopy(nil)
+		return nil
+	})
+	return key, err
+}
+
+// CountDistinctPrefixComponents returns the number of distinct prefixes made of the first depth
+// components of the live keys, where the components of a key are separated by separator. For keys
+// like tenant/entity/id, a separator of '/' and a depth of 1 count the tenants. The prefix of a key
+// with fewer than depth components is made of all of its components, so the keys "a" and "a/b"
+// share the prefix "a" at a depth of 1, but have the prefixes "a" and "a/b" at a depth of 2.
+//
+// Once a key with the next prefix is found, the iterator seeks past all the keys starting with
+// the prefix and the separator, so the cost depends on the number of distinct prefixes rather
+// than the number of keys.
+func (db *DB) CountDistinctPrefixComponents(separator byte, depth int) (int64, error) {
+	if depth < 1 {
+		return 0, ErrInvalidRequest
+	}
+	var count int64
+	err := db.View(func(txn *Txn) error {
+		opt := DefaultIteratorOptions
+		opt.PrefetchValues = false
+		opt.internal = true
+		it := txn.NewIterator(opt)
+		defer it.Close()
+
+		// The keys with fewer than depth components which were counted, and which can still show
+		// up as the prefix of a later key: a key "a" can be followed by keys like "a!" before the
+		// keys starting with "a/", whose prefix must not be counted again. Each entry is a prefix
+		// of the next one.
+		var open [][]byte
+		for it.Rewind(); it.Valid(); {
+			key := it.Item().Key()
+			prefix, rest := key, false
+			for i, n := 0, 0; i < len(key); i++ {
+				if key[i] == separator {
+					if n++; n == depth {
+						prefix, rest = key[:i], true
+						break
+					}
+				}
+			}
+			for len(open) > 0 && !bytes.HasPrefix(key, open[len(open)-1]) {
+				open = open[:len(open)-1]
+			}
+			if !rest {
+				count++
+				open = append(open, y.SafeCopy(nil, key))
+				it.Next()
+				continue
+			}
+			if !slices.ContainsFunc(open, func(o []byte) bool { return bytes.Equal(o, prefix) }) {
+				count++
+			}
+			// Skip all the keys starting with the prefix and the separator.
+			next := prefixSuccessor(append(append([]byte{}, prefix...), separator))
+			if next == nil {
+				break
+			}
+			it.Seek(next)
+		}
+		return nil
+	})
+	return count, err
+}
+
+// prefixSuccessor returns the smallest key which is larger than every key with the given
+// prefix, or nil if there is no such key.
+func prefixSuccessor(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			upper := append([]byte{}, prefix[:i+1]...)
+			upper[i]++
+			return upper
+		}
+	}
+	return nil
+}
+
 func (it *Iterator) newItem() *Item {
 	item := it.waste.pop()
 	if item == nil {
-		item = &Item{slice: new(y.Slice), txn: it.txn}
+		item = &Item{slice: new(y.Slice), txn: it.txn, bytesRead: &it.bytesRead}
+		if it.opt.StripPrefix {
+			item.stripLen = it.stripLen
+		}
 	}
 	return item
 }
 
+// BytesRead returns the total size of the values read so far via Item.Value and Item.ValueCopy on
+// the items of this iterator. Each call is counted, so reading a value twice counts it twice. It
+// can be used to stop the iteration once the caller has materialized enough data.
+func (it *Iterator) BytesRead() int64 {
+	if it.multi != nil {
+		var n int64
+		for _, sub := range it.multi.iters {
+			n += sub.BytesRead()
+		}
+		return n
+	}
+	return it.bytesRead.Load()
+}
+
 // Item returns pointer to the current key-value pair.
 // This item is only valid until it.Next() gets called.
 func (it *Iterator) Item() *Item {
 	tx := it.txn
-	tx.addReadKey(it.item.Key())
+	tx.addReadKey(it.item.key)
 	return it.item
 }
 
+// Peek returns the item which Next moves to, without advancing the iterator. Next then makes it
+// the current item, so Item returns the same pointer. Peek returns nil if Next would end the
+// iteration. Like Item, the returned item is only valid until the iterator moves past it.
+func (it *Iterator) Peek() *Item {
+	var item *Item
+	switch {
+	case it.multi != nil:
+		item = it.multi.peek()
+	case it.item != nil:
+		item = it.data.head
+	}
+	if !it.validItem(item) {
+		return nil
+	}
+	it.txn.addReadKey(item.key)
+	return item
+}
+
 // Valid returns false when iteration is done.
 func (it *Iterator) Valid() bool {
-	if it.item == nil {
+	return it.validItem(it.item)
+}
+
+// validItem tells whether item is within the iteration.
+func (it *Iterator) validItem(item *Item) bool {
+	if item == nil {
 		return false
 	}
 	if it.opt.prefixIsKey {
-		return bytes.Equal(it.item.key, it.opt.Prefix)
+		return bytes.Equal(item.key, it.opt.Prefix)
 	}
-	return bytes.HasPrefix(it.item.key, it.opt.Prefix)
+	return bytes.HasPrefix(item.key, it.opt.Prefix)
 }
 
 // ValidForPrefix returns false when iteration is done
 // or when the current key is not prefixed by the specified prefix.
+// The full key is checked, even if IteratorOptions.StripPrefix is set.
 func (it *Iterator) ValidForPrefix(prefix []byte) bool {
-	return it.Valid() && bytes.HasPrefix(it.item.key, prefix)
+	return it.Valid() && bytes.HasPrefix(it.item.FullKey(), prefix)
 }
 
 // Close would close the iterator. It is important to call this when you're done with iteration.
@@ -553,6 +963,19 @@ func (it *Iterator) Close() {
 		return
 	}
 	it.closed = true
+	if it.slotted {
+		<-it.txn.db.iteratorSlots
+	}
+	if it.multi != nil {
+		for _, sub := range it.multi.iters {
+			sub.Close()
+		}
+		return
+	}
+	if it.prefetchCh != nil {
+		// The workers exit once they prefetched the items already queued.
+		close(it.prefetchCh)
+	}
 	if it.iitr == nil {
 		it.txn.numIterators.Add(-1)
 		return
@@ -573,15 +996,29 @@ func (it *Iterator) Close() {
 
 	// TODO: We could handle this error.
 	_ = it.txn.db.vlog.decrIteratorCount()
+	if it.txn.db.blob != nil {
+		_ = it.txn.db.blob.decrIteratorCount()
+	}
 	it.txn.numIterators.Add(-1)
+	if it.discardTxn {
+		it.txn.Discard()
+	}
 }
 
 // Next would advance the iterator by one. Always check it.Valid() after a Next()
 // to ensure you have access to a valid it.Item().
 func (it *Iterator) Next() {
+	if it.multi != nil {
+		it.multi.next()
+		it.item = it.multi.item()
+		return
+	}
 	if it.iitr == nil {
 		return
 	}
+	if it.txn.db.latency != nil {
+		defer it.txn.db.latency.iterNext.since(time.Now())
+	}
 	// Reuse current item
 	it.item.wg.Wait() // Just cleaner to wait before pushing to avoid doing ref counting.
 	it.scanned += len(it.item.key) + len(it.item.val) + len(it.item.vptr) + 2
@@ -641,6 +1078,11 @@ func (it *Iterator) parseItem() bool {
 		mi.Next()
 		return false
 	}
+	// Versions deleted by DB.DeleteBelowVersion which compactions haven't dropped yet.
+	if it.deleteTs > 0 && version <= it.deleteTs && !isInternalKey {
+		mi.Next()
+		return false
+	}
 
 	// Skip banned keys only if it does not have badger internal prefix.
 	if !isInternalKey && it.txn.db.isBanned(key) != nil {
@@ -648,7 +1090,18 @@ func (it *Iterator) parseItem() bool {
 		return false
 	}
 
+	// Skip keys which are not part of the sample. The decision only depends on the key, so all
+	// the versions of a key are either skipped or kept together.
+	if !it.sampled(key) {
+		mi.Next()
+		return false
+	}
+
 	if it.opt.AllVersions {
+		if it.opt.DeletesOnly && !it.isDeletion(mi.Value()) {
+			mi.Next()
+			return false
+		}
 		// Return deleted or expired values also, otherwise user can't figure out
 		// whether the key was deleted.
 		item := it.newItem()
@@ -676,7 +1129,12 @@ func (it *Iterator) parseItem() bool {
 FILL:
 	// If deleted, advance and return.
 	vs := mi.Value()
-	if isDeletedOrExpired(vs.Meta, vs.ExpiresAt) {
+	if it.opt.DeletesOnly {
+		if !it.isDeletion(vs) {
+			mi.Next()
+			return false
+		}
+	} else if isDeletedOrExpired(vs.Meta, vs.ExpiresAt) && !it.opt.withDeletes {
 		mi.Next()
 		return false
 	}
@@ -704,19 +1162,52 @@ FILL:
 	return true
 }
 
+// isDeletion returns true if an entry with the given value should be returned as per
+// opt.DeletesOnly and opt.IncludeExpired.
+func (it *Iterator) isDeletion(vs y.ValueStruct) bool {
+	if vs.Meta&bitDelete > 0 {
+		return true
+	}
+	return it.opt.IncludeExpired && isDeletedOrExpired(vs.Meta, vs.ExpiresAt)
+}
+
+// sampled returns true if the given key should be returned as per opt.Sample.
+func (it *Iterator) sampled(key []byte) bool {
+	if it.sampler == nil || it.opt.Sample.Rate >= 1.0 {
+		return true
+	}
+	it.sampler.ResetWithSeed(uint64(it.opt.Sample.Seed))
+	_, _ = it.sampler.Write(y.ParseKey(key))
+	// The top 53 bits of the hash give a uniformly distributed float64 in [0, 1).
+	return float64(it.sampler.Sum64()>>11)/(1<<53) < it.opt.Sample.Rate
+}
+
 func (it *Iterator) fill(item *Item) {
 	vs := it.iitr.Value()
 	item.meta = vs.Meta
-	item.userMeta = vs.UserMeta
+	item.userMeta, item.userMetaHigh = vs.UserMeta, vs.UserMetaHigh
 	item.expiresAt = vs.ExpiresAt
 
 	item.version = y.ParseTs(it.iitr.Key())
 	item.key = y.SafeCopy(item.key, y.ParseKey(it.iitr.Key()))
+	switch {
+	case it.opt.RawInternalKeys:
+		item.fullKey = y.SafeCopy(item.fullKey, it.iitr.Key())
+	case it.keyTransform:
+		item.fullKey = y.SafeCopy(item.fullKey, it.txn.db.opt.KeyReadTransform(item.key))
+	}
 
 	item.vptr = y.SafeCopy(item.vptr, vs.Value)
 	item.val = nil
+	if it.opt.ValueReadAhead > 0 && item.meta&bitValuePointer > 0 {
+		it.readAhead(item.vptr)
+	}
 	if it.opt.PrefetchValues {
 		item.wg.Add(1)
+		if it.prefetchCh != nil {
+			it.prefetchCh <- item
+			return
+		}
 		go func() {
 			// FIXME we are not handling errors here.
 			item.prefetchValue()
@@ -725,6 +1216,54 @@ func (it *Iterator) fill(item *Item) {
 	}
 }
 
+// readAhead reads the value log ahead of vptr, if the values are read sequentially and vptr isn't
+// in the window read ahead already.
+func (it *Iterator) readAhead(vptr []byte) {
+	var vp valuePointer
+	vp.Decode(vptr)
+	last := it.lastVp
+	it.lastVp = vp
+
+	ahead := it.aheadVp
+	if vp.Fid == ahead.Fid && vp.Offset >= ahead.Offset &&
+		uint64(vp.Offset)+uint64(vp.Len) <= uint64(ahead.Offset)+uint64(ahead.Len) {
+		return
+	}
+	window := uint32(it.opt.ValueReadAhead)
+	if last.Len == 0 || vp.Fid != last.Fid || vp.Offset <= last.Offset ||
+		vp.Offset-last.Offset > window {
+		// Random access.
+		return
+	}
+	it.aheadVp = valuePointer{Fid: vp.Fid, Offset: vp.Offset, Len: window}
+	it.txn.db.valueLogFor(vp).readAhead(it.aheadVp)
+}
+
+// orderCheckIterator panics if the keys of the wrapped iterator aren't strictly increasing, or
+// decreasing if reverse is set. See Options.ValidateKeyOrder.
+type orderCheckIterator struct {
+	y.Iterator
+	reverse bool
+	lastKey []byte
+}
+
+func (it *orderCheckIterator) Next() {
+	it.lastKey = y.SafeCopy(it.lastKey, it.Iterator.Key())
+	it.Iterator.Next()
+	if !it.Iterator.Valid() {
+		return
+	}
+	key := it.Iterator.Key()
+	cmp := y.CompareKeys(key, it.lastKey)
+	if it.reverse {
+		cmp = -cmp
+	}
+	if cmp <= 0 {
+		panic(fmt.Sprintf("merge iterator: key %q at version %d came after key %q at version %d",
+			y.ParseKey(key), y.ParseTs(key), y.ParseKey(it.lastKey), y.ParseTs(it.lastKey)))
+	}
+}
+
 func hasPrefix(it *Iterator) bool {
 	// We shouldn't check prefix in case the iterator is going in reverse. Since in reverse we expect
 	// people to append items to the end of prefix.
@@ -758,9 +1297,17 @@ func (it *Iterator) prefetch() {
 // smallest key greater than the provided key if iterating in the forward direction.
 // Behavior would be reversed if iterating backwards.
 func (it *Iterator) Seek(key []byte) {
+	if it.multi != nil {
+		it.multi.seek(key, it.opt.StripPrefix)
+		it.item = it.multi.item()
+		return
+	}
 	if it.iitr == nil {
 		return
 	}
+	if len(key) > 0 && it.keyTransform {
+		key = it.txn.db.opt.KeyWriteTransform(key)
+	}
 	if len(key) > 0 {
 		it.txn.addReadKey(key)
 	}
@@ -770,6 +1317,17 @@ func (it *Iterator) Seek(key []byte) {
 	}
 
 	it.lastKey = it.lastKey[:0]
+	if it.opt.Sample != nil && it.opt.Sample.Rate <= 0.0 {
+		// Nothing would be sampled, so don't bother scanning.
+		it.item = nil
+		return
+	}
+	if len(key) == 0 && it.opt.Reverse && it.keyTransform && it.stripLen == 0 {
+		// opt.Prefix only holds the namespace of Options.KeyWriteTransform, of which a reverse
+		// rewind must find the last key, like for the whole key space without a transform.
+		it.seekPrefixEnd()
+		return
+	}
 	if len(key) == 0 {
 		key = it.opt.Prefix
 	}
@@ -788,6 +1346,52 @@ func (it *Iterator) Seek(key []byte) {
 	it.prefetch()
 }
 
+// SeekWhile moves the iterator, starting from the current item, to the first item at which fn
+// returns false for skip. For an item to be skipped, fn may return the key to seek to next, which
+// lets a scan jump over a range of keys it doesn't want instead of stepping through them one by
+// one. If next is nil, or doesn't lie past the current key in the direction of iteration, the
+// iterator moves to the following item instead. fn gets the key as Item.Key returns it, and next
+// is taken the same way: with IteratorOptions.StripPrefix, or from a multi-prefix iterator which
+// strips the prefixes, both leave the prefix out. Check it.Valid() after SeekWhile returns.
+func (it *Iterator) SeekWhile(fn func(key []byte) (skip bool, next []byte)) {
+	for it.Valid() {
+		item := it.Item()
+		key := item.Key()
+		skip, next := fn(key)
+		if !skip {
+			return
+		}
+		cmp := bytes.Compare(next, key)
+		if len(next) == 0 || (!it.opt.Reverse && cmp <= 0) || (it.opt.Reverse && cmp >= 0) {
+			it.Next()
+			continue
+		}
+		if it.multi == nil && item.stripLen > 0 {
+			// Seek takes the full key, unlike the seek of a multi-prefix iterator.
+			next = append(append([]byte{}, item.FullKey()[:item.stripLen]...), next...)
+		}
+		it.Seek(next)
+	}
+}
+
+// seekPrefixEnd positions a new reverse iterator at the last key with opt.Prefix, where Rewind
+// would position it at the prefix itself. A reverse seek finds the largest key at or below the
+// seek key, so it seeks to the first key past the prefix range and steps back over it. If there's
+// no such key, the prefix range runs to the end of the key space.
+func (it *Iterator) seekPrefixEnd() {
+	upper := prefixSuccessor(it.opt.Prefix)
+	if upper == nil {
+		it.iitr.Rewind()
+		it.prefetch()
+		return
+	}
+	it.iitr.Seek(y.KeyWithTs(upper, 0))
+	it.prefetch()
+	for it.item != nil && bytes.Equal(it.item.key, upper) {
+		it.Next()
+	}
+}
+
 // Rewind would rewind the iterator cursor all the way to zero-th position, which would be the
 // smallest key if iterating forward, and largest if iterating backward. It does not keep track of
 // whether the cursor started with a Seek().
@@ -18,13 +18,18 @@ package badger
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"math"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -424,3 +429,1248 @@ func BenchmarkIteratePrefixSingleKey(b *testing.B) {
 		}
 	})
 }
+
+func TestIteratorSample(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		n := 10000
+		batch := db.NewWriteBatch()
+		for i := 0; i < n; i++ {
+			require.NoError(t, batch.Set([]byte(fmt.Sprintf("%05d", i)), []byte("OK")))
+		}
+		require.NoError(t, batch.Flush())
+
+		scan := func(sample *IteratorSample, reverse bool) []string {
+			var keys []string
+			require.NoError(t, db.View(func(txn *Txn) error {
+				iopt := DefaultIteratorOptions
+				iopt.Sample = sample
+				iopt.Reverse = reverse
+				it := txn.NewIterator(iopt)
+				defer it.Close()
+				for it.Rewind(); it.Valid(); it.Next() {
+					keys = append(keys, string(it.Item().KeyCopy(nil)))
+				}
+				return nil
+			}))
+			return keys
+		}
+
+		require.Len(t, scan(&IteratorSample{Rate: 1.0}, false), n)
+		require.Len(t, scan(&IteratorSample{Rate: 0.0}, false), 0)
+
+		first := scan(&IteratorSample{Rate: 0.1, Seed: 42}, false)
+		require.InDelta(t, n/10, len(first), float64(n/50))
+		// The same seed must give the same sample, irrespective of the direction.
+		require.Equal(t, first, scan(&IteratorSample{Rate: 0.1, Seed: 42}, false))
+		reversed := scan(&IteratorSample{Rate: 0.1, Seed: 42}, true)
+		require.Len(t, reversed, len(first))
+		require.Equal(t, first[0], reversed[len(reversed)-1])
+		// A different seed should give a different sample.
+		require.NotEqual(t, first, scan(&IteratorSample{Rate: 0.1, Seed: 7}, false))
+	})
+}
+
+func TestIteratorBytesRead(t *testing.T) {
+	opt := getTestOptions("").WithValueThreshold(64)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		txn := db.NewTransaction(true)
+		for i := 0; i < 100; i++ {
+			// Half the values go into the value log.
+			val := make([]byte, 10+(i%2)*100)
+			require.NoError(t, txn.Set([]byte(fmt.Sprintf("key%03d", i)), val))
+		}
+		require.NoError(t, txn.Commit())
+
+		for _, prefetch := range []bool{false, true} {
+			require.NoError(t, db.View(func(txn *Txn) error {
+				iopt := DefaultIteratorOptions
+				iopt.PrefetchValues = prefetch
+				it := txn.NewIterator(iopt)
+				defer it.Close()
+
+				var want int64
+				for it.Rewind(); it.Valid(); it.Next() {
+					require.Equal(t, want, it.BytesRead())
+					val, err := it.Item().ValueCopy(nil)
+					require.NoError(t, err)
+					want += int64(len(val))
+					require.NoError(t, it.Item().Value(func(v []byte) error { return nil }))
+					want += int64(len(val))
+					require.Equal(t, want, it.BytesRead())
+				}
+				require.Equal(t, int64(2*(50*10+50*110)), want)
+
+				// Items read via Get are not counted.
+				item, err := txn.Get([]byte("key001"))
+				require.NoError(t, err)
+				_, err = item.ValueCopy(nil)
+				require.NoError(t, err)
+				require.Equal(t, want, it.BytesRead())
+				return nil
+			}))
+		}
+	})
+}
+
+func TestIteratorStripPrefix(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			for _, k := range []string{"a", "pre", "pre1", "pre2", "z"} {
+				if err := txn.Set([]byte(k), []byte("val-"+k)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+
+		check := func(reverse bool, expected []string) {
+			require.NoError(t, db.View(func(txn *Txn) error {
+				opt := DefaultIteratorOptions
+				opt.Prefix = []byte("pre")
+				opt.StripPrefix = true
+				opt.Reverse = reverse
+				it := txn.NewIterator(opt)
+				defer it.Close()
+
+				var keys []string
+				// Reverse iteration has to seek past the last key with the prefix.
+				start := []byte("pre")
+				if reverse {
+					start = []byte("pre\xff")
+				}
+				for it.Seek(start); it.Valid(); it.Next() {
+					item := it.Item()
+					require.Equal(t, string(item.Key()), string(item.KeyCopy(nil)))
+					require.Equal(t, "pre"+string(item.Key()), string(item.FullKey()))
+					require.Equal(t, "val-"+string(item.FullKey()), string(getItemValue(t, item)))
+					keys = append(keys, string(item.Key()))
+				}
+				require.Equal(t, expected, keys)
+				return nil
+			}))
+		}
+		check(false, []string{"", "1", "2"})
+		check(true, []string{"2", "1", ""})
+	})
+}
+
+func TestIteratorSeekWhile(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			for i := 0; i < 100; i++ {
+				if err := txn.Set([]byte(key("k", i)), []byte("val")); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+
+		// Only the multiples of ten match. Any other key skips ahead to the next multiple.
+		check := func(reverse bool, expected []string) {
+			require.NoError(t, db.View(func(txn *Txn) error {
+				opt := DefaultIteratorOptions
+				opt.Reverse = reverse
+				it := txn.NewIterator(opt)
+				defer it.Close()
+
+				var keys []string
+				calls := 0
+				skip := func(k []byte) (bool, []byte) {
+					calls++
+					i, err := strconv.Atoi(string(k[1:]))
+					require.NoError(t, err)
+					if i%10 == 0 {
+						return false, nil
+					}
+					if reverse {
+						return true, []byte(key("k", i-i%10))
+					}
+					return true, []byte(key("k", i-i%10+10))
+				}
+				for it.Rewind(); it.Valid(); it.Next() {
+					it.SeekWhile(skip)
+					if !it.Valid() {
+						break
+					}
+					keys = append(keys, string(it.Item().Key()))
+				}
+				require.Equal(t, expected, keys)
+				require.Less(t, calls, 25)
+				return nil
+			}))
+		}
+		var keys []string
+		for i := 0; i < 100; i += 10 {
+			keys = append(keys, key("k", i))
+		}
+		check(false, keys)
+		slices.Reverse(keys)
+		check(true, keys)
+
+		// A key which doesn't move the iterator forward steps to the next item.
+		require.NoError(t, db.View(func(txn *Txn) error {
+			it := txn.NewIterator(DefaultIteratorOptions)
+			defer it.Close()
+			it.Seek([]byte(key("k", 5)))
+			it.SeekWhile(func(k []byte) (bool, []byte) {
+				return string(k) != key("k", 7), []byte(key("k", 0))
+			})
+			require.True(t, it.Valid())
+			require.Equal(t, key("k", 7), string(it.Item().Key()))
+			return nil
+		}))
+	})
+	// With the prefixes stripped, fn gets and returns the keys without them.
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			for i := 0; i < 50; i++ {
+				for _, prefix := range []string{"a/", "b/"} {
+					if err := txn.Set([]byte(key(prefix, i)), []byte("val")); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}))
+		skip := func(k []byte) (bool, []byte) {
+			i, err := strconv.Atoi(string(k))
+			require.NoError(t, err)
+			if i%10 == 0 {
+				return false, nil
+			}
+			return true, []byte(key("", i-i%10+10))
+		}
+		walk := func(it *Iterator) []string {
+			defer it.Close()
+			var keys []string
+			for it.Rewind(); it.Valid(); it.Next() {
+				it.SeekWhile(skip)
+				if !it.Valid() {
+					break
+				}
+				keys = append(keys, string(it.Item().FullKey()))
+			}
+			return keys
+		}
+		require.NoError(t, db.View(func(txn *Txn) error {
+			opt := DefaultIteratorOptions
+			opt.Prefix = []byte("b/")
+			opt.StripPrefix = true
+			require.Equal(t, []string{"b/0000", "b/0010", "b/0020", "b/0030", "b/0040"},
+				walk(txn.NewIterator(opt)))
+
+			it, err := txn.NewMultiPrefixIterator([][]byte{[]byte("a/"), []byte("b/")}, true)
+			require.NoError(t, err)
+			require.Equal(t, []string{"a/0000", "b/0000", "a/0010", "b/0010", "a/0020", "b/0020",
+				"a/0030", "b/0030", "a/0040", "b/0040"}, walk(it))
+			return nil
+		}))
+	})
+}
+
+func TestIteratorDeletesOnly(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			for i := 0; i < 10; i++ {
+				if err := txn.Set([]byte(fmt.Sprintf("p%d", i)), []byte("val")); err != nil {
+					return err
+				}
+			}
+			return txn.Set([]byte("q"), []byte("val"))
+		}))
+		txnDelete(t, db, []byte("p1"))
+		txnDelete(t, db, []byte("p3"))
+		txnDelete(t, db, []byte("q"))
+		since := db.MaxVersion()
+		txnDelete(t, db, []byte("p5"))
+		txnSet(t, db, []byte("p3"), []byte("val"), 0)
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			e := NewEntry([]byte("p7"), []byte("val"))
+			e.ExpiresAt = 1
+			return txn.SetEntry(e)
+		}))
+
+		check := func(opt IteratorOptions, expected []string) {
+			require.NoError(t, db.View(func(txn *Txn) error {
+				opt.Prefix = []byte("p")
+				it := txn.NewIterator(opt)
+				defer it.Close()
+
+				var keys []string
+				start := []byte("p")
+				if opt.Reverse {
+					start = []byte("p\xff")
+				}
+				for it.Seek(start); it.Valid(); it.Next() {
+					require.True(t, it.Item().IsDeletedOrExpired())
+					keys = append(keys, string(it.Item().Key()))
+				}
+				require.Equal(t, expected, keys)
+				return nil
+			}))
+		}
+		opt := DefaultIteratorOptions
+		opt.DeletesOnly = true
+		check(opt, []string{"p1", "p5"})
+		opt.Reverse = true
+		check(opt, []string{"p5", "p1"})
+		opt.Reverse = false
+		opt.IncludeExpired = true
+		check(opt, []string{"p1", "p5", "p7"})
+		opt.IncludeExpired = false
+		opt.AllVersions = true
+		check(opt, []string{"p1", "p3", "p5"})
+		opt.SinceTs = since
+		check(opt, []string{"p5"})
+	})
+}
+
+func TestIteratorCursor(t *testing.T) {
+	dir, err := os.MkdirTemp("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	db, err := Open(getTestOptions(dir))
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		for i := 0; i < 100; i++ {
+			if err := txn.Set([]byte(fmt.Sprintf("key%03d", i)), []byte("val")); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	var cursor []byte
+	var readTs uint64
+	require.NoError(t, db.View(func(txn *Txn) error {
+		it := txn.NewIterator(DefaultIteratorOptions)
+		defer it.Close()
+		require.Nil(t, it.Cursor())
+		n := 0
+		for it.Rewind(); it.Valid() && n < 30; it.Next() {
+			n++
+			cursor = it.Cursor()
+		}
+		readTs = txn.readTs
+		return nil
+	}))
+	ts, err := CursorReadTs(cursor)
+	require.NoError(t, err)
+	require.Equal(t, readTs, ts)
+
+	require.NoError(t, db.Close())
+	db, err = Open(getTestOptions(dir))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+
+	require.NoError(t, db.View(func(txn *Txn) error {
+		it, err := txn.NewIteratorFromCursor(cursor, DefaultIteratorOptions)
+		require.NoError(t, err)
+		defer it.Close()
+		i := 30
+		for ; it.Valid(); it.Next() {
+			require.Equal(t, fmt.Sprintf("key%03d", i), string(it.Item().Key()))
+			i++
+		}
+		require.Equal(t, 100, i)
+
+		opt := DefaultIteratorOptions
+		opt.Reverse = true
+		_, err = txn.NewIteratorFromCursor(cursor, opt)
+		require.Equal(t, ErrInvalidCursor, err)
+		_, err = txn.NewIteratorFromCursor([]byte("foo"), DefaultIteratorOptions)
+		require.Equal(t, ErrInvalidCursor, err)
+		return nil
+	}))
+}
+
+func TestIteratorCursorAllVersions(t *testing.T) {
+	opt := getTestOptions("")
+	opt.managedTxns = true
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		for version := uint64(1); version <= 3; version++ {
+			txn := db.NewTransactionAt(version, true)
+			for _, k := range []string{"a", "b", "c"} {
+				require.NoError(t, txn.Set([]byte(k), []byte("val")))
+			}
+			require.NoError(t, txn.CommitAt(version, nil))
+		}
+
+		type kv struct {
+			key     string
+			version uint64
+		}
+		for _, reverse := range []bool{false, true} {
+			iopt := DefaultIteratorOptions
+			iopt.AllVersions = true
+			iopt.Reverse = reverse
+
+			txn := db.NewTransactionAt(3, false)
+			var all []kv
+			cursors := make(map[int][]byte)
+			it := txn.NewIterator(iopt)
+			for it.Rewind(); it.Valid(); it.Next() {
+				cursors[len(all)] = it.Cursor()
+				all = append(all, kv{string(it.Item().Key()), it.Item().Version()})
+			}
+			it.Close()
+			require.Len(t, all, 9)
+
+			for i, cursor := range cursors {
+				ts, err := CursorReadTs(cursor)
+				require.NoError(t, err)
+				rtxn := db.NewTransactionAt(ts, false)
+				it, err := rtxn.NewIteratorFromCursor(cursor, iopt)
+				require.NoError(t, err)
+				rest := []kv{}
+				for ; it.Valid(); it.Next() {
+					rest = append(rest, kv{string(it.Item().Key()), it.Item().Version()})
+				}
+				it.Close()
+				rtxn.Discard()
+				require.Equal(t, all[i+1:], rest, "reverse=%v cursor=%d", reverse, i)
+			}
+			txn.Discard()
+		}
+	})
+}
+
+func TestMaxConcurrentIterators(t *testing.T) {
+	t.Run("panic", func(t *testing.T) {
+		opt := getTestOptions("").WithMaxConcurrentIterators(2)
+		runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+			txn := db.NewTransaction(false)
+			defer txn.Discard()
+			it1 := txn.NewIterator(DefaultIteratorOptions)
+			it2 := txn.NewKeyIterator([]byte("key"), DefaultIteratorOptions)
+			require.PanicsWithValue(t, ErrTooManyIterators, func() {
+				txn.NewIterator(DefaultIteratorOptions)
+			})
+			it1.Close()
+			it1.Close()
+			it3 := txn.NewIterator(DefaultIteratorOptions)
+			it2.Close()
+			it3.Close()
+		})
+	})
+	t.Run("block", func(t *testing.T) {
+		opt := getTestOptions("").WithMaxConcurrentIterators(1).WithBlockOnMaxIterators(true)
+		runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+			txn := db.NewTransaction(false)
+			defer txn.Discard()
+			it := txn.NewIterator(DefaultIteratorOptions)
+
+			opened := make(chan struct{})
+			go func() {
+				defer close(opened)
+				require.NoError(t, db.View(func(txn *Txn) error {
+					txn.NewIterator(DefaultIteratorOptions).Close()
+					return nil
+				}))
+			}()
+			select {
+			case <-opened:
+				t.Fatal("NewIterator should block while the limit is reached")
+			case <-time.After(100 * time.Millisecond):
+			}
+			it.Close()
+			<-opened
+		})
+	})
+	t.Run("try", func(t *testing.T) {
+		opt := getTestOptions("").WithMaxConcurrentIterators(1).WithBlockOnMaxIterators(true)
+		runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+			txn := db.NewTransaction(false)
+			defer txn.Discard()
+			it, err := txn.TryNewIterator(DefaultIteratorOptions)
+			require.NoError(t, err)
+			// TryNewIterator doesn't block, even with BlockOnMaxIterators.
+			_, err = txn.TryNewIterator(DefaultIteratorOptions)
+			require.ErrorIs(t, err, ErrTooManyIterators)
+			it.Close()
+			it, err = txn.TryNewIterator(DefaultIteratorOptions)
+			require.NoError(t, err)
+			it.Close()
+		})
+	})
+	t.Run("internal", func(t *testing.T) {
+		opt := getTestOptions("").WithMaxConcurrentIterators(1)
+		runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+			txnSet(t, db, []byte("key"), []byte("a"), 0)
+			txn := db.NewTransaction(false)
+			defer txn.Discard()
+			it := txn.NewIterator(DefaultIteratorOptions)
+			defer it.Close()
+
+			// The iterators Badger opens for itself don't need a slot.
+			key, err := db.FirstKey(nil)
+			require.NoError(t, err)
+			require.Equal(t, []byte("key"), key)
+			_, err = db.ChangesSince(0, func(Change) error { return nil })
+			require.NoError(t, err)
+			op := db.GetMergeOperator([]byte("key"), func(a, b []byte) []byte {
+				return append(a, b...)
+			}, time.Hour)
+			defer op.Stop()
+			require.NoError(t, op.Add([]byte("b")))
+			val, err := op.Get()
+			require.NoError(t, err)
+			require.Equal(t, []byte("ab"), val)
+		})
+	})
+	t.Run("multi-prefix", func(t *testing.T) {
+		opt := getTestOptions("").WithMaxConcurrentIterators(1)
+		runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+			txn := db.NewTransaction(false)
+			defer txn.Discard()
+			// The iterator takes a single slot for all of its prefixes, and gives it back on Close.
+			for i := 0; i < 2; i++ {
+				it, err := txn.NewMultiPrefixIterator([][]byte{[]byte("a"), []byte("b")}, false)
+				require.NoError(t, err)
+				_, err = txn.NewMultiPrefixIterator([][]byte{[]byte("a")}, false)
+				require.ErrorIs(t, err, ErrTooManyIterators)
+				it.Close()
+			}
+		})
+	})
+}
+
+func TestDiff(t *testing.T) {
+	type change struct {
+		key     string
+		version uint64
+		deleted bool
+	}
+	collect := func(t *testing.T, it *Iterator) []change {
+		defer it.Close()
+		var res []change
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			res = append(res, change{string(item.Key()), item.Version(), item.IsDeletedOrExpired()})
+		}
+		return res
+	}
+
+	t.Run("managed", func(t *testing.T) {
+		opt := getTestOptions("")
+		opt.managedTxns = true
+		runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+			update := func(version uint64, fn func(txn *Txn)) {
+				txn := db.NewTransactionAt(version, true)
+				fn(txn)
+				require.NoError(t, txn.CommitAt(version, nil))
+			}
+			update(1, func(txn *Txn) {
+				for _, k := range []string{"p/a", "p/b", "p/c", "p/d", "q/a"} {
+					require.NoError(t, txn.Set([]byte(k), []byte("v1")))
+				}
+			})
+			update(3, func(txn *Txn) {
+				require.NoError(t, txn.Set([]byte("p/a"), []byte("v3")))
+				require.NoError(t, txn.Delete([]byte("p/b")))
+				require.NoError(t, txn.Set([]byte("p/e"), []byte("v3")))
+				require.NoError(t, txn.Set([]byte("q/a"), []byte("v3")))
+			})
+			update(5, func(txn *Txn) {
+				require.NoError(t, txn.Set([]byte("p/a"), []byte("v5")))
+				require.NoError(t, txn.Set([]byte("p/c"), []byte("v5")))
+			})
+
+			it, err := db.Diff(2, 4, []byte("p/"))
+			require.NoError(t, err)
+			require.Equal(t, []change{
+				{"p/a", 3, false},
+				{"p/b", 3, true},
+				{"p/e", 3, false},
+			}, collect(t, it))
+
+			it, err = db.Diff(1, 5, nil)
+			require.NoError(t, err)
+			require.Equal(t, []change{
+				{"p/a", 5, false},
+				{"p/b", 3, true},
+				{"p/c", 5, false},
+				{"p/e", 3, false},
+				{"q/a", 3, false},
+			}, collect(t, it))
+
+			it, err = db.Diff(5, 6, nil)
+			require.NoError(t, err)
+			require.Empty(t, collect(t, it))
+
+			_, err = db.Diff(4, 4, nil)
+			require.Equal(t, ErrInvalidRequest, err)
+		})
+	})
+	t.Run("normal", func(t *testing.T) {
+		runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+			require.NoError(t, db.Update(func(txn *Txn) error {
+				return txn.Set([]byte("a"), []byte("v1"))
+			}))
+			txn := db.NewTransaction(false)
+			from := txn.readTs
+			txn.Discard()
+			require.NoError(t, db.Update(func(txn *Txn) error {
+				return txn.Set([]byte("b"), []byte("v2"))
+			}))
+			txn = db.NewTransaction(false)
+			to := txn.readTs
+			txn.Discard()
+			require.NoError(t, db.Update(func(txn *Txn) error {
+				return txn.Delete([]byte("a"))
+			}))
+
+			it, err := db.Diff(from, to, nil)
+			require.NoError(t, err)
+			require.Equal(t, []change{{"b", to, false}}, collect(t, it))
+
+			_, err = db.Diff(from, to+10, nil)
+			require.Equal(t, ErrInvalidRequest, err)
+		})
+	})
+}
+
+func TestChangesSince(t *testing.T) {
+	opt := getTestOptions("")
+	opt.managedTxns = true
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		update := func(version uint64, fn func(txn *Txn)) {
+			txn := db.NewTransactionAt(version, true)
+			fn(txn)
+			require.NoError(t, txn.CommitAt(version, nil))
+		}
+		update(1, func(txn *Txn) {
+			require.NoError(t, txn.Set([]byte("b"), []byte("v1")))
+			require.NoError(t, txn.Set([]byte("a"), []byte("v1")))
+		})
+		update(2, func(txn *Txn) {
+			require.NoError(t, txn.SetEntry(NewEntry([]byte("c"), []byte("v2")).WithMeta(7)))
+		})
+		update(3, func(txn *Txn) {
+			require.NoError(t, txn.Delete([]byte("a")))
+			require.NoError(t, txn.Set([]byte("b"), []byte("v3")))
+		})
+
+		var got []Change
+		collect := func(c Change) error {
+			got = append(got, c)
+			return nil
+		}
+		last, err := db.ChangesSince(0, collect)
+		require.NoError(t, err)
+		require.Equal(t, uint64(3), last)
+		require.Equal(t, []Change{
+			{Key: []byte("a"), Value: []byte("v1"), Version: 1},
+			{Key: []byte("b"), Value: []byte("v1"), Version: 1},
+			{Key: []byte("c"), Value: []byte("v2"), Version: 2, UserMeta: 7},
+			{Key: []byte("a"), Version: 3, IsDelete: true},
+			{Key: []byte("b"), Value: []byte("v3"), Version: 3},
+		}, got)
+
+		// Nothing changed since the last version.
+		got = nil
+		last, err = db.ChangesSince(last, collect)
+		require.NoError(t, err)
+		require.Equal(t, uint64(3), last)
+		require.Empty(t, got)
+
+		// An error stops the changes after the last complete version, so they can be resumed.
+		got = nil
+		errStop := errors.New("stop")
+		last, err = db.ChangesSince(0, func(c Change) error {
+			if c.Version == 3 {
+				return errStop
+			}
+			return collect(c)
+		})
+		require.ErrorIs(t, err, errStop)
+		require.Equal(t, uint64(2), last)
+		require.Len(t, got, 3)
+
+		got = nil
+		last, err = db.ChangesSince(last, collect)
+		require.NoError(t, err)
+		require.Equal(t, uint64(3), last)
+		require.Len(t, got, 2)
+		require.True(t, got[0].IsDelete)
+
+		// Small batches pass the same changes, a version at a time.
+		var want []Change
+		_, err = db.ChangesSince(0, func(c Change) error {
+			want = append(want, c)
+			return nil
+		})
+		require.NoError(t, err)
+		got = nil
+		last, err = db.changesSince(0, 1, collect)
+		require.NoError(t, err)
+		require.Equal(t, uint64(3), last)
+		require.Equal(t, want, got)
+
+		// An error stops small batches after the last complete version too.
+		got = nil
+		last, err = db.changesSince(1, 1, func(c Change) error {
+			if c.Version == 3 {
+				return errStop
+			}
+			return collect(c)
+		})
+		require.ErrorIs(t, err, errStop)
+		require.Equal(t, uint64(2), last)
+		require.Len(t, got, 1)
+	})
+}
+
+func TestFirstLastKey(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		_, err := db.FirstKey(nil)
+		require.Equal(t, ErrKeyNotFound, err)
+		_, err = db.LastKey(nil)
+		require.Equal(t, ErrKeyNotFound, err)
+
+		keys := []string{"a", "b1", "b2", "b3", "b\xff", "b\xff\xff", "c", "\xff\xff"}
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			for _, k := range keys {
+				if err := txn.Set([]byte(k), []byte("v")); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+
+		check := func(prefix, first, last string) {
+			k, err := db.FirstKey([]byte(prefix))
+			require.NoError(t, err)
+			require.Equal(t, first, string(k), "first %q", prefix)
+			k, err = db.LastKey([]byte(prefix))
+			require.NoError(t, err)
+			require.Equal(t, last, string(k), "last %q", prefix)
+		}
+		check("", "a", "\xff\xff")
+		check("b", "b1", "b\xff\xff")
+		check("b\xff", "b\xff", "b\xff\xff")
+		check("\xff", "\xff\xff", "\xff\xff")
+		check("c", "c", "c")
+
+		_, err = db.FirstKey([]byte("d"))
+		require.Equal(t, ErrKeyNotFound, err)
+		_, err = db.LastKey([]byte("d"))
+		require.Equal(t, ErrKeyNotFound, err)
+
+		// Deleted keys are skipped.
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			for _, k := range []string{"b1", "b\xff", "b\xff\xff"} {
+				if err := txn.Delete([]byte(k)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+		check("b", "b2", "b3")
+	})
+}
+
+func TestPage(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			for i := 0; i < 25; i++ {
+				if err := txn.Set([]byte(key("page", i)), []byte(key("val", i))); err != nil {
+					return err
+				}
+			}
+			return txn.Set([]byte("zzz"), []byte("other"))
+		}))
+
+		readAll := func(opt PageOptions, between func()) []string {
+			var keys []string
+			for pages := 0; ; pages++ {
+				items, token, err := db.Page(opt)
+				require.NoError(t, err)
+				require.LessOrEqual(t, len(items), opt.Limit)
+				for _, item := range items {
+					val, err := item.ValueCopy(nil)
+					require.NoError(t, err)
+					require.Equal(t, "val"+string(item.Key()[len("page"):]), string(val))
+					keys = append(keys, string(item.Key()))
+				}
+				if token == nil {
+					return keys
+				}
+				require.Less(t, pages, 10)
+				opt.StartToken = token
+				if between != nil {
+					between()
+				}
+			}
+		}
+
+		var want []string
+		for i := 0; i < 25; i++ {
+			want = append(want, key("page", i))
+		}
+		require.Equal(t, want, readAll(PageOptions{Prefix: []byte("page"), Limit: 10}, nil))
+		// A page which ends with the last key doesn't return a token.
+		items, token, err := db.Page(PageOptions{Prefix: []byte("page"), Limit: 25})
+		require.NoError(t, err)
+		require.Len(t, items, 25)
+		require.Nil(t, token)
+
+		slices.Reverse(want)
+		require.Equal(t, want, readAll(PageOptions{Prefix: []byte("page"), Limit: 7, Reverse: true}, nil))
+
+		// Deleting the last key of a page doesn't change where the next page starts.
+		var deleted bool
+		got := readAll(PageOptions{Prefix: []byte("page"), Limit: 4}, func() {
+			if !deleted {
+				deleted = true
+				require.NoError(t, db.Update(func(txn *Txn) error {
+					return txn.Delete([]byte(key("page", 3)))
+				}))
+			}
+		})
+		require.Len(t, got, 25)
+		require.Equal(t, key("page", 4), got[4])
+
+		_, _, err = db.Page(PageOptions{Limit: 0})
+		require.ErrorIs(t, err, ErrInvalidRequest)
+		_, _, err = db.Page(PageOptions{Prefix: []byte("page"), Limit: 1, StartToken: []byte("zzz")})
+		require.ErrorIs(t, err, ErrInvalidRequest)
+		_, token, err = db.Page(PageOptions{Limit: 1})
+		require.NoError(t, err)
+		_, _, err = db.Page(PageOptions{Prefix: []byte("zzz"), Limit: 1, StartToken: token})
+		require.ErrorIs(t, err, ErrInvalidRequest)
+	})
+}
+
+func TestCountDistinctPrefixComponents(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		count := func(separator byte, depth int) int64 {
+			n, err := db.CountDistinctPrefixComponents(separator, depth)
+			require.NoError(t, err)
+			return n
+		}
+		require.Zero(t, count('/', 1))
+
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			for i := 0; i < 20; i++ {
+				for j := 0; j < 5; j++ {
+					for k := 0; k < 10; k++ {
+						key := fmt.Sprintf("tenant%02d/entity%d/%d", i, j, k)
+						require.NoError(t, txn.Set([]byte(key), nil))
+					}
+				}
+			}
+			// A key without the separator shares its prefix with the keys below it, while keys
+			// sorting between them have a prefix of their own.
+			require.NoError(t, txn.Set([]byte("tenant00"), nil))
+			require.NoError(t, txn.Set([]byte("tenant00!"), nil))
+			return txn.Set([]byte("\xff\xff/a/b"), nil)
+		}))
+		require.Equal(t, int64(22), count('/', 1))
+		require.Equal(t, int64(103), count('/', 2))
+		require.Equal(t, int64(1003), count('/', 3))
+		require.Equal(t, int64(1003), count('/', 4))
+		require.Equal(t, int64(1003), count(0xff, 1))
+
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Delete([]byte("tenant00!"))
+		}))
+		require.Equal(t, int64(21), count('/', 1))
+
+		_, err := db.CountDistinctPrefixComponents('/', 0)
+		require.ErrorIs(t, err, ErrInvalidRequest)
+	})
+
+	// A key with fewer components than the depth is a prefix of its own.
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			for _, key := range []string{"a", "a/b", "a/b/c"} {
+				require.NoError(t, txn.Set([]byte(key), nil))
+			}
+			return nil
+		}))
+		for depth, want := range []int64{1, 2, 3, 3} {
+			n, err := db.CountDistinctPrefixComponents('/', depth+1)
+			require.NoError(t, err)
+			require.Equal(t, want, n, "depth %d", depth+1)
+		}
+	})
+}
+
+func TestIteratorRawInternalKeys(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		for i := 0; i < 3; i++ {
+			require.NoError(t, db.Update(func(txn *Txn) error {
+				if err := txn.Set([]byte("a"), []byte("va")); err != nil {
+					return err
+				}
+				return txn.Set([]byte("b"), []byte("vb"))
+			}))
+		}
+
+		require.NoError(t, db.View(func(txn *Txn) error {
+			opt := DefaultIteratorOptions
+			opt.AllVersions = true
+			opt.RawInternalKeys = true
+			it := txn.NewIterator(opt)
+			defer it.Close()
+			var count int
+			for it.Rewind(); it.Valid(); it.Next() {
+				item := it.Item()
+				k := item.KeyCopy(nil)
+				require.Equal(t, y.KeyWithTs(y.ParseKey(k), item.Version()), k)
+				require.Equal(t, k, item.FullKey())
+				require.Equal(t, "v"+string(y.ParseKey(k)), string(getItemValue(t, item)))
+				count++
+			}
+			require.Equal(t, 6, count)
+
+			// A key equal to the prefix still matches.
+			opt.Prefix = []byte("b")
+			it2 := txn.NewIterator(opt)
+			defer it2.Close()
+			it2.Rewind()
+			require.True(t, it2.Valid())
+			require.Equal(t, "b", string(y.ParseKey(it2.Item().Key())))
+			return nil
+		}))
+	})
+}
+
+func TestTailIterator(t *testing.T) {
+	dir, err := os.MkdirTemp("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	db, err := Open(getTestOptions(dir))
+	require.NoError(t, err)
+
+	set := func(keys ...string) {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			for _, k := range keys {
+				if err := txn.Set([]byte(k), []byte("v"+k)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+	}
+	set("q0", "q1", "q2", "q3")
+
+	txn := db.NewTransaction(false)
+	defer txn.Discard()
+	tail, err := txn.NewTailIterator([]byte("q1"))
+	require.NoError(t, err)
+	next := func(timeout time.Duration) (string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		item, err := tail.Next(ctx)
+		if err != nil {
+			return "", err
+		}
+		require.Equal(t, "v"+string(item.Key()), string(getItemValue(t, item)))
+		return string(item.Key()), nil
+	}
+	for _, want := range []string{"q2", "q3"} {
+		k, err := next(time.Second)
+		require.NoError(t, err)
+		require.Equal(t, want, k)
+	}
+	_, err = next(50 * time.Millisecond)
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	// Keys committed while Next waits, or before it's called again, are returned.
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		set("q4")
+		set("q5", "q6")
+	}()
+	for _, want := range []string{"q4", "q5", "q6"} {
+		k, err := next(5 * time.Second)
+		require.NoError(t, err)
+		require.Equal(t, want, k)
+	}
+	tail.Close()
+
+	// Closing the DB unblocks Next.
+	tail, err = txn.NewTailIterator([]byte("q6"))
+	require.NoError(t, err)
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := tail.Next(context.Background())
+		errCh <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, db.Close())
+	require.Equal(t, ErrDBClosed, <-errCh)
+	tail.Close()
+}
+
+func TestMultiPrefixIterator(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			for _, k := range []string{"t1/a", "t1/c", "t1/e", "t2/b", "t2/c", "t2/f", "t3/a", "t0/z"} {
+				if err := txn.Set([]byte(k), []byte(k)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+
+		txn := db.NewTransaction(false)
+		defer txn.Discard()
+		_, err := txn.NewMultiPrefixIterator(nil, true)
+		require.Equal(t, ErrInvalidPrefixes, err)
+		_, err = txn.NewMultiPrefixIterator([][]byte{[]byte("t1"), []byte("t1/")}, true)
+		require.Equal(t, ErrInvalidPrefixes, err)
+
+		collect := func(it *Iterator) (keys, full []string) {
+			for ; it.Valid(); it.Next() {
+				keys = append(keys, string(it.Item().Key()))
+				full = append(full, string(it.Item().FullKey()))
+			}
+			return keys, full
+		}
+		it, err := txn.NewMultiPrefixIterator([][]byte{[]byte("t2/"), []byte("t1/")}, true)
+		require.NoError(t, err)
+		it.Rewind()
+		keys, full := collect(it)
+		require.Equal(t, []string{"a", "b", "c", "c", "e", "f"}, keys)
+		require.Equal(t, []string{"t1/a", "t2/b", "t2/c", "t1/c", "t1/e", "t2/f"}, full)
+		it.Seek([]byte("c"))
+		keys, _ = collect(it)
+		require.Equal(t, []string{"c", "c", "e", "f"}, keys)
+		require.Nil(t, it.Cursor())
+		it.Close()
+
+		it, err = txn.NewMultiPrefixIterator([][]byte{[]byte("t2/"), []byte("t1/")}, false)
+		require.NoError(t, err)
+		defer it.Close()
+		it.Rewind()
+		keys, _ = collect(it)
+		require.Equal(t, []string{"t1/a", "t1/c", "t1/e", "t2/b", "t2/c", "t2/f"}, keys)
+	})
+}
+
+func TestIteratorValueReadAhead(t *testing.T) {
+	opt := getTestOptions("")
+	opt.ValueThreshold = 32
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		value := func(i int) []byte { return bytes.Repeat([]byte{byte(i)}, 1<<10) }
+		// Values written in key order are read sequentially. The entries of a transaction aren't
+		// written in key order, so each key gets its own.
+		for i := 0; i < 100; i++ {
+			require.NoError(t, db.Update(func(txn *Txn) error {
+				return txn.Set([]byte(key("key", i)), value(i))
+			}))
+		}
+
+		for _, prefetch := range []bool{false, true} {
+			txn := db.NewTransaction(false)
+			iopt := DefaultIteratorOptions
+			iopt.PrefetchValues = prefetch
+			iopt.ValueReadAhead = 16 << 10
+			it := txn.NewIterator(iopt)
+			var n int
+			for it.Rewind(); it.Valid(); it.Next() {
+				require.Equal(t, value(n), getItemValue(t, it.Item()))
+				n++
+			}
+			require.Equal(t, 100, n)
+			require.Equal(t, uint32(16<<10), it.aheadVp.Len)
+			it.Close()
+			txn.Discard()
+		}
+
+		// Going backwards, each value comes before the previous one, so nothing is read ahead.
+		txn := db.NewTransaction(false)
+		defer txn.Discard()
+		iopt := DefaultIteratorOptions
+		iopt.Reverse = true
+		iopt.ValueReadAhead = 16 << 10
+		it := txn.NewIterator(iopt)
+		defer it.Close()
+		n := 100
+		for it.Rewind(); it.Valid(); it.Next() {
+			n--
+			require.Equal(t, value(n), getItemValue(t, it.Item()))
+		}
+		require.Zero(t, n)
+		require.Zero(t, it.aheadVp.Len)
+	})
+}
+
+func TestIteratorPeek(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			for _, k := range []string{"a1", "a2", "a3", "b1", "b2"} {
+				if err := txn.Set([]byte(k), []byte("val-"+k)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			// A deleted key is never peeked at.
+			return txn.Delete([]byte("a2"))
+		}))
+
+		// walk returns the keys peeked at from each position after seeking to seek, and checks that
+		// Next moves to the peeked item.
+		walk := func(it *Iterator, seek string) []string {
+			defer it.Close()
+			var res []string
+			it.Seek([]byte(seek))
+			for it.Valid() {
+				peeked := it.Peek()
+				// Peek is idempotent.
+				require.Same(t, peeked, it.Peek())
+				it.Next()
+				if peeked == nil {
+					require.False(t, it.Valid())
+					res = append(res, "")
+					continue
+				}
+				require.Same(t, peeked, it.Item())
+				require.Equal(t, "val-"+string(peeked.Key()), string(getItemValue(t, peeked)))
+				res = append(res, string(peeked.Key()))
+			}
+			require.Nil(t, it.Peek())
+			return res
+		}
+
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for _, prefetch := range []bool{false, true} {
+				opt := DefaultIteratorOptions
+				opt.PrefetchValues = prefetch
+				require.Equal(t, []string{"a3", "b1", "b2", ""}, walk(txn.NewIterator(opt), ""))
+
+				opt.Reverse = true
+				require.Equal(t, []string{"b1", "a3", "a1", ""}, walk(txn.NewIterator(opt), ""))
+
+				opt.Reverse = false
+				opt.Prefix = []byte("a")
+				require.Equal(t, []string{"a3", ""}, walk(txn.NewIterator(opt), ""))
+
+				opt.Reverse = true
+				opt.Prefix = []byte("b")
+				require.Equal(t, []string{"b1", ""}, walk(txn.NewIterator(opt), "b\xff"))
+			}
+
+			it, err := txn.NewMultiPrefixIterator([][]byte{[]byte("b"), []byte("a")}, true)
+			require.NoError(t, err)
+			// Stripped, the keys are 1 (a), 1 (b), 3 (a), 2 (b).
+			defer it.Close()
+			var peeked []string
+			for it.Rewind(); it.Valid(); it.Next() {
+				next := it.Peek()
+				if next == nil {
+					peeked = append(peeked, "")
+					continue
+				}
+				peeked = append(peeked, string(next.FullKey()))
+			}
+			require.Equal(t, []string{"a1", "b2", "a3", ""}, peeked)
+			return nil
+		}))
+	})
+}
+
+func TestValidateKeyOrder(t *testing.T) {
+	// A table built without the check, with keys out of order.
+	opts := table.Options{BlockSize: 4 << 10, BloomFalsePositive: 0.01}
+	b := table.NewTableBuilder(opts)
+	for _, k := range []string{"a", "c", "b"} {
+		b.Add(y.KeyWithTs([]byte(k), 1), y.ValueStruct{Value: []byte("val")}, 0)
+	}
+	tbl, err := table.OpenInMemoryTable(b.Finish(), 1, &opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, tbl.DecrRef()) }()
+
+	it := &orderCheckIterator{Iterator: tbl.NewIterator(0)}
+	defer it.Close()
+	it.Rewind()
+	it.Next()
+	require.PanicsWithValue(t,
+		`merge iterator: key "b" at version 1 came after key "c" at version 1`, it.Next)
+
+	rit := &orderCheckIterator{Iterator: tbl.NewIterator(table.REVERSED), reverse: true}
+	defer rit.Close()
+	rit.Rewind()
+	require.Panics(t, rit.Next)
+
+	// Correctly ordered writes, reads and compactions go through.
+	opt := getTestOptions("").WithValidateKeyOrder(true)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		for i := 0; i < 100; i++ {
+			require.NoError(t, db.Update(func(txn *Txn) error {
+				return txn.Set([]byte(key("key", i%10)), []byte("val"))
+			}))
+		}
+		require.NoError(t, db.Flatten(1))
+		for _, reverse := range []bool{false, true} {
+			require.NoError(t, db.View(func(txn *Txn) error {
+				iopt := DefaultIteratorOptions
+				iopt.AllVersions = true
+				iopt.Reverse = reverse
+				it := txn.NewIterator(iopt)
+				defer it.Close()
+				n := 0
+				for it.Rewind(); it.Valid(); it.Next() {
+					n++
+				}
+				require.Equal(t, 100, n)
+				return nil
+			}))
+		}
+	})
+}
+
+func TestIteratorPrefetchWorkers(t *testing.T) {
+	opt := getTestOptions("").WithValueThreshold(64)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		txn := db.NewTransaction(true)
+		for i := 0; i < 500; i++ {
+			val := []byte(fmt.Sprintf("%0100d", i))
+			require.NoError(t, txn.Set([]byte(fmt.Sprintf("key%03d", i)), val))
+		}
+		require.NoError(t, txn.Commit())
+
+		for _, workers := range []int{1, 4, 200} {
+			for _, reverse := range []bool{false, true} {
+				require.NoError(t, db.View(func(txn *Txn) error {
+					iopt := DefaultIteratorOptions
+					iopt.PrefetchWorkers = workers
+					iopt.PrefetchSize = 50
+					iopt.Reverse = reverse
+					it := txn.NewIterator(iopt)
+					defer it.Close()
+
+					var n int
+					for it.Rewind(); it.Valid(); it.Next() {
+						i := n
+						if reverse {
+							i = 499 - n
+						}
+						require.Equal(t, fmt.Sprintf("key%03d", i), string(it.Item().Key()))
+						require.Equal(t, fmt.Sprintf("%0100d", i), string(getItemValue(t, it.Item())))
+						n++
+					}
+					require.Equal(t, 500, n)
+					return nil
+				}))
+			}
+		}
+
+		// Closing an iterator before reading all the prefetched values stops the workers.
+		require.NoError(t, db.View(func(txn *Txn) error {
+			iopt := DefaultIteratorOptions
+			iopt.PrefetchWorkers = 2
+			it := txn.NewIterator(iopt)
+			it.Rewind()
+			require.True(t, it.Valid())
+			it.Close()
+			return nil
+		}))
+	})
+}
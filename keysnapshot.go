@@ -0,0 +1,121 @@
+/*
+ * Copyright 2024 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"errors"
+)
+
+// KeySnapshot is a sorted set of keys, frozen by Txn.SnapshotKeys at the read timestamp of the
+// transaction. Only the keys are frozen: Get and Page read the values the keys have at the time
+// they're called, so a UI can list a stable set of keys while showing current values. A
+// KeySnapshot holds no resources and stays valid after the transaction is done. It is safe for
+// concurrent use.
+type KeySnapshot struct {
+	db   *DB
+	buf  []byte // The keys, one after another.
+	ends []int  // The offset in buf where each key ends.
+}
+
+// SnapshotKeys returns the keys with the given prefix which exist as of the read timestamp of the
+// transaction, including its pending writes, as a KeySnapshot. Values aren't read. The keys are
+// copied into a single buffer, so the snapshot takes little more memory than the keys themselves.
+func (txn *Txn) SnapshotKeys(prefix []byte) (*KeySnapshot, error) {
+	if txn.discarded {
+		return nil, ErrDiscardedTxn
+	}
+	snap := &KeySnapshot{db: txn.db}
+	iopt := DefaultIteratorOptions
+	iopt.PrefetchValues = false
+	iopt.Prefix = prefix
+	iopt.internal = true
+	it := txn.NewIterator(iopt)
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		snap.buf = append(snap.buf, it.Item().Key()...)
+		snap.ends = append(snap.ends, len(snap.buf))
+	}
+	return snap, nil
+}
+
+// Len returns the number of keys in the snapshot.
+func (snap *KeySnapshot) Len() int {
+	return len(snap.ends)
+}
+
+// Key returns the i-th key of the snapshot. The key must not be modified.
+func (snap *KeySnapshot) Key(i int) []byte {
+	start := 0
+	if i > 0 {
+		start = snap.ends[i-1]
+	}
+	return snap.buf[start:snap.ends[i]:snap.ends[i]]
+}
+
+// Get reads the current value of the i-th key of the snapshot, with a read-only transaction of
+// its own. If the key was deleted since the snapshot was taken, it returns ErrKeyNotFound. The
+// returned item holds copies of the key and value, and stays valid after Get returns.
+func (snap *KeySnapshot) Get(i int) (*Item, error) {
+	if i < 0 || i >= snap.Len() {
+		return nil, ErrInvalidRequest
+	}
+	var item *Item
+	err := snap.db.View(func(txn *Txn) error {
+		got, err := txn.Get(snap.Key(i))
+		if err != nil {
+			return err
+		}
+		item, err = detachItem(got)
+		return err
+	})
+	return item, err
+}
+
+// Page reads the current values of up to limit keys of the snapshot, starting at the key at
+// offset, with a single read-only transaction. The returned items are in the order of the keys,
+// so the i-th item belongs to the key at offset+i. The item of a key deleted since the snapshot
+// was taken is nil. An offset past the last key returns no items. The returned items hold copies
+// of their keys and values, and stay valid after Page returns.
+func (snap *KeySnapshot) Page(offset, limit int) ([]*Item, error) {
+	if offset < 0 || limit <= 0 {
+		return nil, ErrInvalidRequest
+	}
+	if offset >= snap.Len() {
+		return nil, nil
+	}
+	end := min(offset+limit, snap.Len())
+	items := make([]*Item, end-offset)
+	err := snap.db.View(func(txn *Txn) error {
+		for i := offset; i < end; i++ {
+			got, err := txn.Get(snap.Key(i))
+			if errors.Is(err, ErrKeyNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if items[i-offset], err = detachItem(got); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
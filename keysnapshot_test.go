@@ -0,0 +1,88 @@
+/*
+ * Copyright 2024 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotKeys(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		for i := 0; i < 10; i++ {
+			txnSet(t, db, []byte(key("a", i)), []byte(key("v", i)), 0)
+		}
+		txnSet(t, db, []byte("b"), []byte("b"), 0)
+
+		var snap *KeySnapshot
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			// Pending writes are part of the snapshot.
+			require.NoError(t, txn.Set([]byte(key("a", 10)), []byte(key("v", 10))))
+			require.NoError(t, txn.Delete([]byte(key("a", 9))))
+			var err error
+			snap, err = txn.SnapshotKeys([]byte("a"))
+			return err
+		}))
+		require.Equal(t, 10, snap.Len())
+		for i := 0; i < 9; i++ {
+			require.Equal(t, []byte(key("a", i)), snap.Key(i))
+		}
+		require.Equal(t, []byte(key("a", 10)), snap.Key(9))
+
+		// The keys stay frozen, while the values read are the current ones.
+		txnSet(t, db, []byte(key("a", 1)), []byte("new"), 0)
+		txnDelete(t, db, []byte(key("a", 2)))
+		txnSet(t, db, []byte(key("a", 11)), []byte(key("v", 11)), 0)
+		require.Equal(t, 10, snap.Len())
+
+		item, err := snap.Get(1)
+		require.NoError(t, err)
+		require.Equal(t, []byte(key("a", 1)), item.Key())
+		require.Equal(t, []byte("new"), getItemValue(t, item))
+		_, err = snap.Get(2)
+		require.ErrorIs(t, err, ErrKeyNotFound)
+		_, err = snap.Get(10)
+		require.Equal(t, ErrInvalidRequest, err)
+
+		items, err := snap.Page(0, 4)
+		require.NoError(t, err)
+		require.Len(t, items, 4)
+		require.Equal(t, []byte(key("v", 0)), getItemValue(t, items[0]))
+		require.Equal(t, []byte("new"), getItemValue(t, items[1]))
+		require.Nil(t, items[2])
+		require.Equal(t, []byte(key("a", 3)), items[3].Key())
+
+		items, err = snap.Page(8, 4)
+		require.NoError(t, err)
+		require.Len(t, items, 2)
+		require.Equal(t, []byte(key("v", 10)), getItemValue(t, items[1]))
+
+		items, err = snap.Page(10, 4)
+		require.NoError(t, err)
+		require.Empty(t, items)
+		_, err = snap.Page(0, 0)
+		require.Equal(t, ErrInvalidRequest, err)
+
+		require.NoError(t, db.View(func(txn *Txn) error {
+			snap, err := txn.SnapshotKeys([]byte("c"))
+			require.NoError(t, err)
+			require.Zero(t, snap.Len())
+			return nil
+		}))
+	})
+}
@@ -0,0 +1,167 @@
+/*
+ * Copyright 2024 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"math"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// Names of the operations tracked by DB.LatencyStats.
+const (
+	// LatencyGet tracks Txn.Get.
+	LatencyGet = "get"
+	// LatencyCommit tracks Txn.Commit, which is what makes a Set durable.
+	LatencyCommit = "commit"
+	// LatencyIteratorNext tracks Iterator.Next.
+	LatencyIteratorNext = "iterator.next"
+	// LatencyVlogRead tracks reads of values from the value log.
+	LatencyVlogRead = "vlog.read"
+)
+
+// numLatencyBuckets is the number of buckets of a latency histogram. Bucket i counts the
+// latencies in [2^(i-1), 2^i) nanoseconds, and the last one also holds anything slower.
+// 2^36ns is a little over a minute.
+const numLatencyBuckets = 37
+
+// Histogram is a snapshot of the latencies recorded for an operation. Counts[i] is the number of
+// operations which took less than Bounds[i], and at least Bounds[i-1]. The last bucket has no
+// upper bound, and its Bounds entry is set to math.MaxInt64.
+type Histogram struct {
+	Bounds []time.Duration
+	Counts []int64
+	Count  int64
+	Sum    time.Duration
+	Min    time.Duration
+	Max    time.Duration
+}
+
+// Mean returns the average latency.
+func (h *Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// Percentile returns the upper bound of the bucket holding the p-th percentile, where p is
+// within [0, 1]. The bound is capped at Max, so the result is never above the slowest operation.
+func (h *Histogram) Percentile(p float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(p * float64(h.Count)))
+	var seen int64
+	for i, c := range h.Counts {
+		seen += c
+		if seen >= rank && c > 0 {
+			return min(h.Bounds[i], h.Max)
+		}
+	}
+	return h.Max
+}
+
+// latencyHistogram records latencies into power of two buckets. It's lock-free, so that recording
+// a latency costs a few atomic operations.
+type latencyHistogram struct {
+	counts [numLatencyBuckets]atomic.Int64
+	count  atomic.Int64
+	sum    atomic.Int64
+	min    atomic.Int64
+	max    atomic.Int64
+}
+
+func newLatencyHistogram() *latencyHistogram {
+	h := &latencyHistogram{}
+	h.min.Store(math.MaxInt64)
+	return h
+}
+
+// since records the time elapsed since start.
+func (h *latencyHistogram) since(start time.Time) {
+	d := int64(time.Since(start))
+	if d < 0 {
+		d = 0
+	}
+	h.counts[min(bits.Len64(uint64(d)), numLatencyBuckets-1)].Add(1)
+	h.count.Add(1)
+	h.sum.Add(d)
+	for cur := h.min.Load(); d < cur; cur = h.min.Load() {
+		if h.min.CompareAndSwap(cur, d) {
+			break
+		}
+	}
+	for cur := h.max.Load(); d > cur; cur = h.max.Load() {
+		if h.max.CompareAndSwap(cur, d) {
+			break
+		}
+	}
+}
+
+func (h *latencyHistogram) snapshot() *Histogram {
+	s := &Histogram{
+		Bounds: make([]time.Duration, numLatencyBuckets),
+		Counts: make([]int64, numLatencyBuckets),
+		Count:  h.count.Load(),
+		Sum:    time.Duration(h.sum.Load()),
+		Max:    time.Duration(h.max.Load()),
+	}
+	if s.Count > 0 {
+		s.Min = time.Duration(h.min.Load())
+	}
+	for i := range h.counts {
+		s.Bounds[i] = time.Duration(1) << i
+		s.Counts[i] = h.counts[i].Load()
+	}
+	s.Bounds[numLatencyBuckets-1] = math.MaxInt64
+	return s
+}
+
+// latencyStats holds the histograms of the tracked operations. It's nil unless
+// Options.LatencyTracking is set.
+type latencyStats struct {
+	get      *latencyHistogram
+	commit   *latencyHistogram
+	iterNext *latencyHistogram
+	vlogRead *latencyHistogram
+}
+
+func newLatencyStats() *latencyStats {
+	return &latencyStats{
+		get:      newLatencyHistogram(),
+		commit:   newLatencyHistogram(),
+		iterNext: newLatencyHistogram(),
+		vlogRead: newLatencyHistogram(),
+	}
+}
+
+// LatencyStats returns a snapshot of the latency histograms of the operations tracked when
+// Options.LatencyTracking is set, keyed by the Latency* operation names. It returns nil if latency
+// tracking is disabled.
+func (db *DB) LatencyStats() map[string]*Histogram {
+	if db.latency == nil {
+		return nil
+	}
+	return map[string]*Histogram{
+		LatencyGet:          db.latency.get.snapshot(),
+		LatencyCommit:       db.latency.commit.snapshot(),
+		LatencyIteratorNext: db.latency.iterNext.snapshot(),
+		LatencyVlogRead:     db.latency.vlogRead.snapshot(),
+	}
+}
@@ -17,7 +17,9 @@
 package badger
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"sort"
 	"sync"
 
@@ -273,16 +275,23 @@ func (s *levelHandler) getTableForKey(key []byte) ([]*table.Table, func() error)
 	return []*table.Table{tbl}, tbl.DecrRef
 }
 
-// get returns value for a given key or the key after that. If not found, return nil.
-func (s *levelHandler) get(key []byte) (y.ValueStruct, error) {
+// get returns value for a given key or the key after that. If not found, return nil. The tables
+// and blocks consulted are added to stats, if it's not nil.
+func (s *levelHandler) get(key []byte, stats *ReadStats) (y.ValueStruct, error) {
 	tables, decr := s.getTableForKey(key)
 	keyNoTs := y.ParseKey(key)
 
 	hash := y.Hash(keyNoTs)
 	var maxVs y.ValueStruct
+	if stats != nil {
+		stats.TablesConsulted += len(tables)
+	}
 	for _, th := range tables {
 		if th.DoesNotHave(hash) {
 			y.NumLSMBloomHitsAdd(s.db.opt.MetricsEnabled, s.strLevel, 1)
+			if stats != nil {
+				stats.BloomFilterNegatives++
+			}
 			continue
 		}
 
@@ -291,6 +300,9 @@ func (s *levelHandler) get(key []byte) (y.ValueStruct, error) {
 
 		y.NumLSMGetsAdd(s.db.opt.MetricsEnabled, s.strLevel, 1)
 		it.Seek(key)
+		if stats != nil {
+			stats.BlocksRead += it.BlocksRead()
+		}
 		if !it.Valid() {
 			continue
 		}
@@ -304,6 +316,115 @@ func (s *levelHandler) get(key []byte) (y.ValueStruct, error) {
 	return maxVs, decr()
 }
 
+// getCached looks up key in the tables of the level like get, but only if that can be done from
+// memory. It returns false if a table would have to be read.
+func (s *levelHandler) getCached(key []byte) (y.ValueStruct, bool, error) {
+	tables, decr := s.getTableForKey(key)
+	hash := y.Hash(y.ParseKey(key))
+	var maxVs y.ValueStruct
+	for _, th := range tables {
+		if !th.IndexCached() {
+			return maxVs, false, decr()
+		}
+		if th.DoesNotHave(hash) {
+			continue
+		}
+		k, vs, err := th.SeekCached(key)
+		switch {
+		case errors.Is(err, table.ErrNotCached):
+			return maxVs, false, decr()
+		case err == io.EOF:
+			continue
+		case err != nil:
+			_ = decr()
+			return maxVs, false, err
+		}
+		if y.SameKey(key, k) {
+			if version := y.ParseTs(k); maxVs.Version < version {
+				maxVs = vs
+				maxVs.Version = version
+			}
+		}
+	}
+	return maxVs, true, decr()
+}
+
+// getMany looks up the keys, which must be sorted, in the tables of the level. vs[i] is replaced
+// if a newer version of keys[i] is found. Keys for which done[i] is set are skipped. Unlike calling
+// get for each key, each table is searched using a single iterator.
+func (s *levelHandler) getMany(keys [][]byte, vs []y.ValueStruct, done []bool) error {
+	s.RLock()
+	tables := make([]*table.Table, 0, len(s.tables))
+	if s.level == 0 {
+		// Newer tables are at the end of level 0. Check them first.
+		for i := len(s.tables) - 1; i >= 0; i-- {
+			tables = append(tables, s.tables[i])
+		}
+	} else {
+		tables = append(tables, s.tables...)
+	}
+	for _, t := range tables {
+		t.IncrRef()
+	}
+	s.RUnlock()
+
+	iters := make([]*table.Iterator, len(tables))
+	defer func() {
+		for _, it := range iters {
+			if it != nil {
+				_ = it.Close()
+			}
+		}
+	}()
+	lookup := func(idx int, key []byte, hash uint32) *y.ValueStruct {
+		th := tables[idx]
+		if th.DoesNotHave(hash) {
+			y.NumLSMBloomHitsAdd(s.db.opt.MetricsEnabled, s.strLevel, 1)
+			return nil
+		}
+		if iters[idx] == nil {
+			iters[idx] = th.NewIterator(0)
+		}
+		it := iters[idx]
+		y.NumLSMGetsAdd(s.db.opt.MetricsEnabled, s.strLevel, 1)
+		it.Seek(key)
+		if !it.Valid() || !y.SameKey(key, it.Key()) {
+			return nil
+		}
+		v := it.ValueCopy()
+		v.Version = y.ParseTs(it.Key())
+		return &v
+	}
+
+	var ti int
+	for i, key := range keys {
+		if done[i] {
+			continue
+		}
+		hash := y.Hash(y.ParseKey(key))
+		if s.level == 0 {
+			for idx := range tables {
+				if v := lookup(idx, key, hash); v != nil && vs[i].Version < v.Version {
+					vs[i] = *v
+				}
+			}
+			continue
+		}
+		// For level >= 1, tables are sorted and don't overlap. As keys are sorted too, we only
+		// ever move forward in tables.
+		for ti < len(tables) && y.CompareKeys(tables[ti].Biggest(), key) < 0 {
+			ti++
+		}
+		if ti == len(tables) {
+			break
+		}
+		if v := lookup(ti, key, hash); v != nil && vs[i].Version < v.Version {
+			vs[i] = *v
+		}
+	}
+	return decrRefs(tables)
+}
+
 // appendIterators appends iterators to an array of iterators, for merging.
 // Note: This obtains references for the table handlers. Remember to close these iterators.
 func (s *levelHandler) appendIterators(iters []y.Iterator, opt *IteratorOptions) []y.Iterator {
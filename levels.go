@@ -18,13 +18,14 @@ package badger
 
 import (
 	"bytes"
-	//"context"
+	"context"
 	"encoding/hex"
 	stderrors "errors"
 	"fmt"
 	"math"
-	"math/rand"
 	"os"
+	"runtime"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -46,6 +47,8 @@ import (
 type levelsController struct {
 	nextFileID atomic.Uint64
 	l0stallsMs atomic.Int64
+	// numCompactions is the number of compactions completed since the DB was opened.
+	numCompactions atomic.Int64
 
 	// The following are initialized once and const.
 	levels []*levelHandler
@@ -351,6 +354,148 @@ func (s *levelsController) dropPrefixes(prefixes [][]byte) error {
 	return nil
 }
 
+// rewriteAllTables compacts all the tables, so that versions which have become invalid, e.g. after
+// DB.DeleteBelowVersion, get dropped. Tables are rewritten in place one at a time, from the last
+// level up. Tables which are already being compacted are skipped, as that compaction does the
+// job. It stops early once lc gets closed.
+func (s *levelsController) rewriteAllTables(lc *z.Closer) {
+	// Go from the last level up, for the same reason as in dropPrefixes.
+	for i := len(s.levels) - 1; i > 0; i-- {
+		l := s.levels[i]
+		l.RLock()
+		tables := append([]*table.Table{}, l.tables...)
+		l.RUnlock()
+
+		for _, t := range tables {
+			select {
+			case <-lc.HasBeenClosed():
+				return
+			default:
+			}
+			cd := compactDef{
+				thisLevel: l,
+				nextLevel: l,
+				bot:       []*table.Table{t},
+				t:         s.levelTargets(),
+			}
+			cd.t.baseLevel = l.level
+			cd.thisRange = getKeyRange(t)
+			cd.nextRange = cd.thisRange
+
+			l.RLock()
+			// The table might have been compacted away since we listed the tables.
+			ok := slices.Contains(l.tables, t) &&
+				s.cstatus.compareAndAdd(thisAndNextLevelRLocked{}, cd)
+			l.RUnlock()
+			if !ok {
+				continue
+			}
+			err := s.runCompactDef(-1, l.level, cd)
+			s.cstatus.delete(cd)
+			if err != nil {
+				s.kv.opt.Warningf("While rewriting table %d at level %d: %v", t.ID(), l.level, err)
+			}
+		}
+	}
+
+	select {
+	case <-lc.HasBeenClosed():
+		return
+	default:
+	}
+	// Level 0 tables can't be rewritten in place. Push them down instead.
+	cp := compactionPriority{level: 0, score: 1.76, t: s.levelTargets()}
+	if err := s.doCompact(176, cp); err != nil && err != errFillTables {
+		s.kv.opt.Warningf("While compacting level 0: %v", err)
+	}
+}
+
+// purgeTombstones compacts the tables which can hold keys in kr level by level, from L0 down to
+// the last level. A compaction only drops a tombstone along with the versions it hides once no
+// lower level holds the key, which is always the case at the last level. Tombstones above
+// discardTs are kept, as are all the other versions above it. Live compactions must be stopped.
+func (s *levelsController) purgeTombstones(kr keyRange, discardTs uint64) error {
+	for i, lh := range s.levels {
+		// The tables of the levels in between are pushed down one at a time, until none of them
+		// is left in the range. L0 and the last level take a single compaction.
+		for {
+			cd := compactDef{
+				compactorId:  -1,
+				t:            s.levelTargets(),
+				thisLevel:    lh,
+				nextLevel:    s.levels[min(i+1, len(s.levels)-1)],
+				maxDiscardTs: discardTs,
+			}
+			cd.t.baseLevel = cd.nextLevel.level
+			cd.p = compactionPriority{level: lh.level, t: cd.t}
+			if !s.fillTablesInRange(&cd, kr) {
+				break
+			}
+			err := s.runCompactDef(-1, lh.level, cd)
+			s.cstatus.delete(cd)
+			if err != nil {
+				return err
+			}
+			if lh.level == 0 || lh.isLastLevel() {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// fillTablesInRange picks the tables of cd.thisLevel which can hold keys in kr, along with the
+// tables of cd.nextLevel they overlap with. As L0 tables overlap each other, either all or none
+// of them are picked. Other levels are compacted into the next one a table at a time, except for
+// the last level, whose tables are all rewritten in place. It returns false if there are no such
+// tables, or if they are being compacted.
+func (s *levelsController) fillTablesInRange(cd *compactDef, kr keyRange) bool {
+	cd.lockLevels()
+	defer cd.unlockLevels()
+
+	tables := cd.thisLevel.tables
+	var top []*table.Table
+	switch {
+	case cd.thisLevel.level == 0:
+		if slices.ContainsFunc(tables, func(t *table.Table) bool {
+			return kr.overlapsWith(getKeyRange(t))
+		}) {
+			top = tables
+		}
+	case kr.inf:
+		top = tables
+	default:
+		left, right := cd.thisLevel.overlappingTables(levelHandlerRLocked{}, kr)
+		top = tables[left:right]
+	}
+	if cd.thisLevel.level > 0 && cd.thisLevel != cd.nextLevel {
+		top = top[:min(len(top), 1)]
+	}
+	if len(top) == 0 {
+		return false
+	}
+	top = append([]*table.Table{}, top...)
+
+	if cd.thisLevel == cd.nextLevel {
+		cd.bot = top
+		cd.thisRange = getKeyRange(top...)
+		cd.nextRange = cd.thisRange
+	} else {
+		cd.top = top
+		cd.thisRange = getKeyRange(top...)
+		left, right := cd.nextLevel.overlappingTables(levelHandlerRLocked{}, cd.thisRange)
+		cd.bot = append([]*table.Table{}, cd.nextLevel.tables[left:right]...)
+		cd.nextRange = cd.thisRange
+		if len(cd.bot) > 0 {
+			cd.nextRange = getKeyRange(cd.bot...)
+		}
+	}
+	for _, t := range top {
+		cd.thisSize += t.Size()
+	}
+	return s.cstatus.compareAndAdd(thisAndNextLevelRLocked{}, *cd)
+}
+
 func (s *levelsController) startCompact(lc *z.Closer) {
 	n := s.kv.opt.NumCompactors
 	lc.AddRunning(n - 1)
@@ -378,6 +523,8 @@ type targets struct {
 // example, when L6 reaches 1.1GB, then L4 target sizes becomes 11MB, thus exceeding the
 // BaseLevelSize of 10MB. L3 would then become the new Lbase, with a target size of 1MB <
 // BaseLevelSize.
+//
+// With Options.CustomLevelSizes, the target sizes are fixed instead, and Lbase is always L1.
 func (s *levelsController) levelTargets() targets {
 	adjust := func(sz int64) int64 {
 		if sz < s.kv.opt.BaseLevelSize {
@@ -390,15 +537,21 @@ func (s *levelsController) levelTargets() targets {
 		targetSz: make([]int64, len(s.levels)),
 		fileSz:   make([]int64, len(s.levels)),
 	}
-	// DB size is the size of the last level.
-	dbSize := s.lastLevel().getTotalSize()
-	for i := len(s.levels) - 1; i > 0; i-- {
-		ltarget := adjust(dbSize)
-		t.targetSz[i] = ltarget
-		if t.baseLevel == 0 && ltarget <= s.kv.opt.BaseLevelSize {
-			t.baseLevel = i
+	custom := len(s.kv.opt.CustomLevelSizes) > 0
+	if custom {
+		copy(t.targetSz[1:], s.kv.opt.CustomLevelSizes)
+		t.baseLevel = 1
+	} else {
+		// DB size is the size of the last level.
+		dbSize := s.lastLevel().getTotalSize()
+		for i := len(s.levels) - 1; i > 0; i-- {
+			ltarget := adjust(dbSize)
+			t.targetSz[i] = ltarget
+			if t.baseLevel == 0 && ltarget <= s.kv.opt.BaseLevelSize {
+				t.baseLevel = i
+			}
+			dbSize /= int64(s.kv.opt.LevelSizeMultiplier)
 		}
-		dbSize /= int64(s.kv.opt.LevelSizeMultiplier)
 	}
 
 	tsz := s.kv.opt.BaseTableSize
@@ -416,6 +569,9 @@ func (s *levelsController) levelTargets() targets {
 			t.fileSz[i] = tsz
 		}
 	}
+	if custom {
+		return t
+	}
 
 	// Bring the base level down to the last empty level.
 	for i := t.baseLevel + 1; i < len(s.levels)-1; i++ {
@@ -438,7 +594,7 @@ func (s *levelsController) levelTargets() targets {
 func (s *levelsController) runCompactor(id int, lc *z.Closer) {
 	defer lc.Done()
 
-	randomDelay := time.NewTimer(time.Duration(rand.Int31n(1000)) * time.Millisecond)
+	randomDelay := time.NewTimer(time.Duration(s.kv.randInt63n(1000)) * time.Millisecond)
 	select {
 	case <-randomDelay.C:
 	case <-lc.HasBeenClosed():
@@ -543,6 +699,32 @@ func (s *levelsController) lastLevel() *levelHandler {
 	return s.levels[len(s.levels)-1]
 }
 
+// compactionDebt estimates the number of bytes that compactions have to move down to bring all the
+// levels under their targets, with the same math as pickCompactLevels. L0 counts fully once it
+// has reached NumLevelZeroTables tables. The excess of a level adds to the size of the level
+// below it, where it can cause more excess.
+func (s *levelsController) compactionDebt() int64 {
+	t := s.levelTargets()
+	var debt, l0, carry int64
+	if s.levels[0].numTables() >= s.kv.opt.NumLevelZeroTables {
+		l0 = s.levels[0].getTotalSize()
+		debt = l0
+	}
+	for i := 1; i < len(s.levels)-1; i++ {
+		// L0 gets compacted into the base level.
+		sz := s.levels[i].getTotalSize() + carry
+		if i == t.baseLevel {
+			sz += l0
+		}
+		carry = 0
+		if excess := sz - t.targetSz[i]; excess > 0 {
+			debt += excess
+			carry = excess
+		}
+	}
+	return debt
+}
+
 // pickCompactLevel determines which level to compact.
 // Based on: https://github.com/facebook/rocksdb/wiki/Leveled-Compaction
 // It tries to reuse priosBuffer to reduce memory allocation,
@@ -656,16 +838,44 @@ func (s *levelsController) subcompact(it y.Iterator, kr keyRange, cd compactDef,
 	// never discard any versions starting from above this timestamp, because
 	// that would affect the snapshot view guarantee provided by transactions.
 	discardTs := s.kv.orc.discardAtOrBelow()
+	if cd.maxDiscardTs > 0 {
+		discardTs = min(discardTs, cd.maxDiscardTs)
+	}
+	// All versions at or below deleteTs are dropped. See DB.DeleteBelowVersion.
+	deleteTs := s.kv.orc.deleteAtOrBelow()
 
 	// Try to collect stats so that we can inform value log about GC. That would help us find which
 	// value log file should be GCed.
 	discardStats := make(map[uint32]int64)
-	updateStats := func(vs y.ValueStruct) {
+	// The key being scanned and the value pointers of its versions. The versions written by
+	// DB.RefreshTTL share the value pointer of the version they refreshed, so a value is only
+	// discarded if no other version of the key scanned so far points to it.
+	var vpKey []byte
+	var keyVps []valuePointer
+	sharedVp := func(key []byte, vs y.ValueStruct) bool {
+		if vs.Meta&bitValuePointer == 0 {
+			return false
+		}
+		if !y.SameKey(key, vpKey) {
+			vpKey = y.SafeCopy(vpKey, key)
+			keyVps = keyVps[:0]
+		}
+		var vp valuePointer
+		vp.Decode(vs.Value)
+		for _, p := range keyVps {
+			if p == vp {
+				return true
+			}
+		}
+		keyVps = append(keyVps, vp)
+		return false
+	}
+	updateStats := func(key []byte, vs y.ValueStruct) {
 		// We don't need to store/update discard stats when badger is running in Disk-less mode.
 		if s.kv.opt.InMemory {
 			return
 		}
-		if vs.Meta&bitValuePointer > 0 {
+		if vs.Meta&bitValuePointer > 0 && !sharedVp(key, vs) {
 			var vp valuePointer
 			vp.Decode(vs.Value)
 			discardStats[vp.Fid] += int64(vp.Len)
@@ -688,24 +898,99 @@ func (s *levelsController) subcompact(it y.Iterator, kr keyRange, cd compactDef,
 		return r-l >= 10
 	}
 
+	// The key whose versions are being dropped, the number dropped so far, and the keys with more
+	// than one version dropped, not yet passed on. See Options.HotspotKeys.
+	var dropKey []byte
+	var numDropped uint64
+	var dropStats []KeyStat
+	flushDrops := func(force bool) {
+		if numDropped > 1 {
+			dropStats = append(dropStats, KeyStat{Key: dropKey, VersionsDropped: numDropped})
+			dropKey = nil
+		}
+		numDropped = 0
+		if len(dropStats) >= hotspotBatchSize || (force && len(dropStats) > 0) {
+			s.kv.hotspots.add(dropStats)
+			dropStats = dropStats[:0]
+		}
+	}
+	if s.kv.hotspots != nil {
+		defer flushDrops(true)
+	}
+
+	// notifyDrop reports the entries dropped by this compaction to the CompactionDropHook.
+	var missedDrops int
+	notifyDrop := func(key []byte, reason DropReason) {
+		if s.kv.hotspots != nil {
+			if !bytes.Equal(y.ParseKey(key), dropKey) {
+				flushDrops(false)
+				dropKey = y.SafeCopy(dropKey, y.ParseKey(key))
+			}
+			numDropped++
+		}
+		if s.kv.opt.CompactionDropHook == nil {
+			return
+		}
+		if !s.kv.notifyDrop(key, reason) {
+			missedDrops++
+		}
+	}
+
 	var (
 		lastKey, skipKey       []byte
 		numBuilds, numVersions int
 		// Denotes if the first key is a series of duplicate keys had
 		// "DiscardEarlierVersions" set
 		firstKeyHasDiscardSet bool
+		// Denotes if a version of lastKey has been added to the table.
+		lastKeyAdded bool
+		// The reason the versions of skipKey are being dropped.
+		skipReason DropReason
 	)
 
+	// Bytes read and written since the last call to the compaction rate limiter.
+	var ioBytes int
+	defer func() { s.kv.compactionLimiter.wait(ioBytes) }()
+	// The key being scanned and the number of its versions scanned so far, including the ones
+	// skipped. See Options.MaxVersionsScannedPerKey.
+	maxScanned := s.kv.opt.MaxVersionsScannedPerKey
+	var scanKey []byte
+	var numScanned int
+
 	addKeys := func(builder *table.Builder) {
 		timeStart := time.Now()
 		var numKeys, numSkips uint64
 		var rangeCheck int
 		var tableKr keyRange
 		for ; it.Valid(); it.Next() {
+			// Going through the rate limiter in chunks keeps its overhead low.
+			if ioBytes >= compactionRateChunk {
+				s.kv.compactionLimiter.wait(ioBytes)
+				ioBytes = 0
+			}
+			read := it.Value()
+			ioBytes += len(it.Key()) + int(read.EncodedSize())
+
+			if maxScanned > 0 {
+				if !y.SameKey(it.Key(), scanKey) {
+					scanKey = y.SafeCopy(scanKey, it.Key())
+					numScanned = 0
+				}
+				numScanned++
+				if numScanned == maxScanned+1 {
+					s.kv.opt.Warningf("Compaction L%d -> L%d: key %q has more than %d versions",
+						cd.thisLevel.level, cd.nextLevel.level, y.ParseKey(it.Key()), maxScanned)
+				}
+				if numScanned%maxScanned == 0 {
+					runtime.Gosched()
+				}
+			}
+
 			// See if we need to skip the prefix.
 			if len(cd.dropPrefixes) > 0 && hasAnyPrefixes(it.Key(), cd.dropPrefixes) {
 				numSkips++
-				updateStats(it.Value())
+				updateStats(it.Key(), it.Value())
+				notifyDrop(it.Key(), DropPrefixed)
 				continue
 			}
 
@@ -713,7 +998,13 @@ func (s *levelsController) subcompact(it y.Iterator, kr keyRange, cd compactDef,
 			if len(skipKey) > 0 {
 				if y.SameKey(it.Key(), skipKey) {
 					numSkips++
-					updateStats(it.Value())
+					updateStats(it.Key(), it.Value())
+					notifyDrop(it.Key(), skipReason)
+					if merge := s.kv.opt.UserMetaMerge; merge != nil &&
+						skipReason == DropVersionLimit {
+						// The last key added is the oldest version of skipKey kept.
+						builder.SetLastUserMeta(merge(builder.LastUserMeta(), it.Value().UserMeta))
+					}
 					continue
 				} else {
 					skipKey = skipKey[:0]
@@ -733,6 +1024,7 @@ func (s *levelsController) subcompact(it y.Iterator, kr keyRange, cd compactDef,
 				}
 				lastKey = y.SafeCopy(lastKey, it.Key())
 				numVersions = 0
+				lastKeyAdded = false
 				firstKeyHasDiscardSet = it.Value().Meta&bitDiscardEarlierVersions > 0
 
 				if len(tableKr.left) == 0 {
@@ -759,6 +1051,24 @@ func (s *levelsController) subcompact(it y.Iterator, kr keyRange, cd compactDef,
 
 			isExpired := isDeletedOrExpired(vs.Meta, vs.ExpiresAt)
 
+			if version <= deleteTs && !bytes.HasPrefix(it.Key(), badgerPrefix) {
+				// This and all the older versions of the key get deleted.
+				skipKey = y.SafeCopy(skipKey, it.Key())
+				skipReason = DropBelowVersion
+				numSkips++
+				updateStats(it.Key(), vs)
+				notifyDrop(it.Key(), DropBelowVersion)
+				if lastKeyAdded || !hasOverlap {
+					continue
+				}
+				// Lower levels might still have older versions of the key. Leave a deletion
+				// marker in place of this version, so that those don't resurface.
+				numKeys++
+				builder.AddStaleKey(it.Key(), y.ValueStruct{Meta: bitDelete}, 0)
+				lastKeyAdded = true
+				continue
+			}
+
 			// Do not discard entries inserted by merge operator. These entries will be
 			// discarded once they're merged
 			if version <= discardTs && vs.Meta&bitMergeEntry == 0 {
@@ -777,6 +1087,14 @@ func (s *levelsController) subcompact(it y.Iterator, kr keyRange, cd compactDef,
 					// If this version of the key is deleted or expired, skip all the rest of the
 					// versions. Ensure that we're only removing versions below readTs.
 					skipKey = y.SafeCopy(skipKey, it.Key())
+					switch {
+					case vs.Meta&bitDelete > 0:
+						skipReason = DropTombstone
+					case isExpired:
+						skipReason = DropExpired
+					default:
+						skipReason = DropVersionLimit
+					}
 
 					switch {
 					// Add the key to the table only if it has not expired.
@@ -791,7 +1109,8 @@ func (s *levelsController) subcompact(it y.Iterator, kr keyRange, cd compactDef,
 					default:
 						// If no overlap, we can skip all the versions, by continuing here.
 						numSkips++
-						updateStats(vs)
+						updateStats(it.Key(), vs)
+						notifyDrop(it.Key(), skipReason)
 						continue // Skip adding this key.
 					}
 				}
@@ -800,6 +1119,8 @@ func (s *levelsController) subcompact(it y.Iterator, kr keyRange, cd compactDef,
 			var vp valuePointer
 			if vs.Meta&bitValuePointer > 0 {
 				vp.Decode(vs.Value)
+				// The older versions sharing the value pointer don't discard it.
+				sharedVp(it.Key(), vs)
 			}
 			switch {
 			case firstKeyHasDiscardSet:
@@ -814,6 +1135,9 @@ func (s *levelsController) subcompact(it y.Iterator, kr keyRange, cd compactDef,
 			default:
 				builder.Add(it.Key(), vs, vp.Len)
 			}
+			// The key gets written out as well.
+			ioBytes += len(it.Key()) + int(vs.EncodedSize())
+			lastKeyAdded = true
 		}
 		s.kv.opt.Debugf("[%d] LOG Compact. Added %d keys. Skipped %d keys. Iteration took: %v",
 			cd.compactorId, numKeys, numSkips, time.Since(timeStart).Round(time.Millisecond))
@@ -865,13 +1189,18 @@ func (s *levelsController) subcompact(it y.Iterator, kr keyRange, cd compactDef,
 
 			// If we couldn't build the table, return fast.
 			if err != nil {
+				s.kv.manifest.forget(fileID)
 				return
 			}
 			res <- tbl
 		}(builder, s.reserveFileID())
 	}
-	s.kv.vlog.updateDiscardStats(discardStats)
+	s.kv.updateDiscardStats(discardStats)
 	s.kv.opt.Debugf("Discard stats: %v", discardStats)
+	if missedDrops > 0 {
+		s.kv.opt.Warningf("[%d] CompactionDropHook queue was full. %d dropped keys not reported",
+			cd.compactorId, missedDrops)
+	}
 }
 
 // compactBuildTables merges topTables and botTables to form a list of new tables.
@@ -932,6 +1261,9 @@ func (s *levelsController) compactBuildTables(
 		go func(kr keyRange) {
 			defer inflightBuilders.Done(nil)
 			it := table.NewMergeIterator(newIterator(), false)
+			if s.kv.opt.ValidateKeyOrder {
+				it = &orderCheckIterator{Iterator: it}
+			}
 			defer it.Close()
 			s.subcompact(it, kr, cd, inflightBuilders, res)
 		}(kr)
@@ -1057,6 +1389,9 @@ type compactDef struct {
 	thisSize int64
 
 	dropPrefixes [][]byte
+	// maxDiscardTs caps the discard timestamp of the compaction, if it's not zero. See
+	// DB.PurgeTombstones.
+	maxDiscardTs uint64
 }
 
 // addSplits can allow us to run multiple sub-compactions in parallel across the split key ranges.
@@ -1366,6 +1701,76 @@ func (s *levelsController) fillMaxLevelTables(tables []*table.Table, cd *compact
 	return s.cstatus.compareAndAdd(thisAndNextLevelRLocked{}, *cd)
 }
 
+// gcCompact rewrites, in place, every table on levels one and below whose stale data makes up
+// more than staleFraction of its size. Unlike a regular compaction, no tables from the next
+// level are pulled in, so live data in the rest of the level is left untouched. It returns the
+// number of bytes by which the levels shrank.
+func (s *levelsController) gcCompact(staleFraction float64) (int64, error) {
+	var reclaimed int64
+	discardTs := s.kv.orc.discardAtOrBelow()
+	for _, lh := range s.levels[1:] {
+		lh.RLock()
+		tables := make([]*table.Table, len(lh.tables))
+		copy(tables, lh.tables)
+		lh.RUnlock()
+
+		for _, t := range tables {
+			if t.Size() == 0 || float64(t.StaleDataSize())/float64(t.Size()) <= staleFraction {
+				continue
+			}
+			// Versions above discardTs can't be dropped, so rewriting the table wouldn't
+			// reclaim anything.
+			if t.MaxVersion() > discardTs {
+				continue
+			}
+			n, err := s.gcCompactTable(lh, t)
+			if err != nil {
+				return reclaimed, err
+			}
+			reclaimed += n
+		}
+	}
+	return reclaimed, nil
+}
+
+// gcCompactTable rewrites t into the same level, dropping its stale entries.
+func (s *levelsController) gcCompactTable(lh *levelHandler, t *table.Table) (int64, error) {
+	cd := compactDef{
+		compactorId: -1,
+		t:           s.levelTargets(),
+		thisLevel:   lh,
+		nextLevel:   lh,
+		top:         []*table.Table{t},
+		bot:         []*table.Table{},
+		thisRange:   getKeyRange(t),
+		thisSize:    t.Size(),
+	}
+	cd.p = compactionPriority{level: lh.level, t: cd.t}
+	cd.nextRange = cd.thisRange
+
+	cd.lockLevels()
+	stillThere := false
+	for _, lt := range lh.tables {
+		if lt.ID() == t.ID() {
+			stillThere = true
+			break
+		}
+	}
+	ok := stillThere && s.cstatus.compareAndAdd(thisAndNextLevelRLocked{}, cd)
+	cd.unlockLevels()
+	if !ok {
+		// The table was compacted away or is being compacted right now.
+		return 0, nil
+	}
+	defer s.cstatus.delete(cd)
+
+	before := lh.getTotalSize()
+	if err := s.runCompactDef(-1, lh.level, cd); err != nil {
+		return 0, err
+	}
+	return before - lh.getTotalSize(), nil
+}
+
 func (s *levelsController) fillTables(cd *compactDef) bool {
 	cd.lockLevels()
 	defer cd.unlockLevels()
@@ -1452,9 +1857,26 @@ func (s *levelsController) runCompactDef(id, l int, cd compactDef) (err error) {
 	changeSet := buildChangeSet(&cd, newTables)
 
 	// We write to the manifest _before_ we delete files (and after we created files)
-	if err := s.kv.manifest.addChanges(changeSet.Changes); err != nil {
+	if s.kv.opt.SyncManifest {
+		err = s.kv.manifest.addChanges(changeSet.Changes)
+	} else {
+		err = s.kv.manifest.appendChanges(changeSet.Changes)
+	}
+	if err != nil {
 		return err
 	}
+	if !s.kv.opt.SyncManifest {
+		// Keep the files of the compacted tables until the changes are synced.
+		old := append(append([]*table.Table{}, cd.top...), cd.bot...)
+		for _, t := range old {
+			t.IncrRef()
+		}
+		defer func() {
+			if relErr := s.kv.manifest.releaseAfterSync(old); err == nil {
+				err = relErr
+			}
+		}()
+	}
 
 	getSizes := func(tables []*table.Table) int64 {
 		size := int64(0)
@@ -1564,6 +1986,7 @@ func (s *levelsController) doCompact(id int, p compactionPriority) error {
 		return err
 	}
 
+	s.numCompactions.Add(1)
 	s.kv.opt.Debugf("[Compactor: %d] Compaction for level: %d DONE", id, cd.thisLevel.level)
 	return nil
 }
@@ -1608,8 +2031,8 @@ func (s *levelsController) close() error {
 // get searches for a given key in all the levels of the LSM tree. It returns
 // key version <= the expected version (version in key). If not found,
 // it returns an empty y.ValueStruct.
-func (s *levelsController) get(key []byte, maxVs y.ValueStruct, startLevel int) (
-	y.ValueStruct, error) {
+func (s *levelsController) get(key []byte, maxVs y.ValueStruct, startLevel int,
+	stats *ReadStats) (y.ValueStruct, error) {
 	if s.kv.IsClosed() {
 		return y.ValueStruct{}, ErrDBClosed
 	}
@@ -1624,7 +2047,7 @@ func (s *levelsController) get(key []byte, maxVs y.ValueStruct, startLevel int)
 		if h.level < startLevel {
 			continue
 		}
-		vs, err := h.get(key) // Calls h.RLock() and h.RUnlock().
+		vs, err := h.get(key, stats) // Calls h.RLock() and h.RUnlock().
 		if err != nil {
 			return y.ValueStruct{}, y.Wrapf(err, "get key: %q", key)
 		}
@@ -1645,6 +2068,59 @@ func (s *levelsController) get(key []byte, maxVs y.ValueStruct, startLevel int)
 	return maxVs, nil
 }
 
+// getCached looks up key in the levels like get, but only if that can be done from memory. It
+// returns false if a table would have to be read.
+func (s *levelsController) getCached(key []byte, maxVs y.ValueStruct) (y.ValueStruct, bool, error) {
+	if s.kv.IsClosed() {
+		return y.ValueStruct{}, false, ErrDBClosed
+	}
+	version := y.ParseTs(key)
+	for _, h := range s.levels {
+		vs, cached, err := h.getCached(key)
+		if err != nil || !cached {
+			return y.ValueStruct{}, false, err
+		}
+		if vs.Value == nil && vs.Meta == 0 {
+			continue
+		}
+		if vs.Version == version {
+			return vs, true, nil
+		}
+		if maxVs.Version < vs.Version {
+			maxVs = vs
+		}
+	}
+	return maxVs, true, nil
+}
+
+// getMany looks up the keys, which must be sorted, in all the levels. It works like get, but
+// searches each level once for the whole batch of keys. vs[i] holds the newest version of keys[i]
+// found so far, and is replaced if a newer one is found.
+func (s *levelsController) getMany(keys [][]byte, vs []y.ValueStruct) error {
+	if s.kv.IsClosed() {
+		return ErrDBClosed
+	}
+	// Keys whose requested version was found don't need to be searched for any further.
+	done := make([]bool, len(keys))
+	markDone := func() {
+		for i, key := range keys {
+			found := vs[i].Meta != 0 || vs[i].Value != nil
+			if found && vs[i].Version == y.ParseTs(key) {
+				done[i] = true
+			}
+		}
+	}
+	markDone()
+	// Levels must be searched from 0 upward. See get.
+	for _, h := range s.levels {
+		if err := h.getMany(keys, vs, done); err != nil {
+			return y.Wrapf(err, "get many keys")
+		}
+		markDone()
+	}
+	return nil
+}
+
 func appendIteratorsReversed(out []y.Iterator, th []*table.Table, opt int) []y.Iterator {
 	for i := len(th) - 1; i >= 0; i-- {
 		// This will increment the reference of the table handler.
@@ -1675,9 +2151,13 @@ type TableInfo struct {
 	OnDiskSize       uint32
 	StaleDataSize    uint32
 	UncompressedSize uint32
+	BlocksSize       uint32 // Size of the blocks on disk, after compression.
 	MaxVersion       uint64
 	IndexSz          int
 	BloomFilterSize  int
+	// When the table file was written, by a memtable flush or a compaction. It is taken from the
+	// modification time of the file, and is zero in InMemory mode.
+	CreatedAt time.Time
 }
 
 func (s *levelsController) getTableInfo() (result []TableInfo) {
@@ -1695,7 +2175,9 @@ func (s *levelsController) getTableInfo() (result []TableInfo) {
 				IndexSz:          t.IndexSize(),
 				BloomFilterSize:  t.BloomFilterSize(),
 				UncompressedSize: t.UncompressedSize(),
+				BlocksSize:       t.BlocksSize(),
 				MaxVersion:       t.MaxVersion(),
+				CreatedAt:        t.CreatedAt,
 			}
 			result = append(result, info)
 		}
@@ -1720,6 +2202,11 @@ type LevelInfo struct {
 	Score          float64
 	Adjusted       float64
 	StaleDatSize   int64
+	// Size of the blocks of the tables before compression, and on disk.
+	UncompressedSize int64
+	BlocksSize       int64
+	// Number of entries in the tables, counting every version and delete marker of a key.
+	NumKeys int64
 }
 
 func (s *levelsController) getLevelInfo() []LevelInfo {
@@ -1732,6 +2219,11 @@ func (s *levelsController) getLevelInfo() []LevelInfo {
 		result[i].Size = l.totalSize
 		result[i].NumTables = len(l.tables)
 		result[i].StaleDatSize = l.totalStaleSize
+		for _, t := range l.tables {
+			result[i].UncompressedSize += int64(t.UncompressedSize())
+			result[i].BlocksSize += int64(t.BlocksSize())
+			result[i].NumKeys += int64(t.KeyCount())
+		}
 
 		l.RUnlock()
 
@@ -1774,6 +2266,87 @@ func (s *levelsController) verifyChecksum() error {
 	return nil
 }
 
+// rangeSize estimates the bytes held by the tables for the keys in [start, end), which are keys
+// with timestamps. It returns the size of the overlapping table blocks, and the size of the value
+// log entries attributed to those blocks.
+func (s *levelsController) rangeSize(start, end []byte) (lsm, vlog int64) {
+	for _, l := range s.levels {
+		l.RLock()
+		for _, t := range l.tables {
+			if y.CompareKeys(t.Biggest(), start) < 0 ||
+				(len(end) > 0 && y.CompareKeys(t.Smallest(), end) >= 0) {
+				continue
+			}
+			blocks, v := t.RangeSize(start, end)
+			lsm += blocks
+			vlog += v
+		}
+		l.RUnlock()
+	}
+	return lsm, vlog
+}
+
+// warmCache loads the blocks of the tables for the keys in [start, end), which are keys with
+// timestamps, into the block cache. The levels are warmed from the top, where the latest versions
+// live, and it stops once budget bytes were loaded. It returns the number of bytes loaded.
+func (s *levelsController) warmCache(ctx context.Context, start, end []byte,
+	budget int64) (int64, error) {
+	var tables []*table.Table
+	for _, l := range s.levels {
+		l.RLock()
+		for _, t := range l.tables {
+			if y.CompareKeys(t.Biggest(), start) < 0 ||
+				(len(end) > 0 && y.CompareKeys(t.Smallest(), end) >= 0) {
+				continue
+			}
+			t.IncrRef()
+			tables = append(tables, t)
+		}
+		l.RUnlock()
+	}
+	defer func() {
+		_ = decrRefs(tables)
+	}()
+
+	var warmed int64
+	for _, t := range tables {
+		n, err := t.Warm(ctx, start, end, budget-warmed)
+		warmed += n
+		if err != nil {
+			return warmed, err
+		}
+	}
+	return warmed, nil
+}
+
+// pinRange pins the blocks of the tables for the keys in [start, end), which are keys with
+// timestamps. The range must have been added to the block pins.
+func (s *levelsController) pinRange(start, end []byte) error {
+	var tables []*table.Table
+	for _, l := range s.levels {
+		l.RLock()
+		for _, t := range l.tables {
+			if y.CompareKeys(t.Biggest(), start) < 0 ||
+				(len(end) > 0 && y.CompareKeys(t.Smallest(), end) >= 0) {
+				continue
+			}
+			t.IncrRef()
+			tables = append(tables, t)
+		}
+		l.RUnlock()
+	}
+	defer func() {
+		_ = decrRefs(tables)
+	}()
+
+	for _, t := range tables {
+		if err := t.Pin(start, end); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Returns the sorted list of splits for all the levels and tables based
 // on the block offsets.
 func (s *levelsController) keySplits(numPerTable int, prefix []byte) []string {
@@ -21,7 +21,10 @@ import (
 	"math"
 	"math/rand"
 	"os"
+	"path/filepath"
+	"reflect"
 	"sort"
+	"sync"
 	"testing"
 	"time"
 
@@ -39,6 +42,7 @@ func createAndOpen(db *DB, td []keyValVersion, level int) {
 		BlockSize:          db.opt.BlockSize,
 		BloomFalsePositive: db.opt.BloomFalsePositive,
 		ChkMode:            options.NoVerification,
+		OnDelete:           db.tableDeleted,
 	}
 	b := table.NewTableBuilder(opts)
 	defer b.Close()
@@ -160,6 +164,69 @@ func getAllAndCheck(t *testing.T, db *DB, expected []keyValVersion) {
 	}))
 }
 
+func TestUserMetaMerge(t *testing.T) {
+	// userMetas opens a DB in which versions 1 to 4 of a key were written with the user meta bits
+	// 1, 2, 4 and 8, flushed to level 0, optionally compacts level 0 into level 1, and returns the
+	// user meta of each version left.
+	userMetas := func(t *testing.T, opt Options, compact bool) map[uint64]byte {
+		dir, err := os.MkdirTemp("", "badger-test")
+		require.NoError(t, err)
+		defer removeDir(dir)
+		opt.Dir, opt.ValueDir = dir, dir
+		opt.managedTxns = true
+
+		db, err := Open(opt)
+		require.NoError(t, err)
+		for version := uint64(1); version <= 4; version++ {
+			txn := db.NewTransactionAt(version, true)
+			e := NewEntry([]byte("key"), []byte("val")).WithMeta(1 << (version - 1))
+			require.NoError(t, txn.SetEntry(e))
+			require.NoError(t, txn.CommitAt(version, nil))
+		}
+		db.SetDiscardTs(10)
+		require.NoError(t, db.Close())
+
+		db, err = Open(opt)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, db.Close()) }()
+		db.SetDiscardTs(10)
+		if compact {
+			cdef := compactDef{
+				thisLevel: db.lc.levels[0],
+				nextLevel: db.lc.levels[1],
+				top:       db.lc.levels[0].tables,
+				bot:       db.lc.levels[1].tables,
+				t:         db.lc.levelTargets(),
+			}
+			cdef.t.baseLevel = 1
+			require.NoError(t, db.lc.runCompactDef(-1, 0, cdef))
+		}
+
+		metas := make(map[uint64]byte)
+		txn := db.NewTransactionAt(math.MaxUint64, false)
+		defer txn.Discard()
+		iopt := DefaultIteratorOptions
+		iopt.AllVersions = true
+		it := txn.NewIterator(iopt)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			metas[it.Item().Version()] = it.Item().UserMeta()
+		}
+		return metas
+	}
+	or := func(newer, older byte) byte { return newer | older }
+
+	opt := DefaultOptions("").WithNumCompactors(0).WithNumVersionsToKeep(2)
+	// Without a merge function, the versions kept keep their own user meta.
+	require.Equal(t, map[uint64]byte{4: 8, 3: 4}, userMetas(t, opt, true))
+	// The user meta of the dropped versions is folded into the oldest version kept.
+	opt = opt.WithUserMetaMerge(or)
+	require.Equal(t, map[uint64]byte{4: 8, 3: 7}, userMetas(t, opt, true))
+	// Versions dropped by a flush get folded as well.
+	require.Equal(t, map[uint64]byte{4: 8, 3: 7},
+		userMetas(t, opt.WithPruneVersionsOnFlush(true), false))
+}
+
 func TestCompaction(t *testing.T) {
 	// Disable compactions and keep single version of each key.
 	opt := DefaultOptions("").WithNumCompactors(0).WithNumVersionsToKeep(1)
@@ -1302,3 +1369,535 @@ func TestStaleDataCleanup(t *testing.T) {
 
 	})
 }
+
+func TestRangeSize(t *testing.T) {
+	dir, err := os.MkdirTemp("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	opt := getTestOptions(dir).WithValueThreshold(64)
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	val := make([]byte, 1<<10)
+	wb := db.NewWriteBatch()
+	for i := 0; i < 5000; i++ {
+		require.NoError(t, wb.Set([]byte(fmt.Sprintf("a%05d", i)), val))
+		require.NoError(t, wb.Set([]byte(fmt.Sprintf("b%05d", i)), val))
+	}
+	require.NoError(t, wb.Flush())
+	// Reopen, so that the memtable gets flushed to disk.
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+
+	_, err = db.RangeSize([]byte("b"), []byte("a"))
+	require.Equal(t, ErrInvalidRequest, err)
+
+	all, err := db.RangeSize(nil, nil)
+	require.NoError(t, err)
+	a, err := db.RangeSize([]byte("a"), []byte("b"))
+	require.NoError(t, err)
+	b, err := db.RangeSize([]byte("b"), nil)
+	require.NoError(t, err)
+	half, err := db.RangeSize([]byte("a02500"), []byte("b"))
+	require.NoError(t, err)
+	none, err := db.RangeSize([]byte("c"), []byte("d"))
+	require.NoError(t, err)
+
+	// 5000 values of 1KB in the value log for each prefix.
+	require.InDelta(t, 5000<<10, a, 0.2*(5000<<10))
+	require.InDelta(t, a, b, 0.1*float64(a))
+	require.InDelta(t, a/2, half, 0.1*float64(a))
+	require.InDelta(t, all, a+b, 0.05*float64(all))
+	require.Less(t, none, int64(1<<10))
+}
+
+func TestCompactionDropHook(t *testing.T) {
+	var mu sync.Mutex
+	dropped := make(map[string][]DropReason)
+	hook := func(key []byte, reason DropReason) {
+		mu.Lock()
+		defer mu.Unlock()
+		dropped[string(key)] = append(dropped[string(key)], reason)
+	}
+
+	// Disable compactions and keep single version of each key.
+	opt := DefaultOptions("").WithNumCompactors(0).WithNumVersionsToKeep(1).
+		WithCompactionDropHook(hook)
+	opt.managedTxns = true
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		l0 := []keyValVersion{
+			{"del", "", 3, bitDelete}, {"del", "bar", 2, 0},
+			{"drop", "bar", 1, 0}, {"foo", "bar", 3, 0}, {"foo", "bar", 2, 0},
+		}
+		l1 := []keyValVersion{{"foo", "bar", 1, 0}, {"keep", "bar", 1, 0}}
+		createAndOpen(db, l0, 0)
+		createAndOpen(db, l1, 1)
+
+		// Set a high discard timestamp so that all the keys are below the discard timestamp.
+		db.SetDiscardTs(10)
+
+		cdef := compactDef{
+			thisLevel:    db.lc.levels[0],
+			nextLevel:    db.lc.levels[1],
+			top:          db.lc.levels[0].tables,
+			bot:          db.lc.levels[1].tables,
+			t:            db.lc.levelTargets(),
+			dropPrefixes: [][]byte{[]byte("drop")},
+		}
+		cdef.t.baseLevel = 1
+		require.NoError(t, db.lc.runCompactDef(-1, 0, cdef))
+		getAllAndCheck(t, db, []keyValVersion{{"foo", "bar", 3, 0}, {"keep", "bar", 1, 0}})
+
+		expected := map[string][]DropReason{
+			"del":  {DropTombstone, DropTombstone},
+			"drop": {DropPrefixed},
+			"foo":  {DropVersionLimit, DropVersionLimit},
+		}
+		require.Eventually(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return reflect.DeepEqual(expected, dropped)
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestCompactionDebt(t *testing.T) {
+	opt := DefaultOptions("")
+	// Disable all compactions.
+	opt.NumCompactors = 0
+	opt.NumLevelZeroTables = 2
+
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		require.Zero(t, db.CompactionDebt())
+
+		createAndOpen(db, []keyValVersion{{"foo", "bar", 1, 0}}, 0)
+		require.Zero(t, db.CompactionDebt())
+		createAndOpen(db, []keyValVersion{{"foo", "baz", 2, 0}}, 0)
+		l0 := db.lc.levels[0].getTotalSize()
+		require.Equal(t, l0, db.CompactionDebt())
+
+		// L6 has 1000MB, so L5 has a target of 100MB and L4, the base level, of 10MB.
+		const mb = 1 << 20
+		setSize := func(level int, sz int64) {
+			db.lc.levels[level].Lock()
+			db.lc.levels[level].totalSize = sz
+			db.lc.levels[level].Unlock()
+		}
+		setSize(6, 1000*mb)
+		setSize(5, 300*mb)
+		setSize(4, 15*mb)
+		defer func() {
+			for level := 4; level <= 6; level++ {
+				setSize(level, 0)
+			}
+		}()
+		require.Equal(t, 4, db.lc.levelTargets().baseLevel)
+		// L0 moves to L4, whose excess moves to L5, whose excess moves to L6.
+		require.Equal(t, l0+(5*mb+l0)+(205*mb+l0), db.CompactionDebt())
+	})
+}
+
+func TestLevelInfoNumKeys(t *testing.T) {
+	opt := DefaultOptions("").WithNumCompactors(0)
+	opt.managedTxns = true
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		createAndOpen(db, []keyValVersion{{"foo", "bar", 3, 0}, {"foo", "", 2, bitDelete}}, 0)
+		createAndOpen(db, []keyValVersion{{"fooz", "baz", 1, 0}}, 0)
+		createAndOpen(db, []keyValVersion{{"a", "b", 1, 0}, {"c", "d", 1, 0}, {"e", "f", 1, 0}}, 6)
+
+		levels := db.Levels()
+		require.Equal(t, int64(3), levels[0].NumKeys)
+		require.Equal(t, int64(3), levels[6].NumKeys)
+		for _, l := range levels[1:6] {
+			require.Zero(t, l.NumKeys)
+		}
+	})
+}
+
+func TestTableInfoCreatedAt(t *testing.T) {
+	dir, err := os.MkdirTemp("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opt := getTestOptions(dir).WithNumCompactors(0)
+	opt.managedTxns = true
+
+	// File modification times may be truncated to the second.
+	start := time.Now().Truncate(time.Second)
+	db, err := Open(opt)
+	require.NoError(t, err)
+	createAndOpen(db, []keyValVersion{{"foo", "bar", 1, 0}}, 0)
+	createdAt := db.Tables()[0].CreatedAt
+	require.False(t, createdAt.Before(start))
+	require.False(t, createdAt.After(time.Now()))
+	require.NoError(t, db.Close())
+
+	// The time the table was written is kept across restarts.
+	db, err = Open(opt)
+	require.NoError(t, err)
+	require.Equal(t, createdAt, db.Tables()[0].CreatedAt)
+	require.NoError(t, db.Close())
+}
+
+func TestCustomLevelSizes(t *testing.T) {
+	const mb = 1 << 20
+	sizes := []int64{100 * mb, 200 * mb, 10 << 30}
+	opt := DefaultOptions("").WithNumCompactors(0).WithMaxLevels(4).WithCustomLevelSizes(sizes)
+	// The options keep a copy of the sizes.
+	sizes[0] = 1
+
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		tt := db.lc.levelTargets()
+		require.Equal(t, 1, tt.baseLevel)
+		require.Equal(t, []int64{0, 100 * mb, 200 * mb, 10 << 30}, tt.targetSz)
+
+		setSize := func(level int, sz int64) {
+			db.lc.levels[level].Lock()
+			db.lc.levels[level].totalSize = sz
+			db.lc.levels[level].Unlock()
+		}
+		// A large last level doesn't move the base level, or change the targets.
+		setSize(3, 5<<30)
+		setSize(1, 50*mb)
+		defer func() {
+			setSize(1, 0)
+			setSize(3, 0)
+		}()
+		tt = db.lc.levelTargets()
+		require.Equal(t, 1, tt.baseLevel)
+		require.Equal(t, int64(100*mb), tt.targetSz[1])
+		require.Empty(t, db.lc.pickCompactLevels(nil))
+
+		setSize(1, 150*mb)
+		prios := db.lc.pickCompactLevels(nil)
+		require.Len(t, prios, 1)
+		require.Equal(t, 1, prios[0].level)
+		require.Equal(t, 1.5, prios[0].score)
+	})
+
+	for _, sizes := range [][]int64{{100, 200}, {100, 100, 300}, {0, 200, 300}} {
+		_, err := Open(opt.WithCustomLevelSizes(sizes))
+		require.ErrorContains(t, err, "Invalid CustomLevelSizes")
+	}
+}
+
+func TestKeyLevel(t *testing.T) {
+	opt := DefaultOptions("").WithNumCompactors(0)
+	opt.managedTxns = true
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		createAndOpen(db, []keyValVersion{{"foo", "bar", 1, 0}, {"fooz", "baz", 1, 0}}, 3)
+		createAndOpen(db, []keyValVersion{{"foo", "bar", 2, 0}}, 1)
+		createAndOpen(db, []keyValVersion{{"del", "", 2, bitDelete}}, 2)
+		createAndOpen(db, []keyValVersion{{"del", "val", 1, 0}}, 4)
+
+		check := func(key string, level int, found bool) {
+			l, f, err := db.KeyLevel([]byte(key))
+			require.NoError(t, err)
+			require.Equal(t, level, l, key)
+			require.Equal(t, found, f, key)
+		}
+		check("foo", 1, true)
+		check("fooz", 3, true)
+		check("del", 2, false)
+		check("missing", 0, false)
+
+		txn := db.NewTransactionAt(5, true)
+		require.NoError(t, txn.Set([]byte("fooz"), []byte("new")))
+		require.NoError(t, txn.CommitAt(5, nil))
+		check("fooz", 0, true)
+
+		_, _, err := db.KeyLevel(nil)
+		require.Equal(t, ErrEmptyKey, err)
+	})
+}
+
+func TestReadRepair(t *testing.T) {
+	const mb = 1 << 20
+	opt := DefaultOptions("").WithNumCompactors(0).WithMaxLevels(4).
+		WithCustomLevelSizes([]int64{10 * mb, 100 * mb, 1000 * mb}).WithReadRepair(8)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		createAndOpen(db, []keyValVersion{{"cold", "c", 1, 0}, {"hot", "h", 1, 0}}, 3)
+		createAndOpen(db, []keyValVersion{{"warm", "w", 1, 0}}, 1)
+		// Move the read timestamp past the version of the tables.
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set([]byte("other"), []byte("o"))
+		}))
+
+		read := func(key string, times int) {
+			require.NoError(t, db.View(func(txn *Txn) error {
+				for i := 0; i < times; i++ {
+					if _, err := txn.Get([]byte(key)); err != nil {
+						return err
+					}
+				}
+				return nil
+			}))
+		}
+		level := func(key string) int {
+			l, found, err := db.KeyLevel([]byte(key))
+			require.NoError(t, err)
+			require.True(t, found)
+			return l
+		}
+		// A transaction reading the key doesn't conflict with its repair.
+		user := db.NewTransaction(true)
+		defer user.Discard()
+		_, err := user.Get([]byte("hot"))
+		require.NoError(t, err)
+		require.NoError(t, user.Set([]byte("other"), []byte("u")))
+
+		read("cold", 1)
+		read("warm", 100)
+		read("hot", 100)
+		require.Eventually(t, func() bool { return level("hot") == 0 }, 5*time.Second,
+			10*time.Millisecond)
+		require.Equal(t, 3, level("cold"))
+		require.Equal(t, 1, level("warm"))
+		require.NoError(t, user.Commit())
+
+		require.NoError(t, db.View(func(txn *Txn) error {
+			item, err := txn.Get([]byte("hot"))
+			require.NoError(t, err)
+			require.Equal(t, uint64(1), item.Version())
+			require.Equal(t, []byte("h"), getItemValue(t, item))
+			return nil
+		}))
+	})
+
+	opt.managedTxns = true
+	_, err := Open(opt)
+	require.ErrorContains(t, err, "Cannot use ReadRepair")
+}
+
+// warningLogger records the warnings, and drops the other messages.
+type warningLogger struct {
+	sync.Mutex
+	warnings []string
+}
+
+func (l *warningLogger) Errorf(string, ...interface{}) {}
+func (l *warningLogger) Infof(string, ...interface{})  {}
+func (l *warningLogger) Debugf(string, ...interface{}) {}
+func (l *warningLogger) Warningf(f string, v ...interface{}) {
+	l.Lock()
+	defer l.Unlock()
+	l.warnings = append(l.warnings, fmt.Sprintf(f, v...))
+}
+
+func TestMaxVersionsScannedPerKey(t *testing.T) {
+	logger := &warningLogger{}
+	opt := DefaultOptions("").WithNumCompactors(0).WithMaxVersionsScannedPerKey(5).
+		WithLogger(logger)
+	opt.managedTxns = true
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		var hot []keyValVersion
+		for v := 12; v > 0; v-- {
+			hot = append(hot, keyValVersion{"hot", "val", v, 0})
+		}
+		createAndOpen(db, hot, 0)
+		// Exactly at the limit.
+		createAndOpen(db, []keyValVersion{{"cold", "val", 5, 0}, {"cold", "val", 4, 0},
+			{"cold", "val", 3, 0}, {"cold", "val", 2, 0}, {"cold", "val", 1, 0}}, 1)
+
+		cdef := compactDef{
+			thisLevel: db.lc.levels[0],
+			nextLevel: db.lc.levels[1],
+			top:       db.lc.levels[0].tables,
+			bot:       db.lc.levels[1].tables,
+			t:         db.lc.levelTargets(),
+		}
+		cdef.t.baseLevel = 1
+		require.NoError(t, db.lc.runCompactDef(-1, 0, cdef))
+
+		logger.Lock()
+		defer logger.Unlock()
+		require.Equal(t, []string{`Compaction L0 -> L1: key "hot" has more than 5 versions`},
+			logger.warnings)
+	})
+	_, err := Open(DefaultOptions(t.TempDir()).WithMaxVersionsScannedPerKey(-1))
+	require.Error(t, err)
+}
+
+func TestWriteAmplificationHotspots(t *testing.T) {
+	opt := DefaultOptions("").WithNumCompactors(0).WithHotspotKeys(2)
+	opt.managedTxns = true
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		require.Empty(t, db.WriteAmplificationHotspots())
+
+		var kvs []keyValVersion
+		for v := 10; v > 0; v-- {
+			kvs = append(kvs, keyValVersion{"hot", "val", v, 0})
+		}
+		kvs = append(kvs, keyValVersion{"single", "val", 2, 0}, keyValVersion{"single", "val", 1, 0})
+		for v := 4; v > 0; v-- {
+			kvs = append(kvs, keyValVersion{"warm", "val", v, 0})
+		}
+		createAndOpen(db, kvs, 0)
+		createAndOpen(db, []keyValVersion{{"cold", "val", 1, 0}}, 1)
+		db.SetDiscardTs(20)
+
+		cdef := compactDef{
+			thisLevel: db.lc.levels[0],
+			nextLevel: db.lc.levels[1],
+			top:       db.lc.levels[0].tables,
+			bot:       db.lc.levels[1].tables,
+			t:         db.lc.levelTargets(),
+		}
+		cdef.t.baseLevel = 1
+		require.NoError(t, db.lc.runCompactDef(-1, 0, cdef))
+
+		require.Equal(t, []KeyStat{
+			{Key: []byte("hot"), VersionsDropped: 9},
+			{Key: []byte("warm"), VersionsDropped: 3},
+		}, db.WriteAmplificationHotspots())
+	})
+	_, err := Open(DefaultOptions(t.TempDir()).WithHotspotKeys(-1))
+	require.Error(t, err)
+}
+
+func TestGCCompact(t *testing.T) {
+	opt := DefaultOptions("").WithNumCompactors(0)
+	opt.managedTxns = true
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		var kvs []keyValVersion
+		for i := 0; i < 100; i++ {
+			k := key("a", i)
+			kvs = append(kvs, keyValVersion{k, "new", 5, bitDiscardEarlierVersions})
+			for v := 4; v > 0; v-- {
+				kvs = append(kvs, keyValVersion{k, "old", v, 0})
+			}
+		}
+		createAndOpen(db, kvs, 0)
+		createAndOpen(db, []keyValVersion{{"z", "val", 1, 0}}, 1)
+
+		// With discardTs at zero, the old versions are kept but marked as stale.
+		cdef := compactDef{
+			thisLevel: db.lc.levels[0],
+			nextLevel: db.lc.levels[1],
+			top:       db.lc.levels[0].tables,
+			bot:       []*table.Table{},
+			t:         db.lc.levelTargets(),
+		}
+		cdef.t.baseLevel = 1
+		require.NoError(t, db.lc.runCompactDef(-1, 0, cdef))
+		require.Len(t, db.lc.levels[1].tables, 2)
+		untouched := db.lc.levels[1].tables[1].ID()
+
+		// Nothing can be dropped until discardTs moves past the versions.
+		reclaimed, err := db.GCCompact(0.2)
+		require.NoError(t, err)
+		require.Zero(t, reclaimed)
+
+		db.SetDiscardTs(10)
+		reclaimed, err = db.GCCompact(0.2)
+		require.NoError(t, err)
+		require.Greater(t, reclaimed, int64(0))
+		require.Len(t, db.lc.levels[1].tables, 2)
+		require.Equal(t, untouched, db.lc.levels[1].tables[1].ID())
+
+		var expected []keyValVersion
+		for i := 0; i < 100; i++ {
+			expected = append(expected, keyValVersion{key("a", i), "new", 5, bitDiscardEarlierVersions})
+		}
+		getAllAndCheck(t, db, append(expected, keyValVersion{"z", "val", 1, 0}))
+
+		_, err = db.GCCompact(1)
+		require.Error(t, err)
+	})
+}
+
+func TestPurgeTombstones(t *testing.T) {
+	opt := DefaultOptions("").WithNumCompactors(0)
+	opt.managedTxns = true
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		createAndOpen(db, []keyValVersion{
+			{"p/a", "1", 1, 0}, {"p/b", "1", 1, 0}, {"q/a", "1", 1, 0}}, 3)
+		createAndOpen(db, []keyValVersion{{"p/a", "", 3, bitDelete}, {"p/b", "", 6, bitDelete}}, 1)
+		createAndOpen(db, []keyValVersion{{"q/a", "", 3, bitDelete}}, 1)
+		createAndOpen(db, []keyValVersion{{"p/c", "2", 2, 0}}, 0)
+		all := []keyValVersion{
+			{"p/a", "", 3, bitDelete}, {"p/a", "1", 1, 0},
+			{"p/b", "", 6, bitDelete}, {"p/b", "1", 1, 0},
+			{"p/c", "2", 2, 0},
+			{"q/a", "", 3, bitDelete}, {"q/a", "1", 1, 0},
+		}
+
+		// Nothing is dropped while the versions are visible to readers.
+		require.NoError(t, db.PurgeTombstones([]byte("p/"), 5))
+		getAllAndCheck(t, db, all)
+
+		db.SetDiscardTs(10)
+		require.NoError(t, db.PurgeTombstones([]byte("p/"), 5))
+		// The tombstone of p/b is above the watermark, and q/a doesn't have the prefix.
+		getAllAndCheck(t, db, all[2:])
+		require.Zero(t, db.lc.levels[0].numTables())
+
+		require.NoError(t, db.PurgeTombstones(nil, 7))
+		getAllAndCheck(t, db, []keyValVersion{{"p/c", "2", 2, 0}})
+	})
+}
+
+func TestOrphanedFiles(t *testing.T) {
+	opt := getTestOptions("").WithNumCompactors(0)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		createAndOpen(db, []keyValVersion{{"a", "1", 1, 0}}, 0)
+		createAndOpen(db, []keyValVersion{{"b", "1", 1, 0}}, 0)
+		paths, err := db.OrphanedFiles()
+		require.NoError(t, err)
+		require.Empty(t, paths)
+
+		// A table which is being built isn't orphaned.
+		building := table.NewFilename(db.lc.reserveFileID(), db.opt.Dir)
+		require.NoError(t, os.WriteFile(building, []byte("building"), 0600))
+
+		// Neither is a deleted table whose file is still open.
+		held := db.lc.levels[0].tables[0]
+		held.IncrRef()
+		cdef := compactDef{
+			thisLevel: db.lc.levels[0],
+			nextLevel: db.lc.levels[1],
+			top:       db.lc.levels[0].tables,
+			bot:       db.lc.levels[1].tables,
+			t:         db.lc.levelTargets(),
+		}
+		cdef.t.baseLevel = 1
+		require.NoError(t, db.lc.runCompactDef(-1, 0, cdef))
+		_, err = os.Stat(held.Filename())
+		require.NoError(t, err)
+		// The manifest only tracks the reserved ID and the held table.
+		require.Len(t, db.manifest.building, 1)
+		require.Len(t, db.manifest.retired, 1)
+
+		orphan := table.NewFilename(9999, db.opt.Dir)
+		require.NoError(t, os.WriteFile(orphan, []byte("orphan"), 0600))
+		rewrite := filepath.Join(db.opt.Dir, manifestRewriteFilename)
+		require.NoError(t, os.WriteFile(rewrite, []byte("rewrite"), 0600))
+
+		paths, err = db.OrphanedFiles()
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{orphan, rewrite}, paths)
+
+		require.NoError(t, held.DecrRef())
+		_, err = os.Stat(held.Filename())
+		require.True(t, os.IsNotExist(err))
+		require.Empty(t, db.manifest.retired)
+
+		removed, bytes, err := db.CleanupOrphans()
+		require.NoError(t, err)
+		require.Equal(t, 2, removed)
+		require.Equal(t, int64(len("orphan")+len("rewrite")), bytes)
+		_, err = os.Stat(orphan)
+		require.True(t, os.IsNotExist(err))
+		_, err = os.Stat(building)
+		require.NoError(t, err)
+
+		paths, err = db.OrphanedFiles()
+		require.NoError(t, err)
+		require.Empty(t, paths)
+		require.NoError(t, os.Remove(building))
+		_, err = os.Stat(db.lc.levels[1].tables[0].Filename())
+		require.NoError(t, err)
+	})
+}
@@ -16,6 +16,8 @@
 
 package badger
 
+import "github.com/0xEggTart/badger/pb"
+
 // OpenManaged returns a new DB, which allows more control over setting
 // transaction timestamps, aka managed mode.
 //
@@ -81,9 +83,66 @@ func (txn *Txn) CommitAt(commitTs uint64, callback func(error)) error {
 // SetDiscardTs sets a timestamp at or below which, any invalid or deleted
 // versions can be discarded from the LSM tree, and thence from the value log to
 // reclaim disk space. Can only be used with managed transactions.
+//
+// The discard timestamp can be moved as often as needed, e.g. to follow the read timestamp of the
+// slowest reader, and each compaction honors the latest value set when it starts. Versions above
+// ts are always kept. At or below it, compactions keep the latest NumVersionsToKeep versions of
+// each key, unless they're deleted, expired or followed by a version set with
+// Entry.WithDiscard. Moving ts back doesn't bring back the versions already dropped, so readers
+// must not read below the highest ts ever set. Managed transactions reading below it may also miss
+// conflicts on commit, since the record of the transactions committed at or below it was already
+// cleaned up. See GetDiscardTs.
 func (db *DB) SetDiscardTs(ts uint64) {
 	if !db.opt.managedTxns {
 		panic("Cannot use SetDiscardTs with managedDB=false.")
 	}
 	db.orc.setDiscardTs(ts)
 }
+
+// GetDiscardTs returns the timestamp at or below which compactions may discard versions. With
+// managed transactions, it's the value last set by SetDiscardTs, or raised by DeleteBelowVersion,
+// and zero until then, so that nothing is discarded. Otherwise, Badger tracks it by itself as the
+// read timestamp of the oldest running transaction, and it moves as transactions finish.
+func (db *DB) GetDiscardTs() uint64 {
+	return db.orc.discardAtOrBelow()
+}
+
+// DeleteBelowVersion permanently deletes all the versions with a commit timestamp at or below ts.
+// Versions above ts are not affected. Beware that this includes the latest version of a key if it
+// isn't newer than ts: such a key is deleted altogether, and reads at any timestamp stop finding
+// it. The discard timestamp is raised to ts too, as reads at or below ts would no longer see
+// consistent data. Can only be used with managed transactions.
+//
+// The versions are hidden from reads and iterators as soon as DeleteBelowVersion returns, and ts
+// is recorded in the manifest, so they stay hidden after a restart. The space is reclaimed by
+// compactions: DeleteBelowVersion starts a background pass which rewrites all the tables, and
+// returns without waiting for it to finish. If compactions are stopped before the pass is done,
+// e.g. by DropPrefix or Close, the remaining versions are deleted as regular compactions get to
+// them.
+func (db *DB) DeleteBelowVersion(ts uint64) error {
+	if !db.opt.managedTxns {
+		panic("Cannot use DeleteBelowVersion with managedDB=false.")
+	}
+	if db.opt.ReadOnly {
+		panic("Attempting to delete data in read-only mode.")
+	}
+	if db.IsClosed() {
+		return ErrDBClosed
+	}
+	// The delete ts is recorded in the manifest, so that it's still honoured after a restart.
+	if ts > db.orc.deleteAtOrBelow() {
+		if err := db.manifest.addChanges([]*pb.ManifestChange{
+			newDeleteBelowVersionChange(ts)}); err != nil {
+			return err
+		}
+	}
+	db.orc.setDeleteTs(ts)
+
+	lc := db.closers.compactors
+	lc.AddRunning(1)
+	go func() {
+		defer lc.Done()
+		db.lc.rewriteAllTables(lc)
+	}()
+	return nil
+}
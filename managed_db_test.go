@@ -772,6 +772,45 @@ func TestWriteBatchDuplicate(t *testing.T) {
 	})
 }
 
+func TestRejectDuplicateVersions(t *testing.T) {
+	opt := getTestOptions("")
+	opt.managedTxns = true
+	opt.RejectDuplicateVersions = true
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		write := func(k string, v string, ts uint64) error {
+			txn := db.NewTransactionAt(math.MaxUint64, true)
+			defer txn.Discard()
+			require.NoError(t, txn.SetEntry(NewEntry([]byte(k), []byte(v))))
+			return txn.CommitAt(ts, nil)
+		}
+		require.NoError(t, write("a", "a1", 1))
+		require.NoError(t, write("a", "a2", 2))
+		require.NoError(t, write("b", "b1", 1))
+
+		err := write("a", "a1-again", 1)
+		require.ErrorIs(t, err, ErrDuplicateVersion)
+		var dupErr *DuplicateVersionError
+		require.ErrorAs(t, err, &dupErr)
+		require.Equal(t, []byte("a"), dupErr.Key)
+		require.Equal(t, uint64(1), dupErr.Version)
+
+		// Versions the key doesn't have yet, even below its latest one, can still be written.
+		require.NoError(t, write("a", "a4", 4))
+		require.NoError(t, write("a", "a3", 3))
+
+		// Entries set at an explicit version are checked against that version.
+		wb := db.NewManagedWriteBatch()
+		require.NoError(t, wb.SetEntryAt(NewEntry([]byte("b"), []byte("b1-again")), 1))
+		require.ErrorIs(t, wb.Flush(), ErrDuplicateVersion)
+
+		txn := db.NewTransactionAt(1, false)
+		defer txn.Discard()
+		item, err := txn.Get([]byte("a"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("a1"), getItemValue(t, item))
+	})
+}
+
 func TestZeroDiscardStats(t *testing.T) {
 	N := uint64(10000)
 	populate := func(t *testing.T, db *DB) {
@@ -832,3 +871,260 @@ func TestZeroDiscardStats(t *testing.T) {
 		})
 	})
 }
+
+func TestDeleteBelowVersion(t *testing.T) {
+	dir, err := os.MkdirTemp("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	opts := getTestOptions(dir)
+	opts.managedTxns = true
+
+	write := func(db *DB, from, to uint64) {
+		for version := from; version <= to; version++ {
+			txn := db.NewTransactionAt(version, true)
+			for i := 0; i < 100; i++ {
+				require.NoError(t, txn.SetEntry(NewEntry([]byte(key("key", i)), val(true))))
+			}
+			if version == 2 {
+				require.NoError(t, txn.SetEntry(NewEntry([]byte("old"), val(true))))
+			}
+			require.NoError(t, txn.CommitAt(version, nil))
+		}
+	}
+
+	// Versions 1 to 3 go to the last level, versions 4 and 5 stay in level 0.
+	db, err := Open(opts)
+	require.NoError(t, err)
+	write(db, 1, 3)
+	require.NoError(t, db.Close())
+	db, err = Open(opts)
+	require.NoError(t, err)
+	require.NoError(t, db.Flatten(1))
+	write(db, 4, 5)
+	require.NoError(t, db.Close())
+	db, err = Open(opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+
+	require.NoError(t, db.DeleteBelowVersion(3))
+
+	// If stored is set, the versions still stored are returned, including the ones hidden from
+	// reads until compactions drop them.
+	versions := func(stored bool) map[string][]uint64 {
+		txn := db.NewTransactionAt(math.MaxUint64, false)
+		defer txn.Discard()
+		iopt := DefaultIteratorOptions
+		iopt.AllVersions = true
+		it := txn.NewIterator(iopt)
+		defer it.Close()
+		if stored {
+			it.deleteTs = 0
+		}
+		res := make(map[string][]uint64)
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			if item.IsDeletedOrExpired() {
+				continue
+			}
+			res[string(item.Key())] = append(res[string(item.Key())], item.Version())
+		}
+		return res
+	}
+	// Reads stop seeing the versions right away. The only version of "old" is deleted, so the key
+	// is gone altogether.
+	check := func() {
+		res := versions(false)
+		require.Len(t, res, 100)
+		for k, vs := range res {
+			require.Equal(t, []uint64{5, 4}, vs, "key %s", k)
+		}
+		txn := db.NewTransactionAt(10, false)
+		defer txn.Discard()
+		_, err := txn.Get([]byte("old"))
+		require.Equal(t, ErrKeyNotFound, err)
+	}
+	check()
+
+	require.Eventually(t, func() bool {
+		res := versions(true)
+		if len(res) != 100 {
+			return false
+		}
+		for _, vs := range res {
+			if len(vs) != 2 {
+				return false
+			}
+		}
+		return true
+	}, 10*time.Second, 50*time.Millisecond)
+	for k, vs := range versions(true) {
+		require.Equal(t, []uint64{5, 4}, vs, "key %s", k)
+	}
+
+	// The delete ts is kept in the manifest.
+	require.NoError(t, db.Close())
+	db, err = Open(opts)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), db.orc.deleteAtOrBelow())
+	require.Equal(t, uint64(3), db.GetDiscardTs())
+	check()
+}
+
+func TestDeleteBelowVersionNonManaged(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		require.Panics(t, func() { _ = db.DeleteBelowVersion(1) })
+	})
+}
+
+func TestGetDiscardTs(t *testing.T) {
+	t.Run("managed", func(t *testing.T) {
+		opt := getTestOptions("")
+		opt.managedTxns = true
+		runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+			require.Zero(t, db.GetDiscardTs())
+			db.SetDiscardTs(10)
+			require.Equal(t, uint64(10), db.GetDiscardTs())
+			// The discard ts may move back, as consumers come and go.
+			db.SetDiscardTs(5)
+			require.Equal(t, uint64(5), db.GetDiscardTs())
+			require.NoError(t, db.DeleteBelowVersion(20))
+			require.Equal(t, uint64(20), db.GetDiscardTs())
+		})
+	})
+	t.Run("non-managed", func(t *testing.T) {
+		runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+			txnSet(t, db, []byte("first"), []byte("val"), 0)
+			txn := db.NewTransaction(false)
+			for i := 0; i < 3; i++ {
+				txnSet(t, db, []byte(key("key", i)), []byte("val"), 0)
+			}
+			// The running transaction holds the discard ts back.
+			require.Less(t, db.GetDiscardTs(), txn.ReadTs())
+			txn.Discard()
+			txnSet(t, db, []byte("last"), []byte("val"), 0)
+			require.Eventually(t, func() bool {
+				return db.GetDiscardTs() >= txn.ReadTs()
+			}, 5*time.Second, 10*time.Millisecond)
+		})
+	})
+}
+
+func TestDropPrefixCompactAfter(t *testing.T) {
+	opts := getTestOptions("")
+	opts.ValueLogFileSize = 1 << 20
+	opts.ValueThreshold = 1 << 10
+	runBadgerTest(t, &opts, func(t *testing.T, db *DB) {
+		v := make([]byte, 4<<10)
+		for _, prefix := range []string{"drop", "keep"} {
+			writer := db.NewWriteBatch()
+			for i := 0; i < 1000; i++ {
+				require.NoError(t, writer.Set([]byte(key(prefix, i)), v))
+			}
+			require.NoError(t, writer.Flush())
+		}
+		vlogSize := func() int64 {
+			db.vlog.filesLock.RLock()
+			defer db.vlog.filesLock.RUnlock()
+			var size int64
+			for _, lf := range db.vlog.filesMap {
+				size += int64(lf.size.Load())
+			}
+			return size
+		}
+		before := vlogSize()
+		numFiles := len(db.vlog.sortedFids())
+
+		require.NoError(t, db.DropPrefixWithOptions(DropPrefixOptions{CompactAfter: true},
+			[]byte("drop")))
+		require.Equal(t, 1000, numKeys(db))
+		require.Less(t, len(db.vlog.sortedFids()), numFiles)
+		require.Less(t, vlogSize(), before)
+
+		err := db.DropPrefixWithOptions(DropPrefixOptions{DiscardRatio: 1}, []byte("keep"))
+		require.Equal(t, ErrInvalidRequest, err)
+	})
+}
+
+func TestDropPrefixIteratorSnapshot(t *testing.T) {
+	opts := getTestOptions("")
+	opts.ValueLogFileSize = 1 << 20
+	opts.ValueThreshold = 1 << 10
+	runBadgerTest(t, &opts, func(t *testing.T, db *DB) {
+		populate := func(prefix string) {
+			writer := db.NewWriteBatch()
+			for i := 0; i < 1000; i++ {
+				require.NoError(t, writer.Set([]byte(key(prefix, i)), make([]byte, 4<<10)))
+			}
+			require.NoError(t, writer.Flush())
+		}
+		count := func(txn *Txn, prefix string, readValues bool) int {
+			opt := DefaultIteratorOptions
+			opt.Prefix = []byte(prefix)
+			opt.PrefetchValues = false
+			it := txn.NewIterator(opt)
+			defer it.Close()
+			n := 0
+			for it.Rewind(); it.Valid(); it.Next() {
+				if readValues {
+					v, err := it.Item().ValueCopy(nil)
+					require.NoError(t, err)
+					require.Len(t, v, 4<<10)
+				}
+				n++
+			}
+			return n
+		}
+		populate("drop")
+		populate("keep")
+
+		// An iterator created before the drop keeps seeing the keys and their values, even once
+		// the value log files holding them are rewritten.
+		txn := db.NewTransaction(false)
+		opt := DefaultIteratorOptions
+		opt.Prefix = []byte("drop")
+		opt.PrefetchValues = false
+		it := txn.NewIterator(opt)
+		require.NoError(t, db.DropPrefixWithOptions(DropPrefixOptions{CompactAfter: true},
+			[]byte("drop")))
+		n := 0
+		for it.Rewind(); it.Valid(); it.Next() {
+			v, err := it.Item().ValueCopy(nil)
+			require.NoError(t, err)
+			require.Len(t, v, 4<<10)
+			n++
+		}
+		require.Equal(t, 1000, n)
+		it.Close()
+		require.Equal(t, 0, count(txn, "drop", false))
+		txn.Discard()
+
+		// Iterators created while a drop runs see all of the keys or none of them.
+		populate("race")
+		done := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				require.NoError(t, db.View(func(txn *Txn) error {
+					require.Contains(t, []int{0, 1000}, count(txn, "race", false))
+					return nil
+				}))
+			}
+		}()
+		require.NoError(t, db.DropPrefix([]byte("race")))
+		close(done)
+		wg.Wait()
+		require.NoError(t, db.View(func(txn *Txn) error {
+			require.Equal(t, 0, count(txn, "race", false))
+			require.Equal(t, 1000, count(txn, "keep", true))
+			return nil
+		}))
+	})
+}
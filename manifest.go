@@ -34,6 +34,7 @@ import (
 
 	"github.com/0xEggTart/badger/options"
 	"github.com/0xEggTart/badger/pb"
+	"github.com/0xEggTart/badger/table"
 	"github.com/0xEggTart/badger/y"
 )
 
@@ -53,6 +54,12 @@ type Manifest struct {
 	// whether it'd be useful to rewrite the manifest.
 	Creations int
 	Deletions int
+
+	// Set if the DB was opened with Options.WideUserMeta, so it may hold 16 bit user metas.
+	WideUserMeta bool
+
+	// All versions at or below DeleteTs are deleted. See DB.DeleteBelowVersion.
+	DeleteTs uint64
 }
 
 func createManifest() Manifest {
@@ -97,6 +104,20 @@ type manifestFile struct {
 
 	// Used to indicate if badger was opened in InMemory mode.
 	inMemory bool
+
+	// Set if some changes haven't been synced yet. See Options.SyncManifest. Guarded by appendLock.
+	unsynced bool
+	// Tables deleted by the changes not synced yet. Their files are kept, by holding a reference,
+	// until the changes are synced, so that the manifest on disk never refers to missing tables.
+	// Guarded by appendLock.
+	pendingDecr []*table.Table
+
+	// IDs of the tables being built, whose files exist before the changes creating them are
+	// added, and of the tables deleted by the changes, whose files stay until they are released.
+	// These aren't orphaned files, see DB.OrphanedFiles. The IDs are forgotten once the tables are
+	// added, or their files deleted. Guarded by appendLock.
+	building map[uint64]struct{}
+	retired  map[uint64]struct{}
 }
 
 const (
@@ -114,6 +135,9 @@ func (m *Manifest) asChanges() []*pb.ManifestChange {
 	for id, tm := range m.Tables {
 		changes = append(changes, newCreateChange(id, int(tm.Level), tm.KeyID, tm.Compression))
 	}
+	if m.DeleteTs > 0 {
+		changes = append(changes, newDeleteBelowVersionChange(m.DeleteTs))
+	}
 	return changes
 }
 
@@ -121,6 +145,7 @@ func (m *Manifest) clone() Manifest {
 	changeSet := pb.ManifestChangeSet{Changes: m.asChanges()}
 	ret := createManifest()
 	y.Check(applyChangeSet(&ret, &changeSet))
+	ret.WideUserMeta = m.WideUserMeta
 	return ret
 }
 
@@ -131,8 +156,24 @@ func openOrCreateManifestFile(opt Options) (
 	if opt.InMemory {
 		return &manifestFile{inMemory: true}, Manifest{}, nil
 	}
-	return helpOpenOrCreateManifestFile(opt.Dir, opt.ReadOnly, opt.ExternalMagicVersion,
+	mf, m, err := helpOpenOrCreateManifestFile(opt.Dir, opt.ReadOnly, opt.ExternalMagicVersion,
 		manifestDeletionsRewriteThreshold)
+	if err != nil && opt.ManifestRecovery && !opt.ReadOnly {
+		// Only recover from a damaged manifest, not from one written by an incompatible version.
+		var incompatible *incompatibleManifestError
+		_, statErr := os.Stat(filepath.Join(opt.Dir, ManifestFilename))
+		if statErr == nil && !errors.As(err, &incompatible) {
+			mf, m, err = recoverManifest(opt, err)
+		}
+	}
+	if err == nil && opt.WideUserMeta && !opt.ReadOnly && !m.WideUserMeta {
+		if err = mf.enableWideUserMeta(); err != nil {
+			_ = mf.close()
+			return nil, Manifest{}, err
+		}
+		m.WideUserMeta = true
+	}
+	return mf, m, err
 }
 
 func helpOpenOrCreateManifestFile(dir string, readOnly bool, extMagic uint16,
@@ -207,6 +248,15 @@ func (mf *manifestFile) close() error {
 // this depends on the filesystem -- some might append garbage data if a system crash happens at
 // the wrong time.)
 func (mf *manifestFile) addChanges(changesParam []*pb.ManifestChange) error {
+	if err := mf.appendChanges(changesParam); err != nil {
+		return err
+	}
+	return mf.sync()
+}
+
+// appendChanges is like addChanges, but doesn't sync the file. The changes are only durable once
+// sync is called.
+func (mf *manifestFile) appendChanges(changesParam []*pb.ManifestChange) error {
 	if mf.inMemory {
 		return nil
 	}
@@ -222,6 +272,17 @@ func (mf *manifestFile) addChanges(changesParam []*pb.ManifestChange) error {
 	if err := applyChangeSet(&mf.manifest, &changes); err != nil {
 		return err
 	}
+	for _, change := range changes.Changes {
+		switch change.Op {
+		case pb.ManifestChange_CREATE:
+			delete(mf.building, change.Id)
+		case pb.ManifestChange_DELETE:
+			if mf.retired == nil {
+				mf.retired = make(map[uint64]struct{})
+			}
+			mf.retired[change.Id] = struct{}{}
+		}
+	}
 	// Rewrite manifest if it'd shrink by 1/10 and it's big enough to care
 	if mf.manifest.Deletions > mf.deletionsRewriteThreshold &&
 		mf.manifest.Deletions > manifestDeletionsRatio*(mf.manifest.Creations-mf.manifest.Deletions) {
@@ -237,8 +298,68 @@ func (mf *manifestFile) addChanges(changesParam []*pb.ManifestChange) error {
 			return err
 		}
 	}
+	mf.unsynced = true
+	return nil
+}
 
-	return syncFunc(mf.fp)
+// sync syncs the changes appended since the last sync, if any, and then releases the tables they
+// deleted.
+func (mf *manifestFile) sync() error {
+	if mf.inMemory {
+		return nil
+	}
+	mf.appendLock.Lock()
+	if !mf.unsynced {
+		mf.appendLock.Unlock()
+		return nil
+	}
+	if err := syncFunc(mf.fp); err != nil {
+		mf.appendLock.Unlock()
+		return err
+	}
+	mf.unsynced = false
+	tables := mf.pendingDecr
+	mf.pendingDecr = nil
+	mf.appendLock.Unlock()
+	return decrRefs(tables)
+}
+
+// trackBuilding records that the table with the given ID is about to be built.
+func (mf *manifestFile) trackBuilding(id uint64) {
+	if mf.inMemory {
+		return
+	}
+	mf.appendLock.Lock()
+	defer mf.appendLock.Unlock()
+	if mf.building == nil {
+		mf.building = make(map[uint64]struct{})
+	}
+	mf.building[id] = struct{}{}
+}
+
+// forget stops tracking the table with the given ID as being built or deleted, once its file is
+// gone.
+func (mf *manifestFile) forget(id uint64) {
+	if mf.inMemory {
+		return
+	}
+	mf.appendLock.Lock()
+	defer mf.appendLock.Unlock()
+	delete(mf.building, id)
+	delete(mf.retired, id)
+}
+
+// releaseAfterSync decrements the references of tables, which changes passed to appendChanges
+// deleted, once these changes are synced.
+func (mf *manifestFile) releaseAfterSync(tables []*table.Table) error {
+	mf.appendLock.Lock()
+	if mf.unsynced {
+		mf.pendingDecr = append(mf.pendingDecr, tables...)
+		mf.appendLock.Unlock()
+		return nil
+	}
+	mf.appendLock.Unlock()
+	return decrRefs(tables)
 }
 
 // this function is saved here to allow injection of fake filesystem latency at test time.
@@ -250,6 +371,10 @@ var magicText = [4]byte{'B', 'd', 'g', 'r'}
 // The magic version number. It is allocated 2 bytes, so it's value must be <= math.MaxUint16
 const badgerMagicVersion = 8
 
+// The magic version number of the DBs opened with Options.WideUserMeta. Their entries may have
+// 16 bit user metas, which versions of Badger supporting only badgerMagicVersion can't read.
+const badgerMagicVersionWideUserMeta = 9
+
 func helpRewrite(dir string, m *Manifest, extMagic uint16) (*os.File, int, error) {
 	rewritePath := filepath.Join(dir, manifestRewriteFilename)
 	// We explicitly sync.
@@ -263,11 +388,15 @@ func helpRewrite(dir string, m *Manifest, extMagic uint16) (*os.File, int, error
 	// | magicText (4 bytes) | externalMagic (2 bytes) | badgerMagic (2 bytes) |
 	// +---------------------+-------------------------+-----------------------+
 
-	y.AssertTrue(badgerMagicVersion <= math.MaxUint16)
+	y.AssertTrue(badgerMagicVersionWideUserMeta <= math.MaxUint16)
+	version := uint16(badgerMagicVersion)
+	if m.WideUserMeta {
+		version = badgerMagicVersionWideUserMeta
+	}
 	buf := make([]byte, 8)
 	copy(buf[0:4], magicText[:])
 	binary.BigEndian.PutUint16(buf[4:6], extMagic)
-	binary.BigEndian.PutUint16(buf[6:8], badgerMagicVersion)
+	binary.BigEndian.PutUint16(buf[6:8], version)
 
 	netCreations := len(m.Tables)
 	changes := m.asChanges()
@@ -333,6 +462,18 @@ func (mf *manifestFile) rewrite() error {
 	return nil
 }
 
+// enableWideUserMeta rewrites the manifest with the magic version of the DBs which may hold 16 bit
+// user metas. See Options.WideUserMeta.
+func (mf *manifestFile) enableWideUserMeta() error {
+	if mf.inMemory {
+		return nil
+	}
+	mf.appendLock.Lock()
+	defer mf.appendLock.Unlock()
+	mf.manifest.WideUserMeta = true
+	return mf.rewrite()
+}
+
 type countingReader struct {
 	wrapped *bufio.Reader
 	count   int64
@@ -357,6 +498,12 @@ var (
 	errBadChecksum = stderrors.New("manifest has checksum mismatch")
 )
 
+// incompatibleManifestError is returned by ReplayManifestFile for a manifest which is valid, but
+// was written by an incompatible version of Badger or of the application.
+type incompatibleManifestError struct {
+	error
+}
+
 // ReplayManifestFile reads the manifest file and constructs two manifest objects.  (We need one
 // immutable copy and one mutable copy of the manifest.  Easiest way is to construct two of them.)
 // Also, returns the last offset after a completely read manifest entry -- the file must be
@@ -376,18 +523,18 @@ func ReplayManifestFile(fp *os.File, extMagic uint16) (Manifest, int64, error) {
 	extVersion := y.BytesToU16(magicBuf[4:6])
 	version := y.BytesToU16(magicBuf[6:8])
 
-	if version != badgerMagicVersion {
-		return Manifest{}, 0,
+	if version != badgerMagicVersion && version != badgerMagicVersionWideUserMeta {
+		return Manifest{}, 0, &incompatibleManifestError{
 			//nolint:lll
-			fmt.Errorf("manifest has unsupported version: %d (we support %d).\n"+
+			fmt.Errorf("manifest has unsupported version: %d (we support %d and %d).\n"+
 				"Please see https://dgraph.io/docs/badger/faq/#i-see-manifest-has-unsupported-version-x-we-support-y-error"+
 				" on how to fix this.",
-				version, badgerMagicVersion)
+				version, badgerMagicVersion, badgerMagicVersionWideUserMeta)}
 	}
 	if extVersion != extMagic {
-		return Manifest{}, 0,
+		return Manifest{}, 0, &incompatibleManifestError{
 			fmt.Errorf("Cannot open DB because the external magic number doesn't match. "+
-				"Expected: %d, version present in manifest: %d\n", extMagic, extVersion)
+				"Expected: %d, version present in manifest: %d\n", extMagic, extVersion)}
 	}
 
 	stat, err := fp.Stat()
@@ -396,6 +543,7 @@ func ReplayManifestFile(fp *os.File, extMagic uint16) (Manifest, int64, error) {
 	}
 
 	build := createManifest()
+	build.WideUserMeta = version == badgerMagicVersionWideUserMeta
 	var offset int64
 	for {
 		offset = r.count
@@ -462,6 +610,8 @@ func applyManifestChange(build *Manifest, tc *pb.ManifestChange) error {
 		delete(build.Levels[tm.Level].Tables, tc.Id)
 		delete(build.Tables, tc.Id)
 		build.Deletions++
+	case pb.ManifestChange_DELETE_BELOW_VERSION:
+		build.DeleteTs = max(build.DeleteTs, tc.Id)
 	default:
 		return fmt.Errorf("MANIFEST file has invalid manifestChange op")
 	}
@@ -498,3 +648,10 @@ func newDeleteChange(id uint64) *pb.ManifestChange {
 		Op: pb.ManifestChange_DELETE,
 	}
 }
+
+func newDeleteBelowVersionChange(ts uint64) *pb.ManifestChange {
+	return &pb.ManifestChange{
+		Id: ts,
+		Op: pb.ManifestChange_DELETE_BELOW_VERSION,
+	}
+}
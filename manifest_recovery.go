@@ -0,0 +1,191 @@
+/*
+ * Copyright 2024 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/0xEggTart/badger/options"
+	"github.com/0xEggTart/badger/table"
+	"github.com/0xEggTart/badger/y"
+	"github.com/dgraph-io/ristretto/v2/z"
+)
+
+const corruptedSuffix = ".corrupted"
+
+// recoveredTable holds what recoverManifest found out about a table file.
+type recoveredTable struct {
+	id          uint64
+	level       int
+	maxVersion  uint64
+	smallest    []byte
+	biggest     []byte
+	compression options.CompressionType
+}
+
+func (t *recoveredTable) overlaps(o *recoveredTable) bool {
+	return y.CompareKeys(t.smallest, o.biggest) <= 0 && y.CompareKeys(o.smallest, t.biggest) <= 0
+}
+
+// recoverManifest rebuilds the manifest from the table files in opt.Dir, after it failed to be
+// replayed with cause. See Options.WithManifestRecovery.
+func recoverManifest(opt Options, cause error) (*manifestFile, Manifest, error) {
+	if len(opt.EncryptionKey) > 0 {
+		return nil, Manifest{}, errors.Wrapf(cause,
+			"Manifest recovery is not supported for encrypted DBs")
+	}
+	opt.Warningf("Manifest is corrupted: %v. Rebuilding it from the table files.", cause)
+
+	path := filepath.Join(opt.Dir, ManifestFilename)
+	if err := os.Rename(path, path+corruptedSuffix); err != nil {
+		return nil, Manifest{}, y.Wrapf(err, "while moving corrupted manifest")
+	}
+	opt.Warningf("Corrupted manifest moved to %s", path+corruptedSuffix)
+
+	var tables []*recoveredTable
+	for id := range getIDMap(opt.Dir) {
+		fname := table.NewFilename(id, opt.Dir)
+		t, err := inspectTable(opt, fname)
+		if err != nil {
+			opt.Warningf("Unable to read table %s: %v. Renaming it to %s", fname, err,
+				fname+corruptedSuffix)
+			if err := os.Rename(fname, fname+corruptedSuffix); err != nil {
+				return nil, Manifest{}, y.Wrapf(err, "while moving unreadable table")
+			}
+			continue
+		}
+		tables = append(tables, t)
+	}
+	if err := assignLevels(tables, opt); err != nil {
+		return nil, Manifest{}, errors.Wrapf(cause, "Manifest recovery failed: %v", err)
+	}
+
+	m := createManifest()
+	for _, t := range tables {
+		change := newCreateChange(t.id, t.level, 0, t.compression)
+		y.Check(applyManifestChange(&m, change))
+		opt.Warningf("Recovered table %d at level %d: keys %q to %q, max version %d, "+
+			"compression %d", t.id, t.level, y.ParseKey(t.smallest), y.ParseKey(t.biggest),
+			t.maxVersion, t.compression)
+	}
+	fp, netCreations, err := helpRewrite(opt.Dir, &m, opt.ExternalMagicVersion)
+	if err != nil {
+		return nil, Manifest{}, err
+	}
+	y.AssertTrue(netCreations == len(tables))
+	opt.Warningf("Manifest rebuilt with %d tables", len(tables))
+	mf := &manifestFile{
+		fp:                        fp,
+		directory:                 opt.Dir,
+		externalMagic:             opt.ExternalMagicVersion,
+		manifest:                  m.clone(),
+		deletionsRewriteThreshold: manifestDeletionsRewriteThreshold,
+	}
+	return mf, m, nil
+}
+
+// inspectTable reads the metadata of the table at fname, and finds its compression by verifying
+// its blocks.
+func inspectTable(opt Options, fname string) (rt *recoveredTable, err error) {
+	candidates := []options.CompressionType{opt.Compression}
+	for _, c := range []options.CompressionType{options.None, options.Snappy, options.ZSTD} {
+		if c != opt.Compression {
+			candidates = append(candidates, c)
+		}
+	}
+	for _, c := range candidates {
+		if rt, err = inspectTableWith(opt, fname, c); err == nil {
+			return rt, nil
+		}
+	}
+	return nil, err
+}
+
+func inspectTableWith(opt Options, fname string,
+	c options.CompressionType) (rt *recoveredTable, err error) {
+	mf, err := z.OpenMmapFile(fname, opt.getFileFlags(), 0)
+	if err != nil {
+		return nil, err
+	}
+	t, err := table.OpenTable(mf, table.Options{
+		BlockSize:          opt.BlockSize,
+		BloomFalsePositive: opt.BloomFalsePositive,
+		ChkMode:            options.NoVerification,
+		Compression:        c,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Don't use DecrRef, that would delete the file.
+	defer func() { _ = t.MmapFile.Close(-1) }()
+	// Decoding blocks with the wrong compression might hit garbage.
+	defer func() {
+		if r := recover(); r != nil {
+			rt, err = nil, fmt.Errorf("%v", r)
+		}
+	}()
+	if err := t.VerifyChecksum(); err != nil {
+		return nil, err
+	}
+	return &recoveredTable{
+		id:          t.ID(),
+		maxVersion:  t.MaxVersion(),
+		smallest:    y.Copy(t.Smallest()),
+		biggest:     y.Copy(t.Biggest()),
+		compression: c,
+	}, nil
+}
+
+// assignLevels infers the levels of the tables. Newer tables, i.e. those with a bigger max
+// version, must be above the older ones they overlap with. The newest tables go to level 0, as
+// long as their IDs are in the same order as their versions, which is how level 0 is read. The
+// others go to the highest level below all the newer tables they overlap with.
+func assignLevels(tables []*recoveredTable, opt Options) error {
+	sort.Slice(tables, func(i, j int) bool {
+		return tables[i].maxVersion > tables[j].maxVersion
+	})
+	var placed []*recoveredTable
+	inL0 := true
+	for i, t := range tables {
+		if inL0 && (i == opt.NumLevelZeroTables || (i > 0 && t.id > tables[i-1].id)) {
+			inL0 = false
+		}
+		if inL0 {
+			t.level = 0
+			placed = append(placed, t)
+			continue
+		}
+		t.level = 1
+		for _, p := range placed {
+			if p.overlaps(t) && p.level+1 > t.level {
+				t.level = p.level + 1
+			}
+		}
+		if t.level >= opt.MaxLevels {
+			return errors.Errorf("too many overlapping tables to fit table %d in %d levels",
+				t.id, opt.MaxLevels)
+		}
+		placed = append(placed, t)
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].id < tables[j].id })
+	return nil
+}
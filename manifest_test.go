@@ -287,3 +287,102 @@ func TestConcurrentManifestCompaction(t *testing.T) {
 
 	require.NoError(t, mf.close())
 }
+
+func TestManifestRecovery(t *testing.T) {
+	dir, err := os.MkdirTemp("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	opt := getTestOptions(dir)
+	opt.MemTableSize = 1 << 15
+	opt.ValueThreshold = 1 << 10
+	opt.BaseTableSize = 1 << 15
+	opt.BaseLevelSize = 1 << 16
+	opt.NumLevelZeroTables = 2
+	opt.NumCompactors = 0
+
+	const n = 2000
+	write := func(db *DB, version int) {
+		wb := db.NewWriteBatch()
+		for i := 0; i < n; i++ {
+			require.NoError(t, wb.Set([]byte(key("key", i)), []byte(fmt.Sprintf("val%d-%d", i, version))))
+		}
+		require.NoError(t, wb.Flush())
+	}
+	db, err := Open(opt)
+	require.NoError(t, err)
+	write(db, 1)
+	require.NoError(t, db.Flatten(1))
+	write(db, 2)
+	require.NoError(t, db.Close())
+
+	// Corrupt the checksum of the manifest.
+	fp, err := os.OpenFile(filepath.Join(dir, ManifestFilename), os.O_RDWR, 0)
+	require.NoError(t, err)
+	_, err = fp.WriteAt([]byte{'X'}, 15)
+	require.NoError(t, err)
+	require.NoError(t, fp.Close())
+
+	_, err = Open(opt)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "checksum mismatch")
+
+	db, err = Open(opt.WithManifestRecovery(true))
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dir, ManifestFilename+".corrupted"))
+	require.NoError(t, err)
+	check := func(db *DB) {
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for i := 0; i < n; i++ {
+				item, err := txn.Get([]byte(key("key", i)))
+				require.NoError(t, err)
+				require.Equal(t, fmt.Sprintf("val%d-2", i), string(getItemValue(t, item)))
+			}
+			return nil
+		}))
+	}
+	check(db)
+	require.NoError(t, db.Close())
+
+	// The rebuilt manifest is used from now on.
+	db, err = Open(opt)
+	require.NoError(t, err)
+	check(db)
+	require.NoError(t, db.Close())
+}
+
+func TestSyncManifestBatched(t *testing.T) {
+	opt := DefaultOptions("").WithNumCompactors(0).WithSyncManifest(false)
+	opt.managedTxns = true
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		// Stop the periodic syncs, so that the test controls when the changes get synced.
+		db.closers.manifest.SignalAndWait()
+
+		createAndOpen(db, []keyValVersion{{"foo", "bar", 2, 0}}, 0)
+		createAndOpen(db, []keyValVersion{{"foo", "bar", 1, 0}}, 1)
+		old := append(append([]*table.Table{}, db.lc.levels[0].tables...), db.lc.levels[1].tables...)
+		cdef := compactDef{
+			thisLevel: db.lc.levels[0],
+			nextLevel: db.lc.levels[1],
+			top:       db.lc.levels[0].tables,
+			bot:       db.lc.levels[1].tables,
+			t:         db.lc.levelTargets(),
+		}
+		cdef.t.baseLevel = 1
+		require.NoError(t, db.lc.runCompactDef(-1, 0, cdef))
+		getAllAndCheck(t, db, []keyValVersion{{"foo", "bar", 2, 0}, {"foo", "bar", 1, 0}})
+
+		// The compacted tables are kept until the manifest changes are synced.
+		require.True(t, db.manifest.unsynced)
+		for _, tab := range old {
+			_, err := os.Stat(tab.Filename())
+			require.NoError(t, err)
+		}
+		require.NoError(t, db.manifest.sync())
+		require.False(t, db.manifest.unsynced)
+		for _, tab := range old {
+			_, err := os.Stat(tab.Filename())
+			require.True(t, os.IsNotExist(err))
+		}
+	})
+}
@@ -96,7 +96,7 @@ func (db *DB) openMemTables(opt Options) error {
 			continue
 		}
 		// These should no longer be written to. So, make them part of the imm.
-		db.imm = append(db.imm, mt)
+		db.setImm(append(db.imm, mt))
 	}
 	if len(fids) != 0 {
 		db.nextMemFid = fids[len(fids)-1]
@@ -109,7 +109,11 @@ const memFileExt string = ".mem"
 
 func (db *DB) openMemTable(fid, flags int) (*memTable, error) {
 	filepath := db.mtFilePath(fid)
-	s := skl.NewSkiplist(arenaSize(db.opt))
+	s := skl.NewSkiplistWithHeight(arenaSize(db.opt), db.opt.SkiplistMaxHeight,
+		db.opt.SkiplistProbability)
+	if db.rand != nil {
+		s.Rand = db.randUint32
+	}
 	mt := &memTable{
 		sl:  s,
 		opt: db.opt,
@@ -187,6 +191,8 @@ func (mt *memTable) Put(key []byte, value y.ValueStruct) error {
 		UserMeta:  value.UserMeta,
 		meta:      value.Meta,
 		ExpiresAt: value.ExpiresAt,
+
+		userMetaHigh: value.UserMetaHigh,
 	}
 
 	// wal is nil only when badger in running in in-memory mode and we don't need the wal.
@@ -250,6 +256,8 @@ func (mt *memTable) replayFunction(opt Options) func(Entry, valuePointer) error
 			Meta:      e.meta,
 			UserMeta:  e.UserMeta,
 			ExpiresAt: e.ExpiresAt,
+
+			UserMetaHigh: e.userMetaHigh,
 		}
 		// This is already encoded correctly. Value would be either a vptr, or a full value
 		// depending upon how big the original value was. Skiplist makes a copy of the key and
@@ -300,6 +308,8 @@ func (lf *logFile) encodeEntry(buf *bytes.Buffer, e *Entry, offset uint32) (int,
 		expiresAt: e.ExpiresAt,
 		meta:      e.meta,
 		userMeta:  e.UserMeta,
+
+		userMetaHigh: e.userMetaHigh,
 	}
 
 	hash := crc32.New(y.CastagnoliCrcTable)
@@ -366,6 +376,8 @@ func (lf *logFile) decodeEntry(buf []byte, offset uint32) (*Entry, error) {
 		offset:    offset,
 		Key:       kv[:h.klen],
 		Value:     kv[h.klen : h.klen+h.vlen],
+
+		userMetaHigh: h.userMetaHigh,
 	}
 	return e, nil
 }
@@ -64,6 +64,7 @@ func (op *MergeOperator) iterateAndMerge() (newVal []byte, latest uint64, err er
 	defer txn.Discard()
 	opt := DefaultIteratorOptions
 	opt.AllVersions = true
+	opt.internal = true
 	it := txn.NewKeyIterator(op.key, opt)
 	defer it.Close()
 
@@ -107,14 +108,14 @@ func (op *MergeOperator) compact() error {
 	op.Lock()
 	defer op.Unlock()
 	val, version, err := op.iterateAndMerge()
-	if err == ErrKeyNotFound || err == errNoMerge {
+	if stderrors.Is(err, ErrKeyNotFound) || err == errNoMerge {
 		return nil
 	} else if err != nil {
 		return err
 	}
 	entries := []*Entry{
 		{
-			Key:   y.KeyWithTs(op.key, version),
+			Key:   y.KeyWithTs(op.db.storedKey(op.key), version),
 			Value: val,
 			meta:  bitDiscardEarlierVersions,
 		},
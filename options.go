@@ -18,6 +18,7 @@ package badger
 
 import (
 	"fmt"
+	"math/rand"
 	"os"
 	"reflect"
 	"strconv"
@@ -27,6 +28,7 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/0xEggTart/badger/options"
+	"github.com/0xEggTart/badger/skl"
 	"github.com/0xEggTart/badger/table"
 	"github.com/0xEggTart/badger/y"
 	"github.com/dgraph-io/ristretto/v2/z"
@@ -70,9 +72,26 @@ type Options struct {
 	TableSizeMultiplier int
 	MaxLevels           int
 
-	VLogPercentile float64
-	ValueThreshold int64
-	NumMemtables   int
+	// Target sizes of L1 and the levels below, replacing the ones derived from
+	// LevelSizeMultiplier.
+	CustomLevelSizes []int64
+
+	// Drop the versions beyond NumVersionsToKeep when flushing memtables, not only in compactions.
+	PruneVersionsOnFlush bool
+
+	VLogPercentile      float64
+	ValueThreshold      int64
+	EntrySpillThreshold int
+	NumMemtables        int
+	FlushPriority       FlushPriority
+
+	// The memory the oracle may use to detect conflicts, zero for no limit.
+	MaxOracleMemory int64
+
+	// The shape of the memtable skiplists.
+	SkiplistMaxHeight   int
+	SkiplistProbability float64
+
 	// Changing BlockSize across DB runs will not break badger. The block size is
 	// read from the block index stored at the end of the table.
 	BlockSize          int
@@ -80,17 +99,41 @@ type Options struct {
 	BlockCacheSize     int64
 	IndexCacheSize     int64
 
+	// The memory for the blocks pinned by DB.PinRange.
+	PinnedCacheSize int64
+
 	NumLevelZeroTables      int
 	NumLevelZeroTablesStall int
 
 	ValueLogFileSize   int64
 	ValueLogMaxEntries uint32
+	// How long the active value log file keeps taking writes, zero for no limit.
+	ValueLogMaxAge time.Duration
+	// Total bytes of value log files past which writes of values to them fail, zero for no limit.
+	MaxValueLogSize int64
+	// Accept values larger than ValueLogFileSize, each going to a value log file of its own.
+	AllowLargeValues bool
+	// How often value log GC runs on its own with AutoValueLogGCRatio, zero to disable.
+	AutoValueLogGCInterval time.Duration
+	AutoValueLogGCRatio    float64
+
+	CommitPipelineDepth int
 
 	NumCompactors        int
 	CompactL0OnClose     bool
 	LmaxCompaction       bool
 	ZSTDCompressionLevel int
 
+	// Bytes per second that compactions may read and write, zero for no limit.
+	CompactionRateLimit int64
+	// Versions of a single key a compaction scans before warning about it, zero for no limit.
+	MaxVersionsScannedPerKey int
+	// Keys tracked by DB.WriteAmplificationHotspots, zero to disable.
+	HotspotKeys int
+
+	// Blocks smaller than MinCompressionSize are stored uncompressed.
+	MinCompressionSize int
+
 	// When set, checksum will be validated for each entry read from the value log file.
 	VerifyValueChecksum bool
 
@@ -102,14 +145,27 @@ type Options struct {
 	// guard can cause data corruption if multiple badger instances are using
 	// the same directory. Use this options with caution.
 	BypassLockGuard bool
+	// How long Open retries to acquire the lock on a directory held by another process.
+	LockTimeout time.Duration
 
 	// ChecksumVerificationMode decides when db should verify checksums for SSTable blocks.
 	ChecksumVerificationMode options.ChecksumVerificationMode
+	// VlogChecksumVerification decides when db should verify checksums for value log entries.
+	VlogChecksumVerification options.ChecksumVerificationMode
+
+	// ValidateKeyOrder checks that keys come in order when building tables and merging iterators.
+	ValidateKeyOrder bool
 
 	// DetectConflicts determines whether the transactions would be checked for
 	// conflicts. The transactions can be processed at a higher rate when
 	// conflict detection is disabled.
 	DetectConflicts bool
+	// Set if the transactions return structured errors. See WithDetailedErrors.
+	DetailedErrors bool
+
+	// When set, commits in managed mode which write a key at a version it already has fail with
+	// ErrDuplicateVersion. See WithRejectDuplicateVersions.
+	RejectDuplicateVersions bool
 
 	// NamespaceOffset specifies the offset from where the next 8 bytes contains the namespace.
 	NamespaceOffset int
@@ -118,6 +174,61 @@ type Options struct {
 	// with incompatible data format.
 	ExternalMagicVersion uint16
 
+	// ReadThroughLoader is called by read-only transactions on a Get miss.
+	ReadThroughLoader ReadThroughLoader
+
+	// Keys read more than this many times are rewritten if found deep in the LSM tree, zero to
+	// disable.
+	ReadRepair int
+
+	// When set, latency histograms of common operations are collected. See DB.LatencyStats.
+	LatencyTracking bool
+
+	// Keys whose latest version Txn.Get caches, zero to disable, and for how long.
+	PointReadCacheSize int
+	PointReadCacheTTL  time.Duration
+
+	// CompactionDropHook is called for every entry that a compaction drops permanently.
+	CompactionDropHook func(key []byte, reason DropReason)
+
+	// UserMetaMerge folds the user meta of versions dropped by compactions into the versions kept.
+	UserMetaMerge func(newer, older byte) byte
+
+	// When set, entries may carry 16 bit user metas. See WithWideUserMeta.
+	WideUserMeta bool
+
+	// ValueLogGCConflictHook is called for every key that value log GC skips because it moved.
+	ValueLogGCConflictHook func(key []byte)
+
+	// LargeReadSetHook is called when a transaction tracks more than LargeReadSetThreshold reads.
+	LargeReadSetHook      func(count int)
+	LargeReadSetThreshold int
+
+	// Source of the randomness used by Badger. See WithRandSource.
+	RandSource rand.Source
+
+	// When set, a corrupted manifest gets rebuilt from the table files on Open.
+	ManifestRecovery bool
+	// When not set, the manifest changes of compactions are synced in batches. See WithSyncManifest.
+	SyncManifest bool
+
+	// When BlobDir is set, values of at least BlobThreshold bytes are stored in value log files
+	// in BlobDir, instead of ValueDir. See WithBlobDir.
+	BlobDir       string
+	BlobThreshold int64
+
+	// Maximum number of open iterators, zero for unlimited. See WithMaxConcurrentIterators.
+	MaxConcurrentIterators int
+	BlockOnMaxIterators    bool
+
+	// Keys are passed through KeyWriteTransform on the way in and KeyReadTransform on the way
+	// out of transactions, iterators and subscriptions. See WithKeyTransform.
+	KeyWriteTransform func(key []byte) []byte
+	KeyReadTransform  func(key []byte) []byte
+
+	// The writes of every transaction go through WriteMiddleware. See WithWriteMiddleware.
+	WriteMiddleware []WriteMiddleware
+
 	// Transaction start and commit timestamps are managed by end-user.
 	// This is only useful for databases built on top of Badger (like Dgraph).
 	// Not recommended for most users.
@@ -131,6 +242,11 @@ type Options struct {
 	maxValueThreshold float64
 }
 
+// ReadThroughLoader loads the value of a key which is missing from the DB, typically from a
+// slower source of truth that the DB caches. It returns found as false if the source doesn't have
+// the key either. A non-zero ttl sets the time after which the loaded value expires.
+type ReadThroughLoader func(key []byte) (val []byte, ttl time.Duration, found bool, err error)
+
 // DefaultOptions sets a list of recommended options for good performance.
 // Feel free to modify these to suit your needs with the WithX methods.
 func DefaultOptions(path string) Options {
@@ -139,6 +255,8 @@ func DefaultOptions(path string) Options {
 		ValueDir: path,
 
 		MemTableSize:        64 << 20,
+		SkiplistMaxHeight:   skl.DefaultMaxHeight,
+		SkiplistProbability: skl.DefaultProbability,
 		BaseTableSize:       2 << 20,
 		BaseLevelSize:       10 << 20,
 		TableSizeMultiplier: 2,
@@ -154,6 +272,7 @@ func DefaultOptions(path string) Options {
 		BloomFalsePositive:      0.01,
 		BlockSize:               4 * 1024,
 		SyncWrites:              false,
+		SyncManifest:            true,
 		NumVersionsToKeep:       1,
 		CompactL0OnClose:        false,
 		VerifyValueChecksum:     false,
@@ -179,8 +298,11 @@ func DefaultOptions(path string) Options {
 
 		ValueLogMaxEntries: 1000000,
 
+		CommitPipelineDepth: 1,
+
 		VLogPercentile: 0.0,
 		ValueThreshold: maxValueThreshold,
+		BlobThreshold:  1 << 20,
 
 		Logger:                        defaultLogger(INFO),
 		EncryptionKey:                 []byte{},
@@ -203,10 +325,14 @@ func buildTableOptions(db *DB) table.Options {
 		ChkMode:              opt.ChecksumVerificationMode,
 		Compression:          opt.Compression,
 		ZSTDCompressionLevel: opt.ZSTDCompressionLevel,
+		MinCompressionSize:   opt.MinCompressionSize,
 		BlockCache:           db.blockCache,
 		IndexCache:           db.indexCache,
+		BlockPins:            db.blockPins,
+		OnDelete:             db.tableDeleted,
 		AllocPool:            db.allocPool,
 		DataKey:              dk,
+		ValidateKeyOrder:     opt.ValidateKeyOrder,
 	}
 }
 
@@ -404,6 +530,26 @@ func (opt Options) WithNumVersionsToKeep(val int) Options {
 	return opt
 }
 
+// WithPruneVersionsOnFlush returns a new Options value with PruneVersionsOnFlush set to the given
+// value.
+//
+// PruneVersionsOnFlush drops the older versions of a key when a memtable is flushed to level 0,
+// instead of leaving that to compactions. A key which is rewritten constantly then takes up one
+// version per memtable in level 0 rather than one per write, which saves the disk space and the
+// compaction work these take. The versions are not pruned when written: a memtable is an arena
+// which is only freed as a whole, so they take up memory until their memtable is flushed.
+//
+// Versions are dropped the same way compactions drop them: beyond NumVersionsToKeep, below a
+// version with DiscardEarlierVersions, or below a delete or expired version, but only at or below
+// the version every transaction still reading can see. The dropped entries are passed on to the
+// CompactionDropHook, and counted in the discard stats of the value log.
+//
+// The default value of PruneVersionsOnFlush is false.
+func (opt Options) WithPruneVersionsOnFlush(val bool) Options {
+	opt.PruneVersionsOnFlush = val
+	return opt
+}
+
 // WithNumGoroutines sets the number of goroutines to be used in Stream.
 //
 // The default value of NumGoroutines is 8.
@@ -494,6 +640,24 @@ func (opt Options) WithMaxLevels(val int) Options {
 	return opt
 }
 
+// WithCustomLevelSizes returns a new Options value with CustomLevelSizes set to the given value.
+//
+// CustomLevelSizes sets explicit target sizes in bytes for the levels L1 to L(MaxLevels-1), so it
+// must hold MaxLevels-1 increasing sizes. By default the targets are derived from the size of the
+// last level, dividing it by LevelSizeMultiplier for each level above, and L0 tables are compacted
+// into the first level whose target doesn't exceed BaseLevelSize. With custom sizes, compactions
+// are triggered when a level exceeds its target, and L0 tables are always compacted into L1. This
+// allows to keep recent data in large upper levels, and to avoid frequent compactions of a cold
+// tail in the deeper levels.
+//
+// The sizes are copied, so the given slice can be reused.
+//
+// The default value of CustomLevelSizes is nil.
+func (opt Options) WithCustomLevelSizes(sizes []int64) Options {
+	opt.CustomLevelSizes = append([]int64(nil), sizes...)
+	return opt
+}
+
 // WithValueThreshold returns a new Options value with ValueThreshold set to the given value.
 //
 // ValueThreshold sets the threshold used to decide whether a value is stored directly in the LSM
@@ -506,6 +670,22 @@ func (opt Options) WithValueThreshold(val int64) Options {
 	return opt
 }
 
+// WithEntrySpillThreshold sets the size of an entry, its key and value together, at which the
+// value gets stored in the value log, even if the value alone is smaller than ValueThreshold.
+// This helps workloads where large keys with small values would otherwise bloat the memtables and
+// the LSM tree. ValueThreshold keeps applying on its own, so a value is moved to the value log if
+// either of the thresholds is reached.
+//
+// Note that keys always stay in the LSM tree, and moving a value replaces it with a value pointer
+// of about 12 bytes. So entries with values smaller than that don't get any smaller. Empty values
+// are never moved. This option is ignored in InMemory mode.
+//
+// The default value of EntrySpillThreshold is 0, which disables it.
+func (opt Options) WithEntrySpillThreshold(val int) Options {
+	opt.EntrySpillThreshold = val
+	return opt
+}
+
 // WithVLogPercentile returns a new Options value with ValLogPercentile set to given value.
 //
 // VLogPercentile with 0.0 means no dynamic thresholding is enabled.
@@ -533,6 +713,32 @@ func (opt Options) WithNumMemtables(val int) Options {
 	return opt
 }
 
+// FlushPriority tells which of the full memtables is flushed first. See Options.WithFlushPriority.
+type FlushPriority int
+
+const (
+	// FlushFIFO flushes the memtables in the order they filled up.
+	FlushFIFO FlushPriority = iota
+	// FlushLargestFirst flushes the memtable taking the most memory first.
+	FlushLargestFirst
+)
+
+// WithFlushPriority returns a new Options value with FlushPriority set to the given value.
+//
+// FlushPriority sets which of the full memtables waiting to be flushed goes first. With
+// FlushLargestFirst, the memtable taking the most memory is flushed first, which frees memory the
+// fastest when several are waiting. A memtable is only flushed before older ones if its key range
+// doesn't overlap theirs, as the older versions of its keys would otherwise end up in newer
+// tables than the newer versions. So writes spread over the whole key space are still flushed in
+// order, and FlushLargestFirst only helps when the memtables cover distinct key ranges, like with
+// keys growing over time.
+//
+// The default value of FlushPriority is FlushFIFO.
+func (opt Options) WithFlushPriority(val FlushPriority) Options {
+	opt.FlushPriority = val
+	return opt
+}
+
 // WithMemTableSize returns a new Options value with MemTableSize set to the given value.
 //
 // MemTableSize sets the maximum size in bytes for memtable table.
@@ -543,6 +749,46 @@ func (opt Options) WithMemTableSize(val int64) Options {
 	return opt
 }
 
+// WithMaxOracleMemory returns a new Options value with MaxOracleMemory set to the given value.
+//
+// MaxOracleMemory limits the memory in bytes used to detect conflicts between transactions. To
+// detect conflicts, the keys written by each committed transaction are kept for as long as a
+// transaction which started before the commit is around, so a transaction which is never
+// discarded makes them pile up. Once they use more than MaxOracleMemory, the oldest ones are
+// dropped with a warning, and the transactions which started before the dropped ones committed
+// fail to commit with ErrConflict. The memory used is exported as the badger_size_bytes_oracle
+// metric. Zero means no limit.
+//
+// The default value of MaxOracleMemory is 0.
+func (opt Options) WithMaxOracleMemory(val int64) Options {
+	opt.MaxOracleMemory = val
+	return opt
+}
+
+// WithSkiplistMaxHeight returns a new Options value with SkiplistMaxHeight set to the given value.
+//
+// SkiplistMaxHeight sets the maximum height of the nodes of the memtable skiplists. Taller lists
+// take fewer steps to search when the memtables hold many keys, at the cost of some memory per
+// node. It must be between 1 and 32.
+//
+// The default value of SkiplistMaxHeight is 20.
+func (opt Options) WithSkiplistMaxHeight(val int) Options {
+	opt.SkiplistMaxHeight = val
+	return opt
+}
+
+// WithSkiplistProbability returns a new Options value with SkiplistProbability set to the given
+// value.
+//
+// SkiplistProbability sets the probability with which a node of the memtable skiplists grows by
+// another level. It must be greater than 0 and less than 1.
+//
+// The default value of SkiplistProbability is 1/3.
+func (opt Options) WithSkiplistProbability(val float64) Options {
+	opt.SkiplistProbability = val
+	return opt
+}
+
 // WithBloomFalsePositive returns a new Options value with BloomFalsePositive set
 // to the given value.
 //
@@ -613,6 +859,383 @@ func (opt Options) WithValueLogMaxEntries(val uint32) Options {
 	return opt
 }
 
+// WithValueLogMaxAge returns a new Options value with ValueLogMaxAge set to the given value.
+//
+// ValueLogMaxAge bounds how long values sit in the active value log file, which value log GC
+// never rewrites. Once the first value in the active file is older than ValueLogMaxAge, the next
+// write goes on to a new file, even if the file is below ValueLogFileSize and ValueLogMaxEntries.
+// As the check is made by the writes, a file isn't sealed while no writes come in, but then its
+// values can't be overwritten or deleted either. Like the other limits, this only rotates files
+// between requests, so the entries of a transaction stay in one file.
+//
+// The default value of ValueLogMaxAge is 0, which means no limit.
+func (opt Options) WithValueLogMaxAge(val time.Duration) Options {
+	opt.ValueLogMaxAge = val
+	return opt
+}
+
+// WithMaxValueLogSize returns a new Options value with MaxValueLogSize set to the given value.
+//
+// MaxValueLogSize bounds the total size of the value log files, blob log files included. Once it
+// is reached, committing a write whose value would go to the value log fails with ErrValueLogFull,
+// and value log GC runs in the background until the size is back below the limit, or there is
+// nothing left to rewrite. Such writes are accepted again once GC has freed up enough space.
+// Entries whose values stay in the LSM tree, and deletes, are still accepted, so they can be used
+// to free up space. The rewrites done by GC are never rejected, so the value log can go past the
+// limit by up to the size of a file while GC runs.
+//
+// The default value of MaxValueLogSize is 0, which means no limit.
+func (opt Options) WithMaxValueLogSize(val int64) Options {
+	opt.MaxValueLogSize = val
+	return opt
+}
+
+// WithAutoValueLogGC returns a new Options value with AutoValueLogGCRatio and
+// AutoValueLogGCInterval set to the given values.
+//
+// With a positive checkInterval, the DB checks the discard stats of the value log every
+// checkInterval, and runs value log GC as long as some file has at least discardRatio of its size to
+// discard, which makes a loop calling DB.RunValueLogGC unnecessary. Only one GC runs at a time, so
+// a check which finds a GC already running, for example one started by DB.RunValueLogGC, is
+// skipped, and so is a check while DB.ExportArchive or DB.RebuildValueLogFile holds GC back. The
+// checks stop when the DB is closed. They never run in read-only or in-memory mode.
+//
+// The default value of AutoValueLogGCInterval is 0, which disables automatic value log GC.
+func (opt Options) WithAutoValueLogGC(discardRatio float64, checkInterval time.Duration) Options {
+	opt.AutoValueLogGCRatio = discardRatio
+	opt.AutoValueLogGCInterval = checkInterval
+	return opt
+}
+
+// WithAllowLargeValues returns a new Options value with AllowLargeValues set to the given value.
+//
+// A value larger than ValueLogFileSize can't be set unless AllowLargeValues is set, and fails with
+// a *ValueTooLargeError. When it is set, such a value is written to a value log file of its own,
+// which is just as large as the value, and is read and garbage collected like any other value log
+// file. The values must still fit into a file of 4GB. The value is held in memory until it's
+// written, so very large values are better split up.
+//
+// The default value of AllowLargeValues is false.
+func (opt Options) WithAllowLargeValues(val bool) Options {
+	opt.AllowLargeValues = val
+	return opt
+}
+
+// WithCommitPipelineDepth returns a new Options value with CommitPipelineDepth set to the given
+// value.
+//
+// CommitPipelineDepth sets the number of batches of commits written at once. A batch goes through
+// two stages, the write to the value log, then the write to the memtable and its WAL, and the
+// batches go through each stage one at a time, in commit order. With a depth above 1, the next
+// batch is written to the value log while the current one is written to the memtable, which helps
+// the throughput under many concurrent writers. Each batch is acknowledged once written to the
+// memtable, and in order, so durability and the order of versions are unchanged. Batches get
+// smaller as they're sent down the pipeline sooner, so a depth above 2 or 3 rarely helps.
+//
+// The default value of CommitPipelineDepth is 1, which writes one batch at a time, as does 0.
+func (opt Options) WithCommitPipelineDepth(val int) Options {
+	opt.CommitPipelineDepth = val
+	return opt
+}
+
+// WithReadThroughLoader sets the loader which is invoked when a Get in a read-only transaction,
+// for example within DB.View, misses. The loaded value is written to the DB in a separate
+// transaction and returned from the Get. If the key got written after the read transaction
+// started, that value is returned instead and the loader is not called. If the write keeps
+// conflicting with concurrent writes of the key, the Get gives up after 100 retries and returns
+// ErrConflict.
+//
+// The loaded value is newer than the snapshot of the transaction that triggered the load. Within
+// that transaction, later calls to Get for the key return the loaded value, but iterators keep
+// reading from the snapshot and don't see it. Read-write transactions never invoke the loader, as
+// the write would conflict with their own read of the key. The loader cannot be used along with
+// ReadOnly or managed mode.
+//
+// The default value of ReadThroughLoader is nil.
+func (opt Options) WithReadThroughLoader(val ReadThroughLoader) Options {
+	opt.ReadThroughLoader = val
+	return opt
+}
+
+// WithReadRepair returns a new Options value with ReadRepair set to the given value.
+//
+// ReadRepair sets the number of reads by Txn.Get after which a key is rewritten, if its newest
+// version is found below the base level of the LSM tree, the level into which L0 tables are
+// compacted. Every Get of such a key searches all the levels above it, so a key written long ago
+// and read often gets slower to read than recent keys. The version is written again into the
+// memtable at the same timestamp, so the key reads the same and the transactions reading it don't
+// conflict with the rewrite. The copy points at the value in the value log, if the value is stored
+// there, so the value isn't copied.
+//
+// The reads are sampled and counted for a bounded number of keys, and the keys are rewritten in
+// the background, so a key can take more reads than ReadRepair to be rewritten, or not be
+// rewritten at all if many keys are read often. Read repair cannot be used along with ReadOnly or
+// managed mode.
+//
+// The default value of ReadRepair is 0, which disables read repair.
+func (opt Options) WithReadRepair(threshold int) Options {
+	opt.ReadRepair = threshold
+	return opt
+}
+
+// WithLatencyTracking returns a new Options value with LatencyTracking set to the given value.
+//
+// When LatencyTracking is set, the DB keeps histograms of the latencies of Txn.Get, Txn.Commit,
+// Iterator.Next and the reads from the value log, which can be read with DB.LatencyStats. The
+// latencies are bucketed by powers of two, so tracking an operation costs a clock read and a few
+// atomic operations. When it's not set, nothing is measured.
+//
+// The default value of LatencyTracking is false.
+func (opt Options) WithLatencyTracking(val bool) Options {
+	opt.LatencyTracking = val
+	return opt
+}
+
+// WithPointReadCache returns a new Options value with PointReadCacheSize set to size and
+// PointReadCacheTTL set to ttl.
+//
+// When PointReadCacheSize is set, Txn.Get caches the latest version of up to that many keys, and
+// serves later reads of those keys without a lookup in the LSM tree. Unlike the block cache, it
+// caches single values. A write to a key evicts it from the cache, and an entry is dropped ttl
+// after it was cached, which bounds how stale a read served from the cache can be. Only reads
+// which see all committed writes fill the cache, and iterators don't use it.
+//
+// The default value of PointReadCacheSize is 0, which disables the cache.
+func (opt Options) WithPointReadCache(size int, ttl time.Duration) Options {
+	opt.PointReadCacheSize = size
+	opt.PointReadCacheTTL = ttl
+	return opt
+}
+
+// WithCompactionDropHook sets a function which is called with the key of every entry that a
+// compaction permanently removes from the LSM tree, along with the reason it was dropped. As each
+// dropped version is reported, a key can be reported more than once. Internal keys used by Badger
+// are not reported. This can be used to keep an audit trail of physical deletions.
+//
+// The hook is called from a single goroutine, off a queue which compactions add to without
+// waiting. A slow hook would therefore not stall compactions, but once the queue is full, the
+// dropped entries are not reported until there's room again, and a warning is logged. Entries
+// removed in bulk by DropAll are not reported either. Close waits for the queued entries to be
+// reported.
+//
+// The default value of CompactionDropHook is nil.
+func (opt Options) WithCompactionDropHook(val func(key []byte, reason DropReason)) Options {
+	opt.CompactionDropHook = val
+	return opt
+}
+
+// WithUserMetaMerge returns a new Options value with UserMetaMerge set to the given value.
+//
+// When a compaction keeps NumVersionsToKeep versions of a key, or a version with
+// Entry.WithDiscard set, it drops the older versions along with their user meta. With
+// UserMetaMerge set, the user meta of each dropped version is folded into the oldest version
+// kept instead, as merge(newer, older), newer being the user meta of the kept version so far.
+// OR-ing them, for example, accumulates flag bits across versions. Versions dropped because
+// the key was deleted or expired, or by DropPrefix or DeleteBelowVersion, aren't folded. The
+// function is called during compactions and memtable flushes, so it must be fast.
+//
+// The default value of UserMetaMerge is nil, which keeps the user meta of the version kept.
+func (opt Options) WithUserMetaMerge(merge func(newer, older byte) byte) Options {
+	opt.UserMetaMerge = merge
+	return opt
+}
+
+// WithWideUserMeta returns a new Options value with WideUserMeta set to the given value.
+//
+// When WideUserMeta is set, entries may carry 16 bit user metas, set via Entry.WithUserMeta16 and
+// read via Item.UserMeta16, which round trip through tables, the value log, backups and streams.
+// Opening a DB with WideUserMeta set marks it in the MANIFEST, after which versions of Badger
+// without wide user metas refuse to open it. Data written before keeps its single byte user
+// metas and stays readable. Once marked, the DB can't be unmarked, but opening it later without
+// WideUserMeta only rejects new writes of 16 bit user metas with ErrWideUserMeta.
+//
+// UserMetaMerge only folds the low byte of the user metas.
+//
+// The default value of WideUserMeta is false.
+func (opt Options) WithWideUserMeta(b bool) Options {
+	opt.WideUserMeta = b
+	return opt
+}
+
+// WithLargeReadSetHook returns a new Options value with LargeReadSetThreshold and LargeReadSetHook
+// set to the given values.
+//
+// The hook is called when a read-write transaction tracks the read of one key more than threshold
+// for conflict detection, with the number of reads tracked. Every Get and every key an iterator
+// moves to is tracked, and a large read set makes commits slower, so this helps to find the
+// transactions which should be split, or not detect conflicts, see Txn.SetDetectConflicts. The
+// hook is called once per transaction, from the goroutine doing the read, so it should be fast
+// and must not use the transaction. Reads aren't tracked in read-only transactions, nor after
+// Txn.SetDetectConflicts(false), so the hook isn't called for them.
+//
+// The default value of LargeReadSetThreshold is 0, which means the hook is never called.
+func (opt Options) WithLargeReadSetHook(threshold int, fn func(count int)) Options {
+	opt.LargeReadSetThreshold = threshold
+	opt.LargeReadSetHook = fn
+	return opt
+}
+
+// WithValueLogGCConflictHook sets a function which is called with the key of every value that
+// value log GC finds in the file it rewrites, but doesn't rewrite because the key moved: the
+// version of the key in the LSM tree points to another location, as the value was rewritten in
+// the meantime, for example by a concurrent update or an earlier GC. This is purely for
+// observability, as skipping such values is correct. Values which were deleted or overwritten by
+// newer versions are not reported. Internal keys used by Badger are not reported.
+//
+// Like the CompactionDropHook, the hook is called from a single goroutine, off a queue which GC
+// adds to without waiting. Once the queue is full, keys are not reported and a warning is logged.
+// Close waits for the queued keys to be reported.
+//
+// The default value of ValueLogGCConflictHook is nil.
+func (opt Options) WithValueLogGCConflictHook(val func(key []byte)) Options {
+	opt.ValueLogGCConflictHook = val
+	return opt
+}
+
+// WithRandSource returns a new Options value with RandSource set to the given value.
+//
+// When RandSource is set, Badger takes the randomness it uses internally, like the heights of the
+// memtable skiplist nodes, the start delays of the compactors and the sampling of the reads counted
+// by ReadRepair, from it instead of the global random sources. With a seeded source, this makes the
+// memtable layout, and so when memtables get flushed, reproducible for tests. The source doesn't
+// need to be safe for concurrent use. Cryptographic randomness, like encryption keys and IVs, never
+// comes from RandSource.
+//
+// The default value of RandSource is nil.
+func (opt Options) WithRandSource(val rand.Source) Options {
+	opt.RandSource = val
+	return opt
+}
+
+// WithBlobDir returns a new Options value with BlobDir set to the given value.
+//
+// When BlobDir is set, the values of at least BlobThreshold bytes which go to the value log are
+// written to a separate set of value log files, the blob log, in BlobDir. This allows keeping
+// the LSM tree and the smaller values on fast storage, while large values go to cheaper storage.
+// The blob log is garbage collected by RunValueLogGC along with the value log. BlobDir must differ
+// from ValueDir, and must be set again on every Open as long as it holds files.
+//
+// The default value of BlobDir is "", which stores all values in ValueDir.
+func (opt Options) WithBlobDir(val string) Options {
+	opt.BlobDir = val
+	return opt
+}
+
+// WithBlobThreshold returns a new Options value with BlobThreshold set to the given value.
+//
+// BlobThreshold is the size from which values go to the blob log, when BlobDir is set. Values
+// which stay in the LSM tree, see ValueThreshold, never go to the blob log.
+//
+// The default value of BlobThreshold is 1 MB.
+func (opt Options) WithBlobThreshold(val int64) Options {
+	opt.BlobThreshold = val
+	return opt
+}
+
+// WithKeyTransform returns a new Options value with KeyWriteTransform set to onWrite and
+// KeyReadTransform set to onRead. This makes for transparent key namespacing, for example by
+// prepending a tenant prefix on write and stripping it on read.
+//
+// onWrite is applied to the keys passed to Txn.Set, SetEntry, Delete, Get, HasMany and Increment,
+// to iterator prefixes and seek keys, and to the prefixes of Subscribe matches. onRead is applied
+// to the keys returned by Item.Key and handed to Subscribe callbacks. onRead must undo onWrite,
+// and onWrite must keep prefixes: if a is a prefix of b, onWrite(a) must be a prefix of
+// onWrite(b), so that prefix iteration stays within the transformed key space. onWrite of an
+// empty key gives the bounds of iteration without a prefix. The transforms must not modify their
+// argument. Stream, Backup and Load work on the stored keys.
+//
+// The default value of KeyWriteTransform and KeyReadTransform is nil.
+func (opt Options) WithKeyTransform(onWrite, onRead func(key []byte) []byte) Options {
+	opt.KeyWriteTransform = onWrite
+	opt.KeyReadTransform = onRead
+	return opt
+}
+
+// WithWriteMiddleware returns a new Options value with the given middleware added to
+// WriteMiddleware.
+//
+// The middleware are chained around the commit of every transaction, WriteBatch included: each
+// gets the entries written, and can inspect or modify them, or replace the slice, before passing
+// them on to the next one, or fail the commit by returning an error instead. The middleware added
+// first sees the entries first. The last one passes them to the commit, which checks the entries
+// like the ones set, and the transaction for conflicts, and queues the entries to be written. It
+// returns the error of the first entry failing its checks, ErrConflict, or the error of queueing
+// them. The keys of the entries are as stored, so after
+// KeyWriteTransform; Entry.IsDelete tells deletes apart. Conflicts are detected on the keys as
+// they were set. A middleware which returns nil without calling next drops the writes of the
+// transaction. If it returns an error after next returned nil, the entries are still written, and
+// the commit returns the error once they are.
+//
+// The default value of WriteMiddleware is nil, which keeps commits from doing any extra work.
+func (opt Options) WithWriteMiddleware(val ...WriteMiddleware) Options {
+	n := len(opt.WriteMiddleware)
+	// Don't let the Options values sharing the slice append to it.
+	opt.WriteMiddleware = append(opt.WriteMiddleware[:n:n], val...)
+	return opt
+}
+
+// WithMaxConcurrentIterators returns a new Options value with MaxConcurrentIterators set to the
+// given value.
+//
+// MaxConcurrentIterators limits the number of iterators which can be open at the same time, across
+// all transactions. Once the limit is reached, NewIterator panics with ErrTooManyIterators, or
+// blocks until an iterator gets closed if BlockOnMaxIterators is set. Txn.TryNewIterator returns
+// ErrTooManyIterators instead, for callers which must not crash under load. As open iterators pin
+// memtables and blocks in memory, this turns an iterator leak into a clear failure rather than
+// unbounded memory usage. Zero means no limit. Only the iterators created by the user count, and
+// an iterator of NewMultiPrefixIterator counts once. The ones Badger opens for itself, e.g. in
+// merge operators, streams or DB.FirstKey, are never limited.
+//
+// The default value of MaxConcurrentIterators is 0.
+func (opt Options) WithMaxConcurrentIterators(val int) Options {
+	opt.MaxConcurrentIterators = val
+	return opt
+}
+
+// WithBlockOnMaxIterators returns a new Options value with BlockOnMaxIterators set to the given
+// value.
+//
+// When BlockOnMaxIterators is set, NewIterator blocks instead of panicking once
+// MaxConcurrentIterators iterators are open. Beware that a goroutine opening an iterator while
+// holding others open can then deadlock.
+//
+// The default value of BlockOnMaxIterators is false.
+func (opt Options) WithBlockOnMaxIterators(val bool) Options {
+	opt.BlockOnMaxIterators = val
+	return opt
+}
+
+// WithManifestRecovery returns a new Options value with ManifestRecovery set to the given value.
+//
+// When ManifestRecovery is set and the manifest can't be read because it's corrupted, e.g. after
+// a partially written update, Open rebuilds it from the table files found in Dir instead of
+// failing. Levels are inferred from the key ranges and versions of the tables, so the rebuilt LSM
+// tree might be shaped differently than the original one; compactions fix that over time. The
+// corrupted manifest is kept as MANIFEST.corrupted, tables which can't be read are renamed with a
+// .corrupted suffix, and everything inferred is logged as warnings. Recovery isn't supported
+// with encryption, nor in read-only mode.
+//
+// The default value of ManifestRecovery is false.
+func (opt Options) WithManifestRecovery(val bool) Options {
+	opt.ManifestRecovery = val
+	return opt
+}
+
+// WithSyncManifest returns a new Options value with SyncManifest set to the given value.
+//
+// When SyncManifest is set, every change to the manifest, which records the tables of each level,
+// is synced to disk before it takes effect. When it's not set, the changes made by compactions are
+// only synced once per second, in a batch, which takes the fsyncs off the path of compactions. The
+// files of the tables that compactions replace are kept until the changes are synced, so a crash
+// only loses the latest compactions: the DB reopens with the tables they replaced. Other changes,
+// like the tables flushed from memtables, are still synced right away.
+//
+// The default value of SyncManifest is true.
+func (opt Options) WithSyncManifest(val bool) Options {
+	opt.SyncManifest = val
+	return opt
+}
+
 // WithNumCompactors sets the number of compaction workers to run concurrently.  Setting this to
 // zero stops compactions, which could eventually cause writes to block forever.
 //
@@ -622,6 +1245,46 @@ func (opt Options) WithNumCompactors(val int) Options {
 	return opt
 }
 
+// WithCompactionRateLimit returns a new Options value with CompactionRateLimit set to the given
+// value. It limits the bytes per second read and written by all compactions combined, using a
+// token bucket, so that compactions don't saturate the disk and starve foreground reads. A
+// limit which is too low for the write load leads to write stalls, as L0 fills up. The limit can
+// be changed at runtime with DB.SetCompactionRateLimit.
+//
+// The default value of CompactionRateLimit is 0, which means no limit.
+func (opt Options) WithCompactionRateLimit(val int64) Options {
+	opt.CompactionRateLimit = val
+	return opt
+}
+
+// WithMaxVersionsScannedPerKey returns a new Options value with MaxVersionsScannedPerKey set to
+// the given value.
+//
+// When a compaction scans more than MaxVersionsScannedPerKey versions of a single key, it logs a
+// warning with the key, as a key accumulating that many versions usually points to a bug in the
+// application, and makes compactions slow. The compaction still processes all the versions, but it
+// yields the processor after every MaxVersionsScannedPerKey versions, so that it doesn't
+// monopolize it while walking a huge version chain.
+//
+// The default value of MaxVersionsScannedPerKey is 0, which means no limit.
+func (opt Options) WithMaxVersionsScannedPerKey(val int) Options {
+	opt.MaxVersionsScannedPerKey = val
+	return opt
+}
+
+// WithHotspotKeys returns a new Options value with HotspotKeys set to the given value.
+//
+// When HotspotKeys is set, compactions count the versions they drop for each key, and
+// DB.WriteAmplificationHotspots reports up to HotspotKeys keys with the most versions dropped.
+// This points to the keys causing the most write amplification. The tracking takes a lock once
+// every few hundred keys with dropped versions, and memory for HotspotKeys keys.
+//
+// The default value of HotspotKeys is 0, which disables the tracking.
+func (opt Options) WithHotspotKeys(val int) Options {
+	opt.HotspotKeys = val
+	return opt
+}
+
 // WithCompactL0OnClose determines whether Level 0 should be compacted before closing the DB.  This
 // ensures that both reads and writes are efficient when the DB is opened later.
 //
@@ -671,6 +1334,21 @@ func (opt Options) WithVerifyValueChecksum(val bool) Options {
 	return opt
 }
 
+// WithVlogChecksumVerification returns a new Options value with VlogChecksumVerification set to
+// the given value.
+//
+// VlogChecksumVerification indicates when the db should verify checksums for value log entries,
+// independently of ChecksumVerificationMode for SSTable blocks. With options.OnTableRead, every
+// value log file but the one being written is verified while opening the DB. With
+// options.OnBlockRead, every entry is verified when its value is read, the same as
+// VerifyValueChecksum. options.OnTableAndBlockRead does both.
+//
+// The default value of VlogChecksumVerification is options.NoVerification.
+func (opt Options) WithVlogChecksumVerification(cvMode options.ChecksumVerificationMode) Options {
+	opt.VlogChecksumVerification = cvMode
+	return opt
+}
+
 // WithChecksumVerificationMode returns a new Options value with ChecksumVerificationMode set to
 // the given value.
 //
@@ -682,6 +1360,33 @@ func (opt Options) WithChecksumVerificationMode(cvMode options.ChecksumVerificat
 	return opt
 }
 
+// WithValidateKeyOrder returns a new Options value with ValidateKeyOrder set to the given value.
+//
+// When ValidateKeyOrder is set, the keys added to tables, by memtable flushes, compactions and
+// StreamWriter, and the keys coming out of the merge of the memtables and tables, by iterators
+// and compactions, are checked to be strictly increasing (decreasing for reverse iterators). If
+// they aren't, Badger panics with the offending pair of keys. This catches ordering bugs, like a
+// broken custom key encoding or comparison, where they happen. It slows down writes and reads, so
+// it's meant for development and tests.
+//
+// The default value of ValidateKeyOrder is false.
+func (opt Options) WithValidateKeyOrder(val bool) Options {
+	opt.ValidateKeyOrder = val
+	return opt
+}
+
+// WithPinnedCacheSize returns a new Options value with PinnedCacheSize set to the given value.
+//
+// PinnedCacheSize sets the memory in bytes for the table blocks kept in memory by DB.PinRange.
+// Pinned blocks are never evicted, and are accounted for separately from the block cache. Ranges
+// can only be pinned if PinnedCacheSize is set.
+//
+// The default value of PinnedCacheSize is 0.
+func (opt Options) WithPinnedCacheSize(size int64) Options {
+	opt.PinnedCacheSize = size
+	return opt
+}
+
 // WithBlockCacheSize returns a new Options value with BlockCacheSize set to the given value.
 //
 // This value specifies how much data cache should hold in memory. A small size
@@ -730,6 +1435,22 @@ func (opt Options) WithZSTDCompressionLevel(cLevel int) Options {
 	return opt
 }
 
+// WithMinCompressionSize returns a new Options value with MinCompressionSize set to the given
+// value.
+//
+// Table blocks smaller than MinCompressionSize bytes are stored uncompressed, even if Compression
+// is set. Compressing small blocks costs CPU for little gain, and can even grow them. Blocks are
+// cut once they reach about BlockSize bytes, so unless MinCompressionSize is close to BlockSize,
+// this mostly affects the last block of each table. Tables can mix compressed and uncompressed
+// blocks, so the option can be changed between runs. Values in the value log are never
+// compressed.
+//
+// The default value of MinCompressionSize is 0, which compresses all the blocks.
+func (opt Options) WithMinCompressionSize(val int) Options {
+	opt.MinCompressionSize = val
+	return opt
+}
+
 // WithBypassLockGuard returns a new Options value with BypassLockGuard
 // set to the given value.
 //
@@ -743,6 +1464,19 @@ func (opt Options) WithBypassLockGuard(b bool) Options {
 	return opt
 }
 
+// WithLockTimeout returns a new Options value with LockTimeout set to the given value.
+//
+// LockTimeout sets how long Open keeps retrying to acquire the lock on a directory of the DB while
+// another process holds it, for example while the previous instance of a service shuts down
+// during a rolling restart. If the lock is still held once LockTimeout has passed, Open returns a
+// *DirectoryLockedError, which holds the pid of the process holding the lock if it's known.
+//
+// The default value of LockTimeout is 0, which fails right away.
+func (opt Options) WithLockTimeout(d time.Duration) Options {
+	opt.LockTimeout = d
+	return opt
+}
+
 // WithIndexCacheSize returns a new Options value with IndexCacheSize set to
 // the given value.
 //
@@ -775,6 +1509,39 @@ func (opt Options) WithDetectConflicts(b bool) Options {
 	return opt
 }
 
+// WithDetailedErrors returns a new Options value with DetailedErrors set to the given value.
+//
+// When DetailedErrors is set, transactions return structured errors carrying the details of what
+// went wrong, instead of the bare ErrKeyNotFound, ErrTxnTooBig and ErrConflict: a
+// *KeyNotFoundError with the key, a *TxnTooBigError with the sizes, and a *ConflictError with the
+// conflicting keys, which Txn.ConflictKeys returns as well. They wrap the sentinels, so they must
+// be checked with errors.Is or errors.As rather than ==. They are returned through DB.Update and
+// DB.View as well. To report the conflicting keys, the keys written by the transactions which
+// recent transactions may conflict with are kept in memory, rather than only their fingerprints.
+//
+// The default value of DetailedErrors is false, so that the errors can be compared with ==.
+func (opt Options) WithDetailedErrors(b bool) Options {
+	opt.DetailedErrors = b
+	return opt
+}
+
+// WithRejectDuplicateVersions returns a new Options value with RejectDuplicateVersions set to the
+// given value.
+//
+// In managed mode the caller picks the versions, and may write a key at a version it already has.
+// The later commit then replaces the entry of the earlier one, so reads and iteration see only the
+// last write. When RejectDuplicateVersions is set, such a commit fails with a
+// *DuplicateVersionError instead, which wraps ErrDuplicateVersion. The check costs a point lookup
+// per written key. It doesn't catch two transactions committing the same key and version
+// concurrently, and it doesn't apply to the StreamWriter. It has no effect outside managed mode,
+// where commit timestamps are unique.
+//
+// The default value of RejectDuplicateVersions is false.
+func (opt Options) WithRejectDuplicateVersions(val bool) Options {
+	opt.RejectDuplicateVersions = val
+	return opt
+}
+
 // WithNamespaceOffset returns a new Options value with NamespaceOffset set to the given value. DB
 // will expect the namespace in each key at the 8 bytes starting from NamespaceOffset. A negative
 // value means that namespace is not stored in the key.
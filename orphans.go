@@ -0,0 +1,105 @@
+/*
+ * Copyright 2024 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	"github.com/0xEggTart/badger/table"
+)
+
+// OrphanedFiles returns the paths of the table files in Options.Dir which the DB doesn't use,
+// along with a MANIFEST-REWRITE file left behind by an interrupted manifest rewrite. Table files
+// which the MANIFEST doesn't list are removed when the DB is opened, so these only show up if a
+// file couldn't be removed or was put there from outside. A table file is not reported while it is
+// listed in the MANIFEST, while its table is being built, and after the table got deleted for as
+// long as the DB still holds it open. It is safe to call on a live DB. Value log files are never
+// reported, as the DB replays all of them on open.
+func (db *DB) OrphanedFiles() ([]string, error) {
+	paths, _, err := db.orphanedFiles(false)
+	return paths, err
+}
+
+// CleanupOrphans removes the files reported by OrphanedFiles. It returns the number of files
+// removed and their total size in bytes.
+func (db *DB) CleanupOrphans() (removed int, bytes int64, err error) {
+	if db.opt.ReadOnly {
+		return 0, 0, errors.New("Cannot remove orphaned files in read-only mode")
+	}
+	paths, bytes, err := db.orphanedFiles(true)
+	return len(paths), bytes, err
+}
+
+// orphanedFiles returns the orphaned files and their total size, removing them if remove is set.
+// It holds the lock of the manifest throughout, so that no table can be created or deleted, and no
+// file ID reserved, while it decides which files are orphaned.
+func (db *DB) orphanedFiles(remove bool) ([]string, int64, error) {
+	if db.opt.InMemory {
+		return nil, 0, nil
+	}
+	mf := db.manifest
+	mf.appendLock.Lock()
+	defer mf.appendLock.Unlock()
+
+	entries, err := os.ReadDir(db.opt.Dir)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "while listing %s", db.opt.Dir)
+	}
+
+	var paths []string
+	var size int64
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if entry.Name() != manifestRewriteFilename {
+			id, ok := table.ParseFileID(entry.Name())
+			if !ok {
+				continue
+			}
+			_, listed := mf.manifest.Tables[id]
+			_, building := mf.building[id]
+			_, retired := mf.retired[id]
+			if listed || building || retired {
+				continue
+			}
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return paths, size, errors.Wrapf(err, "while reading %s", entry.Name())
+		}
+		path := filepath.Join(db.opt.Dir, entry.Name())
+		if remove {
+			if err := os.Remove(path); err != nil {
+				return paths, size, errors.Wrapf(err, "while removing %s", path)
+			}
+			db.opt.Infof("Removed orphaned file %s", path)
+		}
+		paths = append(paths, path)
+		size += info.Size()
+	}
+	return paths, size, nil
+}
+
+// tableDeleted is called once the file of the table with the given ID is deleted, so that the
+// manifest stops tracking it.
+func (db *DB) tableDeleted(id uint64) {
+	db.manifest.forget(id)
+}
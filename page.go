@@ -0,0 +1,121 @@
+/*
+ * Copyright 2024 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+)
+
+// pageTokenVersion is the first byte of the tokens returned by DB.Page, followed by the last key
+// of the page.
+const pageTokenVersion = 1
+
+// PageOptions is used to read a page of keys with DB.Page.
+type PageOptions struct {
+	Prefix  []byte // Only keys with this prefix are returned.
+	Limit   int    // Maximum number of keys in the page, must be positive.
+	Reverse bool   // Return the keys in decreasing order.
+	// StartToken is the token returned for the previous page, or nil for the first page.
+	StartToken []byte
+}
+
+// Page returns up to opt.Limit live keys with opt.Prefix, starting right after the last key of the
+// page that opt.StartToken was returned with. If there are more keys after the page, it also
+// returns a token to pass as StartToken to read the next page, otherwise the token is nil. The
+// token is opaque, but only encodes the last key, so pages can be read with different
+// transactions, or even after the DB was reopened.
+//
+// Each page is read with its own read-only transaction, so the pages are not a consistent
+// snapshot: keys written or deleted between the calls may or may not show up. The returned items
+// hold copies of their keys and values, and stay valid after Page returns.
+func (db *DB) Page(opt PageOptions) ([]*Item, []byte, error) {
+	if opt.Limit <= 0 {
+		return nil, nil, ErrInvalidRequest
+	}
+	var last []byte
+	if opt.StartToken != nil {
+		if len(opt.StartToken) == 0 || opt.StartToken[0] != pageTokenVersion ||
+			!bytes.HasPrefix(opt.StartToken[1:], opt.Prefix) {
+			return nil, nil, ErrInvalidRequest
+		}
+		last = opt.StartToken[1:]
+	}
+
+	var items []*Item
+	var token []byte
+	err := db.View(func(txn *Txn) error {
+		iopt := DefaultIteratorOptions
+		iopt.PrefetchValues = false
+		iopt.Prefix = opt.Prefix
+		iopt.Reverse = opt.Reverse
+		iopt.internal = true
+		it := txn.NewIterator(iopt)
+		defer it.Close()
+
+		switch {
+		case last == nil && opt.Reverse:
+			it.seekPrefixEnd()
+		case last == nil:
+			it.Rewind()
+		default:
+			// Seek lands on the last key if it still exists, which is part of the previous page.
+			it.Seek(last)
+			if it.Valid() && bytes.Equal(it.Item().Key(), last) {
+				it.Next()
+			}
+		}
+		for ; it.Valid(); it.Next() {
+			if len(items) == opt.Limit {
+				key := items[len(items)-1].key
+				token = append([]byte{pageTokenVersion}, key...)
+				return nil
+			}
+			item, err := detachItem(it.Item())
+			if err != nil {
+				return err
+			}
+			items = append(items, item)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return items, token, nil
+}
+
+// detachItem returns a copy of item holding copies of its key and value, which stays valid after
+// the transaction item was read with is done.
+func detachItem(item *Item) (*Item, error) {
+	val, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, err
+	}
+	// Like the items read from pending writes, the copy holds the value itself.
+	return &Item{
+		key:       item.KeyCopy(nil),
+		vptr:      val,
+		val:       val,
+		version:   item.version,
+		expiresAt: item.expiresAt,
+		status:    prefetched,
+		meta:      item.meta &^ bitValuePointer,
+		userMeta:  item.userMeta,
+
+		userMetaHigh: item.userMetaHigh,
+	}, nil
+}
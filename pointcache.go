@@ -0,0 +1,146 @@
+/*
+ * Copyright 2024 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/0xEggTart/badger/y"
+	"github.com/dgraph-io/ristretto/v2/z"
+)
+
+const pointCacheShards = 64
+
+// pointCache caches the latest version of recently read keys for Options.PointReadCacheSize.
+// Writes invalidate the entries of their keys. Each shard has a generation, bumped on every
+// invalidation, so that a read which raced with a write doesn't put the old value back.
+type pointCache struct {
+	shards   [pointCacheShards]pointCacheShard
+	perShard int
+	ttl      time.Duration
+}
+
+type pointCacheShard struct {
+	sync.Mutex
+	gen     uint64
+	entries map[string]pointCacheEntry
+}
+
+type pointCacheEntry struct {
+	vs      y.ValueStruct
+	expires time.Time
+}
+
+func newPointCache(size int, ttl time.Duration) *pointCache {
+	c := &pointCache{
+		perShard: max(size/pointCacheShards, 1),
+		ttl:      ttl,
+	}
+	for i := range c.shards {
+		c.shards[i].entries = make(map[string]pointCacheEntry)
+	}
+	return c
+}
+
+func (c *pointCache) shard(key []byte) *pointCacheShard {
+	return &c.shards[z.MemHash(key)%pointCacheShards]
+}
+
+// get returns the cached latest version of key, if it is visible at readTs. Otherwise, it returns
+// the generation to pass to put along with the result of the lookup.
+func (c *pointCache) get(key []byte, readTs uint64) (y.ValueStruct, uint64, bool) {
+	s := c.shard(key)
+	s.Lock()
+	defer s.Unlock()
+	e, ok := s.entries[string(key)]
+	switch {
+	case !ok:
+	case time.Now().After(e.expires):
+		delete(s.entries, string(key))
+	case e.vs.Version <= readTs:
+		return e.vs, 0, true
+	}
+	return y.ValueStruct{}, s.gen, false
+}
+
+// put caches vs as the latest version of key, unless key was written since get returned gen.
+func (c *pointCache) put(key []byte, vs y.ValueStruct, gen uint64) {
+	s := c.shard(key)
+	s.Lock()
+	defer s.Unlock()
+	if s.gen != gen {
+		return
+	}
+	if _, ok := s.entries[string(key)]; !ok && len(s.entries) >= c.perShard {
+		// Evict an arbitrary entry.
+		for k := range s.entries {
+			delete(s.entries, k)
+			break
+		}
+	}
+	vs.Value = y.Copy(vs.Value)
+	s.entries[string(key)] = pointCacheEntry{vs: vs, expires: time.Now().Add(c.ttl)}
+}
+
+// invalidate removes key from the cache.
+func (c *pointCache) invalidate(key []byte) {
+	s := c.shard(key)
+	s.Lock()
+	s.gen++
+	delete(s.entries, string(key))
+	s.Unlock()
+}
+
+// clear removes all keys from the cache.
+func (c *pointCache) clear() {
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.Lock()
+		s.gen++
+		clear(s.entries)
+		s.Unlock()
+	}
+}
+
+// getLatest returns the latest version of key visible at readTs, like get does for
+// y.KeyWithTs(key, readTs), serving it from the point read cache if possible.
+func (db *DB) getLatest(key []byte, readTs uint64, stats *ReadStats) (y.ValueStruct, error) {
+	seek := y.KeyWithTs(key, readTs)
+	if db.pointCache == nil {
+		return db.getWithStats(seek, stats)
+	}
+	vs, gen, ok := db.pointCache.get(key, readTs)
+	if ok {
+		return db.hideDeleted(key, vs), nil
+	}
+	// Only a read which sees every write committed so far finds the latest version of key. In
+	// managed mode, that is a read at the max timestamp. Writes committed later bump gen.
+	latest := uint64(math.MaxUint64)
+	if !db.opt.managedTxns {
+		db.orc.Lock()
+		latest = db.orc.nextTxnTs - 1
+		db.orc.Unlock()
+	}
+	vs, err := db.getWithStats(seek, stats)
+	if err != nil || readTs < latest || vs.Version == 0 {
+		return vs, err
+	}
+	db.pointCache.put(key, vs, gen)
+	return vs, nil
+}
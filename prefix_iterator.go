@@ -0,0 +1,171 @@
+/*
+ * Copyright 2024 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"container/heap"
+)
+
+// multiPrefixIterator merges the iterators over several prefixes, see NewMultiPrefixIterator.
+// It's a heap of the indexes of the valid iterators, ordered by the keys of their items.
+type multiPrefixIterator struct {
+	prefixes [][]byte
+	iters    []*Iterator
+	valid    []int
+}
+
+func (m *multiPrefixIterator) Len() int { return len(m.valid) }
+func (m *multiPrefixIterator) Less(i, j int) bool {
+	a, b := m.valid[i], m.valid[j]
+	return lessPrefixItem(m.iters[a].item, a, m.iters[b].item, b)
+}
+func (m *multiPrefixIterator) Swap(i, j int) { m.valid[i], m.valid[j] = m.valid[j], m.valid[i] }
+func (m *multiPrefixIterator) Push(x any)    { m.valid = append(m.valid, x.(int)) }
+func (m *multiPrefixIterator) Pop() any {
+	old := m.valid
+	n := len(old)
+	x := old[n-1]
+	m.valid = old[0 : n-1]
+	return x
+}
+
+// lessPrefixItem tells whether item a of the iterator at index i comes before item b of the
+// iterator at index j.
+func lessPrefixItem(a *Item, i int, b *Item, j int) bool {
+	if cmp := bytes.Compare(a.Key(), b.Key()); cmp != 0 {
+		return cmp < 0
+	}
+	// Equal keys are returned in the order of the prefixes.
+	return i < j
+}
+
+// init rebuilds the heap once all the iterators have been positioned.
+func (m *multiPrefixIterator) init() {
+	m.valid = m.valid[:0]
+	for i, it := range m.iters {
+		if it.Valid() {
+			m.valid = append(m.valid, i)
+		}
+	}
+	heap.Init(m)
+}
+
+// item returns the smallest item of all the iterators, or nil if they are all exhausted.
+func (m *multiPrefixIterator) item() *Item {
+	if len(m.valid) == 0 {
+		return nil
+	}
+	return m.iters[m.valid[0]].item
+}
+
+// peek returns the item following the smallest one, or nil if there is none. It's the smallest of
+// the item following the smallest one in its iterator, and of the items of the children of the
+// root of the heap.
+func (m *multiPrefixIterator) peek() *Item {
+	if len(m.valid) == 0 {
+		return nil
+	}
+	idx := m.valid[0]
+	best := m.iters[idx].Peek()
+	for _, c := range m.valid[1:min(len(m.valid), 3)] {
+		if item := m.iters[c].item; best == nil || lessPrefixItem(item, c, best, idx) {
+			best, idx = item, c
+		}
+	}
+	return best
+}
+
+func (m *multiPrefixIterator) next() {
+	if len(m.valid) == 0 {
+		return
+	}
+	it := m.iters[m.valid[0]]
+	it.Next()
+	if it.Valid() {
+		heap.Fix(m, 0)
+	} else {
+		heap.Pop(m)
+	}
+}
+
+// seek positions all the iterators at key, which is taken to be stripped of the prefixes if
+// strip is set.
+func (m *multiPrefixIterator) seek(key []byte, strip bool) {
+	for i, it := range m.iters {
+		switch {
+		case len(key) == 0:
+			it.Rewind()
+		case strip:
+			it.Seek(append(append([]byte{}, m.prefixes[i]...), key...))
+		default:
+			it.Seek(key)
+		}
+	}
+	m.init()
+}
+
+// NewMultiPrefixIterator returns an iterator over the keys with any of the given prefixes, merged
+// into one sorted stream. If stripPrefix is set, the keys are sorted with their prefix left out,
+// and Item.Key and KeyCopy leave it out as well, as with IteratorOptions.StripPrefix. For example,
+// with data stored under a prefix per tenant, this gives a view across a set of tenants, sorted by
+// the keys within the tenants. Keys which are equal once stripped are returned in the order of
+// their prefixes in prefixes; Item.FullKey tells them apart. Otherwise, the keys are sorted as is,
+// which is the same as iterating over each prefix in turn.
+//
+// The iterator uses the default iterator options, and iterates forward. Seek takes a stripped key
+// if stripPrefix is set, and Rewind goes back to the smallest key. Cursor isn't supported and
+// returns nil. ErrInvalidPrefixes is returned if no prefix is given, or if one of the prefixes is
+// empty or starts with another one, as keys would then be returned twice.
+func (txn *Txn) NewMultiPrefixIterator(prefixes [][]byte, stripPrefix bool) (*Iterator, error) {
+	if len(prefixes) == 0 {
+		return nil, ErrInvalidPrefixes
+	}
+	for i, p := range prefixes {
+		if len(p) == 0 {
+			return nil, ErrInvalidPrefixes
+		}
+		for j, q := range prefixes {
+			if i != j && bytes.HasPrefix(p, q) {
+				return nil, ErrInvalidPrefixes
+			}
+		}
+	}
+
+	if txn.discarded {
+		return nil, ErrDiscardedTxn
+	}
+	if txn.db.IsClosed() {
+		return nil, ErrDBClosed
+	}
+	// The iterator takes a single Options.MaxConcurrentIterators slot, whatever the number of
+	// prefixes.
+	if err := txn.db.acquireIteratorSlot(txn.db.opt.BlockOnMaxIterators); err != nil {
+		return nil, err
+	}
+
+	opt := DefaultIteratorOptions
+	opt.StripPrefix = stripPrefix
+	m := &multiPrefixIterator{prefixes: prefixes}
+	for _, p := range prefixes {
+		o := opt
+		o.Prefix = p
+		o.internal = true
+		m.iters = append(m.iters, txn.NewIterator(o))
+	}
+	return &Iterator{txn: txn, opt: opt, multi: m, slotted: txn.db.iteratorSlots != nil}, nil
+}
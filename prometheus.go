@@ -0,0 +1,148 @@
+/*
+ * Copyright 2024 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0xEggTart/badger/y"
+)
+
+// WritePrometheusMetrics writes the metrics of the DB to w in the Prometheus text exposition
+// format, which can be served as is by a /metrics handler. All metric names have the badger_
+// prefix.
+//
+// The output includes the process wide metrics that Badger publishes via expvar, which are only
+// updated if MetricsEnabled is set, along with metrics of this DB: cache hits and misses, the
+// size of each level, compactions, write stalls and, if LatencyTracking is set, the latency
+// histograms.
+func (db *DB) WritePrometheusMetrics(w io.Writer) error {
+	pw := &promWriter{w: w}
+	for _, m := range y.Metrics() {
+		typ := "counter"
+		if m.Gauge {
+			typ = "gauge"
+		}
+		pw.header(m.Name, typ, m.Help)
+		if vars, ok := m.Var.(*expvar.Map); ok {
+			vars.Do(func(kv expvar.KeyValue) {
+				pw.sample(m.Name, promLabel(m.Label, kv.Key), kv.Value.String())
+			})
+			continue
+		}
+		pw.sample(m.Name, "", m.Var.String())
+	}
+
+	pw.header("badger_hit_num_cache", "counter", "Number of hits in the block and index caches.")
+	if m := db.BlockCacheMetrics(); m != nil {
+		pw.sample("badger_hit_num_cache", promLabel("cache", "block"), m.Hits())
+	}
+	if m := db.IndexCacheMetrics(); m != nil {
+		pw.sample("badger_hit_num_cache", promLabel("cache", "index"), m.Hits())
+	}
+	pw.header("badger_miss_num_cache", "counter", "Number of misses in the block and index caches.")
+	if m := db.BlockCacheMetrics(); m != nil {
+		pw.sample("badger_miss_num_cache", promLabel("cache", "block"), m.Misses())
+	}
+	if m := db.IndexCacheMetrics(); m != nil {
+		pw.sample("badger_miss_num_cache", promLabel("cache", "index"), m.Misses())
+	}
+
+	levels := db.Levels()
+	pw.header("badger_size_bytes_level", "gauge", "Size of the tables at each LSM level in bytes.")
+	for _, l := range levels {
+		pw.sample("badger_size_bytes_level", promLabel("level", strconv.Itoa(l.Level)), l.Size)
+	}
+	pw.header("badger_table_num_level", "gauge", "Number of tables at each LSM level.")
+	for _, l := range levels {
+		pw.sample("badger_table_num_level", promLabel("level", strconv.Itoa(l.Level)), l.NumTables)
+	}
+	pw.header("badger_compaction_done_num_lsm", "counter",
+		"Number of compactions completed since the DB was opened.")
+	pw.sample("badger_compaction_done_num_lsm", "", db.lc.numCompactions.Load())
+	pw.header("badger_stall_seconds_l0", "counter",
+		"Time writes were stalled on level 0 since the DB was opened, in seconds.")
+	pw.sample("badger_stall_seconds_l0", "", time.Duration(db.lc.l0stallsMs.Load()).Seconds())
+
+	stats := db.LatencyStats()
+	ops := make([]string, 0, len(stats))
+	for op := range stats {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	for _, op := range ops {
+		pw.histogram("badger_latency_seconds", promLabel("op", op), stats[op])
+	}
+	return pw.err
+}
+
+// promWriter writes metrics in the Prometheus text format. It keeps the first write error and
+// skips all writes after it.
+type promWriter struct {
+	w             io.Writer
+	err           error
+	lastHistogram string // Name of the last histogram whose header was written.
+}
+
+func (pw *promWriter) printf(format string, args ...interface{}) {
+	if pw.err != nil {
+		return
+	}
+	_, pw.err = fmt.Fprintf(pw.w, format, args...)
+}
+
+func (pw *promWriter) header(name, typ, help string) {
+	pw.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes one value of the metric. labels must be formatted by promLabel.
+func (pw *promWriter) sample(name, labels string, value interface{}) {
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	pw.printf("%s%s %v\n", name, labels, value)
+}
+
+func (pw *promWriter) histogram(name, labels string, h *Histogram) {
+	if pw.lastHistogram != name {
+		pw.header(name, "histogram", "Latency of Badger operations in seconds.")
+		pw.lastHistogram = name
+	}
+	var cum int64
+	for i, c := range h.Counts {
+		cum += c
+		le := "+Inf"
+		if i < len(h.Counts)-1 {
+			le = strconv.FormatFloat(h.Bounds[i].Seconds(), 'g', -1, 64)
+		}
+		pw.sample(name+"_bucket", labels+","+promLabel("le", le), cum)
+	}
+	pw.sample(name+"_sum", labels, h.Sum.Seconds())
+	pw.sample(name+"_count", labels, h.Count)
+}
+
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func promLabel(name, value string) string {
+	return name + `="` + promLabelEscaper.Replace(value) + `"`
+}
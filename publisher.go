@@ -42,14 +42,16 @@ type publisher struct {
 	subscribers map[uint64]subscriber
 	nextID      uint64
 	indexer     *trie.Trie
+	keyRead     func([]byte) []byte // See Options.KeyReadTransform.
 }
 
-func newPublisher() *publisher {
+func newPublisher(keyRead func([]byte) []byte) *publisher {
 	return &publisher{
 		pubCh:       make(chan requests, 1000),
 		subscribers: make(map[uint64]subscriber),
 		nextID:      0,
 		indexer:     trie.NewTrie(),
+		keyRead:     keyRead,
 	}
 }
 
@@ -94,10 +96,14 @@ func (p *publisher) publishUpdates(reqs requests) {
 				continue
 			}
 			k := y.SafeCopy(nil, e.Key)
+			key := y.ParseKey(k)
+			if p.keyRead != nil {
+				key = p.keyRead(key)
+			}
 			kv := &pb.KV{
-				Key:       y.ParseKey(k),
+				Key:       key,
 				Value:     y.SafeCopy(nil, e.Value),
-				Meta:      []byte{e.UserMeta},
+				Meta:      encodeUserMeta(e.UserMeta, e.userMetaHigh),
 				ExpiresAt: e.ExpiresAt,
 				Version:   y.ParseTs(k),
 			}
@@ -0,0 +1,94 @@
+/*
+ * Copyright 2024 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"sync"
+	"time"
+)
+
+// compactionRateChunk is the number of bytes a compaction goes through between calls to the rate
+// limiter.
+const compactionRateChunk = 64 << 10
+
+// rateLimiter is a token bucket which limits the rate of bytes going through it. Tokens build up at
+// rate bytes per second, up to a second worth of them. A zero rate means no limit.
+type rateLimiter struct {
+	sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+	stop   chan struct{}
+}
+
+func newRateLimiter(rate int64) *rateLimiter {
+	l := &rateLimiter{stop: make(chan struct{})}
+	l.setRate(rate)
+	return l
+}
+
+// setRate changes the limit to rate bytes per second, zero for no limit.
+func (l *rateLimiter) setRate(rate int64) {
+	l.Lock()
+	defer l.Unlock()
+	l.rate = rate
+	l.tokens = float64(rate)
+	l.last = time.Now()
+}
+
+// wait takes n tokens from the bucket, blocking until they are available. A request for more tokens
+// than the bucket holds runs the bucket into debt, which later requests have to wait out. wait
+// returns right away once the limiter is closed.
+func (l *rateLimiter) wait(n int) {
+	l.Lock()
+	if l.rate <= 0 {
+		l.Unlock()
+		return
+	}
+	now := time.Now()
+	rate := float64(l.rate)
+	l.tokens = min(rate, l.tokens+now.Sub(l.last).Seconds()*rate)
+	l.last = now
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / rate * float64(time.Second))
+	}
+	l.Unlock()
+
+	if delay == 0 {
+		return
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-l.stop:
+	}
+}
+
+// close releases the waiters, and makes later calls to wait return right away.
+func (l *rateLimiter) close() {
+	l.Lock()
+	defer l.Unlock()
+	l.rate = 0
+	select {
+	case <-l.stop:
+	default:
+		close(l.stop)
+	}
+}
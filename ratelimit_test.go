@@ -0,0 +1,83 @@
+/*
+ * Copyright 2024 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(1 << 20)
+	// A full bucket doesn't block.
+	start := time.Now()
+	l.wait(1 << 20)
+	require.Less(t, time.Since(start), 100*time.Millisecond)
+
+	// The bucket is empty, so this has to wait for it to fill up.
+	start = time.Now()
+	l.wait(256 << 10)
+	require.Greater(t, time.Since(start), 200*time.Millisecond)
+
+	// Raising the limit refills the bucket.
+	l.setRate(1 << 30)
+	start = time.Now()
+	l.wait(1 << 20)
+	require.Less(t, time.Since(start), 100*time.Millisecond)
+
+	// Closing the limiter releases the waiters.
+	l.setRate(1)
+	done := make(chan struct{})
+	go func() {
+		l.wait(1 << 20)
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	l.close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("wait didn't return after close")
+	}
+	l.wait(1 << 30)
+}
+
+func TestCompactionRateLimit(t *testing.T) {
+	opt := getTestOptions("")
+	opt.CompactionRateLimit = -1
+	_, err := Open(opt)
+	require.Error(t, err)
+
+	opt = DefaultOptions("").WithInMemory(true).WithCompactionRateLimit(1 << 20)
+	opt.BaseTableSize = 1 << 15
+	opt.MemTableSize = 1 << 15
+	opt.ValueThreshold = 1 << 10
+	db, err := Open(opt)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+
+	for i := 0; i < 4000; i++ {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set([]byte(key("key", i)), val(false))
+		}))
+	}
+	db.SetCompactionRateLimit(0)
+	require.NoError(t, db.Flatten(1))
+	require.Equal(t, 4000, numKeys(db))
+}
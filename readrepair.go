@@ -0,0 +1,143 @@
+/*
+ * Copyright 2024 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/dgraph-io/ristretto/v2/z"
+)
+
+const (
+	// readRepairSampleRate is the share of the reads counted by the read repair. Only one in
+	// readRepairSampleRate reads is counted, as readRepairSampleRate reads.
+	readRepairSampleRate = 4
+	// readRepairMaxKeys bounds the number of keys whose reads are counted. Once it's reached, all
+	// the counts are halved, and the keys whose count drops to zero are forgotten.
+	readRepairMaxKeys = 1 << 16
+	// readRepairChCapacity is the number of keys which can be queued up to be rewritten. Keys which
+	// reach the threshold while the queue is full are not rewritten.
+	readRepairChCapacity = 1000
+)
+
+// readRepair counts the reads of the keys, and rewrites the keys read more than
+// Options.ReadRepair times which are found deep in the LSM tree. See Options.ReadRepair.
+type readRepair struct {
+	sync.Mutex
+	db        *DB
+	threshold uint32
+	counts    map[uint64]uint32 // Sampled reads, by the hash of the key.
+	ch        chan []byte       // Keys to rewrite.
+}
+
+func newReadRepair(db *DB) *readRepair {
+	return &readRepair{
+		db:        db,
+		threshold: uint32(db.opt.ReadRepair),
+		counts:    make(map[uint64]uint32),
+		ch:        make(chan []byte, readRepairChCapacity),
+	}
+}
+
+// record counts a read of key, and queues up the key to be rewritten once it reaches the
+// threshold. It doesn't block, and only counts a sample of the reads.
+func (rr *readRepair) record(key []byte) {
+	if rr.db.randUint32()%readRepairSampleRate != 0 {
+		return
+	}
+	h := z.MemHash(key)
+
+	rr.Lock()
+	count := rr.counts[h] + readRepairSampleRate
+	if count < rr.threshold {
+		if _, ok := rr.counts[h]; !ok && len(rr.counts) >= readRepairMaxKeys {
+			rr.age()
+		}
+		rr.counts[h] = count
+		rr.Unlock()
+		return
+	}
+	delete(rr.counts, h)
+	rr.Unlock()
+
+	select {
+	case rr.ch <- append([]byte{}, key...):
+	default:
+	}
+}
+
+// age halves all the counts, so that the keys which aren't read anymore are forgotten. Must be
+// called with the lock held.
+func (rr *readRepair) age() {
+	for h, count := range rr.counts {
+		if count /= 2; count == 0 {
+			delete(rr.counts, h)
+		} else {
+			rr.counts[h] = count
+		}
+	}
+}
+
+// run rewrites the queued keys until the closer is signalled. The keys left in the queue are
+// dropped.
+func (rr *readRepair) run(lc *z.Closer) {
+	defer lc.Done()
+	for {
+		select {
+		case key := <-rr.ch:
+			if err := rr.repair(key); err != nil {
+				rr.db.opt.Debugf("Read repair of key %q failed: %v", key, err)
+			}
+		case <-lc.HasBeenClosed():
+			return
+		}
+	}
+}
+
+// repair rewrites key if its newest version lies below the base level, the level into which L0
+// is compacted. The version is written again into the memtable at the same timestamp, like the
+// value log GC moves values, so that the key reads the same, and the transactions which read it
+// don't conflict with the repair. The copy points at the value in the value log instead of copying
+// it, like with DB.RefreshTTL. Keys written concurrently are skipped, as they are shallow already.
+func (rr *readRepair) repair(key []byte) error {
+	level, found, err := rr.db.KeyLevel(key)
+	if err != nil || !found || level <= rr.db.lc.levelTargets().baseLevel {
+		return err
+	}
+	err = rr.db.Update(func(txn *Txn) error {
+		item, err := txn.Get(key)
+		if err != nil {
+			return err
+		}
+		e, err := txn.touchEntry(key, item, item.ExpiresAt())
+		if err != nil {
+			return err
+		}
+		e.version = item.Version()
+		if err := txn.modify(e); err != nil {
+			return err
+		}
+		// Only the read of the repair is checked for conflicts.
+		delete(txn.conflictKeys, z.MemHash(e.Key))
+		return nil
+	})
+	if errors.Is(err, ErrConflict) || errors.Is(err, ErrKeyNotFound) {
+		return nil
+	}
+	return err
+}
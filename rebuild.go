@@ -0,0 +1,166 @@
+/*
+ * Copyright 2024 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package badger
+
+import (
+	"math"
+
+	"github.com/pkg/errors"
+
+	"github.com/0xEggTart/badger/y"
+)
+
+// RebuildValueLogFile rewrites the values held by the value log file fid, taking them from source
+// instead of the file, and then deletes the file. It is meant for recovering from the corruption
+// of a file whose values are also found elsewhere, like in a replica. source is called with the
+// key and the version of every version kept in the LSM tree which points into the file, and
+// returns the value of that version, or false if it doesn't have it. The values are written back
+// under the same versions, like value log GC does, so reads return them from then on. A source
+// which only knows the latest values must return false for the older versions, rather than rewrite
+// history with the latest value.
+//
+// If source doesn't have the value of some version, the others are still rewritten, but the file
+// is kept and an error is returned. The file being written to can't be rebuilt. Value log GC
+// doesn't run meanwhile; ErrRejected is returned if it is already running.
+func (db *DB) RebuildValueLogFile(
+	fid uint32, source func(key []byte, version uint64) ([]byte, bool)) error {
+	if db.opt.InMemory || db.opt.ReadOnly {
+		return ErrInvalidRequest
+	}
+	vlog := db.valueLogFor(valuePointer{Fid: fid})
+	vlog.filesLock.RLock()
+	lf, ok := vlog.filesMap[fid]
+	maxFid := vlog.maxFid
+	vlog.filesLock.RUnlock()
+	if !ok {
+		return errors.Errorf("Value log file %d not found", fid)
+	}
+	if fid == maxFid {
+		return errors.Errorf("Cannot rebuild value log file %d, it is being written to", fid)
+	}
+	select {
+	case vlog.garbageCh <- struct{}{}:
+		defer func() { <-vlog.garbageCh }()
+	default:
+		return ErrRejected
+	}
+
+	var missing, rebuilt int
+	var batch []*Entry
+	var size int64
+	err := db.pointersInto(fid, func(key []byte, item *Item) error {
+		val, ok := source(y.ParseKey(key), y.ParseTs(key))
+		if !ok {
+			missing++
+			return nil
+		}
+		e := &Entry{
+			Key:       y.Copy(key),
+			Value:     y.Copy(val),
+			UserMeta:  item.UserMeta(),
+			ExpiresAt: item.ExpiresAt(),
+			meta:      item.meta &^ (bitValuePointer | bitTxn | bitFinTxn),
+
+			userMetaHigh: item.userMetaHigh,
+		}
+		es := e.estimateSizeAndSetThreshold(db.entryValueThreshold(e.Key)) + int64(len(e.Value))
+		if int64(len(batch)+1) >= db.opt.maxBatchCount || size+es >= db.opt.maxBatchSize {
+			if err := db.batchSet(batch); err != nil {
+				return err
+			}
+			batch, size = nil, 0
+		}
+		batch = append(batch, e)
+		size += es
+		rebuilt++
+		return nil
+	})
+	if err == nil && len(batch) > 0 {
+		err = db.batchSet(batch)
+	}
+	if err != nil {
+		return y.Wrapf(err, "while rebuilding value log file %d", fid)
+	}
+	if missing > 0 {
+		return errors.Errorf("Rebuilt %d values of value log file %d, %d values were not found "+
+			"in the source, so the file is kept", rebuilt, fid, missing)
+	}
+
+	// Check that no version points into the file anymore.
+	var left int
+	if err := db.pointersInto(fid, func([]byte, *Item) error {
+		left++
+		return nil
+	}); err != nil {
+		return err
+	}
+	if left > 0 {
+		return errors.Errorf("%d versions still point into value log file %d after the rebuild",
+			left, fid)
+	}
+	vlog.opt.Infof("Rebuilt %d values of value log file %d, removing it", rebuilt, fid)
+
+	var deleteFileNow bool
+	vlog.filesLock.Lock()
+	if vlog.iteratorCount() == 0 {
+		delete(vlog.filesMap, fid)
+		deleteFileNow = true
+	} else {
+		vlog.filesToBeDeleted = append(vlog.filesToBeDeleted, fid)
+	}
+	vlog.filesLock.Unlock()
+	vlog.discardStats.Update(fid, -1)
+	if deleteFileNow {
+		return vlog.deleteLogFile(lf)
+	}
+	return nil
+}
+
+// pointersInto calls fn with the key, including the version, of every version kept in the LSM
+// tree whose value is in the value log file fid.
+func (db *DB) pointersInto(fid uint32, fn func(key []byte, item *Item) error) error {
+	var txn *Txn
+	if db.opt.managedTxns {
+		txn = db.NewTransactionAt(math.MaxUint64, false)
+	} else {
+		txn = db.NewTransaction(false)
+	}
+	defer txn.Discard()
+
+	iopt := DefaultIteratorOptions
+	iopt.AllVersions = true
+	iopt.InternalAccess = true
+	iopt.PrefetchValues = false
+	iopt.rawKeys = true
+	iopt.internal = true
+	it := txn.NewIterator(iopt)
+	defer it.Close()
+	var vp valuePointer
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		if item.meta&bitValuePointer == 0 || item.IsDeletedOrExpired() {
+			continue
+		}
+		vp.Decode(item.vptr)
+		if vp.Fid != fid {
+			continue
+		}
+		if err := fn(y.KeyWithTs(item.Key(), item.Version()), item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
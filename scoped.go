@@ -0,0 +1,92 @@
+/*
+ * Copyright 2024 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+
+	"github.com/0xEggTart/badger/y"
+)
+
+// ScopedDB is a read-only handle on the keys of a DB under a prefix, returned by DB.ScopedView.
+// It has no write methods, and its transactions can't read the keys outside the prefix, so it can
+// be handed to code which must not see or change the rest of the DB.
+type ScopedDB struct {
+	db     *DB
+	prefix []byte
+}
+
+// ScopedTxn is a read-only transaction of a ScopedDB.
+type ScopedTxn struct {
+	txn    *Txn
+	prefix []byte
+}
+
+// ScopedView returns a read-only handle on the keys which start with prefix. The keys given to
+// and returned by the handle are full keys, prefix included.
+func (db *DB) ScopedView(prefix []byte) *ScopedDB {
+	return &ScopedDB{db: db, prefix: y.SafeCopy(nil, prefix)}
+}
+
+// Prefix returns the prefix of the keys visible through s.
+func (s *ScopedDB) Prefix() []byte {
+	return s.prefix
+}
+
+// View executes fn within a read-only transaction limited to the prefix of s. See DB.View.
+func (s *ScopedDB) View(fn func(txn *ScopedTxn) error) error {
+	return s.db.View(func(txn *Txn) error {
+		return fn(&ScopedTxn{txn: txn, prefix: s.prefix})
+	})
+}
+
+// Get looks up the key like Txn.Get. ErrKeyNotFound is returned for the keys outside the prefix.
+func (st *ScopedTxn) Get(key []byte) (*Item, error) {
+	if !bytes.HasPrefix(key, st.prefix) {
+		if len(key) == 0 {
+			return nil, ErrEmptyKey
+		}
+		return nil, st.txn.db.keyNotFound(key)
+	}
+	return st.txn.Get(key)
+}
+
+// NewIterator returns an iterator like Txn.NewIterator, which only yields the keys under the
+// prefix. If opt.Prefix is set, it must start with the prefix, or be a prefix of it. In the latter
+// case, StripPrefix still strips opt.Prefix only. Otherwise, ErrOutOfScope is returned.
+func (st *ScopedTxn) NewIterator(opt IteratorOptions) (*Iterator, error) {
+	stripLen := len(opt.Prefix)
+	switch {
+	case bytes.HasPrefix(opt.Prefix, st.prefix):
+	case bytes.HasPrefix(st.prefix, opt.Prefix):
+		opt.Prefix = st.prefix
+	default:
+		return nil, ErrOutOfScope
+	}
+	it := st.txn.NewIterator(opt)
+	it.stripLen = stripLen
+	return it, nil
+}
+
+// NewKeyIterator returns an iterator over the versions of key, like Txn.NewKeyIterator. The key
+// must be under the prefix, otherwise ErrOutOfScope is returned.
+func (st *ScopedTxn) NewKeyIterator(key []byte, opt IteratorOptions) (*Iterator, error) {
+	if !bytes.HasPrefix(key, st.prefix) {
+		return nil, ErrOutOfScope
+	}
+	return st.txn.NewKeyIterator(key, opt), nil
+}
@@ -17,6 +17,7 @@
 package skl
 
 import (
+	"encoding/binary"
 	"sync/atomic"
 	"unsafe"
 
@@ -55,12 +56,9 @@ func (s *Arena) size() int64 {
 // putNode allocates a node in the arena. The node is aligned on a pointer-sized
 // boundary. The arena offset of the node is returned.
 func (s *Arena) putNode(height int) uint32 {
-	// Compute the amount of the tower that will never be used, since the height
-	// is less than maxHeight.
-	unusedSize := (maxHeight - height) * offsetSize
-
+	// The part of the tower above the height will never be used, so it isn't allocated.
 	// Pad the allocation with enough bytes to ensure pointer alignment.
-	l := uint32(MaxNodeSize - unusedSize + nodeAlign)
+	l := uint32(NodeSize(height) + nodeAlign)
 	n := s.n.Add(l)
 	y.AssertTruef(int(n) <= len(s.buf),
 		"Arena too small, toWrite:%d newTotal:%d limit:%d",
@@ -71,18 +69,30 @@ func (s *Arena) putNode(height int) uint32 {
 	return m
 }
 
+// valSize returns the size of v once put into the arena. Unlike y.ValueStruct.Encode, the arena
+// always keeps the high byte of the user meta, so that any Meta is stored as it is.
+func valSize(v y.ValueStruct) uint32 {
+	var enc [binary.MaxVarintLen64]byte
+	return uint32(3 + binary.PutUvarint(enc[:], v.ExpiresAt) + len(v.Value))
+}
+
 // Put will *copy* val into arena. To make better use of this, reuse your input
 // val buffer. Returns an offset into buf. User is responsible for remembering
 // size of val. We could also store this size inside arena but the encoding and
 // decoding will incur some overhead.
 func (s *Arena) putVal(v y.ValueStruct) uint32 {
-	l := v.EncodedSize()
+	l := valSize(v)
 	n := s.n.Add(l)
 	y.AssertTruef(int(n) <= len(s.buf),
 		"Arena too small, toWrite:%d newTotal:%d limit:%d",
 		l, n, len(s.buf))
 	m := n - l
-	v.Encode(s.buf[m:])
+	b := s.buf[m:n]
+	b[0] = v.Meta
+	b[1] = v.UserMeta
+	b[2] = v.UserMetaHigh
+	sz := binary.PutUvarint(b[3:], v.ExpiresAt)
+	copy(b[3+sz:], v.Value)
 	return m
 }
 
@@ -118,7 +128,13 @@ func (s *Arena) getKey(offset uint32, size uint16) []byte {
 // getVal returns byte slice at offset. The given size should be just the value
 // size and should NOT include the meta bytes.
 func (s *Arena) getVal(offset uint32, size uint32) (ret y.ValueStruct) {
-	ret.Decode(s.buf[offset : offset+size])
+	b := s.buf[offset : offset+size]
+	ret.Meta = b[0]
+	ret.UserMeta = b[1]
+	ret.UserMetaHigh = b[2]
+	var sz int
+	ret.ExpiresAt, sz = binary.Uvarint(b[3:])
+	ret.Value = b[3+sz:]
 	return
 }
 
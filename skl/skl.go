@@ -42,12 +42,27 @@ import (
 )
 
 const (
-	maxHeight      = 20
-	heightIncrease = math.MaxUint32 / 3
+	// MaxHeight is the largest maximum height a skiplist can be configured with.
+	MaxHeight = 32
+	// DefaultMaxHeight is the maximum height of the nodes of a skiplist made by NewSkiplist.
+	DefaultMaxHeight = 20
+	// DefaultProbability is the probability with which the nodes of a skiplist made by NewSkiplist
+	// grow by another level.
+	DefaultProbability = 1.0 / 3
 )
 
-// MaxNodeSize is the memory footprint of a node of maximum height.
-const MaxNodeSize = int(unsafe.Sizeof(node{}))
+// fullNodeSize is the memory footprint of a node of height MaxHeight.
+const fullNodeSize = int(unsafe.Sizeof(node{}))
+
+// MaxNodeSize is the memory footprint of a node of maximum height in a skiplist made by
+// NewSkiplist, which is DefaultMaxHeight. Use NodeSize for skiplists made by NewSkiplistWithHeight.
+const MaxNodeSize = fullNodeSize - (MaxHeight-DefaultMaxHeight)*offsetSize
+
+// NodeSize returns the memory footprint of a node of the given height, which is at most
+// MaxHeight.
+func NodeSize(height int) int {
+	return fullNodeSize - (MaxHeight-height)*offsetSize
+}
 
 type node struct {
 	// Multiple parts of the value are encoded as a single uint64 so that it
@@ -70,15 +85,20 @@ type node struct {
 	// is deliberately truncated to not include unneeded tower elements.
 	//
 	// All accesses to elements should use CAS operations, with no need to lock.
-	tower [maxHeight]atomic.Uint32
+	tower [MaxHeight]atomic.Uint32
 }
 
 type Skiplist struct {
-	height  atomic.Int32 // Current height. 1 <= height <= kMaxHeight. CAS.
+	height  atomic.Int32 // Current height. 1 <= height <= maxHeight. CAS.
 	head    *node
 	ref     atomic.Int32
 	arena   *Arena
 	OnClose func()
+	// Rand, if set, is used instead of z.FastRand to pick the height of new nodes.
+	Rand func() uint32
+
+	maxHeight      int    // The maximum height of the nodes.
+	heightIncrease uint32 // A node grows by another level if a random number is at most this.
 }
 
 // IncrRef increases the refcount
@@ -111,7 +131,7 @@ func newNode(arena *Arena, key []byte, v y.ValueStruct, height int) *node {
 	node.keyOffset = arena.putKey(key)
 	node.keySize = uint16(len(key))
 	node.height = uint16(height)
-	node.value.Store(encodeValue(arena.putVal(v), v.EncodedSize()))
+	node.value.Store(encodeValue(arena.putVal(v), valSize(v)))
 	return node
 }
 
@@ -127,9 +147,23 @@ func decodeValue(value uint64) (valOffset uint32, valSize uint32) {
 
 // NewSkiplist makes a new empty skiplist, with a given arena size
 func NewSkiplist(arenaSize int64) *Skiplist {
+	return NewSkiplistWithHeight(arenaSize, DefaultMaxHeight, DefaultProbability)
+}
+
+// NewSkiplistWithHeight makes a new empty skiplist like NewSkiplist, whose nodes are at most
+// maxHeight tall and grow by another level with probability p. The maxHeight must be between 1
+// and MaxHeight, and p must be in (0, 1).
+func NewSkiplistWithHeight(arenaSize int64, maxHeight int, p float64) *Skiplist {
+	y.AssertTruef(maxHeight >= 1 && maxHeight <= MaxHeight, "maxHeight=%d", maxHeight)
+	y.AssertTruef(p > 0 && p < 1, "p=%f", p)
 	arena := newArena(arenaSize)
 	head := newNode(arena, nil, y.ValueStruct{}, maxHeight)
-	s := &Skiplist{head: head, arena: arena}
+	s := &Skiplist{
+		head:           head,
+		arena:          arena,
+		maxHeight:      maxHeight,
+		heightIncrease: uint32(math.Round(p * math.MaxUint32)),
+	}
 	s.height.Store(1)
 	s.ref.Store(1)
 	return s
@@ -146,7 +180,7 @@ func (s *node) key(arena *Arena) []byte {
 
 func (s *node) setValue(arena *Arena, v y.ValueStruct) {
 	valOffset := arena.putVal(v)
-	value := encodeValue(valOffset, v.EncodedSize())
+	value := encodeValue(valOffset, valSize(v))
 	s.value.Store(value)
 }
 
@@ -166,8 +200,12 @@ func (s *node) casNextOffset(h int, old, val uint32) bool {
 //}
 
 func (s *Skiplist) randomHeight() int {
+	random := z.FastRand
+	if s.Rand != nil {
+		random = s.Rand
+	}
 	h := 1
-	for h < maxHeight && z.FastRand() <= heightIncrease {
+	for h < s.maxHeight && random() <= s.heightIncrease {
 		h++
 	}
 	return h
@@ -286,8 +324,8 @@ func (s *Skiplist) Put(key []byte, v y.ValueStruct) {
 	// increase the height. Let's defer these actions.
 
 	listHeight := s.getHeight()
-	var prev [maxHeight + 1]*node
-	var next [maxHeight + 1]*node
+	var prev [MaxHeight + 1]*node
+	var next [MaxHeight + 1]*node
 	prev[listHeight] = s.head
 	next[listHeight] = nil
 	for i := int(listHeight) - 1; i >= 0; i-- {
@@ -25,6 +25,7 @@ import (
 	"sync/atomic"
 	"testing"
 	"time"
+	"unsafe"
 
 	"github.com/stretchr/testify/require"
 
@@ -128,6 +129,30 @@ func TestBasic(t *testing.T) {
 	require.EqualValues(t, 60, v.Meta)
 }
 
+// TestWideMeta checks that the skiplist keeps any Meta, along with the high byte of the user meta,
+// as the values it stores aren't encoded with y.BitUserMetaHigh.
+func TestWideMeta(t *testing.T) {
+	l := NewSkiplist(arenaSize)
+	defer l.DecrRef()
+
+	l.Put(y.KeyWithTs([]byte("key1"), 0),
+		y.ValueStruct{Value: []byte("v1"), Meta: 0xff, UserMeta: 0x01, UserMetaHigh: 0xab})
+	l.Put(y.KeyWithTs([]byte("key2"), 0),
+		y.ValueStruct{Value: []byte("v2"), Meta: y.BitUserMetaHigh, ExpiresAt: 10})
+
+	v := l.Get(y.KeyWithTs([]byte("key1"), 0))
+	require.EqualValues(t, "v1", v.Value)
+	require.EqualValues(t, 0xff, v.Meta)
+	require.EqualValues(t, 0x01, v.UserMeta)
+	require.EqualValues(t, 0xab, v.UserMetaHigh)
+
+	v = l.Get(y.KeyWithTs([]byte("key2"), 0))
+	require.EqualValues(t, "v2", v.Value)
+	require.EqualValues(t, y.BitUserMetaHigh, v.Meta)
+	require.Zero(t, v.UserMetaHigh)
+	require.EqualValues(t, 10, v.ExpiresAt)
+}
+
 // TestConcurrentBasic tests concurrent writes followed by concurrent reads.
 func TestConcurrentBasic(t *testing.T) {
 	const n = 1000
@@ -448,6 +473,39 @@ func TestIteratorSeek(t *testing.T) {
 	require.EqualValues(t, "01990", v.Value)
 }
 
+func TestSkiplistWithHeight(t *testing.T) {
+	for _, tc := range []struct {
+		maxHeight int
+		p         float64
+	}{{1, 0.5}, {MaxHeight, 0.9}} {
+		l := NewSkiplistWithHeight(arenaSize, tc.maxHeight, tc.p)
+		for i := 0; i < 1000; i++ {
+			key := y.KeyWithTs([]byte(fmt.Sprintf("%05d", i)), 0)
+			l.Put(key, y.ValueStruct{Value: newValue(i)})
+		}
+		require.LessOrEqual(t, int(l.getHeight()), tc.maxHeight)
+		if tc.maxHeight > DefaultMaxHeight {
+			require.Greater(t, int(l.getHeight()), DefaultMaxHeight)
+		}
+
+		it := l.NewIterator()
+		var n int
+		for it.SeekToFirst(); it.Valid(); it.Next() {
+			require.EqualValues(t, newValue(n), it.Value().Value)
+			n++
+		}
+		require.Equal(t, 1000, n)
+		require.NoError(t, it.Close())
+		l.DecrRef()
+	}
+}
+
+func TestNodeSize(t *testing.T) {
+	require.Equal(t, NodeSize(DefaultMaxHeight), MaxNodeSize)
+	require.Equal(t, int(unsafe.Sizeof(node{})), NodeSize(MaxHeight))
+	require.Equal(t, MaxNodeSize+(MaxHeight-DefaultMaxHeight)*offsetSize, NodeSize(MaxHeight))
+}
+
 func randomKey(rng *rand.Rand) []byte {
 	b := make([]byte, 8)
 	key := rng.Uint32()
@@ -19,6 +19,7 @@ package badger
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
 	"sort"
 	"sync"
 	"sync/atomic"
@@ -91,6 +92,12 @@ type Stream struct {
 	// single goroutine, i.e. logic within Send method can expect single threaded execution.
 	Send func(buf *z.Buffer) error
 
+	// KeyAndPointerOnly makes ToList emit the value pointers of the values stored in the value
+	// log, instead of reading the values. The values stored in the LSM tree are emitted as usual.
+	// Use DecodeValuePointer to tell them apart, and DB.ReadValuePointer to fetch a value later.
+	// Such KVs can't be written with StreamWriter.
+	KeyAndPointerOnly bool
+
 	// Read data above the sinceTs. All keys with version =< sinceTs will be ignored.
 	SinceTs      uint64
 	readTs       uint64
@@ -128,7 +135,12 @@ func (st *Stream) ToList(key []byte, itr *Iterator) (*pb.KVList, error) {
 		kv := y.NewKV(a)
 		kv.Key = ka
 
-		if err := item.Value(func(val []byte) error {
+		if st.KeyAndPointerOnly && item.meta&bitValuePointer > 0 {
+			var vp valuePointer
+			vp.Decode(item.vptr)
+			kv.Value = a.Copy(encodeValuePointer(vp))
+			kv.Meta = a.Copy([]byte{bitValuePointer, kvValuePointer})
+		} else if err := item.Value(func(val []byte) error {
 			kv.Value = a.Copy(val)
 			return nil
 
@@ -137,7 +149,7 @@ func (st *Stream) ToList(key []byte, itr *Iterator) (*pb.KVList, error) {
 		}
 		kv.Version = item.Version()
 		kv.ExpiresAt = item.ExpiresAt()
-		kv.UserMeta = a.Copy([]byte{item.UserMeta()})
+		kv.UserMeta = a.Copy(encodeUserMeta(item.userMeta, item.userMetaHigh))
 
 		list.Kv = append(list.Kv, kv)
 		if st.db.opt.NumVersionsToKeep == 1 {
@@ -178,9 +190,15 @@ func (st *Stream) produceKVs(ctx context.Context, threadId int) error {
 	defer st.numProducers.Add(-1)
 
 	var txn *Txn
-	if st.readTs > 0 {
+	switch {
+	case st.readTs > 0 && st.db.opt.managedTxns:
 		txn = st.db.NewTransactionAt(st.readTs, false)
-	} else {
+	case st.readTs > 0:
+		// The read mark of readTs is held by whoever picked it, like DB.CompactTo.
+		txn = st.db.newTransaction(false, true)
+		txn.readTs = st.readTs
+		txn.doneRead = true
+	default:
 		txn = st.db.NewTransaction(false)
 	}
 	defer txn.Discard()
@@ -199,6 +217,8 @@ func (st *Stream) produceKVs(ctx context.Context, threadId int) error {
 		iterOpts.Prefix = st.Prefix
 		iterOpts.PrefetchValues = false
 		iterOpts.SinceTs = st.SinceTs
+		iterOpts.rawKeys = true
+		iterOpts.internal = true
 		itr := txn.NewIterator(iterOpts)
 		itr.ThreadId = threadId
 		defer itr.Close()
@@ -501,3 +521,59 @@ func KVToBuffer(kv *pb.KV, buf *z.Buffer) {
 	_, err := proto.MarshalOptions{}.MarshalAppend(in, kv)
 	y.AssertTrue(err == nil)
 }
+
+// ValuePointer is the location of a value in the value log, as emitted by Stream with
+// KeyAndPointerOnly set.
+type ValuePointer struct {
+	Fid    uint32
+	Len    uint32
+	Offset uint32
+}
+
+// kvValuePointer is set in the second byte of the meta of the KVs which carry a value pointer
+// instead of the value, see KeyAndPointerOnly. The first byte, the meta of the entry, can't tell
+// them apart, as DB.Backup keeps bitValuePointer in the KVs of the values read from the value log.
+const kvValuePointer byte = 1 << 0
+
+// isValuePointerKV tells whether kv carries a value pointer instead of the value.
+func isValuePointerKV(kv *pb.KV) bool {
+	return len(kv.Meta) > 1 && kv.Meta[1]&kvValuePointer > 0
+}
+
+func encodeValuePointer(vp valuePointer) []byte {
+	var b [12]byte
+	binary.BigEndian.PutUint32(b[0:4], vp.Fid)
+	binary.BigEndian.PutUint32(b[4:8], vp.Len)
+	binary.BigEndian.PutUint32(b[8:12], vp.Offset)
+	return b[:]
+}
+
+// DecodeValuePointer returns the value pointer carried by a KV emitted by Stream with
+// KeyAndPointerOnly set. It returns false if the KV carries the value itself.
+func DecodeValuePointer(kv *pb.KV) (ValuePointer, bool) {
+	if !isValuePointerKV(kv) || len(kv.Value) != 12 {
+		return ValuePointer{}, false
+	}
+	return ValuePointer{
+		Fid:    binary.BigEndian.Uint32(kv.Value[0:4]),
+		Len:    binary.BigEndian.Uint32(kv.Value[4:8]),
+		Offset: binary.BigEndian.Uint32(kv.Value[8:12]),
+	}, true
+}
+
+// ReadValuePointer reads the value at the given value pointer, see DecodeValuePointer. The
+// pointer stays valid until value log GC rewrites the value log file it points into, after which
+// ReadValuePointer returns an error.
+func (db *DB) ReadValuePointer(p ValuePointer) ([]byte, error) {
+	if db.IsClosed() {
+		return nil, ErrDBClosed
+	}
+	vp := valuePointer{Fid: p.Fid, Len: p.Len, Offset: p.Offset}
+	vlog := db.valueLogFor(vp)
+	buf, cb, err := vlog.Read(vp, nil)
+	defer runCallback(cb)
+	if err != nil {
+		return nil, err
+	}
+	return y.SafeCopy(nil, buf), nil
+}
@@ -385,3 +385,63 @@ func TestStreamCustomKeyToList(t *testing.T) {
 	require.NoError(t, stream.Orchestrate(ctxb))
 	require.Zero(t, len(res))
 }
+
+func TestStreamKeyAndPointerOnly(t *testing.T) {
+	dir, err := os.MkdirTemp("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	db, err := OpenManaged(DefaultOptions(dir).WithValueThreshold(64))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+
+	small := []byte("small")
+	large := make([]byte, 1<<10)
+	for i := range large {
+		large[i] = byte(i)
+	}
+	txn := db.NewTransactionAt(math.MaxUint64, true)
+	for i := 1; i <= 100; i++ {
+		require.NoError(t, txn.SetEntry(NewEntry(keyWithPrefix("small", i), small)))
+		require.NoError(t, txn.SetEntry(NewEntry(keyWithPrefix("large", i), large)))
+	}
+	require.NoError(t, txn.CommitAt(5, nil))
+
+	stream := db.NewStreamAt(math.MaxUint64)
+	stream.KeyAndPointerOnly = true
+	c := &collector{}
+	stream.Send = c.Send
+	require.NoError(t, stream.Orchestrate(ctxb))
+	require.Equal(t, 200, len(c.kv))
+
+	for _, kv := range c.kv {
+		prefix, _ := keyToInt(kv.Key)
+		vp, ok := DecodeValuePointer(kv)
+		if prefix == "small" {
+			require.False(t, ok)
+			require.Equal(t, small, kv.Value)
+			continue
+		}
+		require.True(t, ok)
+		val, err := db.ReadValuePointer(vp)
+		require.NoError(t, err)
+		require.Equal(t, large, val)
+	}
+
+	// The value pointers can't be written into another DB.
+	dir2, err := os.MkdirTemp("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir2)
+	db2, err := OpenManaged(DefaultOptions(dir2))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db2.Close()) }()
+	sw := db2.NewStreamWriter()
+	require.NoError(t, sw.Prepare())
+	buf := z.NewBuffer(1<<10, "test")
+	defer func() { require.NoError(t, buf.Release()) }()
+	for _, kv := range c.kv {
+		KVToBuffer(kv, buf)
+	}
+	require.Error(t, sw.Write(buf))
+	sw.Cancel()
+}
@@ -50,8 +50,26 @@ type StreamWriter struct {
 	maxVersion uint64
 	writers    map[uint32]*sortedWriter
 	prevLevel  int
+
+	// OnDuplicate tells what to do when a stream has the same key at the same version twice in a
+	// row, possibly across calls to Write. It must be set before the first call to Write. The
+	// default is DuplicateError.
+	OnDuplicate DuplicatePolicy
 }
 
+// DuplicatePolicy tells how a StreamWriter handles duplicate keys. See StreamWriter.OnDuplicate.
+type DuplicatePolicy int
+
+const (
+	// DuplicateError fails the stream with ErrDuplicateKey, which is returned by Write when the
+	// stream is marked done, or by Flush.
+	DuplicateError DuplicatePolicy = iota
+	// DuplicateKeepLast keeps the last of the duplicates.
+	DuplicateKeepLast
+	// DuplicateKeepFirst keeps the first of the duplicates.
+	DuplicateKeepFirst
+)
+
 // NewStreamWriter creates a StreamWriter. Right after creating StreamWriter, Prepare must be
 // called. The memory usage of a StreamWriter is directly proportional to the number of streams
 // possible. So, efforts must be made to keep the number of streams low. Stream framework would
@@ -178,12 +196,21 @@ func (sw *StreamWriter) Write(buf *z.Buffer) error {
 		}
 		sw.writeLock.Unlock()
 
-		var meta, userMeta byte
+		var meta byte
 		if len(kv.Meta) > 0 {
 			meta = kv.Meta[0]
 		}
-		if len(kv.UserMeta) > 0 {
-			userMeta = kv.UserMeta[0]
+		if isValuePointerKV(&kv) {
+			// The value pointer only makes sense in the DB it comes from.
+			return errors.Errorf("cannot write the value pointer of key %q, streamed with "+
+				"KeyAndPointerOnly", kv.Key)
+		}
+		// KVs from DB.Backup keep bitValuePointer for the values read from the value log, but
+		// carry the value itself. Where it goes is decided again below.
+		meta &^= bitValuePointer
+		userMeta, userMetaHigh := decodeUserMeta(kv.UserMeta)
+		if userMetaHigh != 0 && !sw.db.opt.WideUserMeta {
+			return errors.Wrapf(ErrWideUserMeta, "key %q", kv.Key)
 		}
 		e := &Entry{
 			Key:       y.KeyWithTs(kv.Key, kv.Version),
@@ -191,6 +218,8 @@ func (sw *StreamWriter) Write(buf *z.Buffer) error {
 			UserMeta:  userMeta,
 			ExpiresAt: kv.ExpiresAt,
 			meta:      meta,
+
+			userMetaHigh: userMetaHigh,
 		}
 		// If the value can be collocated with the key in LSM tree, we can skip
 		// writing the value to value log.
@@ -217,7 +246,7 @@ func (sw *StreamWriter) Write(buf *z.Buffer) error {
 	// We are writing all requests to vlog even if some request belongs to already closed stream.
 	// It is safe to do because we are panicking while writing to sorted writer, which will be nil
 	// for closed stream. At restart, stream writer will drop all the data in Prepare function.
-	if err := sw.db.vlog.write(all); err != nil {
+	if err := sw.db.writeValueLogs(all); err != nil {
 		return err
 	}
 
@@ -307,6 +336,10 @@ func (sw *StreamWriter) Flush() error {
 	for _, l := range sw.db.lc.levels {
 		l.sortTables()
 	}
+	sw.db.setFlushedVersion(sw.maxVersion)
+	if sw.db.pointCache != nil {
+		sw.db.pointCache.clear()
+	}
 
 	// Now sync the directories, so all the files are registered.
 	if sw.db.opt.ValueDir != sw.db.opt.Dir {
@@ -360,6 +393,14 @@ type sortedWriter struct {
 	reqCh    chan *request
 	// Have separate closer for each writer, as it can be closed at any time.
 	closer *z.Closer
+
+	onDuplicate DuplicatePolicy
+	// The last key given to Add and its value, which are held back in case a duplicate follows.
+	pendingKey []byte
+	pendingVs  y.ValueStruct
+	// The first error met while adding the keys, after which the requests are ignored. It's
+	// returned by Done.
+	err error
 }
 
 func (sw *StreamWriter) newWriter(streamID uint32) (*sortedWriter, error) {
@@ -376,6 +417,8 @@ func (sw *StreamWriter) newWriter(streamID uint32) (*sortedWriter, error) {
 		reqCh:    make(chan *request, 3),
 		closer:   z.NewCloser(1),
 		level:    sw.prevLevel - 1, // Write at the level just above the one we were writing to.
+
+		onDuplicate: sw.OnDuplicate,
 	}
 
 	go w.handleRequests()
@@ -386,15 +429,20 @@ func (w *sortedWriter) handleRequests() {
 	defer w.closer.Done()
 
 	process := func(req *request) {
+		if w.err != nil {
+			return
+		}
 		for i, e := range req.Entries {
 			// If badger is running in InMemory mode, len(req.Ptrs) == 0.
 			var vs y.ValueStruct
-			if e.skipVlogAndSetThreshold(w.db.valueThreshold()) {
+			if e.skipVlogAndSetThreshold(w.db.entryValueThreshold(e.Key)) {
 				vs = y.ValueStruct{
 					Value:     e.Value,
 					Meta:      e.meta,
 					UserMeta:  e.UserMeta,
 					ExpiresAt: e.ExpiresAt,
+
+					UserMetaHigh: e.userMetaHigh,
 				}
 			} else {
 				vptr := req.Ptrs[i]
@@ -403,10 +451,13 @@ func (w *sortedWriter) handleRequests() {
 					Meta:      e.meta | bitValuePointer,
 					UserMeta:  e.UserMeta,
 					ExpiresAt: e.ExpiresAt,
+
+					UserMetaHigh: e.userMetaHigh,
 				}
 			}
 			if err := w.Add(e.Key, vs); err != nil {
-				panic(err)
+				w.err = errors.Wrapf(err, "in stream %d", w.streamID)
+				return
 			}
 		}
 	}
@@ -425,13 +476,35 @@ func (w *sortedWriter) handleRequests() {
 	}
 }
 
-// Add adds key and vs to sortedWriter.
+// Add adds key and vs to sortedWriter. They are held back until the next key comes in, which
+// replaces them or gets dropped if it's a duplicate, as per the DuplicatePolicy.
 func (w *sortedWriter) Add(key []byte, vs y.ValueStruct) error {
-	if len(w.lastKey) > 0 && y.CompareKeys(key, w.lastKey) <= 0 {
-		return errors.Errorf("keys not in sorted order (last key: %s, key: %s)",
-			hex.Dump(w.lastKey), hex.Dump(key))
+	if len(w.pendingKey) > 0 {
+		cmp := y.CompareKeys(key, w.pendingKey)
+		switch {
+		case cmp == 0 && w.onDuplicate == DuplicateKeepLast:
+			w.pendingVs = vs
+			return nil
+		case cmp == 0 && w.onDuplicate == DuplicateKeepFirst:
+			return nil
+		case cmp == 0:
+			return errors.Wrapf(ErrDuplicateKey, "key %q at version %d",
+				y.ParseKey(key), y.ParseTs(key))
+		case cmp < 0:
+			return errors.Errorf("keys not in sorted order (last key: %s, key: %s)",
+				hex.Dump(w.pendingKey), hex.Dump(key))
+		}
+		if err := w.add(w.pendingKey, w.pendingVs); err != nil {
+			return err
+		}
 	}
+	w.pendingKey = y.SafeCopy(w.pendingKey, key)
+	w.pendingVs = vs
+	return nil
+}
 
+// add adds key and vs to the table being built.
+func (w *sortedWriter) add(key []byte, vs y.ValueStruct) error {
 	sameKey := y.SameKey(key, w.lastKey)
 
 	// Same keys should go into the same SSTable.
@@ -473,6 +546,15 @@ func (w *sortedWriter) send(done bool) error {
 // Done is called once we are done writing all keys and valueStructs
 // to sortedWriter. It completes writing current SST to disk.
 func (w *sortedWriter) Done() error {
+	if w.err == nil && len(w.pendingKey) > 0 {
+		w.err = w.add(w.pendingKey, w.pendingVs)
+		w.pendingKey = nil
+	}
+	if w.err != nil {
+		w.builder.Close()
+		w.builder = nil
+		return w.err
+	}
 	if w.builder.Empty() {
 		w.builder.Close()
 		// Assign builder as nil, so that underlying memory can be garbage collected.
@@ -502,6 +584,7 @@ func (w *sortedWriter) createTable(builder *table.Builder) error {
 		var err error
 		fname := table.NewFilename(fileID, w.db.opt.Dir)
 		if tbl, err = table.CreateTable(fname, builder); err != nil {
+			w.db.manifest.forget(fileID)
 			return err
 		}
 	}
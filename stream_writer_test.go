@@ -26,6 +26,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
 
 	"github.com/0xEggTart/badger/pb"
 	"github.com/0xEggTart/badger/y"
@@ -601,6 +602,56 @@ func TestStreamWriterWithLargeValue(t *testing.T) {
 	})
 }
 
+func TestStreamWriterFromBackup(t *testing.T) {
+	small, large := []byte("small"), bytes.Repeat([]byte("large"), 100)
+	opt := getTestOptions("")
+	opt.ValueThreshold = 64
+	var bb bytes.Buffer
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		for i := 0; i < 100; i++ {
+			txnSet(t, db, []byte(key("small", i)), small, 0)
+			txnSet(t, db, []byte(key("large", i)), large, 0)
+		}
+		_, err := db.Backup(&bb, 0)
+		require.NoError(t, err)
+	})
+
+	// The KVs of the values read from the value log keep bitValuePointer, but carry the values.
+	buf := z.NewBuffer(1<<20, "test")
+	defer func() { require.NoError(t, buf.Release()) }()
+	r := bytes.NewReader(bb.Bytes())
+	for r.Len() > 0 {
+		var sz uint64
+		require.NoError(t, binary.Read(r, binary.LittleEndian, &sz))
+		data := make([]byte, sz)
+		_, err := r.Read(data)
+		require.NoError(t, err)
+		list := &pb.KVList{}
+		require.NoError(t, proto.Unmarshal(data, list))
+		for _, kv := range list.Kv {
+			KVToBuffer(kv, buf)
+		}
+	}
+
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		sw := db.NewStreamWriter()
+		require.NoError(t, sw.Prepare())
+		require.NoError(t, sw.Write(buf))
+		require.NoError(t, sw.Flush())
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for i := 0; i < 100; i++ {
+				item, err := txn.Get([]byte(key("small", i)))
+				require.NoError(t, err)
+				require.Equal(t, small, getItemValue(t, item))
+				item, err = txn.Get([]byte(key("large", i)))
+				require.NoError(t, err)
+				require.Equal(t, large, getItemValue(t, item))
+			}
+			return nil
+		}))
+	})
+}
+
 func TestStreamWriterIncremental(t *testing.T) {
 	addIncremental := func(t *testing.T, db *DB, keys [][]byte) {
 		buf := z.NewBuffer(10<<20, "test")
@@ -798,3 +849,53 @@ func TestStreamWriterIncremental(t *testing.T) {
 		})
 	})
 }
+
+func TestStreamWriterOnDuplicate(t *testing.T) {
+	// write streams key 0..4 at version 1, with key 2 three times, split across two calls to
+	// Write, and returns the error of Flush.
+	write := func(db *DB, policy DuplicatePolicy) error {
+		sw := db.NewStreamWriter()
+		sw.OnDuplicate = policy
+		require.NoError(t, sw.Prepare())
+		vals := []string{"0", "1", "2a", "2b", "2c", "3", "4"}
+		keys := []string{"0", "1", "2", "2", "2", "3", "4"}
+		for _, batch := range [][]int{{0, 1, 2, 3}, {4, 5, 6}} {
+			buf := z.NewBuffer(10<<20, "test")
+			for _, i := range batch {
+				KVToBuffer(&pb.KV{
+					Key:     []byte(key("key", int(keys[i][0]-'0'))),
+					Value:   []byte(vals[i]),
+					Version: 1,
+				}, buf)
+			}
+			require.NoError(t, sw.Write(buf))
+			require.NoError(t, buf.Release())
+		}
+		return sw.Flush()
+	}
+
+	for policy, want := range map[DuplicatePolicy]string{
+		DuplicateKeepFirst: "2a",
+		DuplicateKeepLast:  "2c",
+	} {
+		runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+			require.NoError(t, write(db, policy))
+			require.NoError(t, db.View(func(txn *Txn) error {
+				it := txn.NewIterator(DefaultIteratorOptions)
+				defer it.Close()
+				var vals []string
+				for it.Rewind(); it.Valid(); it.Next() {
+					vals = append(vals, string(getItemValue(t, it.Item())))
+				}
+				require.Equal(t, []string{"0", "1", want, "3", "4"}, vals)
+				return nil
+			}))
+		})
+	}
+
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		err := write(db, DuplicateError)
+		require.ErrorIs(t, err, ErrDuplicateKey)
+		require.Contains(t, err.Error(), key("key", 2))
+	})
+}
@@ -21,6 +21,8 @@ import (
 	"fmt"
 	"time"
 	"unsafe"
+
+	"github.com/0xEggTart/badger/y"
 )
 
 type valuePointer struct {
@@ -68,23 +70,31 @@ type header struct {
 	expiresAt uint64
 	meta      byte
 	userMeta  byte
+	// The high byte of a 16 bit user meta, encoded after userMeta if it isn't zero, as
+	// y.BitUserMetaHigh in the encoded meta tells.
+	userMetaHigh byte
 }
 
 const (
-	// Maximum possible size of the header. The maximum size of header struct will be 18 but the
-	// maximum size of varint encoded header will be 22.
-	maxHeaderSize = 22
+	// Maximum possible size of the header. The maximum size of header struct will be 19 but the
+	// maximum size of varint encoded header will be 23.
+	maxHeaderSize = 23
 )
 
 // Encode encodes the header into []byte. The provided []byte should be atleast 5 bytes. The
 // function will panic if out []byte isn't large enough to hold all the values.
 // The encoded header looks like
-// +------+----------+------------+--------------+-----------+
-// | Meta | UserMeta | Key Length | Value Length | ExpiresAt |
-// +------+----------+------------+--------------+-----------+
+// +------+----------+------------------------+------------+--------------+-----------+
+// | Meta | UserMeta | UserMetaHigh, optional | Key Length | Value Length | ExpiresAt |
+// +------+----------+------------------------+------------+--------------+-----------+
 func (h header) Encode(out []byte) int {
 	out[0], out[1] = h.meta, h.userMeta
 	index := 2
+	if h.userMetaHigh != 0 {
+		out[0] |= y.BitUserMetaHigh
+		out[2] = h.userMetaHigh
+		index++
+	}
 	index += binary.PutUvarint(out[index:], uint64(h.klen))
 	index += binary.PutUvarint(out[index:], uint64(h.vlen))
 	index += binary.PutUvarint(out[index:], h.expiresAt)
@@ -94,8 +104,13 @@ func (h header) Encode(out []byte) int {
 // Decode decodes the given header from the provided byte slice.
 // Returns the number of bytes read.
 func (h *header) Decode(buf []byte) int {
-	h.meta, h.userMeta = buf[0], buf[1]
+	h.meta, h.userMeta = buf[0]&^y.BitUserMetaHigh, buf[1]
 	index := 2
+	h.userMetaHigh = 0
+	if buf[0]&y.BitUserMetaHigh > 0 {
+		h.userMetaHigh = buf[2]
+		index++
+	}
 	klen, count := binary.Uvarint(buf[index:])
 	h.klen = uint32(klen)
 	index += count
@@ -118,6 +133,13 @@ func (h *header) DecodeFrom(reader *hashReader) (int, error) {
 	if err != nil {
 		return 0, err
 	}
+	h.userMetaHigh = 0
+	if h.meta&y.BitUserMetaHigh > 0 {
+		h.meta &^= y.BitUserMetaHigh
+		if h.userMetaHigh, err = reader.ReadByte(); err != nil {
+			return 0, err
+		}
+	}
 	klen, err := binary.ReadUvarint(reader)
 	if err != nil {
 		return 0, err
@@ -146,9 +168,15 @@ type Entry struct {
 	UserMeta  byte
 	meta      byte
 
+	// userMetaHigh is the high byte of a 16 bit user meta, see WithUserMeta16.
+	userMetaHigh byte
+
 	// Fields maintained internally.
 	hlen         int // Length of the header.
 	valThreshold int64
+	// vptr is set for entries which reuse a value already in the value log, see DB.RefreshTTL.
+	// Such entries are written to the LSM tree as is, and skip the value log.
+	vptr []byte
 }
 
 func (e *Entry) isZero() bool {
@@ -161,17 +189,21 @@ func (e *Entry) estimateSizeAndSetThreshold(threshold int64) int64 {
 	}
 	k := int64(len(e.Key))
 	v := int64(len(e.Value))
-	if v < e.valThreshold {
-		return k + v + 2 // Meta, UserMeta
+	metas := int64(2) // Meta, UserMeta
+	if e.userMetaHigh != 0 {
+		metas++
 	}
-	return k + 12 + 2 // 12 for ValuePointer, 2 for metas.
+	if v < e.valThreshold && e.vptr == nil {
+		return k + v + metas
+	}
+	return k + 12 + metas // 12 for ValuePointer.
 }
 
 func (e *Entry) skipVlogAndSetThreshold(threshold int64) bool {
 	if e.valThreshold == 0 {
 		e.valThreshold = threshold
 	}
-	return int64(len(e.Value)) < e.valThreshold
+	return int64(len(e.Value)) < e.valThreshold || e.vptr != nil
 }
 
 //nolint:unused
@@ -197,9 +229,44 @@ func NewEntry(key, value []byte) *Entry {
 // bits corresponding to the key-value pair of entry.
 func (e *Entry) WithMeta(meta byte) *Entry {
 	e.UserMeta = meta
+	e.userMetaHigh = 0
 	return e
 }
 
+// WithUserMeta16 adds a 16 bit user meta to Entry e, the low byte of which is e.UserMeta. Its
+// high byte is only written if Options.WideUserMeta is set, otherwise setting the entry fails
+// with ErrWideUserMeta unless the high byte is zero. Item.UserMeta16 reads it back.
+func (e *Entry) WithUserMeta16(meta uint16) *Entry {
+	e.UserMeta = byte(meta)
+	e.userMetaHigh = byte(meta >> 8)
+	return e
+}
+
+// UserMeta16 returns the 16 bit user meta of Entry e, see WithUserMeta16.
+func (e *Entry) UserMeta16() uint16 {
+	return uint16(e.userMetaHigh)<<8 | uint16(e.UserMeta)
+}
+
+// encodeUserMeta returns the user meta of a pb.KV. The high byte of a 16 bit user meta is only
+// appended if it isn't zero, so readers of a single byte still get the low byte.
+func encodeUserMeta(low, high byte) []byte {
+	if high == 0 {
+		return []byte{low}
+	}
+	return []byte{low, high}
+}
+
+// decodeUserMeta returns the low and the high byte of the user meta of a pb.KV.
+func decodeUserMeta(b []byte) (low, high byte) {
+	if len(b) > 0 {
+		low = b[0]
+	}
+	if len(b) > 1 {
+		high = b[1]
+	}
+	return low, high
+}
+
 // WithDiscard adds a marker to Entry e. This means all the previous versions of the key (of the
 // Entry) will be eligible for garbage collection.
 // This method is only useful if you have set a higher limit for options.NumVersionsToKeep. The
@@ -224,3 +291,8 @@ func (e *Entry) withMergeBit() *Entry {
 	e.meta = bitMergeEntry
 	return e
 }
+
+// IsDelete returns true if the entry deletes its key, as added by Txn.Delete.
+func (e *Entry) IsDelete() bool {
+	return e.meta&bitDelete > 0
+}
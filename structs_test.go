@@ -11,11 +11,31 @@ import (
 // Regression test for github.com/dgraph-io/badger/pull/1800
 func TestLargeEncode(t *testing.T) {
 	var headerEnc [maxHeaderSize]byte
-	h := header{math.MaxUint32, math.MaxUint32, math.MaxUint64, math.MaxUint8, math.MaxUint8}
+	h := header{math.MaxUint32, math.MaxUint32, math.MaxUint64, math.MaxUint8, math.MaxUint8,
+		math.MaxUint8}
 	require.NotPanics(t, func() { _ = h.Encode(headerEnc[:]) })
 }
 
 func TestNumFieldsHeader(t *testing.T) {
 	// maxHeaderSize must correspond with any changes made to header
-	require.Equal(t, 5, reflect.TypeOf(header{}).NumField())
+	require.Equal(t, 6, reflect.TypeOf(header{}).NumField())
+}
+
+func TestHeaderUserMetaHigh(t *testing.T) {
+	var buf [maxHeaderSize]byte
+	for _, h := range []header{
+		{klen: 3, vlen: 5, expiresAt: 7, meta: bitDelete, userMeta: 0xcd},
+		{klen: 3, vlen: 5, expiresAt: 7, meta: bitDelete, userMeta: 0xcd, userMetaHigh: 0xab},
+	} {
+		n := h.Encode(buf[:])
+		var got header
+		require.Equal(t, n, got.Decode(buf[:n]))
+		require.Equal(t, h, got)
+	}
+
+	// Headers written without a high byte decode as before.
+	old := []byte{bitDelete, 0xcd, 3, 5, 7}
+	var got header
+	require.Equal(t, len(old), got.Decode(old))
+	require.Equal(t, header{klen: 3, vlen: 5, expiresAt: 7, meta: bitDelete, userMeta: 0xcd}, got)
 }
@@ -18,6 +18,7 @@ package table
 
 import (
 	"crypto/aes"
+	"fmt"
 	"math"
 	"runtime"
 	"sync"
@@ -89,6 +90,8 @@ type Builder struct {
 	maxVersion    uint64
 	onDiskSize    uint32
 	staleDataSize int
+	lastKey       []byte // Only kept if Options.ValidateKeyOrder is set.
+	lastValue     []byte // The encoded value of the last key added, in curBlock.
 
 	// Used to concurrently compress/encrypt blocks.
 	wg        sync.WaitGroup
@@ -96,6 +99,25 @@ type Builder struct {
 	blockList []*bblock
 }
 
+// KeyHash returns the hash of key, a key without version, which goes into the bloom filters of
+// the tables.
+func KeyHash(key []byte) uint32 {
+	return y.Hash(key)
+}
+
+// BloomBitsPerKey returns the number of bits per key giving the false positive rate fp to a bloom
+// filter over numKeys keys, as used with Options.BloomFalsePositive.
+func BloomBitsPerKey(numKeys int, fp float64) int {
+	return y.BloomBitsPerKey(numKeys, fp)
+}
+
+// NewBloomFilter returns the bloom filter of the keys whose KeyHash are given, in the format of the
+// filters of the tables. A Builder builds the same filter, with the hashes of the keys added to it
+// in order, one per version, and the bits per key given by BloomBitsPerKey for that many hashes.
+func NewBloomFilter(hashes []uint32, bitsPerKey int) []byte {
+	return y.NewFilter(hashes, bitsPerKey)
+}
+
 func (b *Builder) allocate(need int) []byte {
 	bb := b.curBlock
 	if len(bb.data[bb.end:]) < need {
@@ -173,8 +195,8 @@ func (b *Builder) handleBlock() {
 	for item := range b.blockChan {
 		// Extract the block.
 		blockBuf := item.data[:item.end]
-		// Compress the block.
-		if doCompress {
+		// Compress the block, unless it's too small for compression to pay off.
+		if doCompress && item.end >= b.opts.MinCompressionSize {
 			out, err := b.compressData(blockBuf)
 			y.Check(err)
 			blockBuf = out
@@ -218,7 +240,7 @@ func (b *Builder) keyDiff(newKey []byte) []byte {
 }
 
 func (b *Builder) addHelper(key []byte, v y.ValueStruct, vpLen uint32) {
-	b.keyHashes = append(b.keyHashes, y.Hash(y.ParseKey(key)))
+	b.keyHashes = append(b.keyHashes, KeyHash(y.ParseKey(key)))
 
 	if version := y.ParseTs(key); version > b.maxVersion {
 		b.maxVersion = version
@@ -252,6 +274,7 @@ func (b *Builder) addHelper(key []byte, v y.ValueStruct, vpLen uint32) {
 
 	dst := b.allocate(int(v.EncodedSize()))
 	v.Encode(dst)
+	b.lastValue = dst
 
 	// Add the vpLen to the onDisk size. We'll add the size of the block to
 	// onDisk size in Finish() function.
@@ -345,6 +368,14 @@ func (b *Builder) Add(key []byte, value y.ValueStruct, valueLen uint32) {
 }
 
 func (b *Builder) addInternal(key []byte, value y.ValueStruct, valueLen uint32, isStale bool) {
+	if b.opts.ValidateKeyOrder {
+		if len(b.lastKey) > 0 && y.CompareKeys(key, b.lastKey) <= 0 {
+			panic(fmt.Sprintf("table builder: key %q at version %d added after key %q at "+
+				"version %d", y.ParseKey(key), y.ParseTs(key), y.ParseKey(b.lastKey),
+				y.ParseTs(b.lastKey)))
+		}
+		b.lastKey = append(b.lastKey[:0], key...)
+	}
 	if b.shouldFinishBlock(key, value) {
 		if isStale {
 			// This key will be added to tableIndex and it is stale.
@@ -359,6 +390,17 @@ func (b *Builder) addInternal(key []byte, value y.ValueStruct, valueLen uint32,
 	b.addHelper(key, value, valueLen)
 }
 
+// LastUserMeta returns the user meta of the last key added.
+func (b *Builder) LastUserMeta() byte {
+	return b.lastValue[1]
+}
+
+// SetLastUserMeta changes the user meta of the last key added. It must be called before the next
+// key is added.
+func (b *Builder) SetLastUserMeta(userMeta byte) {
+	b.lastValue[1] = userMeta
+}
+
 // TODO: vvv this was the comment on ReachedCapacity.
 // FinalSize returns the *rough* final size of the array, counting the header which is
 // not yet written.
@@ -444,8 +486,8 @@ func (b *Builder) Done() buildData {
 
 	var f y.Filter
 	if b.opts.BloomFalsePositive > 0 {
-		bits := y.BloomBitsPerKey(len(b.keyHashes), b.opts.BloomFalsePositive)
-		f = y.NewFilter(b.keyHashes, bits)
+		bits := BloomBitsPerKey(len(b.keyHashes), b.opts.BloomFalsePositive)
+		f = NewBloomFilter(b.keyHashes, bits)
 	}
 	index, dataSize := b.buildIndex(f)
 
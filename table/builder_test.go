@@ -287,3 +287,39 @@ func TestEmptyBuilder(t *testing.T) {
 	require.Equal(t, []byte{}, b.Finish())
 
 }
+
+func TestBuilderValidateKeyOrder(t *testing.T) {
+	opts := getTestTableOptions()
+	opts.ValidateKeyOrder = true
+	b := NewTableBuilder(opts)
+	defer b.Close()
+	vs := y.ValueStruct{Value: []byte("val")}
+	b.Add(y.KeyWithTs([]byte("a"), 2), vs, 0)
+	b.Add(y.KeyWithTs([]byte("a"), 1), vs, 0)
+	b.Add(y.KeyWithTs([]byte("b"), 1), vs, 0)
+	require.PanicsWithValue(t, `table builder: key "a" at version 3 added after key "b" at version 1`,
+		func() { b.Add(y.KeyWithTs([]byte("a"), 3), vs, 0) })
+	require.Panics(t, func() { b.Add(y.KeyWithTs([]byte("b"), 1), vs, 0) })
+}
+
+func TestNewBloomFilter(t *testing.T) {
+	opts := getTestTableOptions()
+	b := NewTableBuilder(opts)
+	var hashes []uint32
+	for i := 0; i < 1000; i++ {
+		k := []byte(fmt.Sprintf("key%05d", i))
+		for version := uint64(2); version > 0; version-- {
+			b.Add(y.KeyWithTs(k, version), y.ValueStruct{Value: []byte("val")}, 0)
+			hashes = append(hashes, KeyHash(k))
+		}
+	}
+	tbl, err := OpenInMemoryTable(b.Finish(), 1, &opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, tbl.DecrRef()) }()
+
+	bits := BloomBitsPerKey(len(hashes), opts.BloomFalsePositive)
+	filter := NewBloomFilter(hashes, bits)
+	require.Equal(t, tbl.fetchIndex().BloomFilterBytes(), filter)
+	require.True(t, y.Filter(filter).MayContain(KeyHash([]byte("key00042"))))
+	require.False(t, tbl.DoesNotHave(KeyHash([]byte("key00042"))))
+}
@@ -38,6 +38,8 @@ type blockIterator struct {
 
 	tableID uint64
 	blockID int
+	// numSet counts the blocks set, which is the number of blocks read by the table iterator.
+	numSet int
 	// prevOverlap stores the overlap of the previous key with the base key.
 	// This avoids unnecessary copy of base key when the overlap is same for multiple keys.
 	prevOverlap uint16
@@ -49,6 +51,7 @@ func (itr *blockIterator) setBlock(b *Block) {
 	itr.block.decrRef()
 
 	itr.block = b
+	itr.numSet++
 	itr.err = nil
 	itr.idx = 0
 	itr.baseKey = itr.baseKey[:0]
@@ -202,6 +205,12 @@ func (itr *Iterator) reset() {
 	itr.err = nil
 }
 
+// BlocksRead returns the number of blocks the iterator has read so far, from the block cache or
+// from the table.
+func (itr *Iterator) BlocksRead() int {
+	return itr.bi.numSet
+}
+
 // Valid follows the y.Iterator interface
 func (itr *Iterator) Valid() bool {
 	return itr.err == nil
@@ -211,6 +220,13 @@ func (itr *Iterator) useCache() bool {
 	return itr.opt&NOCACHE == 0
 }
 
+func (itr *Iterator) block(idx int) (*Block, error) {
+	if itr.opt&cacheOnly != 0 {
+		return itr.t.cachedBlock(idx)
+	}
+	return itr.t.block(idx, itr.useCache())
+}
+
 func (itr *Iterator) seekToFirst() {
 	numBlocks := itr.t.offsetsLength()
 	if numBlocks == 0 {
@@ -218,7 +234,7 @@ func (itr *Iterator) seekToFirst() {
 		return
 	}
 	itr.bpos = 0
-	block, err := itr.t.block(itr.bpos, itr.useCache())
+	block, err := itr.block(itr.bpos)
 	if err != nil {
 		itr.err = err
 		return
@@ -237,7 +253,7 @@ func (itr *Iterator) seekToLast() {
 		return
 	}
 	itr.bpos = numBlocks - 1
-	block, err := itr.t.block(itr.bpos, itr.useCache())
+	block, err := itr.block(itr.bpos)
 	if err != nil {
 		itr.err = err
 		return
@@ -251,7 +267,7 @@ func (itr *Iterator) seekToLast() {
 
 func (itr *Iterator) seekHelper(blockIdx int, key []byte) {
 	itr.bpos = blockIdx
-	block, err := itr.t.block(blockIdx, itr.useCache())
+	block, err := itr.block(blockIdx)
 	if err != nil {
 		itr.err = err
 		return
@@ -328,7 +344,7 @@ func (itr *Iterator) next() {
 	}
 
 	if len(itr.bi.data) == 0 {
-		block, err := itr.t.block(itr.bpos, itr.useCache())
+		block, err := itr.block(itr.bpos)
 		if err != nil {
 			itr.err = err
 			return
@@ -358,7 +374,7 @@ func (itr *Iterator) prev() {
 	}
 
 	if len(itr.bi.data) == 0 {
-		block, err := itr.t.block(itr.bpos, itr.useCache())
+		block, err := itr.block(itr.bpos)
 		if err != nil {
 			itr.err = err
 			return
@@ -432,6 +448,9 @@ var (
 	NOCACHE  int = 4
 )
 
+// cacheOnly makes an iterator fail with ErrNotCached instead of reading blocks from the table.
+const cacheOnly int = 8
+
 // ConcatIterator concatenates the sequences defined by several iterators.  (It only works with
 // TableIterators, probably just because it's faster to not be so generic.)
 type ConcatIterator struct {
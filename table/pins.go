@@ -0,0 +1,202 @@
+/*
+ * Copyright 2024 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package table
+
+import (
+	"bytes"
+	"slices"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/0xEggTart/badger/y"
+)
+
+// ErrPinBudgetExceeded is returned when pinning the blocks of a key range would take more memory
+// than the budget of the BlockPins.
+var ErrPinBudgetExceeded = errors.New("Pinned blocks exceed the budget")
+
+// BlockPins keeps the blocks of some key ranges in memory. Unlike the blocks in the block cache,
+// pinned blocks are never evicted. A block gets pinned when it's read and holds keys of a pinned
+// range, as long as the pinned blocks fit into the budget, and is released once its table is
+// deleted or the range is unpinned.
+type BlockPins struct {
+	sync.RWMutex
+	max    int64
+	size   int64
+	ranges []pinnedRange
+	blocks map[pinnedKey]pinnedBlock
+}
+
+type pinnedRange struct {
+	start, end []byte // Keys with timestamps, an empty end means no upper bound.
+}
+
+type pinnedKey struct {
+	id  uint64
+	idx int
+}
+
+type pinnedBlock struct {
+	blk *Block
+	t   *Table
+}
+
+// NewBlockPins returns a BlockPins which pins at most max bytes of blocks.
+func NewBlockPins(max int64) *BlockPins {
+	return &BlockPins{max: max, blocks: make(map[pinnedKey]pinnedBlock)}
+}
+
+// Size returns the bytes of the pinned blocks.
+func (p *BlockPins) Size() int64 {
+	p.RLock()
+	defer p.RUnlock()
+	return p.size
+}
+
+// AddRange pins the blocks of the range [start, end) from now on. The blocks already in memory
+// aren't pinned until they are read again, see Table.Pin.
+func (p *BlockPins) AddRange(start, end []byte) {
+	p.Lock()
+	defer p.Unlock()
+	p.ranges = append(p.ranges, pinnedRange{start: y.Copy(start), end: y.Copy(end)})
+}
+
+// RemoveRange stops pinning the range [start, end) added by AddRange, and releases the blocks
+// which don't hold keys of another pinned range. It returns false if the range wasn't pinned.
+func (p *BlockPins) RemoveRange(start, end []byte) bool {
+	p.Lock()
+	defer p.Unlock()
+	i := slices.IndexFunc(p.ranges, func(r pinnedRange) bool {
+		return bytes.Equal(r.start, start) && bytes.Equal(r.end, end)
+	})
+	if i < 0 {
+		return false
+	}
+	p.ranges = slices.Delete(p.ranges, i, i+1)
+	for k, pb := range p.blocks {
+		if !p.covers(pb.t, k.idx) {
+			p.release(k, pb)
+		}
+	}
+	return true
+}
+
+// Close releases all the pinned blocks and ranges. It's a no-op for a nil BlockPins.
+func (p *BlockPins) Close() {
+	if p == nil {
+		return
+	}
+	p.Lock()
+	defer p.Unlock()
+	p.ranges = nil
+	for k, pb := range p.blocks {
+		p.release(k, pb)
+	}
+}
+
+// get returns the pinned block idx of table t with a reference, or nil.
+func (p *BlockPins) get(t *Table, idx int) *Block {
+	p.RLock()
+	defer p.RUnlock()
+	pb, ok := p.blocks[pinnedKey{t.id, idx}]
+	if !ok || !pb.blk.incrRef() {
+		return nil
+	}
+	return pb.blk
+}
+
+// add pins block idx of table t if it holds keys of a pinned range and fits into the budget. It
+// returns whether the block is pinned.
+func (p *BlockPins) add(t *Table, idx int, blk *Block) bool {
+	// Most blocks aren't pinned, so check that without blocking the other readers.
+	p.RLock()
+	covered := p.covers(t, idx)
+	p.RUnlock()
+	if !covered {
+		return false
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	k := pinnedKey{t.id, idx}
+	if _, ok := p.blocks[k]; ok {
+		return true
+	}
+	if !p.covers(t, idx) || p.size+blk.size() > p.max || !blk.incrRef() {
+		return false
+	}
+	p.blocks[k] = pinnedBlock{blk: blk, t: t}
+	p.size += blk.size()
+	return true
+}
+
+// covers tells whether block idx of table t holds keys of a pinned range. Must be called with the
+// lock held.
+func (p *BlockPins) covers(t *Table, idx int) bool {
+	for _, r := range p.ranges {
+		if t.blockInRange(idx, r.start, r.end) {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseTable releases the pinned blocks of table t.
+func (p *BlockPins) releaseTable(t *Table) {
+	p.Lock()
+	defer p.Unlock()
+	for k, pb := range p.blocks {
+		if k.id == t.id {
+			p.release(k, pb)
+		}
+	}
+}
+
+// release unpins a block. Must be called with the lock held.
+func (p *BlockPins) release(k pinnedKey, pb pinnedBlock) {
+	delete(p.blocks, k)
+	p.size -= pb.blk.size()
+	pb.blk.decrRef()
+}
+
+// Pin reads the blocks of the table which hold keys in the range [start, end), which has been
+// added to the pins of the table with AddRange, so that they get pinned. Like with RangeSize,
+// start and end are keys with timestamps. It returns ErrPinBudgetExceeded if a block doesn't fit
+// into the budget of the pins.
+func (t *Table) Pin(start, end []byte) error {
+	pins := t.opt.BlockPins
+	if pins == nil {
+		return nil
+	}
+	for i := 0; i < t.offsetsLength(); i++ {
+		if !t.blockInRange(i, start, end) {
+			continue
+		}
+		blk, err := t.block(i, true)
+		if err != nil {
+			return err
+		}
+		// The block might have come from the block cache, so pin it here.
+		pinned := pins.add(t, i, blk)
+		blk.decrRef()
+		if !pinned {
+			return ErrPinBudgetExceeded
+		}
+	}
+	return nil
+}
@@ -18,9 +18,11 @@ package table
 
 import (
 	"bytes"
+	"context"
 	"crypto/aes"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"math"
 	"os"
 	"path/filepath"
@@ -76,14 +78,24 @@ type Options struct {
 	// Compression indicates the compression algorithm used for block compression.
 	Compression options.CompressionType
 
+	// MinCompressionSize is the size below which blocks are stored uncompressed.
+	MinCompressionSize int
+
 	// Block cache is used to cache decompressed and decrypted blocks.
 	BlockCache *ristretto.Cache[[]byte, *Block]
 	IndexCache *ristretto.Cache[uint64, *fb.TableIndex]
+	// BlockPins keeps the blocks of pinned key ranges in memory, if set.
+	BlockPins *BlockPins
+	// OnDelete is called with the ID of the table once its file is deleted, if set.
+	OnDelete func(id uint64)
 
 	AllocPool *z.AllocatorPool
 
 	// ZSTDCompressionLevel is the ZSTD compression level used for compressing blocks.
 	ZSTDCompressionLevel int
+
+	// ValidateKeyOrder makes the builder panic if the keys aren't added in increasing order.
+	ValidateKeyOrder bool
 }
 
 // TableInterface is useful for testing.
@@ -126,6 +138,8 @@ type cheapIndex struct {
 	OnDiskSize        uint32
 	BloomFilterLength int
 	OffsetsLength     int
+	// The size of the unencrypted index, which is part of OnDiskSize.
+	IndexSize int
 }
 
 func (t *Table) cheapIndex() *cheapIndex {
@@ -142,6 +156,10 @@ func (t *Table) BloomFilterSize() int { return t.cheapIndex().BloomFilterLength
 // UncompressedSize is the size uncompressed data stored in this file.
 func (t *Table) UncompressedSize() uint32 { return t.cheapIndex().UncompressedSize }
 
+// BlocksSize is the size of the data blocks of this table on disk, after compression and
+// encryption. Compare with UncompressedSize.
+func (t *Table) BlocksSize() uint32 { return uint32(t.indexStart) }
+
 // KeyCount is the total number of keys in this table.
 func (t *Table) KeyCount() uint32 { return t.cheapIndex().KeyCount }
 
@@ -170,9 +188,15 @@ func (t *Table) DecrRef() error {
 		for i := 0; i < t.offsetsLength(); i++ {
 			t.opt.BlockCache.Del(t.blockCacheKey(i))
 		}
+		if t.opt.BlockPins != nil {
+			t.opt.BlockPins.releaseTable(t)
+		}
 		if err := t.Delete(); err != nil {
 			return err
 		}
+		if t.opt.OnDelete != nil {
+			t.opt.OnDelete(t.ID())
+		}
 	}
 	return nil
 }
@@ -475,6 +499,7 @@ func (t *Table) initIndex() (*fb.BlockOffset, error) {
 		OnDiskSize:        index.OnDiskSize(),
 		OffsetsLength:     index.OffsetsLength(),
 		BloomFilterLength: index.BloomFilterLength(),
+		IndexSize:         len(index.Table().Bytes),
 	}
 
 	t.hasBloomFilter = len(index.BloomFilterBytes()) > 0
@@ -510,6 +535,111 @@ func (t *Table) KeySplits(n int, prefix []byte) []string {
 	return res
 }
 
+// RangeSize estimates the bytes occupied by the keys of the table in the range [start, end). Both
+// start and end are keys with timestamps, and an empty end means that the range has no upper
+// bound. It returns the on-disk size of the blocks which can hold keys in the range, and the share
+// of the value log bytes referenced by the table attributed to those blocks. As whole blocks are
+// counted, the size of a small range is overestimated.
+func (t *Table) RangeSize(start, end []byte) (blocks, vlog int64) {
+	var bo, next fb.BlockOffset
+	var total int64
+	oLen := t.offsetsLength()
+	for i := 0; i < oLen; i++ {
+		y.AssertTrue(t.offsets(&bo, i))
+		total += int64(bo.Len())
+		if len(end) > 0 && y.CompareKeys(bo.KeyBytes(), end) >= 0 {
+			continue
+		}
+		// Block i holds the keys before the first key of block i+1.
+		if i+1 < oLen {
+			y.AssertTrue(t.offsets(&next, i+1))
+			if y.CompareKeys(next.KeyBytes(), start) <= 0 {
+				continue
+			}
+		}
+		blocks += int64(bo.Len())
+	}
+	// OnDiskSize is the size of all the blocks and of the index, plus the value log bytes the
+	// table points to.
+	vlogTotal := int64(t.OnDiskSize()) - total - int64(t.cheapIndex().IndexSize)
+	if total > 0 && vlogTotal > 0 {
+		vlog = vlogTotal * blocks / total
+	}
+	return blocks, vlog
+}
+
+// Warm loads the blocks of the table which can hold keys in the range [start, end) into the block
+// cache, so that the reads of the range don't have to go to disk. Like with RangeSize, start and
+// end are keys with timestamps. The index, which holds the bloom filter, is loaded into the index
+// cache too, if the table uses one. Warm stops before loading more than budget bytes, or once ctx
+// is done, and returns the number of bytes of blocks it loaded.
+func (t *Table) Warm(ctx context.Context, start, end []byte, budget int64) (int64, error) {
+	t.fetchIndex()
+	if t.opt.BlockCache == nil {
+		return 0, nil
+	}
+	var bo fb.BlockOffset
+	var warmed int64
+	for i := 0; i < t.offsetsLength(); i++ {
+		if !t.blockInRange(i, start, end) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return warmed, err
+		}
+		y.AssertTrue(t.offsets(&bo, i))
+		if warmed+int64(bo.Len()) > budget {
+			break
+		}
+		blk, err := t.block(i, true)
+		if err != nil {
+			return warmed, err
+		}
+		warmed += blk.size()
+		blk.decrRef()
+	}
+	return warmed, nil
+}
+
+// blockInRange tells whether block i can hold keys in the range [start, end). An empty end means
+// that the range has no upper bound.
+func (t *Table) blockInRange(i int, start, end []byte) bool {
+	var bo fb.BlockOffset
+	y.AssertTrue(t.offsets(&bo, i))
+	if len(end) > 0 && y.CompareKeys(bo.KeyBytes(), end) >= 0 {
+		return false
+	}
+	// Block i holds the keys before the first key of block i+1.
+	if i+1 < t.offsetsLength() {
+		y.AssertTrue(t.offsets(&bo, i+1))
+		if y.CompareKeys(bo.KeyBytes(), start) <= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Stream calls fn for every entry of the table in key order, passing the key without its
+// timestamp and the value, with the timestamp of the key as its version. The value holds the
+// value pointer instead of the value if the value was not stored in the table. Both are only
+// valid during the call. The blocks are read without going through the block cache. Stream stops
+// at the first error returned by fn and returns it.
+func (t *Table) Stream(fn func(key []byte, vs y.ValueStruct) error) error {
+	itr := t.NewIterator(NOCACHE)
+	defer itr.Close()
+	for itr.Rewind(); itr.Valid(); itr.Next() {
+		vs := itr.Value()
+		vs.Version = y.ParseTs(itr.Key())
+		if err := fn(y.ParseKey(itr.Key()), vs); err != nil {
+			return err
+		}
+	}
+	if itr.err != io.EOF {
+		return itr.err
+	}
+	return nil
+}
+
 func (t *Table) fetchIndex() *fb.TableIndex {
 	if !t.shouldDecrypt() {
 		return t._index
@@ -528,6 +658,59 @@ func (t *Table) fetchIndex() *fb.TableIndex {
 	return index
 }
 
+// ErrNotCached is returned by SeekCached when the table would have to be read.
+var ErrNotCached = errors.New("Table data is not cached")
+
+// IndexCached tells whether the index of the table is in memory, so that bloom filter lookups and
+// seeks don't read it from the table. The index of an unencrypted table is always in memory.
+func (t *Table) IndexCached() bool {
+	switch {
+	case t.IsInmemory:
+		return true
+	case !t.shouldDecrypt():
+		return true
+	case t.opt.IndexCache == nil:
+		return false
+	}
+	_, ok := t.opt.IndexCache.Get(t.indexKey())
+	return ok
+}
+
+// cachedBlock returns block idx like block does, but only from memory. It returns ErrNotCached
+// if the block would have to be read from the table.
+func (t *Table) cachedBlock(idx int) (*Block, error) {
+	if t.IsInmemory {
+		return t.block(idx, true)
+	}
+	if t.opt.BlockPins != nil {
+		if blk := t.opt.BlockPins.get(t, idx); blk != nil {
+			return blk, nil
+		}
+	}
+	if t.opt.BlockCache != nil {
+		if blk, ok := t.opt.BlockCache.Get(t.blockCacheKey(idx)); ok && blk != nil && blk.incrRef() {
+			return blk, nil
+		}
+	}
+	return nil, ErrNotCached
+}
+
+// SeekCached returns the first key at or after key in the table, and its value, like a seek of an
+// iterator, but only if that doesn't read the table: its index and the blocks seeked through have
+// to be in memory. Otherwise, it returns ErrNotCached. If there is no such key, it returns io.EOF.
+func (t *Table) SeekCached(key []byte) ([]byte, y.ValueStruct, error) {
+	if !t.IndexCached() {
+		return nil, y.ValueStruct{}, ErrNotCached
+	}
+	it := t.NewIterator(cacheOnly)
+	defer it.Close()
+	it.seek(key)
+	if it.err != nil {
+		return nil, y.ValueStruct{}, it.err
+	}
+	return y.Copy(it.Key()), it.ValueCopy(), nil
+}
+
 func (t *Table) offsets(ko *fb.BlockOffset, i int) bool {
 	return t.fetchIndex().Offsets(ko, i)
 }
@@ -540,6 +723,11 @@ func (t *Table) block(idx int, useCache bool) (*Block, error) {
 	if idx >= t.offsetsLength() {
 		return nil, errors.New("block out of index")
 	}
+	if t.opt.BlockPins != nil {
+		if blk := t.opt.BlockPins.get(t, idx); blk != nil {
+			return blk, nil
+		}
+	}
 	if t.opt.BlockCache != nil {
 		key := t.blockCacheKey(idx)
 		blk, ok := t.opt.BlockCache.Get(key)
@@ -618,7 +806,9 @@ func (t *Table) block(idx int, useCache bool) (*Block, error) {
 	}
 
 	blk.incrRef()
-	if useCache && t.opt.BlockCache != nil {
+	// A pinned block doesn't need to go into the cache.
+	pinned := t.opt.BlockPins != nil && t.opt.BlockPins.add(t, idx, blk)
+	if !pinned && useCache && t.opt.BlockCache != nil {
 		key := t.blockCacheKey(idx)
 		// incrRef should never return false here because we're calling it on a
 		// new block with ref=1.
@@ -792,6 +982,14 @@ func NewFilename(id uint64, dir string) string {
 	return filepath.Join(dir, IDToFilename(id))
 }
 
+// isUncompressedBlock tells whether a block of a compressed table was stored uncompressed. The
+// first entry of a block has no overlap with the base key, so an uncompressed block starts with
+// two zero bytes. Neither a ZSTD frame, which starts with a magic number, nor a Snappy block, which
+// starts with the varint encoded non-zero length of the block, can start with a zero byte.
+func isUncompressedBlock(data []byte) bool {
+	return len(data) >= 2 && data[0] == 0 && data[1] == 0
+}
+
 // decompress decompresses the data stored in a block.
 func (t *Table) decompress(b *Block) error {
 	var dst []byte
@@ -800,6 +998,11 @@ func (t *Table) decompress(b *Block) error {
 	// Point to the original b.data
 	src := b.data
 
+	if t.opt.Compression != options.None && isUncompressedBlock(b.data) {
+		// Stored as is, see Options.MinCompressionSize.
+		return nil
+	}
+
 	switch t.opt.Compression {
 	case options.None:
 		// Nothing to be done here.
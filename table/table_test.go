@@ -19,6 +19,7 @@ package table
 import (
 	"bytes"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"hash/crc32"
 	"math/rand"
@@ -290,6 +291,35 @@ func TestTable(t *testing.T) {
 	require.EqualValues(t, string(y.ParseKey(k)), key("key", 0))
 }
 
+func TestTableStream(t *testing.T) {
+	opts := getTestTableOptions()
+	table := buildTestTable(t, "key", 1000, opts)
+	defer func() { require.NoError(t, table.DecrRef()) }()
+
+	count := 0
+	require.NoError(t, table.Stream(func(k []byte, vs y.ValueStruct) error {
+		require.Equal(t, key("key", count), string(k))
+		require.Equal(t, fmt.Sprintf("%d", count), string(vs.Value))
+		require.Equal(t, byte('A'), vs.Meta)
+		count++
+		return nil
+	}))
+	require.Equal(t, 1000, count)
+
+	// An error returned by fn stops the stream.
+	errStop := errors.New("stop")
+	count = 0
+	err := table.Stream(func(k []byte, vs y.ValueStruct) error {
+		count++
+		if count == 10 {
+			return errStop
+		}
+		return nil
+	})
+	require.Equal(t, errStop, err)
+	require.Equal(t, 10, count)
+}
+
 func TestIterateBackAndForth(t *testing.T) {
 	opts := getTestTableOptions()
 	table := buildTestTable(t, "key", 10000, opts)
@@ -674,6 +704,32 @@ func TestTableBigValues(t *testing.T) {
 	require.Equal(t, n, int(tbl.MaxVersion()))
 }
 
+func TestMinCompressionSize(t *testing.T) {
+	for _, ctype := range []options.CompressionType{options.Snappy, options.ZSTD} {
+		var sizes []int64
+		// No block is stored uncompressed, the last one is, and all are.
+		for _, minSize := range []int{0, 2 << 10, 1 << 20} {
+			opts := getTestTableOptions()
+			opts.Compression = ctype
+			opts.MinCompressionSize = minSize
+			tbl := buildTestTable(t, "key", 10000, opts)
+			it := tbl.NewIterator(0)
+			count := 0
+			for it.Rewind(); it.Valid(); it.Next() {
+				require.EqualValues(t, y.KeyWithTs([]byte(key("key", count)), 0), it.Key())
+				require.EqualValues(t, fmt.Sprintf("%d", count), string(it.Value().Value))
+				count++
+			}
+			require.Equal(t, 10000, count)
+			it.Close()
+			require.NoError(t, tbl.VerifyChecksum())
+			sizes = append(sizes, tbl.Size())
+			require.NoError(t, tbl.DecrRef())
+		}
+		require.Less(t, sizes[0], sizes[2])
+	}
+}
+
 // This test is for verifying checksum failure during table open.
 func TestTableChecksum(t *testing.T) {
 	rand.Seed(time.Now().Unix())
@@ -907,3 +963,30 @@ func TestMaxVersion(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, N, int(table.MaxVersion()))
 }
+
+func TestRangeSize(t *testing.T) {
+	opt := getTestTableOptions()
+	b := NewTableBuilder(opt)
+	defer b.Close()
+
+	filename := fmt.Sprintf("%s%s%d.sst", os.TempDir(), string(os.PathSeparator), rand.Uint32())
+	N := 1000
+	for i := 0; i < N; i++ {
+		// Every other key points to 100 bytes in the value log.
+		b.Add(y.KeyWithTs([]byte(key("key", i)), 0), y.ValueStruct{Value: []byte("v")},
+			uint32(i%2)*100)
+	}
+	table, err := CreateTable(filename, b)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, table.DecrRef()) }()
+
+	// The index isn't counted as value log bytes.
+	blocks, vlog := table.RangeSize(y.KeyWithTs([]byte("key"), 0), nil)
+	require.Equal(t, int64(N/2*100), vlog)
+	require.Less(t, blocks, int64(table.OnDiskSize())-vlog)
+
+	noVlog := buildTestTable(t, "key", N, opt)
+	defer func() { require.NoError(t, noVlog.DecrRef()) }()
+	_, vlog = noVlog.RangeSize(y.KeyWithTs([]byte("key"), 0), nil)
+	require.Zero(t, vlog)
+}
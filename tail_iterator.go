@@ -0,0 +1,173 @@
+/*
+ * Copyright 2024 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"context"
+	"math"
+
+	"github.com/0xEggTart/badger/pb"
+	"github.com/dgraph-io/ristretto/v2/z"
+)
+
+// TailIterator iterates over the keys after a cursor key, in increasing key order, and once it
+// reaches the last key, waits for keys after it to be committed. See Txn.NewTailIterator.
+type TailIterator struct {
+	db     *DB
+	txn    *Txn // The snapshot being iterated.
+	ownTxn bool // Whether txn was created by the iterator, and must be discarded by it.
+	it     *Iterator
+	cursor []byte // Key of the last item returned, or the key passed to NewTailIterator.
+
+	notify chan struct{} // Signaled when keys get committed.
+	stop   chan struct{} // Closed by Close.
+	done   chan struct{} // Closed once listen returns, when the iterator or the DB is closed.
+	closer *z.Closer
+	sub    subscriber
+}
+
+// NewTailIterator returns an iterator over the keys strictly after afterKey, or all the keys if
+// afterKey is empty. The keys in the snapshot of the transaction are returned first. Once they are
+// exhausted, TailIterator.Next blocks until more keys get committed, and then iterates over a new
+// snapshot, from the last key it returned. This turns polling a queue for keys after a cursor into
+// waiting for them to be pushed.
+//
+// Keys are returned in increasing order, and each key at most once, as the iterator only moves
+// forward: a key committed after the iterator went past it is never returned. So the keys should
+// be committed in increasing order, as the keys of a queue usually are. Concurrent transactions
+// can commit out of key order; use a single writer, or sequence numbers obtained with
+// DB.GetSequence in the same order as the commits, to avoid skipping keys. Only the latest version
+// of each key is returned. Nothing is persisted: to get at-least-once delivery across restarts,
+// save the key of the last processed item and pass it as afterKey to a new iterator.
+//
+// The iterator must be closed with Close. The transaction must outlive the iterator.
+func (txn *Txn) NewTailIterator(afterKey []byte) (*TailIterator, error) {
+	if txn.discarded {
+		return nil, ErrDiscardedTxn
+	}
+	db := txn.db
+	if db.IsClosed() {
+		return nil, ErrDBClosed
+	}
+	// Subscribe to all the writes before iterating the snapshot, so that no commit is missed.
+	c := z.NewCloser(1)
+	s, err := db.pub.newSubscriber(c, []pb.Match{{}})
+	if err != nil {
+		return nil, err
+	}
+	t := &TailIterator{
+		db:     db,
+		txn:    txn,
+		cursor: append([]byte{}, afterKey...),
+		notify: make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+		closer: c,
+		sub:    s,
+	}
+	go t.listen()
+	return t, nil
+}
+
+// listen signals notify for every batch of keys committed, until the iterator or the DB is closed.
+func (t *TailIterator) listen() {
+	defer close(t.done)
+	for {
+		select {
+		case <-t.closer.HasBeenClosed():
+			// The DB is closing. The subscriber is deleted by cleanSubscribers.
+			t.closer.Done()
+			return
+		case <-t.stop:
+			return
+		case <-t.sub.sendCh:
+			select {
+			case t.notify <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// Next returns the next item after the last one returned, waiting for one to be committed if
+// there is none yet. It returns the error of ctx if ctx is done before that, and ErrDBClosed if
+// the DB gets closed. Errors don't invalidate the iterator, so Next can be called again. The item
+// is only valid until the next call to Next or Close.
+func (t *TailIterator) Next(ctx context.Context) (*Item, error) {
+	for {
+		if t.it == nil {
+			opt := DefaultIteratorOptions
+			opt.internal = true
+			t.it = t.txn.NewIterator(opt)
+			t.it.Seek(t.cursor)
+		} else if t.it.Valid() {
+			t.it.Next()
+		}
+		for ; t.it.Valid(); t.it.Next() {
+			item := t.it.Item()
+			key := t.it.seekKey(item)
+			if bytes.Equal(key, t.cursor) {
+				// Seek positioned the iterator on the cursor.
+				continue
+			}
+			t.cursor = append(t.cursor[:0], key...)
+			return item, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-t.done:
+			return nil, ErrDBClosed
+		case <-t.notify:
+		}
+		// Move to a snapshot which includes the new keys.
+		t.it.Close()
+		t.it = nil
+		if t.ownTxn {
+			t.txn.Discard()
+		}
+		if t.db.opt.managedTxns {
+			t.txn = t.db.NewTransactionAt(math.MaxUint64, false)
+		} else {
+			t.txn = t.db.NewTransaction(false)
+		}
+		t.ownTxn = true
+	}
+}
+
+// Close stops waiting for new keys and releases the snapshot held by the iterator.
+func (t *TailIterator) Close() {
+	if t.stop == nil {
+		return
+	}
+	if t.it != nil {
+		t.it.Close()
+		t.it = nil
+	}
+	if t.ownTxn {
+		t.txn.Discard()
+		t.ownTxn = false
+	}
+	// Once the subscriber is deleted, no more keys get sent to it, so listen can stop.
+	t.sub.active.Store(0)
+	t.db.pub.deleteSubscriber(t.sub.id)
+	close(t.stop)
+	<-t.done
+	t.stop = nil
+}
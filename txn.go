@@ -20,11 +20,14 @@ import (
 	"bytes"
 	"context"
 	"encoding/hex"
+	"expvar"
 	"math"
 	"sort"
 	"strconv"
 	"sync"
 	"sync/atomic"
+	"time"
+	"unsafe"
 
 	"github.com/pkg/errors"
 
@@ -49,20 +52,50 @@ type oracle struct {
 	// discarded during compaction.
 	discardTs uint64       // Used by ManagedDB.
 	readMark  *y.WaterMark // Used by DB.
+	// Versions kept visible by pinVersion, with the number of their pins. Used by DB.
+	pins map[uint64]int
+
+	// All versions at or below deleteTs are hidden from reads, and dropped during compaction. Set
+	// by DB.DeleteBelowVersion. It's read without holding the lock on every read.
+	deleteTs atomic.Uint64
 
 	// committedTxns contains all committed writes (contains fingerprints
 	// of keys written and their latest commit counter).
 	committedTxns []committedTxn
 	lastCleanupTs uint64
 
+	// memUsed estimates the memory held by committedTxns. Once it exceeds maxMemory, the oldest
+	// committed transactions get dropped, and the transactions which read before droppedTs can't
+	// commit anymore. See Options.MaxOracleMemory.
+	memUsed   expvar.Int
+	maxMemory int64
+	droppedTs uint64
+	warningf  func(format string, v ...interface{})
+
 	// closer is used to stop watermarks.
 	closer *z.Closer
 }
 
 type committedTxn struct {
 	ts uint64
-	// ConflictKeys Keeps track of the entries written at timestamp ts.
-	conflictKeys map[uint64]struct{}
+	// ConflictKeys Keeps track of the entries written at timestamp ts, by their fingerprints. The
+	// keys are empty unless Options.DetailedErrors is set.
+	conflictKeys map[uint64]string
+	size         int64 // The estimated memory used by the transaction.
+}
+
+// committedKeySize is the estimated memory used by a key in committedTxn.conflictKeys, besides the
+// bytes of the key: the fingerprint, the string header and the overhead of the map.
+const committedKeySize = 48
+
+// newCommittedTxn returns the committedTxn for a transaction committed at ts.
+func newCommittedTxn(ts uint64, conflictKeys map[uint64]string) committedTxn {
+	ct := committedTxn{ts: ts, conflictKeys: conflictKeys}
+	ct.size = int64(unsafe.Sizeof(ct))
+	for _, k := range conflictKeys {
+		ct.size += committedKeySize + int64(len(k))
+	}
+	return ct
 }
 
 func newOracle(opt Options) *oracle {
@@ -73,10 +106,13 @@ func newOracle(opt Options) *oracle {
 		//
 		// WaterMarks must be 64-bit aligned for atomic package, hence we must use pointers here.
 		// See https://golang.org/pkg/sync/atomic/#pkg-note-BUG.
-		readMark: &y.WaterMark{Name: "badger.PendingReads"},
-		txnMark:  &y.WaterMark{Name: "badger.TxnTimestamp"},
-		closer:   z.NewCloser(2),
+		readMark:  &y.WaterMark{Name: "badger.PendingReads"},
+		txnMark:   &y.WaterMark{Name: "badger.TxnTimestamp"},
+		maxMemory: opt.MaxOracleMemory,
+		warningf:  opt.Warningf,
+		closer:    z.NewCloser(2),
 	}
+	y.OracleMemorySet(opt.MetricsEnabled, opt.Dir, &orc.memUsed)
 	orc.readMark.Init(orc.closer)
 	orc.txnMark.Init(orc.closer)
 	return orc
@@ -126,20 +162,75 @@ func (o *oracle) setDiscardTs(ts uint64) {
 	o.cleanupCommittedTransactions()
 }
 
+// setDeleteTs makes compactions drop all versions at or below ts. As such versions must not be
+// read anymore, the discard ts is raised to ts as well.
+func (o *oracle) setDeleteTs(ts uint64) {
+	o.Lock()
+	defer o.Unlock()
+	if ts > o.deleteTs.Load() {
+		o.deleteTs.Store(ts)
+	}
+	if ts > o.discardTs {
+		o.discardTs = ts
+		o.cleanupCommittedTransactions()
+	}
+}
+
+func (o *oracle) deleteAtOrBelow() uint64 {
+	return o.deleteTs.Load()
+}
+
 func (o *oracle) discardAtOrBelow() uint64 {
 	if o.isManaged {
 		o.Lock()
 		defer o.Unlock()
 		return o.discardTs
 	}
-	return o.readMark.DoneUntil()
+	o.Lock()
+	defer o.Unlock()
+	ts := o.readMark.DoneUntil()
+	for pin := range o.pins {
+		if pin < ts {
+			ts = pin
+		}
+	}
+	return ts
 }
 
-// hasConflict must be called while having a lock.
-func (o *oracle) hasConflict(txn *Txn) bool {
-	if len(txn.reads) == 0 {
+// pinVersion keeps compactions from discarding the versions visible at ts outside managed mode,
+// like a read at ts would, until unpinVersion is called. Unlike a read mark, it can be taken on a
+// version below the latest one. It returns false if ts is below discardAtOrBelow, as the versions
+// may be gone already.
+func (o *oracle) pinVersion(ts uint64) bool {
+	o.Lock()
+	defer o.Unlock()
+	if ts < o.readMark.DoneUntil() {
 		return false
 	}
+	if o.pins == nil {
+		o.pins = make(map[uint64]int)
+	}
+	o.pins[ts]++
+	return true
+}
+
+func (o *oracle) unpinVersion(ts uint64) {
+	o.Lock()
+	defer o.Unlock()
+	if o.pins[ts]--; o.pins[ts] == 0 {
+		delete(o.pins, ts)
+	}
+}
+
+// conflicts tells whether keys read by txn were written by the transactions committed since it
+// started, and returns these keys if Options.DetailedErrors is set. It must be called while having
+// a lock.
+func (o *oracle) conflicts(txn *Txn) ([][]byte, bool) {
+	if len(txn.reads) == 0 {
+		return nil, false
+	}
+	var keys [][]byte
+	var found map[uint64]struct{}
 	for _, committedTxn := range o.committedTxns {
 		// If the committedTxn.ts is less than txn.readTs that implies that the
 		// committedTxn finished before the current transaction started.
@@ -152,21 +243,40 @@ func (o *oracle) hasConflict(txn *Txn) bool {
 		}
 
 		for _, ro := range txn.reads {
-			if _, has := committedTxn.conflictKeys[ro]; has {
-				return true
+			key, has := committedTxn.conflictKeys[ro]
+			if !has {
+				continue
+			}
+			if _, dup := found[ro]; dup {
+				continue
+			}
+			if found == nil {
+				found = make(map[uint64]struct{})
+			}
+			found[ro] = struct{}{}
+			if key != "" {
+				keys = append(keys, []byte(key))
 			}
 		}
 	}
 
-	return false
+	return keys, found != nil
 }
 
-func (o *oracle) newCommitTs(txn *Txn) (uint64, bool) {
+// newCommitTs returns the commit timestamp of txn. If txn conflicts, it returns false along with
+// the keys it conflicts on, which are empty if the conflict is due to Options.MaxOracleMemory, or
+// if Options.DetailedErrors isn't set.
+func (o *oracle) newCommitTs(txn *Txn) (uint64, [][]byte, bool) {
 	o.Lock()
 	defer o.Unlock()
 
-	if o.hasConflict(txn) {
-		return 0, true
+	if conflicts, conflict := o.conflicts(txn); conflict {
+		return 0, conflicts, false
+	}
+	if len(txn.reads) > 0 && txn.readTs < o.droppedTs {
+		// Transactions committed after txn started were dropped, so its conflicts can't be
+		// checked.
+		return 0, nil, false
 	}
 
 	var ts uint64
@@ -189,13 +299,37 @@ func (o *oracle) newCommitTs(txn *Txn) (uint64, bool) {
 	if o.detectConflicts {
 		// We should ensure that txns are not added to o.committedTxns slice when
 		// conflict detection is disabled otherwise this slice would keep growing.
-		o.committedTxns = append(o.committedTxns, committedTxn{
-			ts:           ts,
-			conflictKeys: txn.conflictKeys,
-		})
+		ct := newCommittedTxn(ts, txn.conflictKeys)
+		o.committedTxns = append(o.committedTxns, ct)
+		o.memUsed.Add(ct.size)
+		o.shrinkCommittedTxns()
 	}
 
-	return ts, false
+	return ts, nil, true
+}
+
+// shrinkCommittedTxns drops the oldest committed transactions once their memory exceeds
+// maxMemory, until they use no more than three quarters of it. This keeps a transaction which is
+// never discarded from making committedTxns grow without bound. The transactions which started
+// before a dropped one committed fail to commit with ErrConflict from then on. Must be called
+// under o.Lock.
+func (o *oracle) shrinkCommittedTxns() {
+	if o.maxMemory <= 0 || o.memUsed.Value() <= o.maxMemory {
+		return
+	}
+	mem := o.memUsed.Value()
+	var n int
+	for n < len(o.committedTxns) && mem > o.maxMemory/4*3 {
+		ct := o.committedTxns[n]
+		mem -= ct.size
+		o.droppedTs = max(o.droppedTs, ct.ts)
+		n++
+	}
+	o.committedTxns = append(o.committedTxns[:0], o.committedTxns[n:]...)
+	o.memUsed.Set(mem)
+	o.warningf("Conflict detection uses more than MaxOracleMemory of %d bytes, likely because "+
+		"of a transaction which isn't discarded. Dropped %d committed transactions, transactions "+
+		"which read before version %d will fail with ErrConflict.", o.maxMemory, n, o.droppedTs)
 }
 
 func (o *oracle) doneRead(txn *Txn) {
@@ -219,11 +353,10 @@ func (o *oracle) cleanupCommittedTransactions() { // Must be called under o.Lock
 		maxReadTs = o.readMark.DoneUntil()
 	}
 
-	y.AssertTrue(maxReadTs >= o.lastCleanupTs)
-
 	// do not run clean up if the maxReadTs (read timestamp of the
-	// oldest transaction that is still in flight) has not increased
-	if maxReadTs == o.lastCleanupTs {
+	// oldest transaction that is still in flight) has not increased.
+	// In managed mode, it decreases if SetDiscardTs moves the discard ts back.
+	if maxReadTs <= o.lastCleanupTs {
 		return
 	}
 	o.lastCleanupTs = maxReadTs
@@ -231,6 +364,7 @@ func (o *oracle) cleanupCommittedTransactions() { // Must be called under o.Lock
 	tmp := o.committedTxns[:0]
 	for _, txn := range o.committedTxns {
 		if txn.ts <= maxReadTs {
+			o.memUsed.Add(-txn.size)
 			continue
 		}
 		tmp = append(tmp, txn)
@@ -255,12 +389,25 @@ type Txn struct {
 	db       *DB
 
 	reads []uint64 // contains fingerprints of keys read.
-	// contains fingerprints of keys written. This is used for conflict detection.
-	conflictKeys map[uint64]struct{}
-	readsLock    sync.Mutex // guards the reads slice. See addReadKey.
+	// maps the fingerprints of keys written to the keys. This is used for conflict detection.
+	conflictKeys map[uint64]string
+	readsLock    sync.Mutex // guards the reads slice, lastRead and skipConflicts.
+	lastRead     *ReadStats // Stats of the last Get. See LastReadStats.
+	readStats    bool       // Set if the stats of the reads are collected. See SetReadStats.
 
 	pendingWrites   map[string]*Entry // cache stores any writes done by txn.
 	duplicateWrites []*Entry          // Used in managed mode to store duplicate entries.
+	// dedupWrites makes a write replace any earlier write of the same key, even if the two
+	// writes have different versions. Set by WriteBatch.SetDedup.
+	dedupWrites bool
+	// loaded caches the values fetched by the ReadThroughLoader, so that a key loaded by this
+	// txn reads the same on every Get. Only used by read-only transactions.
+	loaded map[string]*Item
+	// conflicts holds the keys the last commit attempt conflicted on. See ConflictKeys.
+	conflicts [][]byte
+	// skipConflicts makes the txn not track its reads, so that it can't conflict. See
+	// SetDetectConflicts.
+	skipConflicts bool
 
 	numIterators atomic.Int32
 	discarded    bool
@@ -309,6 +456,8 @@ func (pi *pendingWritesIterator) Value() y.ValueStruct {
 		UserMeta:  entry.UserMeta,
 		ExpiresAt: entry.ExpiresAt,
 		Version:   pi.readTs,
+
+		UserMetaHigh: entry.userMetaHigh,
 	}
 }
 
@@ -346,9 +495,9 @@ func (txn *Txn) newPendingWritesIterator(reversed bool) *pendingWritesIterator {
 func (txn *Txn) checkSize(e *Entry) error {
 	count := txn.count + 1
 	// Extra bytes for the version in key.
-	size := txn.size + e.estimateSizeAndSetThreshold(txn.db.valueThreshold()) + 10
+	size := txn.size + e.estimateSizeAndSetThreshold(txn.db.entryValueThreshold(e.Key)) + 10
 	if count >= txn.db.opt.maxBatchCount || size >= txn.db.opt.maxBatchSize {
-		return ErrTxnTooBig
+		return txn.db.txnTooBig(size, count)
 	}
 	txn.count, txn.size = count, size
 	return nil
@@ -360,13 +509,47 @@ func exceedsSize(prefix string, max int64, key []byte) error {
 }
 
 func (txn *Txn) modify(e *Entry) error {
-	const maxKeySize = 65000
-
 	switch {
 	case !txn.update:
 		return ErrReadOnlyTxn
 	case txn.discarded:
 		return ErrDiscardedTxn
+	}
+	if err := txn.checkEntry(e); err != nil {
+		return err
+	}
+	if err := txn.checkSize(e); err != nil {
+		return err
+	}
+
+	// The txn.conflictKeys is used for conflict detection. If conflict detection
+	// is disabled, we don't need to store key hashes in this map. The keys themselves are only
+	// kept to report the conflicts, see Options.DetailedErrors.
+	k := string(e.Key)
+	if txn.db.opt.DetectConflicts {
+		fp := z.MemHash(e.Key) // Avoid dealing with byte arrays.
+		if txn.db.opt.DetailedErrors {
+			txn.conflictKeys[fp] = k
+		} else {
+			txn.conflictKeys[fp] = ""
+		}
+	}
+	// If a duplicate entry was inserted in managed mode, move it to the duplicate writes slice.
+	// Add the entry to duplicateWrites only if both the entries have different versions. For
+	// same versions, or if dedupWrites is set, we will overwrite the existing entry.
+	if oldEntry, ok := txn.pendingWrites[k]; ok && oldEntry.version != e.version &&
+		!txn.dedupWrites {
+		txn.duplicateWrites = append(txn.duplicateWrites, oldEntry)
+	}
+	txn.pendingWrites[k] = e
+	return nil
+}
+
+// checkEntry checks the key and the value of an entry before it gets written.
+func (txn *Txn) checkEntry(e *Entry) error {
+	const maxKeySize = 65000
+
+	switch {
 	case len(e.Key) == 0:
 		return ErrEmptyKey
 	case bytes.HasPrefix(e.Key, badgerPrefix):
@@ -376,34 +559,60 @@ func (txn *Txn) modify(e *Entry) error {
 		// keep things safe and allow badger move prefix and a timestamp suffix, let's
 		// cut it down to 65000, instead of using 65536.
 		return exceedsSize("Key", maxKeySize, e.Key)
-	case int64(len(e.Value)) > txn.db.opt.ValueLogFileSize:
-		return exceedsSize("Value", txn.db.opt.ValueLogFileSize, e.Value)
+	case int64(len(e.Value)) > txn.db.maxValueSize(e.Key):
+		return &ValueTooLargeError{Size: int64(len(e.Value)), Max: txn.db.maxValueSize(e.Key)}
 	case txn.db.opt.InMemory && int64(len(e.Value)) > txn.db.valueThreshold():
 		return exceedsSize("Value", txn.db.valueThreshold(), e.Value)
+	case e.userMetaHigh != 0 && !txn.db.opt.WideUserMeta:
+		return ErrWideUserMeta
 	}
 
-	if err := txn.db.isBanned(e.Key); err != nil {
-		return err
-	}
+	return txn.db.isBanned(e.Key)
+}
 
-	if err := txn.checkSize(e); err != nil {
-		return err
+// validate runs the checks of the writes and of the commit of the transaction again on its
+// pending writes, without committing them. The checks which depend on the options or the state of
+// the DB, like the size limits or the banned namespaces, may fail now even though they passed when
+// the writes were added. It also fails for writes which have already expired. An
+// *InvalidEntryError for the first write which fails a check, in key order, is returned, or a
+// *TxnTooBigError if the writes don't fit into one request.
+func (txn *Txn) validate() error {
+	if txn.discarded {
+		return ErrDiscardedTxn
 	}
+	entries := make([]*Entry, 0, len(txn.pendingWrites)+len(txn.duplicateWrites))
+	for _, e := range txn.pendingWrites {
+		entries = append(entries, e)
+	}
+	entries = append(entries, txn.duplicateWrites...)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].Key, entries[j].Key) < 0
+	})
 
-	// The txn.conflictKeys is used for conflict detection. If conflict detection
-	// is disabled, we don't need to store key hashes in this map.
-	if txn.db.opt.DetectConflicts {
-		fp := z.MemHash(e.Key) // Avoid dealing with byte arrays.
-		txn.conflictKeys[fp] = struct{}{}
+	now := uint64(time.Now().Unix())
+	var size, count int64
+	for _, e := range entries {
+		err := txn.checkEntry(e)
+		switch {
+		case err != nil:
+		case e.meta&(bitValuePointer|bitTxn|bitFinTxn) != 0:
+			// Internal meta bits.
+			err = ErrInvalidRequest
+		case e.ExpiresAt != 0 && e.ExpiresAt <= now:
+			err = ErrExpiredEntry
+		}
+		if err != nil {
+			return &InvalidEntryError{Key: e.Key, Err: err}
+		}
+		count++
+		size += e.estimateSizeAndSetThreshold(txn.db.entryValueThreshold(e.Key)) + 10
 	}
-	// If a duplicate entry was inserted in managed mode, move it to the duplicate writes slice.
-	// Add the entry to duplicateWrites only if both the entries have different versions. For
-	// same versions, we will overwrite the existing entry.
-	if oldEntry, ok := txn.pendingWrites[string(e.Key)]; ok && oldEntry.version != e.version {
-		txn.duplicateWrites = append(txn.duplicateWrites, oldEntry)
+	if count >= txn.db.opt.maxBatchCount || size >= txn.db.opt.maxBatchSize {
+		// Unlike the writes, this always tells the sizes.
+		return &TxnTooBigError{Size: size, Max: txn.db.opt.maxBatchSize,
+			Count: count, MaxCount: txn.db.opt.maxBatchCount}
 	}
-	txn.pendingWrites[string(e.Key)] = e
-	return nil
+	return txn.commitPrecheck()
 }
 
 // Set adds a key-value pair to the database.
@@ -421,9 +630,32 @@ func (txn *Txn) Set(key, val []byte) error {
 // The current transaction keeps a reference to the entry passed in argument.
 // Users must not modify the entry until the end of the transaction.
 func (txn *Txn) SetEntry(e *Entry) error {
+	if len(e.Key) > 0 && txn.db.opt.KeyWriteTransform != nil {
+		te := *e
+		te.Key = txn.db.opt.KeyWriteTransform(e.Key)
+		e = &te
+	}
 	return txn.modify(e)
 }
 
+// setKey sets the keys of an item looked up by userKey, which is stored under key, the same way
+// Iterator.fill does: item.key holds the stored key, and FullKey returns userKey if it differs.
+func (item *Item) setKey(key, userKey []byte) {
+	item.key = key
+	if !bytes.Equal(key, userKey) {
+		item.fullKey = userKey
+	}
+}
+
+// storedKey returns the key under which key is stored, see Options.WithKeyTransform. Empty keys
+// are returned as is, so that they still get rejected.
+func (db *DB) storedKey(key []byte) []byte {
+	if len(key) == 0 || db.opt.KeyWriteTransform == nil {
+		return key
+	}
+	return db.opt.KeyWriteTransform(key)
+}
+
 // Delete deletes a key.
 //
 // This is done by adding a delete marker for the key at commit timestamp.  Any
@@ -434,7 +666,7 @@ func (txn *Txn) SetEntry(e *Entry) error {
 // Users must not modify the key until the end of the transaction.
 func (txn *Txn) Delete(key []byte) error {
 	e := &Entry{
-		Key:  key,
+		Key:  txn.db.storedKey(key),
 		meta: bitDelete,
 	}
 	return txn.modify(e)
@@ -448,25 +680,44 @@ func (txn *Txn) Get(key []byte) (item *Item, rerr error) {
 	} else if txn.discarded {
 		return nil, ErrDiscardedTxn
 	}
+	userKey := key
+	key = txn.db.storedKey(key)
 
 	if err := txn.db.isBanned(key); err != nil {
 		return nil, err
 	}
+	if txn.db.latency != nil {
+		defer txn.db.latency.get.since(time.Now())
+	}
 
 	item = new(Item)
+	var stats *ReadStats
+	if txn.readStats {
+		stats = new(ReadStats)
+		defer txn.setLastRead(stats)
+	}
 	if txn.update {
 		if e, has := txn.pendingWrites[string(key)]; has && bytes.Equal(key, e.Key) {
 			if isDeletedOrExpired(e.meta, e.ExpiresAt) {
-				return nil, ErrKeyNotFound
+				return nil, txn.db.keyNotFound(userKey)
 			}
 			// Fulfill from cache.
 			item.meta = e.meta
 			item.val = e.Value
-			item.userMeta = e.UserMeta
-			item.key = key
+			item.userMeta, item.userMetaHigh = e.UserMeta, e.userMetaHigh
+			item.setKey(key, userKey)
 			item.status = prefetched
 			item.version = txn.readTs
 			item.expiresAt = e.ExpiresAt
+			if e.vptr != nil {
+				// The value is still in the value log, see DB.RefreshTTL.
+				item.meta |= bitValuePointer
+				item.vptr = e.vptr
+				item.val = nil
+				item.status = 0
+				item.txn = txn
+				item.readStats = stats
+			}
 			// We probably don't need to set db on item here.
 			return item, nil
 		}
@@ -475,28 +726,247 @@ func (txn *Txn) Get(key []byte) (item *Item, rerr error) {
 		txn.addReadKey(key)
 	}
 
-	seek := y.KeyWithTs(key, txn.readTs)
-	vs, err := txn.db.get(seek)
+	loadThrough := !txn.update && txn.db.opt.ReadThroughLoader != nil
+	if loadThrough {
+		if item, has := txn.loaded[string(userKey)]; has {
+			return item, nil
+		}
+	}
+
+	vs, err := txn.db.getLatest(key, txn.readTs, stats)
 	if err != nil {
 		return nil, y.Wrapf(err, "DB::Get key: %q", key)
 	}
-	if vs.Value == nil && vs.Meta == 0 {
-		return nil, ErrKeyNotFound
-	}
-	if isDeletedOrExpired(vs.Meta, vs.ExpiresAt) {
-		return nil, ErrKeyNotFound
+	if (vs.Value == nil && vs.Meta == 0) || isDeletedOrExpired(vs.Meta, vs.ExpiresAt) {
+		if loadThrough {
+			return txn.loadThrough(userKey)
+		}
+		return nil, txn.db.keyNotFound(userKey)
 	}
 
-	item.key = key
+	if txn.db.readRepair != nil {
+		txn.db.readRepair.record(userKey)
+	}
+	item.setKey(key, userKey)
 	item.version = vs.Version
 	item.meta = vs.Meta
-	item.userMeta = vs.UserMeta
+	item.userMeta, item.userMetaHigh = vs.UserMeta, vs.UserMetaHigh
 	item.vptr = y.SafeCopy(item.vptr, vs.Value)
 	item.txn = txn
+	item.readStats = stats
 	item.expiresAt = vs.ExpiresAt
 	return item, nil
 }
 
+// ReadStats describes the work done by Txn.Get to read a key, which tells the read amplification.
+type ReadStats struct {
+	// TablesConsulted is the number of tables whose key range holds the key, including the ones
+	// skipped thanks to their bloom filter.
+	TablesConsulted int
+	// BlocksRead is the number of table blocks read, from the block cache or from disk.
+	BlocksRead int
+	// BloomFilterNegatives is the number of tables skipped because their bloom filter showed that
+	// they don't hold the key.
+	BloomFilterNegatives int
+	// VlogReads is the number of times the value was read from the value log by Item.Value or
+	// Item.ValueCopy.
+	VlogReads int
+}
+
+// SetReadStats sets whether the transaction collects the stats of its reads, returned by
+// LastReadStats. It is off by default, as collecting them costs an allocation and a lock on every
+// Get. It applies to the reads made after it's called, and mustn't be called concurrently with
+// them.
+func (txn *Txn) SetReadStats(collect bool) {
+	txn.readStats = collect
+}
+
+// LastReadStats returns the stats of the last call to Get made with SetReadStats turned on,
+// including the calls which returned ErrKeyNotFound. Reads served from the writes of the
+// transaction, or from the point read cache, don't consult any table. The stats of the memtables
+// aren't included, as reading them doesn't touch the disk. VlogReads keeps counting the value log
+// reads of the item returned by the last Get.
+func (txn *Txn) LastReadStats() ReadStats {
+	txn.readsLock.Lock()
+	defer txn.readsLock.Unlock()
+	if txn.lastRead == nil {
+		return ReadStats{}
+	}
+	return *txn.lastRead
+}
+
+func (txn *Txn) setLastRead(stats *ReadStats) {
+	txn.readsLock.Lock()
+	txn.lastRead = stats
+	txn.readsLock.Unlock()
+}
+
+// GetAtVersion looks for the key as it was at the given version, i.e. its latest version at or
+// below it, like the all-versions iterator would return it. Versions above the read timestamp of
+// the transaction are never returned, so a version above it reads the same as Get, minus the
+// pending writes of the transaction. ErrKeyNotFound is returned if the key didn't exist at that
+// version, was deleted or has expired, or if its versions up to that one have been discarded by
+// compactions, see Options.NumVersionsToKeep.
+func (txn *Txn) GetAtVersion(key []byte, version uint64) (*Item, error) {
+	if len(key) == 0 {
+		return nil, ErrEmptyKey
+	} else if txn.discarded {
+		return nil, ErrDiscardedTxn
+	}
+	userKey := key
+	key = txn.db.storedKey(key)
+
+	if err := txn.db.isBanned(key); err != nil {
+		return nil, err
+	}
+	if txn.update {
+		txn.addReadKey(key)
+	}
+	if version > txn.readTs {
+		version = txn.readTs
+	}
+	vs, err := txn.db.get(y.KeyWithTs(key, version))
+	if err != nil {
+		return nil, y.Wrapf(err, "DB::GetAtVersion key: %q", key)
+	}
+	if (vs.Value == nil && vs.Meta == 0) || isDeletedOrExpired(vs.Meta, vs.ExpiresAt) {
+		return nil, txn.db.keyNotFound(userKey)
+	}
+	item := &Item{
+		version:   vs.Version,
+		meta:      vs.Meta,
+		userMeta:  vs.UserMeta,
+		vptr:      y.SafeCopy(nil, vs.Value),
+		txn:       txn,
+		expiresAt: vs.ExpiresAt,
+
+		userMetaHigh: vs.UserMetaHigh,
+	}
+	item.setKey(key, userKey)
+	return item, nil
+}
+
+// HasMany reports whether each of the keys exists, as Get would, in the same order as keys.
+// Deleted or expired keys are reported as missing. Instead of looking up each key separately, the
+// keys are sorted and looked up in a single pass over the memtables and the levels, with bloom
+// filters skipping the tables which can't have a key. This is much faster than calling Get for
+// each of a large batch of keys.
+func (txn *Txn) HasMany(keys [][]byte) ([]bool, error) {
+	if txn.discarded {
+		return nil, ErrDiscardedTxn
+	}
+	res := make([]bool, len(keys))
+	if txn.db.opt.KeyWriteTransform != nil {
+		stored := make([][]byte, len(keys))
+		for i, key := range keys {
+			stored[i] = txn.db.storedKey(key)
+		}
+		keys = stored
+	}
+	var idx []int // Indexes of the keys that need to be looked up.
+	for i, key := range keys {
+		if len(key) == 0 {
+			return nil, ErrEmptyKey
+		}
+		if err := txn.db.isBanned(key); err != nil {
+			return nil, err
+		}
+		if txn.update {
+			if e, has := txn.pendingWrites[string(key)]; has && bytes.Equal(key, e.Key) {
+				res[i] = !isDeletedOrExpired(e.meta, e.ExpiresAt)
+				continue
+			}
+			txn.addReadKey(key)
+		}
+		idx = append(idx, i)
+	}
+	sort.Slice(idx, func(i, j int) bool {
+		return bytes.Compare(keys[idx[i]], keys[idx[j]]) < 0
+	})
+
+	seeks := make([][]byte, len(idx))
+	for i, ki := range idx {
+		seeks[i] = y.KeyWithTs(keys[ki], txn.readTs)
+	}
+	vs, err := txn.db.getMany(seeks)
+	if err != nil {
+		return nil, y.Wrapf(err, "DB::HasMany")
+	}
+	for i, ki := range idx {
+		v := vs[i]
+		res[ki] = !(v.Value == nil && v.Meta == 0) && !isDeletedOrExpired(v.Meta, v.ExpiresAt)
+	}
+	return res, nil
+}
+
+// maxLoadThroughRetries is the number of times loadThrough reads the key again after the
+// transaction writing the loaded value conflicted, before it gives up.
+const maxLoadThroughRetries = 100
+
+// loadThrough fetches a key missing from the snapshot of txn using the ReadThroughLoader, and
+// writes it to the DB in a separate transaction. If the key was written after txn started, that
+// write wins and is returned instead of calling the loader. ErrConflict is returned if the write
+// still conflicts after maxLoadThroughRetries retries.
+func (txn *Txn) loadThrough(key []byte) (*Item, error) {
+	key = y.SafeCopy(nil, key)
+	item := &Item{status: prefetched}
+	item.setKey(txn.db.storedKey(key), key)
+	for retries := 0; ; retries++ {
+		var e *Entry
+		err := txn.db.Update(func(wtxn *Txn) error {
+			e = nil
+			cur, err := wtxn.Get(key)
+			switch {
+			case err == nil:
+				item.val, err = cur.ValueCopy(nil)
+				item.version = cur.version
+				item.userMeta, item.userMetaHigh = cur.userMeta, cur.userMetaHigh
+				item.expiresAt = cur.expiresAt
+				return err
+			case !errors.Is(err, ErrKeyNotFound):
+				return err
+			}
+
+			val, ttl, found, err := txn.db.opt.ReadThroughLoader(key)
+			if err != nil {
+				return y.Wrapf(err, "ReadThroughLoader key: %q", key)
+			}
+			if !found {
+				return ErrKeyNotFound
+			}
+			e = NewEntry(key, y.SafeCopy(nil, val))
+			if ttl > 0 {
+				e = e.WithTTL(ttl)
+			}
+			return wtxn.SetEntry(e)
+		})
+		if errors.Is(err, ErrConflict) && retries < maxLoadThroughRetries {
+			// Someone else wrote the key in the meantime. Pick up their write.
+			continue
+		}
+		if errors.Is(err, ErrKeyNotFound) {
+			return nil, txn.db.keyNotFound(key)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if e != nil {
+			// Commit has set the version of the entry to its commit timestamp.
+			item.val = e.Value
+			item.version = e.version
+			item.userMeta, item.userMetaHigh = e.UserMeta, e.userMetaHigh
+			item.expiresAt = e.ExpiresAt
+		}
+		break
+	}
+
+	if txn.loaded == nil {
+		txn.loaded = make(map[string]*Item)
+	}
+	txn.loaded[string(key)] = item
+	return item, nil
+}
+
 func (txn *Txn) addReadKey(key []byte) {
 	if txn.update {
 		fp := z.MemHash(key)
@@ -505,9 +975,66 @@ func (txn *Txn) addReadKey(key []byte) {
 		// for multiple threads within a read-write transaction to read keys at
 		// the same time. The reads slice is not currently thread-safe and
 		// needs to be locked whenever we mark a key as read.
+		var count int
 		txn.readsLock.Lock()
-		txn.reads = append(txn.reads, fp)
+		if !txn.skipConflicts {
+			txn.reads = append(txn.reads, fp)
+			count = len(txn.reads)
+		}
 		txn.readsLock.Unlock()
+
+		// The hook is called out of the lock, and only as the threshold is crossed.
+		opt := &txn.db.opt
+		if opt.LargeReadSetThreshold > 0 && opt.LargeReadSetHook != nil &&
+			count == opt.LargeReadSetThreshold+1 {
+			opt.LargeReadSetHook(count)
+		}
+	}
+}
+
+// IsHot tells whether a Get of key, and reading the value of the item it returns, would likely be
+// served from memory: from the writes of the transaction, the point read cache, the memtables, or
+// from tables whose index and blocks the key is looked up in are in the index and block caches.
+// A value stored in the value log counts as read from disk. A key which doesn't exist is hot if
+// finding that out doesn't read from disk. IsHot itself only looks into memory, and doesn't add
+// anything to the caches, nor does it track the key as read for conflict detection.
+//
+// IsHot is a hint: the caches may change right after it returns. It returns false if the key can't
+// be read, e.g. because the transaction was discarded.
+func (txn *Txn) IsHot(key []byte) bool {
+	if len(key) == 0 || txn.discarded {
+		return false
+	}
+	key = txn.db.storedKey(key)
+	if txn.db.isBanned(key) != nil {
+		return false
+	}
+	if txn.update {
+		if e, has := txn.pendingWrites[string(key)]; has && bytes.Equal(key, e.Key) {
+			return e.vptr == nil || isDeletedOrExpired(e.meta, e.ExpiresAt)
+		}
+	}
+	vs, cached, err := txn.db.getCached(key, txn.readTs)
+	if err != nil || !cached {
+		return false
+	}
+	return vs.Meta&bitValuePointer == 0 || isDeletedOrExpired(vs.Meta, vs.ExpiresAt)
+}
+
+// SetDetectConflicts sets whether the commit of the transaction is checked for conflicts with the
+// transactions committed since it started. By default, it is as per Options.DetectConflicts.
+// Turning it off for a transaction which doesn't read the keys it writes, like one only
+// appending new keys, saves tracking its reads. The writes of the transaction are still tracked,
+// so the other transactions reading the keys it writes do conflict with it. As conflict detection
+// can't be turned on for a single transaction, SetDetectConflicts(true) has no effect if
+// Options.DetectConflicts is false. It should be called before the first read of the transaction,
+// as turning it off forgets the reads made until then.
+func (txn *Txn) SetDetectConflicts(detect bool) {
+	txn.readsLock.Lock()
+	defer txn.readsLock.Unlock()
+	txn.skipConflicts = !detect
+	if txn.skipConflicts {
+		txn.reads = nil
 	}
 }
 
@@ -538,8 +1065,17 @@ func (txn *Txn) commitAndSend() (func() error, error) {
 	orc.writeChLock.Lock()
 	defer orc.writeChLock.Unlock()
 
-	commitTs, conflict := orc.newCommitTs(txn)
-	if conflict {
+	commitTs, conflicts, ok := orc.newCommitTs(txn)
+	if !ok {
+		if transform := txn.db.opt.KeyReadTransform; transform != nil {
+			for i, key := range conflicts {
+				conflicts[i] = transform(key)
+			}
+		}
+		txn.conflicts = conflicts
+		if txn.db.opt.DetailedErrors {
+			return nil, &ConflictError{Keys: conflicts}
+		}
 		return nil, ErrConflict
 	}
 
@@ -617,6 +1153,83 @@ func (txn *Txn) commitAndSend() (func() error, error) {
 	return ret, nil
 }
 
+// WriteFunc takes the entries written by a transaction. See Options.WriteMiddleware.
+type WriteFunc func(entries []*Entry) error
+
+// WriteMiddleware returns a WriteFunc which runs before next. See Options.WithWriteMiddleware.
+type WriteMiddleware func(next WriteFunc) WriteFunc
+
+// sendWrites passes the writes of txn through Options.WriteMiddleware, if any, on to
+// commitAndSend.
+func (txn *Txn) sendWrites() (func() error, error) {
+	mws := txn.db.opt.WriteMiddleware
+	if len(mws) == 0 {
+		return txn.commitAndSend()
+	}
+	var commitCb func() error
+	write := WriteFunc(func(entries []*Entry) error {
+		if err := txn.setWrites(entries); err != nil {
+			return err
+		}
+		var err error
+		commitCb, err = txn.commitAndSend()
+		return err
+	})
+	for i := len(mws) - 1; i >= 0; i-- {
+		write = mws[i](write)
+	}
+
+	entries := make([]*Entry, 0, len(txn.pendingWrites)+len(txn.duplicateWrites))
+	for _, e := range txn.pendingWrites {
+		entries = append(entries, e)
+	}
+	entries = append(entries, txn.duplicateWrites...)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].Key, entries[j].Key) < 0
+	})
+
+	err := write(entries)
+	switch {
+	case commitCb == nil && err != nil:
+		return nil, err
+	case commitCb == nil:
+		// The writes were dropped by a middleware.
+		return func() error { return nil }, nil
+	case err != nil:
+		return func() error {
+			if cerr := commitCb(); cerr != nil {
+				return cerr
+			}
+			return err
+		}, nil
+	}
+	return commitCb, nil
+}
+
+// setWrites replaces the writes of txn with entries. The entries are checked like the writes
+// added to txn, as a middleware may have changed or added them.
+func (txn *Txn) setWrites(entries []*Entry) error {
+	txn.count, txn.size = 0, 0
+	for _, e := range entries {
+		if err := txn.checkEntry(e); err != nil {
+			return err
+		}
+		if err := txn.checkSize(e); err != nil {
+			return err
+		}
+	}
+	txn.pendingWrites = make(map[string]*Entry, len(entries))
+	txn.duplicateWrites = nil
+	for _, e := range entries {
+		k := string(e.Key)
+		if old, ok := txn.pendingWrites[k]; ok && old.version != e.version && !txn.dedupWrites {
+			txn.duplicateWrites = append(txn.duplicateWrites, old)
+		}
+		txn.pendingWrites[k] = e
+	}
+	return nil
+}
+
 func (txn *Txn) commitPrecheck() error {
 	if txn.discarded {
 		return errors.New("Trying to commit a discarded txn")
@@ -636,6 +1249,42 @@ func (txn *Txn) commitPrecheck() error {
 	if keepTogether && txn.db.opt.managedTxns && txn.commitTs == 0 {
 		return errors.New("CommitTs cannot be zero. Please use commitAt instead")
 	}
+	if err := txn.db.checkValueLogSpace(txn); err != nil {
+		return err
+	}
+	if txn.db.opt.managedTxns && txn.db.opt.RejectDuplicateVersions {
+		return txn.checkDuplicateVersions()
+	}
+	return nil
+}
+
+// checkDuplicateVersions returns a *DuplicateVersionError if a write of txn is at a version its
+// key already has.
+func (txn *Txn) checkDuplicateVersions() error {
+	check := func(e *Entry) error {
+		version := e.version
+		if version == 0 {
+			version = txn.commitTs
+		}
+		vs, err := txn.db.get(y.KeyWithTs(e.Key, version))
+		if err != nil {
+			return err
+		}
+		if vs.Version == version && (vs.Meta != 0 || vs.Value != nil) {
+			return &DuplicateVersionError{Key: y.SafeCopy(nil, e.Key), Version: version}
+		}
+		return nil
+	}
+	for _, e := range txn.pendingWrites {
+		if err := check(e); err != nil {
+			return err
+		}
+	}
+	for _, e := range txn.duplicateWrites {
+		if err := check(e); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -643,7 +1292,9 @@ func (txn *Txn) commitPrecheck() error {
 //
 // 1. If there are no writes, return immediately.
 //
-// 2. Check if read rows were updated since txn started. If so, return ErrConflict.
+// 2. Check if read rows were updated since txn started. If so, return ErrConflict, or a
+// *ConflictError wrapping it if Options.DetailedErrors is set. The conflicting keys are then
+// available via ConflictKeys as well.
 //
 // 3. If no conflict, generate a commit timestamp and update written rows' commit ts.
 //
@@ -670,8 +1321,11 @@ func (txn *Txn) Commit() error {
 		return err
 	}
 	defer txn.Discard()
+	if txn.db.latency != nil {
+		defer txn.db.latency.commit.since(time.Now())
+	}
 
-	txnCb, err := txn.commitAndSend()
+	txnCb, err := txn.sendWrites()
 	if err != nil {
 		return err
 	}
@@ -682,6 +1336,36 @@ func (txn *Txn) Commit() error {
 	return txnCb()
 }
 
+// ConflictKeys returns the keys read by the transaction which made its commit fail with
+// ErrConflict, because transactions committed since it started wrote them. The keys are only kept
+// if Options.DetailedErrors is set, otherwise it returns nil. It returns nil as well if the
+// transaction didn't fail to commit due to a conflict, or if it failed because the transactions
+// it could conflict with were dropped due to Options.MaxOracleMemory. Conflicts are detected via
+// fingerprints of the keys, so in the rare case of a collision a key may be returned which another
+// transaction wrote but this one didn't read.
+func (txn *Txn) ConflictKeys() [][]byte {
+	return txn.conflicts
+}
+
+// keyNotFound returns the error of a lookup of key which found nothing: a *KeyNotFoundError if
+// Options.DetailedErrors is set, else ErrKeyNotFound, so that misses don't allocate by default.
+func (db *DB) keyNotFound(key []byte) error {
+	if !db.opt.DetailedErrors {
+		return ErrKeyNotFound
+	}
+	return &KeyNotFoundError{Key: key}
+}
+
+// txnTooBig returns the error of a write which would take a request to size bytes and count
+// entries: a *TxnTooBigError if Options.DetailedErrors is set, else ErrTxnTooBig.
+func (db *DB) txnTooBig(size, count int64) error {
+	if !db.opt.DetailedErrors {
+		return ErrTxnTooBig
+	}
+	return &TxnTooBigError{Size: size, Max: db.opt.maxBatchSize,
+		Count: count, MaxCount: db.opt.maxBatchCount}
+}
+
 type txnCb struct {
 	commit func() error
 	user   func(error)
@@ -731,7 +1415,7 @@ func (txn *Txn) CommitWith(cb func(error)) {
 
 	defer txn.Discard()
 
-	commitCb, err := txn.commitAndSend()
+	commitCb, err := txn.sendWrites()
 	if err != nil {
 		go runTxnCallback(&txnCb{user: cb, err: err})
 		return
@@ -740,6 +1424,27 @@ func (txn *Txn) CommitWith(cb func(error)) {
 	go runTxnCallback(&txnCb{user: cb, commit: commitCb})
 }
 
+// CommitAsync commits the transaction like CommitWith, but returns a channel which receives the
+// result of the commit once its writes are durable: nil, or the error Commit would have returned,
+// such as ErrConflict. The channel is buffered, so it doesn't need to be read.
+//
+// Conflicts are checked and the commit timestamp is assigned before CommitAsync returns, so the
+// transactions committed one after another are applied in that order, and the next transaction
+// can be prepared while the writes of the previous ones are in flight. Without Options.SyncWrites,
+// the logs are synced with DB.Sync after the writes, so that a nil result means that the commit
+// survives a crash.
+func (txn *Txn) CommitAsync() <-chan error {
+	db := txn.db
+	done := make(chan error, 1)
+	txn.CommitWith(func(err error) {
+		if err == nil && !db.opt.SyncWrites && !db.opt.InMemory {
+			err = db.Sync()
+		}
+		done <- err
+	})
+	return done
+}
+
 // ReadTs returns the read timestamp of the transaction.
 func (txn *Txn) ReadTs() uint64 {
 	return txn.readTs
@@ -783,7 +1488,7 @@ func (db *DB) newTransaction(update, isManaged bool) *Txn {
 	}
 	if update {
 		if db.opt.DetectConflicts {
-			txn.conflictKeys = make(map[uint64]struct{})
+			txn.conflictKeys = make(map[uint64]string)
 		}
 		txn.pendingWrites = make(map[string]*Entry)
 	}
@@ -830,3 +1535,74 @@ func (db *DB) Update(fn func(txn *Txn) error) error {
 
 	return txn.Commit()
 }
+
+// touch rewrites the latest version of key with the given expiry. A value stored in the value log
+// isn't rewritten, the new version points at it instead.
+func (txn *Txn) touch(key []byte, expiresAt uint64) error {
+	item, err := txn.Get(key)
+	if err != nil {
+		return err
+	}
+	e, err := txn.touchEntry(key, item, expiresAt)
+	if err != nil {
+		return err
+	}
+	return txn.modify(e)
+}
+
+// touchEntry returns an entry writing the value of item, read for key, again with the given
+// expiry. The entry points at the value in the value log, if the value is stored there.
+func (txn *Txn) touchEntry(key []byte, item *Item, expiresAt uint64) (*Entry, error) {
+	e := &Entry{
+		Key:       txn.db.storedKey(key),
+		ExpiresAt: expiresAt,
+		UserMeta:  item.userMeta,
+		meta:      item.meta &^ (bitValuePointer | bitTxn | bitFinTxn),
+
+		userMetaHigh: item.userMetaHigh,
+	}
+	if item.meta&bitValuePointer > 0 {
+		e.vptr = item.vptr
+		return e, nil
+	}
+	var err error
+	e.Value, err = item.ValueCopy(nil)
+	return e, err
+}
+
+// RefreshTTL sets the time to live of each of the given keys to ttl, or removes their expiry if
+// ttl is zero. Unlike setting the keys again, the values aren't read or rewritten: each key gets a
+// new version which points at the value already in the value log, so refreshing the TTL of keys
+// with large values is cheap. Keys which don't exist, or are deleted or expired, are skipped.
+//
+// The keys are updated in as many transactions as needed, so a failure can leave some of them
+// refreshed. ErrConflict is returned if a key was updated concurrently. RefreshTTL cannot be used
+// with managed transactions.
+func (db *DB) RefreshTTL(keys [][]byte, ttl time.Duration) error {
+	if ttl < 0 {
+		return errors.Errorf("Invalid TTL: %s. It cannot be negative", ttl)
+	}
+	var expiresAt uint64
+	if ttl > 0 {
+		expiresAt = uint64(time.Now().Add(ttl).Unix())
+	}
+	if db.IsClosed() {
+		return ErrDBClosed
+	}
+	txn := db.NewTransaction(true)
+	defer func() { txn.Discard() }()
+	for _, key := range keys {
+		err := txn.touch(key, expiresAt)
+		if errors.Is(err, ErrTxnTooBig) {
+			if err := txn.Commit(); err != nil {
+				return err
+			}
+			txn = db.NewTransaction(true)
+			err = txn.touch(key, expiresAt)
+		}
+		if err != nil && !errors.Is(err, ErrKeyNotFound) {
+			return err
+		}
+	}
+	return txn.Commit()
+}
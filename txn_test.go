@@ -17,6 +17,9 @@
 package badger
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"os"
@@ -28,6 +31,7 @@ import (
 
 	"github.com/stretchr/testify/require"
 
+	"github.com/0xEggTart/badger/pb"
 	"github.com/0xEggTart/badger/y"
 	"github.com/dgraph-io/ristretto/v2/z"
 )
@@ -162,6 +166,41 @@ func TestTxnCommitAsync(t *testing.T) {
 	})
 }
 
+func TestTxnCommitAsyncChannel(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		// Commits sent one after another are applied in that order.
+		var results []<-chan error
+		for i := 0; i < 20; i++ {
+			txn := db.NewTransaction(true)
+			require.NoError(t, txn.Set([]byte("counter"), []byte(strconv.Itoa(i))))
+			results = append(results, txn.CommitAsync())
+		}
+		for _, res := range results {
+			require.NoError(t, <-res)
+		}
+		require.NoError(t, db.View(func(txn *Txn) error {
+			item, err := txn.Get([]byte("counter"))
+			require.NoError(t, err)
+			require.Equal(t, []byte("19"), getItemValue(t, item))
+			return nil
+		}))
+
+		txn := db.NewTransaction(true)
+		_, err := txn.Get([]byte("counter"))
+		require.NoError(t, err)
+		require.NoError(t, txn.Set([]byte("counter"), []byte("a")))
+		other := db.NewTransaction(true)
+		require.NoError(t, other.Set([]byte("counter"), []byte("b")))
+		require.NoError(t, <-other.CommitAsync())
+		require.ErrorIs(t, <-txn.CommitAsync(), ErrConflict)
+		// The keys are only kept with Options.DetailedErrors.
+		require.Nil(t, txn.ConflictKeys())
+
+		// A transaction without writes succeeds right away.
+		require.NoError(t, <-db.NewTransaction(true).CommitAsync())
+	})
+}
+
 func TestTxnVersions(t *testing.T) {
 	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
 		k := []byte("key")
@@ -953,3 +992,675 @@ func TestConflict(t *testing.T) {
 		runTest(t, testAndSetItr)
 	})
 }
+
+func TestDetailedErrors(t *testing.T) {
+	// run triggers ErrKeyNotFound, ErrTxnTooBig and ErrConflict, and returns the errors.
+	run := func(t *testing.T, db *DB) (notFound, tooBig, conflict error, conflictKeys [][]byte) {
+		notFound = db.View(func(txn *Txn) error {
+			_, err := txn.Get([]byte("missing"))
+			return err
+		})
+
+		txn := db.NewTransaction(true)
+		val := make([]byte, 512)
+		for i := 0; tooBig == nil; i++ {
+			tooBig = txn.Set([]byte(fmt.Sprintf("big%06d", i)), val)
+		}
+		txn.Discard()
+
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set([]byte("a"), []byte("0"))
+		}))
+		txna := db.NewTransaction(true)
+		defer txna.Discard()
+		_, err := txna.Get([]byte("a"))
+		require.NoError(t, err)
+		require.NoError(t, txna.Set([]byte("b"), []byte("1")))
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set([]byte("a"), []byte("2"))
+		}))
+		conflict = txna.Commit()
+		return notFound, tooBig, conflict, txna.ConflictKeys()
+	}
+
+	opt := getTestOptions("")
+	opt.MemTableSize = 1 << 20 // Keep the batch limits small.
+	opt.ValueThreshold = 1 << 10
+	t.Run("sentinels", func(t *testing.T) {
+		runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+			notFound, tooBig, conflict, keys := run(t, db)
+			require.Equal(t, ErrKeyNotFound, notFound)
+			require.Equal(t, ErrTxnTooBig, tooBig)
+			require.Equal(t, ErrConflict, conflict)
+			require.Nil(t, keys)
+		})
+	})
+	t.Run("detailed", func(t *testing.T) {
+		opt := opt.WithDetailedErrors(true)
+		runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+			notFound, tooBig, conflict, keys := run(t, db)
+			var nf *KeyNotFoundError
+			require.ErrorAs(t, notFound, &nf)
+			require.Equal(t, []byte("missing"), nf.Key)
+			require.ErrorIs(t, notFound, ErrKeyNotFound)
+
+			var tb *TxnTooBigError
+			require.ErrorAs(t, tooBig, &tb)
+			require.Equal(t, db.opt.maxBatchSize, tb.Max)
+			require.Greater(t, tb.Size, tb.Max)
+			require.ErrorIs(t, tooBig, ErrTxnTooBig)
+
+			var ce *ConflictError
+			require.ErrorAs(t, conflict, &ce)
+			require.Equal(t, [][]byte{[]byte("a")}, ce.Keys)
+			require.Equal(t, ce.Keys, keys)
+			require.ErrorIs(t, conflict, ErrConflict)
+		})
+	})
+}
+
+func TestTxnLastReadStats(t *testing.T) {
+	dir, err := os.MkdirTemp("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	opt := getTestOptions(dir).WithValueThreshold(32)
+	// Write each key into its own L0 table.
+	for _, k := range []string{"a", "b"} {
+		db, err := Open(opt)
+		require.NoError(t, err)
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set([]byte(k), bytes.Repeat([]byte(k), 64))
+		}))
+		require.NoError(t, db.Close())
+	}
+	db, err := Open(opt)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+	require.Equal(t, 2, db.lc.levels[0].numTables())
+
+	txn := db.NewTransaction(true)
+	defer txn.Discard()
+	// The stats are only collected once turned on.
+	_, err = txn.Get([]byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, ReadStats{}, txn.LastReadStats())
+	txn.SetReadStats(true)
+
+	item, err := txn.Get([]byte("a"))
+	require.NoError(t, err)
+	stats := txn.LastReadStats()
+	require.Equal(t, 2, stats.TablesConsulted)
+	require.Equal(t, 1, stats.BloomFilterNegatives)
+	require.Equal(t, 1, stats.BlocksRead)
+	require.Zero(t, stats.VlogReads)
+	val, err := item.ValueCopy(nil)
+	require.NoError(t, err)
+	require.Equal(t, bytes.Repeat([]byte("a"), 64), val)
+	require.Equal(t, 1, txn.LastReadStats().VlogReads)
+
+	_, err = txn.Get([]byte("c"))
+	require.Equal(t, ErrKeyNotFound, err)
+	require.Equal(t, ReadStats{TablesConsulted: 2, BloomFilterNegatives: 2},
+		txn.LastReadStats())
+
+	// Pending writes are read without consulting the tables.
+	require.NoError(t, txn.Set([]byte("c"), []byte("c")))
+	_, err = txn.Get([]byte("c"))
+	require.NoError(t, err)
+	require.Equal(t, ReadStats{}, txn.LastReadStats())
+}
+
+func TestTxnConflictKeys(t *testing.T) {
+	// The keys are only kept with detailed errors.
+	opt := getTestOptions("").WithDetailedErrors(true)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		txn := db.NewTransaction(true)
+		defer txn.Discard()
+		for _, k := range []string{"a", "b", "c"} {
+			_, err := txn.Get([]byte(k))
+			require.ErrorIs(t, err, ErrKeyNotFound)
+		}
+		require.NoError(t, txn.Set([]byte("d"), []byte("1")))
+
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			require.NoError(t, txn.Set([]byte("a"), []byte("1")))
+			return txn.Set([]byte("x"), []byte("1"))
+		}))
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set([]byte("c"), []byte("1"))
+		}))
+
+		require.ErrorIs(t, txn.Commit(), ErrConflict)
+		require.ElementsMatch(t, [][]byte{[]byte("a"), []byte("c")}, txn.ConflictKeys())
+
+		// A successful commit reports no conflicts.
+		txn = db.NewTransaction(true)
+		require.NoError(t, txn.Set([]byte("e"), []byte("1")))
+		require.NoError(t, txn.Commit())
+		require.Nil(t, txn.ConflictKeys())
+	})
+}
+
+func TestTxnSetDetectConflicts(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		newTxn := func(detect bool) *Txn {
+			txn := db.NewTransaction(true)
+			txn.SetDetectConflicts(detect)
+			_, err := txn.Get([]byte("a"))
+			require.Equal(t, ErrKeyNotFound, err)
+			require.NoError(t, txn.Set([]byte("b"), []byte("1")))
+			return txn
+		}
+		checked, unchecked := newTxn(true), newTxn(false)
+		defer checked.Discard()
+		defer unchecked.Discard()
+
+		txnSet(t, db, []byte("a"), []byte("1"), 0)
+		require.ErrorIs(t, checked.Commit(), ErrConflict)
+		require.NoError(t, unchecked.Commit())
+
+		// The writes of a transaction which doesn't detect conflicts still cause them.
+		txn := db.NewTransaction(true)
+		defer txn.Discard()
+		_, err := txn.Get([]byte("c"))
+		require.Equal(t, ErrKeyNotFound, err)
+		require.NoError(t, txn.Set([]byte("d"), []byte("1")))
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			txn.SetDetectConflicts(false)
+			return txn.Set([]byte("c"), []byte("1"))
+		}))
+		require.ErrorIs(t, txn.Commit(), ErrConflict)
+	})
+}
+
+func TestIsHot(t *testing.T) {
+	dir, err := os.MkdirTemp("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opt := getTestOptions(dir).WithValueThreshold(1 << 10)
+
+	db, err := Open(opt)
+	require.NoError(t, err)
+	txnSet(t, db, []byte("small"), []byte("val"), 0)
+	txnSet(t, db, []byte("large"), make([]byte, 2<<10), 0)
+	isHot := func(key string) bool {
+		txn := db.NewTransaction(false)
+		defer txn.Discard()
+		return txn.IsHot([]byte(key))
+	}
+	// Keys in the memtable are hot, unless the value is in the value log.
+	require.True(t, isHot("small"))
+	require.False(t, isHot("large"))
+	require.True(t, isHot("missing"))
+	require.NoError(t, db.Close())
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+	require.Len(t, db.Tables(), 1)
+	// The block holding the keys isn't in the block cache yet.
+	require.False(t, isHot("small"))
+	// The bloom filter tells right away that the key doesn't exist.
+	require.True(t, isHot("missing"))
+
+	require.NoError(t, db.View(func(txn *Txn) error {
+		_, err := txn.Get([]byte("small"))
+		return err
+	}))
+	db.blockCache.Wait()
+	require.True(t, isHot("small"))
+	require.False(t, isHot("large"))
+
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		require.NoError(t, txn.Set([]byte("pending"), []byte("val")))
+		require.True(t, txn.IsHot([]byte("pending")))
+		return nil
+	}))
+	txn := db.NewTransaction(false)
+	txn.Discard()
+	require.False(t, txn.IsHot([]byte("small")))
+}
+
+func TestLargeReadSetHook(t *testing.T) {
+	var counts []int
+	opt := getTestOptions("").WithLargeReadSetHook(3, func(count int) {
+		counts = append(counts, count)
+	})
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		read := func(txn *Txn, n int) {
+			for i := 0; i < n; i++ {
+				_, err := txn.Get([]byte(key("key", i)))
+				require.Equal(t, ErrKeyNotFound, err)
+			}
+		}
+		txn := db.NewTransaction(true)
+		read(txn, 3)
+		require.Empty(t, counts)
+		read(txn, 3)
+		require.Equal(t, []int{4}, counts)
+		txn.Discard()
+
+		// Transactions which don't track their reads don't call the hook.
+		counts = nil
+		txn = db.NewTransaction(false)
+		read(txn, 10)
+		txn.Discard()
+		txn = db.NewTransaction(true)
+		txn.SetDetectConflicts(false)
+		read(txn, 10)
+		txn.Discard()
+		require.Empty(t, counts)
+	})
+}
+
+func TestWriteMiddleware(t *testing.T) {
+	errBad := errors.New("bad value")
+	var calls []string
+	reject := func(next WriteFunc) WriteFunc {
+		return func(entries []*Entry) error {
+			calls = append(calls, "reject")
+			for _, e := range entries {
+				if string(e.Value) == "bad" {
+					return errBad
+				}
+			}
+			return next(entries)
+		}
+	}
+	suffix := func(next WriteFunc) WriteFunc {
+		return func(entries []*Entry) error {
+			calls = append(calls, "suffix")
+			for _, e := range entries {
+				if !e.IsDelete() {
+					e.Value = append(e.Value, '!')
+				}
+			}
+			return next(entries)
+		}
+	}
+	opt := getTestOptions("").WithWriteMiddleware(reject).WithWriteMiddleware(suffix)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		txnSet(t, db, []byte("b"), []byte("y"), 0)
+		require.Equal(t, []string{"reject", "suffix"}, calls)
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			require.NoError(t, txn.Set([]byte("a"), []byte("x")))
+			return txn.Delete([]byte("b"))
+		}))
+		err := db.Update(func(txn *Txn) error {
+			require.NoError(t, txn.Set([]byte("a"), []byte("z")))
+			return txn.Set([]byte("c"), []byte("bad"))
+		})
+		require.ErrorIs(t, err, errBad)
+
+		wb := db.NewWriteBatch()
+		require.NoError(t, wb.Set([]byte("d"), []byte("w")))
+		require.NoError(t, wb.Flush())
+
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for k, v := range map[string]string{"a": "x!", "d": "w!"} {
+				item, err := txn.Get([]byte(k))
+				require.NoError(t, err)
+				require.Equal(t, v, string(getItemValue(t, item)))
+			}
+			for _, k := range []string{"b", "c"} {
+				_, err := txn.Get([]byte(k))
+				require.Equal(t, ErrKeyNotFound, err)
+			}
+			return nil
+		}))
+	})
+
+	// The entries passed on by a middleware are checked like the ones set.
+	add := func(next WriteFunc) WriteFunc {
+		return func(entries []*Entry) error {
+			return next(append(entries, NewEntry(nil, []byte("v"))))
+		}
+	}
+	opt = getTestOptions("").WithWriteMiddleware(add)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		err := db.Update(func(txn *Txn) error {
+			return txn.Set([]byte("a"), []byte("x"))
+		})
+		require.Equal(t, ErrEmptyKey, err)
+		require.NoError(t, db.View(func(txn *Txn) error {
+			_, err := txn.Get([]byte("a"))
+			require.Equal(t, ErrKeyNotFound, err)
+			return nil
+		}))
+	})
+}
+
+func TestMaxOracleMemory(t *testing.T) {
+	opt := getTestOptions("").WithMaxOracleMemory(16 << 10)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		// A transaction which is never discarded keeps the committed transactions around.
+		stuck := db.NewTransaction(true)
+		defer stuck.Discard()
+		_, err := stuck.Get([]byte("key0000"))
+		require.Equal(t, ErrKeyNotFound, err)
+		require.NoError(t, stuck.Set([]byte("stuck"), []byte("v")))
+
+		for i := 0; i < 1000; i++ {
+			require.NoError(t, db.Update(func(txn *Txn) error {
+				return txn.Set([]byte(key("key", i)), []byte("v"))
+			}))
+		}
+		orc := db.orc
+		orc.Lock()
+		require.LessOrEqual(t, orc.memUsed.Value(), opt.MaxOracleMemory)
+		require.Less(t, len(orc.committedTxns), 1000)
+		orc.Unlock()
+		require.Equal(t, &orc.memUsed, y.OracleMemoryGet(true, opt.Dir))
+
+		require.ErrorIs(t, stuck.Commit(), ErrConflict)
+		require.Nil(t, stuck.ConflictKeys())
+
+		// Transactions started since are checked for conflicts as usual.
+		txn := db.NewTransaction(true)
+		defer txn.Discard()
+		_, err = txn.Get([]byte("key0001"))
+		require.NoError(t, err)
+		require.NoError(t, txn.Set([]byte("other"), []byte("v")))
+		require.NoError(t, txn.Commit())
+	})
+}
+
+func TestTxnReadThroughLoader(t *testing.T) {
+	var calls atomic.Int32
+	loader := func(key []byte) ([]byte, time.Duration, bool, error) {
+		calls.Add(1)
+		switch string(key) {
+		case "missing":
+			return nil, 0, false, nil
+		case "broken":
+			return nil, 0, false, fmt.Errorf("source unavailable")
+		}
+		return []byte("loaded-" + string(key)), 0, true, nil
+	}
+	opt := getTestOptions("").WithReadThroughLoader(loader)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set([]byte("present"), []byte("stored"))
+		}))
+
+		require.NoError(t, db.View(func(txn *Txn) error {
+			item, err := txn.Get([]byte("present"))
+			require.NoError(t, err)
+			require.Equal(t, []byte("stored"), getItemValue(t, item))
+			require.Equal(t, int32(0), calls.Load())
+
+			item, err = txn.Get([]byte("foo"))
+			require.NoError(t, err)
+			require.Equal(t, []byte("loaded-foo"), getItemValue(t, item))
+			require.Greater(t, item.Version(), txn.readTs)
+
+			// The loaded value stays visible to Get within the same txn, without reloading.
+			item, err = txn.Get([]byte("foo"))
+			require.NoError(t, err)
+			require.Equal(t, []byte("loaded-foo"), getItemValue(t, item))
+			require.Equal(t, int32(1), calls.Load())
+
+			_, err = txn.Get([]byte("missing"))
+			require.Equal(t, ErrKeyNotFound, err)
+			_, err = txn.Get([]byte("broken"))
+			require.ErrorContains(t, err, "source unavailable")
+			return nil
+		}))
+
+		// The loaded value was persisted.
+		calls.Store(0)
+		require.NoError(t, db.View(func(txn *Txn) error {
+			item, err := txn.Get([]byte("foo"))
+			require.NoError(t, err)
+			require.Equal(t, []byte("loaded-foo"), getItemValue(t, item))
+			return nil
+		}))
+		require.Equal(t, int32(0), calls.Load())
+
+		// Read-write transactions don't invoke the loader.
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			_, err := txn.Get([]byte("bar"))
+			require.Equal(t, ErrKeyNotFound, err)
+			return nil
+		}))
+		require.Equal(t, int32(0), calls.Load())
+	})
+}
+
+func TestTxnReadThroughLoaderManaged(t *testing.T) {
+	dir, err := os.MkdirTemp("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	opt := getTestOptions(dir).WithReadThroughLoader(
+		func([]byte) ([]byte, time.Duration, bool, error) { return nil, 0, false, nil })
+	_, err = OpenManaged(opt)
+	require.Error(t, err)
+}
+
+func TestTxnHasMany(t *testing.T) {
+	dir, err := os.MkdirTemp("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	opt := getTestOptions(dir)
+	db, err := Open(opt)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+
+	key := func(i int) []byte { return []byte(fmt.Sprintf("key%05d", i)) }
+	// Spread the keys over a table at the last level, a table on level 0 and the memtable.
+	for round := 0; round < 3; round++ {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			for i := round; i < 1000; i += 3 {
+				if err := txn.Set(key(i), []byte("val")); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+		if round == 2 {
+			break
+		}
+		// Reopen the DB, so that the memtable gets flushed.
+		require.NoError(t, db.Close())
+		db, err = Open(opt)
+		require.NoError(t, err)
+		if round == 0 {
+			require.NoError(t, db.Flatten(1))
+		}
+	}
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		for i := 0; i < 100; i++ {
+			if err := txn.Delete(key(i)); err != nil {
+				return err
+			}
+		}
+		return txn.SetEntry(NewEntry(key(100), []byte("val")).WithTTL(time.Nanosecond))
+	}))
+	time.Sleep(time.Millisecond)
+
+	// Lookup in random order, with keys that don't exist and a duplicate.
+	var keys [][]byte
+	var expected []bool
+	for _, i := range rand.Perm(1200) {
+		keys = append(keys, key(i))
+		expected = append(expected, i > 100 && i < 1000)
+	}
+	keys = append(keys, key(500))
+	expected = append(expected, true)
+
+	require.NoError(t, db.View(func(txn *Txn) error {
+		res, err := txn.HasMany(keys)
+		require.NoError(t, err)
+		require.Equal(t, expected, res)
+
+		_, err = txn.HasMany([][]byte{key(1), nil})
+		require.Equal(t, ErrEmptyKey, err)
+		return nil
+	}))
+
+	// Pending writes of the transaction are taken into account.
+	txn := db.NewTransaction(true)
+	defer txn.Discard()
+	require.NoError(t, txn.Set(key(5), []byte("val")))
+	require.NoError(t, txn.Delete(key(505)))
+	res, err := txn.HasMany([][]byte{key(505), key(5), key(506)})
+	require.NoError(t, err)
+	require.Equal(t, []bool{false, true, true}, res)
+}
+
+func TestKeyTransform(t *testing.T) {
+	dir, err := os.MkdirTemp("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	tenant := func(prefix string) Options {
+		return getTestOptions(dir).WithKeyTransform(
+			func(key []byte) []byte { return append([]byte(prefix), key...) },
+			func(key []byte) []byte { return key[len(prefix):] })
+	}
+	write := func(db *DB, keys ...string) {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			for _, k := range keys {
+				if err := txn.Set([]byte(k), []byte("val-"+k)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+	}
+
+	db, err := Open(tenant("t0/"))
+	require.NoError(t, err)
+	write(db, "a", "b1", "z")
+	require.NoError(t, db.Close())
+	db, err = Open(tenant("t2/"))
+	require.NoError(t, err)
+	write(db, "a", "b2")
+	require.NoError(t, db.Close())
+
+	db, err = Open(tenant("t1/"))
+	require.NoError(t, err)
+	defer db.Close()
+	write(db, "a", "b1", "b2", "b3", "c")
+
+	iterate := func(opt IteratorOptions, seek string) []string {
+		var keys []string
+		require.NoError(t, db.View(func(txn *Txn) error {
+			it := txn.NewIterator(opt)
+			defer it.Close()
+			for it.Seek([]byte(seek)); it.Valid(); it.Next() {
+				item := it.Item()
+				require.Equal(t, "val-"+string(item.FullKey()), string(getItemValue(t, item)))
+				keys = append(keys, string(item.Key()))
+			}
+			return nil
+		}))
+		return keys
+	}
+	require.Equal(t, []string{"a", "b1", "b2", "b3", "c"}, iterate(IteratorOptions{}, ""))
+	require.Equal(t, []string{"c", "b3", "b2", "b1", "a"},
+		iterate(IteratorOptions{Reverse: true}, ""))
+	require.Equal(t, []string{"b2", "b3", "c"}, iterate(IteratorOptions{}, "b2"))
+	require.Equal(t, []string{"b1", "b2", "b3"}, iterate(IteratorOptions{Prefix: []byte("b")}, ""))
+	require.Equal(t, []string{"3", "2", "1"},
+		iterate(IteratorOptions{Prefix: []byte("b"), Reverse: true, StripPrefix: true}, "b\xff"))
+
+	require.NoError(t, db.View(func(txn *Txn) error {
+		item, err := txn.Get([]byte("b2"))
+		require.NoError(t, err)
+		require.Equal(t, "b2", string(item.Key()))
+		// Get returns the keys the iterators do.
+		it := txn.NewIterator(IteratorOptions{})
+		defer it.Close()
+		it.Seek([]byte("b2"))
+		require.True(t, it.Valid())
+		require.Equal(t, it.Item().key, item.key)
+		require.Equal(t, it.Item().FullKey(), item.FullKey())
+		item, err = txn.GetAtVersion([]byte("b2"), ^uint64(0))
+		require.NoError(t, err)
+		require.Equal(t, it.Item().key, item.key)
+		require.Equal(t, it.Item().FullKey(), item.FullKey())
+		_, err = txn.Get([]byte("z"))
+		require.Equal(t, ErrKeyNotFound, err)
+		found, err := txn.HasMany([][]byte{[]byte("c"), []byte("z")})
+		require.NoError(t, err)
+		require.Equal(t, []bool{true, false}, found)
+		return nil
+	}))
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		require.Equal(t, ErrEmptyKey, txn.Set(nil, nil))
+		return txn.Delete([]byte("a"))
+	}))
+	first, err := db.FirstKey(nil)
+	require.NoError(t, err)
+	require.Equal(t, "b1", string(first))
+	last, err := db.LastKey(nil)
+	require.NoError(t, err)
+	require.Equal(t, "c", string(last))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	got := make(chan string, 100)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = db.Subscribe(ctx, func(kvs *KVList) error {
+			for _, kv := range kvs.Kv {
+				got <- string(kv.Key)
+			}
+			return nil
+		}, []pb.Match{{Prefix: []byte("s")}})
+	}()
+	// Keep writing until the subscription is in place.
+	for received := false; !received; {
+		write(db, "sub", "other")
+		select {
+		case k := <-got:
+			require.Equal(t, "sub", k)
+			received = true
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	cancel()
+	<-done
+}
+
+func TestTxnGetAtVersion(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		k := []byte("key")
+		set := func(val string) {
+			require.NoError(t, db.Update(func(txn *Txn) error {
+				return txn.Set(k, []byte(val))
+			}))
+		}
+		// Versions 1 to 4: set, delete, set, and a write to another key.
+		set("v1")
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Delete(k)
+		}))
+		set("v3")
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set([]byte("other"), []byte("val"))
+		}))
+
+		txn := db.NewTransaction(true)
+		defer txn.Discard()
+		require.NoError(t, txn.Set(k, []byte("pending")))
+		set("v5") // Version 5, after txn started.
+
+		for version, want := range map[uint64]string{1: "v1", 3: "v3", 4: "v3", 10: "v3"} {
+			item, err := txn.GetAtVersion(k, version)
+			require.NoError(t, err)
+			require.Equal(t, want, string(getItemValue(t, item)))
+			require.LessOrEqual(t, item.Version(), version)
+		}
+		for _, version := range []uint64{0, 2} {
+			_, err := txn.GetAtVersion(k, version)
+			require.Equal(t, ErrKeyNotFound, err)
+		}
+		_, err := txn.GetAtVersion(nil, 1)
+		require.Equal(t, ErrEmptyKey, err)
+	})
+}
@@ -22,6 +22,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/dgraph-io/ristretto/v2/z"
 	"github.com/pkg/errors"
 
 	"github.com/0xEggTart/badger/table"
@@ -91,8 +92,9 @@ func (s *levelHandler) validate() error {
 
 // reserveFileID reserves a unique file id.
 func (s *levelsController) reserveFileID() uint64 {
-	id := s.nextFileID.Add(1)
-	return id - 1
+	id := s.nextFileID.Add(1) - 1
+	s.kv.manifest.trackBuilding(id)
+	return id
 }
 
 func getIDMap(dir string) map[uint64]struct{} {
@@ -115,3 +117,23 @@ func getIDMap(dir string) map[uint64]struct{} {
 func init() {
 	rand.Seed(time.Now().UnixNano())
 }
+
+// randInt63n returns a random number in [0, n), taken from Options.RandSource if it is set.
+func (db *DB) randInt63n(n int64) int64 {
+	if db.rand == nil {
+		return rand.Int63n(n)
+	}
+	db.randLock.Lock()
+	defer db.randLock.Unlock()
+	return db.rand.Int63n(n)
+}
+
+// randUint32 returns a random number taken from Options.RandSource if it is set.
+func (db *DB) randUint32() uint32 {
+	if db.rand == nil {
+		return z.FastRand()
+	}
+	db.randLock.Lock()
+	defer db.randLock.Unlock()
+	return db.rand.Uint32()
+}
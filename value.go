@@ -31,11 +31,13 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/pkg/errors"
 	//	"go.opentelemetry.io/otel"
 	//	"go.opentelemetry.io/otel/trace"
 
+	"github.com/0xEggTart/badger/options"
 	"github.com/0xEggTart/badger/y"
 	"github.com/dgraph-io/ristretto/v2/z"
 )
@@ -54,6 +56,8 @@ const (
 	bitDiscardEarlierVersions byte = 1 << 2 // Set if earlier versions can be discarded.
 	// Set if item shouldn't be discarded via compactions (used by merge operator)
 	bitMergeEntry byte = 1 << 3
+	// Bit 5 is reserved for y.BitUserMetaHigh, which is only set in encoded metas. It's never
+	// available for another meta bit, even in DBs without Options.WideUserMeta.
 	// The MSB 2 bits are for transactions.
 	bitTxn    byte = 1 << 6 // Set if the entry is part of a txn.
 	bitFinTxn byte = 1 << 7 // Set if the entry is to indicate end of txn in value log.
@@ -168,11 +172,90 @@ func (r *safeRead) Entry(reader io.Reader) (*Entry, error) {
 		return nil, errTruncate
 	}
 	e.meta = h.meta
-	e.UserMeta = h.userMeta
+	e.UserMeta, e.userMetaHigh = h.userMeta, h.userMetaHigh
 	e.ExpiresAt = h.expiresAt
 	return e, nil
 }
 
+// gcConflictChCapacity is the number of keys which can be queued up for the
+// ValueLogGCConflictHook. Value log GC doesn't wait for the hook, so the keys found while the queue
+// is full are not reported.
+const gcConflictChCapacity = 10000
+
+// notifyGCConflict queues up the key for the ValueLogGCConflictHook. It returns false if the queue
+// was full. Internal keys are not reported.
+func (db *DB) notifyGCConflict(key []byte) bool {
+	key = y.ParseKey(key)
+	if bytes.HasPrefix(key, badgerPrefix) {
+		return true
+	}
+	select {
+	case db.gcConflictCh <- y.SafeCopy(nil, key):
+		return true
+	default:
+		return false
+	}
+}
+
+// runGCConflictHook calls the ValueLogGCConflictHook for the queued keys until the closer is
+// signalled, after which the keys left in the queue are delivered before returning.
+func (db *DB) runGCConflictHook(lc *z.Closer) {
+	defer lc.Done()
+	for {
+		select {
+		case key := <-db.gcConflictCh:
+			db.opt.ValueLogGCConflictHook(key)
+		case <-lc.HasBeenClosed():
+			for {
+				select {
+				case key := <-db.gcConflictCh:
+					db.opt.ValueLogGCConflictHook(key)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// versionsAbove reads the versions of the key of e, the entry at offset e.offset of log file fid,
+// with a single iterator. It calls fn with each version above the one of e which points to e,
+// as written by DB.RefreshTTL, from the latest one, and returns the latest version at or below the
+// one of e, like get does.
+func (vlog *valueLog) versionsAbove(e Entry, fid uint32, fn func(te Entry)) (y.ValueStruct, error) {
+	db := vlog.db
+	if db.IsClosed() {
+		return y.ValueStruct{}, ErrDBClosed
+	}
+	key, version := y.ParseKey(e.Key), y.ParseTs(e.Key)
+	itr, done := db.versionsOf(key)
+	defer done()
+	for ; itr != nil && itr.Valid() && bytes.Equal(y.ParseKey(itr.Key()), key); itr.Next() {
+		vs := itr.Value()
+		vs.Version = y.ParseTs(itr.Key())
+		if vs = db.hideDeleted(key, vs); vs.Version == 0 {
+			// The versions at or below the DeleteBelowVersion timestamp are gone.
+			break
+		}
+		if vs.Version <= version {
+			vs.Value = y.SafeCopy(nil, vs.Value)
+			return vs, nil
+		}
+		var vp valuePointer
+		if vs.Meta&bitValuePointer > 0 && !isDeletedOrExpired(vs.Meta, vs.ExpiresAt) {
+			vp.Decode(vs.Value)
+		}
+		if vp.Fid == fid && vp.Offset == e.offset && vp.Len > 0 {
+			te := e
+			te.Key = y.KeyWithTs(key, vs.Version)
+			te.meta, te.UserMeta, te.ExpiresAt = vs.Meta, vs.UserMeta, vs.ExpiresAt
+			te.userMetaHigh = vs.UserMetaHigh
+			fn(te)
+		}
+	}
+	return y.ValueStruct{}, nil
+}
+
 func (vlog *valueLog) rewrite(f *logFile) error {
 	vlog.filesLock.RLock()
 	for _, fid := range vlog.filesToBeDeleted {
@@ -190,19 +273,130 @@ func (vlog *valueLog) rewrite(f *logFile) error {
 	var size int64
 
 	y.AssertTrue(vlog.db != nil)
-	var count, moved int
+	var count, moved, missedConflicts int
+	// conflict reports a key whose latest version in the LSM tree no longer points to the entry
+	// being rewritten.
+	conflict := func(e Entry) {
+		if vlog.db.gcConflictCh != nil && !vlog.db.notifyGCConflict(e.Key) {
+			missedConflicts++
+		}
+	}
+	// The versions written by DB.RefreshTTL share the value of the version they refreshed. Only one
+	// of them, the carrier, gets its value moved. The others are re-pointed to the moved value once
+	// the batch holding the carrier is written.
+	type repointReq struct {
+		carrier []byte // Key with timestamp.
+		value   []byte
+		entries []*Entry
+	}
+	var repoints []repointReq
+	repoint := func() error {
+		for _, r := range repoints {
+			vs, err := vlog.db.get(r.carrier)
+			if err != nil {
+				return err
+			}
+			for _, ne := range r.entries {
+				if vs.Version == y.ParseTs(r.carrier) && vs.Meta&bitValuePointer > 0 {
+					ne.vptr = y.SafeCopy(nil, vs.Value)
+				} else {
+					// The carrier was written with its value inline, or is gone already.
+					ne.Value = r.value
+				}
+			}
+			if err := vlog.db.batchSet(r.entries); err != nil {
+				return err
+			}
+		}
+		repoints = repoints[:0]
+		return nil
+	}
+	// move writes the value of e back to the DB, under the key and metadata of e.
+	move := func(e Entry) error {
+		moved++
+		// This new entry only contains the key, and a pointer to the value.
+		ne := new(Entry)
+		// Remove only the bitValuePointer and transaction markers. We
+		// should keep the other bits.
+		ne.meta = e.meta &^ (bitValuePointer | bitTxn | bitFinTxn)
+		ne.UserMeta, ne.userMetaHigh = e.UserMeta, e.userMetaHigh
+		ne.ExpiresAt = e.ExpiresAt
+		ne.Key = append([]byte{}, e.Key...)
+		ne.Value = append([]byte{}, e.Value...)
+		es := ne.estimateSizeAndSetThreshold(vlog.db.entryValueThreshold(ne.Key))
+		// Consider size of value as well while considering the total size
+		// of the batch. There have been reports of high memory usage in
+		// rewrite because we don't consider the value size. See #1292.
+		es += int64(len(e.Value))
+
+		// Ensure length and size of wb is within transaction limits.
+		if int64(len(wb)+1) >= vlog.opt.maxBatchCount ||
+			size+es >= vlog.opt.maxBatchSize {
+			if err := vlog.db.batchSet(wb); err != nil {
+				return err
+			}
+			size = 0
+			wb = wb[:0]
+			if err := repoint(); err != nil {
+				return err
+			}
+		}
+		wb = append(wb, ne)
+		size += es
+		return nil
+	}
+	// moveShared moves e, and the versions in refreshed, which point to the value of e.
+	moveShared := func(e Entry, refreshed []Entry) error {
+		if err := move(e); err != nil {
+			return err
+		}
+		if len(refreshed) == 0 {
+			return nil
+		}
+		r := repointReq{carrier: y.Copy(e.Key), value: y.Copy(e.Value)}
+		for _, te := range refreshed {
+			r.entries = append(r.entries, &Entry{
+				Key:       y.Copy(te.Key),
+				ExpiresAt: te.ExpiresAt,
+				UserMeta:  te.UserMeta,
+				meta:      te.meta &^ (bitValuePointer | bitTxn | bitFinTxn),
+
+				userMetaHigh: te.userMetaHigh,
+			})
+		}
+		repoints = append(repoints, r)
+		return nil
+	}
 	fe := func(e Entry) error {
 		count++
 		if count%100000 == 0 {
 			vlog.opt.Debugf("Processing entry %d", count)
 		}
 
-		vs, err := vlog.db.get(e.Key)
+		// The versions of the key above the one of e also point to this entry if they were
+		// written by DB.RefreshTTL. Walk down to the version of e, collecting them.
+		var refreshed []Entry
+		vs, err := vlog.versionsAbove(e, f.fid, func(te Entry) {
+			refreshed = append(refreshed, te)
+		})
 		if err != nil {
 			return err
 		}
+		// skip leaves the version of e alone. The refreshed versions are moved regardless, the
+		// oldest of them carrying the value.
+		skip := func() error {
+			if len(refreshed) == 0 {
+				conflict(e)
+				return nil
+			}
+			last := len(refreshed) - 1
+			return moveShared(refreshed[last], refreshed[:last])
+		}
 		if discardEntry(e, vs, vlog.db) {
-			return nil
+			if len(refreshed) == 0 {
+				return nil
+			}
+			return skip()
 		}
 
 		// Value is still present in value log.
@@ -214,46 +408,28 @@ func (vlog *valueLog) rewrite(f *logFile) error {
 
 		// If the entry found from the LSM Tree points to a newer vlog file, don't do anything.
 		if vp.Fid > f.fid {
-			return nil
+			return skip()
 		}
 		// If the entry found from the LSM Tree points to an offset greater than the one
 		// read from vlog, don't do anything.
 		if vp.Offset > e.offset {
-			return nil
+			return skip()
 		}
 		// If the entry read from LSM Tree and vlog file point to the same vlog file and offset,
 		// insert them back into the DB.
 		// NOTE: It might be possible that the entry read from the LSM Tree points to
 		// an older vlog file. See the comments in the else part.
 		if vp.Fid == f.fid && vp.Offset == e.offset {
-			moved++
-			// This new entry only contains the key, and a pointer to the value.
-			ne := new(Entry)
-			// Remove only the bitValuePointer and transaction markers. We
-			// should keep the other bits.
-			ne.meta = e.meta &^ (bitValuePointer | bitTxn | bitFinTxn)
-			ne.UserMeta = e.UserMeta
-			ne.ExpiresAt = e.ExpiresAt
-			ne.Key = append([]byte{}, e.Key...)
-			ne.Value = append([]byte{}, e.Value...)
-			es := ne.estimateSizeAndSetThreshold(vlog.db.valueThreshold())
-			// Consider size of value as well while considering the total size
-			// of the batch. There have been reports of high memory usage in
-			// rewrite because we don't consider the value size. See #1292.
-			es += int64(len(e.Value))
-
-			// Ensure length and size of wb is within transaction limits.
-			if int64(len(wb)+1) >= vlog.opt.maxBatchCount ||
-				size+es >= vlog.opt.maxBatchSize {
-				if err := vlog.db.batchSet(wb); err != nil {
-					return err
-				}
-				size = 0
-				wb = wb[:0]
+			// Compactions may have changed the user meta in the LSM tree. See
+			// Options.UserMetaMerge.
+			e.UserMeta, e.userMetaHigh = vs.UserMeta, vs.UserMetaHigh
+			if err := moveShared(e, refreshed); err != nil {
+				return err
+			}
+		} else {
+			if err := skip(); err != nil {
+				return err
 			}
-			wb = append(wb, ne)
-			size += es
-		} else { //nolint:staticcheck
 			// It might be possible that the entry read from LSM Tree points to
 			// an older vlog file.  This can happen in the following situation.
 			// Assume DB is opened with
@@ -311,6 +487,10 @@ func (vlog *valueLog) rewrite(f *logFile) error {
 	_, err := f.iterate(vlog.opt.ReadOnly, 0, func(e Entry, vp valuePointer) error {
 		return fe(e)
 	})
+	if missedConflicts > 0 {
+		vlog.opt.Warningf("ValueLogGCConflictHook queue was full. %d keys not reported",
+			missedConflicts)
+	}
 	if err != nil {
 		return err
 	}
@@ -328,7 +508,7 @@ func (vlog *valueLog) rewrite(f *logFile) error {
 			end = len(wb)
 		}
 		if err := vlog.db.batchSet(wb[i:end]); err != nil {
-			if err == ErrTxnTooBig {
+			if errors.Is(err, ErrTxnTooBig) {
 				// Decrease the batch size to half.
 				batchSize = batchSize / 2
 				continue
@@ -337,9 +517,16 @@ func (vlog *valueLog) rewrite(f *logFile) error {
 		}
 		i += batchSize
 	}
+	if err := repoint(); err != nil {
+		return err
+	}
 	vlog.opt.Infof("Processed %d entries in %d loops", len(wb), loops)
 	vlog.opt.Infof("Total entries: %d. Moved: %d", count, moved)
 	vlog.opt.Infof("Removing fid: %d", f.fid)
+	// The compactions which discarded the values of f must not be lost once f is gone.
+	if err := vlog.db.manifest.sync(); err != nil {
+		return err
+	}
 	var deleteFileNow bool
 	// Entries written to LSM. Remove the older file now.
 	{
@@ -427,7 +614,7 @@ func (vlog *valueLog) dropAll() (int, error) {
 			count++
 		}
 		vlog.filesMap = make(map[uint32]*logFile)
-		vlog.maxFid = 0
+		vlog.maxFid = vlog.fidBase
 		return nil
 	}
 	if err := deleteAll(); err != nil {
@@ -445,8 +632,21 @@ func (db *DB) valueThreshold() int64 {
 	return db.threshold.valueThreshold.Load()
 }
 
+// entryValueThreshold returns the value threshold for an entry with the given key. If
+// EntrySpillThreshold is set, the threshold is lowered so that the value of an entry whose key
+// and value together reach EntrySpillThreshold goes to the value log as well.
+func (db *DB) entryValueThreshold(key []byte) int64 {
+	threshold := db.valueThreshold()
+	if spill := int64(db.opt.EntrySpillThreshold); spill > 0 {
+		// Empty values are always kept in the LSM tree, as there's nothing to move.
+		threshold = min(threshold, max(spill-int64(len(key)), 1))
+	}
+	return threshold
+}
+
 type valueLog struct {
 	dirPath string
+	fidBase uint32 // Zero, or blobFidBit for the blob log.
 
 	// guards our view of which files exist, which to be deleted, how many active iterators
 	filesLock        sync.RWMutex
@@ -459,7 +659,10 @@ type valueLog struct {
 	db                *DB
 	writableLogOffset atomic.Uint32 // read by read, written by write
 	numEntriesWritten uint32
-	opt               Options
+	// When the first entry was written to the active file, zero while it's empty. Only used by
+	// write. See Options.ValueLogMaxAge.
+	firstWriteAt time.Time
+	opt          Options
 
 	garbageCh    chan struct{}
 	discardStats *discardStats
@@ -470,7 +673,7 @@ func vlogFilePath(dirPath string, fid uint32) string {
 }
 
 func (vlog *valueLog) fpath(fid uint32) string {
-	return vlogFilePath(vlog.dirPath, fid)
+	return vlogFilePath(vlog.dirPath, fid&^blobFidBit)
 }
 
 func (vlog *valueLog) populateFilesMap() error {
@@ -495,6 +698,7 @@ func (vlog *valueLog) populateFilesMap() error {
 			return errFile(err, file.Name(), "Duplicate file found. Please delete one.")
 		}
 		found[fid] = struct{}{}
+		fid |= uint64(vlog.fidBase)
 
 		lf := &logFile{
 			fid:      uint32(fid),
@@ -533,6 +737,7 @@ func (vlog *valueLog) createVlogFile() (*logFile, error) {
 	// done via atomics.
 	vlog.writableLogOffset.Store(vlogHeaderSize)
 	vlog.numEntriesWritten = 0
+	vlog.firstWriteAt = time.Time{}
 	vlog.filesLock.Unlock()
 
 	return lf, nil
@@ -552,7 +757,12 @@ func (vlog *valueLog) init(db *DB) {
 	if vlog.opt.InMemory {
 		return
 	}
+	if vlog.isBlob() {
+		// The blob log is a value log living in BlobDir.
+		vlog.opt.ValueDir = vlog.opt.BlobDir
+	}
 	vlog.dirPath = vlog.opt.ValueDir
+	vlog.maxFid = vlog.fidBase
 
 	vlog.garbageCh = make(chan struct{}, 1) // Only allow one GC at a time.
 	lf, err := InitDiscardStats(vlog.opt)
@@ -598,6 +808,13 @@ func (vlog *valueLog) open(db *DB) error {
 				return y.Wrapf(err, "while trying to delete empty file: %s", lf.path)
 			}
 			delete(vlog.filesMap, fid)
+			continue
+		}
+		// The last file may end in a partially written entry, which is truncated below.
+		if fid != vlog.maxFid && vlog.verifyOnOpen() {
+			if err := vlog.verifyFile(lf); err != nil {
+				return err
+			}
 		}
 	}
 
@@ -681,6 +898,8 @@ type request struct {
 	Wg   sync.WaitGroup
 	Err  error
 	ref  atomic.Int32
+	// rotate makes the write path swap out the active memtable instead. See DB.RotateMemtable.
+	rotate bool
 }
 
 func (req *request) reset() {
@@ -689,6 +908,7 @@ func (req *request) reset() {
 	req.Wg = sync.WaitGroup{}
 	req.Err = nil
 	req.ref.Store(0)
+	req.rotate = false
 }
 
 func (req *request) IncrRef() {
@@ -757,14 +977,42 @@ func (vlog *valueLog) woffset() uint32 {
 	return vlog.writableLogOffset.Load()
 }
 
+// size returns the number of bytes written to the files of the value log.
+func (vlog *valueLog) size() int64 {
+	vlog.filesLock.RLock()
+	defer vlog.filesLock.RUnlock()
+	var sz int64
+	for fid, lf := range vlog.filesMap {
+		// The size of the file being written is that of its mmap, so go by the write offset.
+		if fid == vlog.maxFid {
+			sz += int64(vlog.woffset())
+			continue
+		}
+		sz += int64(lf.size.Load())
+	}
+	return sz
+}
+
 // validateWrites will check whether the given requests can fit into 4GB vlog file.
 // NOTE: 4GB is the maximum size we can create for vlog because value pointer offset is of type
 // uint32. If we create more than 4GB, it will overflow uint32. So, limiting the size to 4GB.
 func (vlog *valueLog) validateWrites(reqs []*request) error {
 	vlogOffset := uint64(vlog.woffset())
 	for _, req := range reqs {
-		// calculate size of the request.
-		size := estimateRequestSize(req)
+		// calculate size of the request. A large value goes to a file of its own, with the
+		// entries after it going to a new file again.
+		var size uint64
+		for _, e := range req.Entries {
+			if !vlog.isLarge(e) {
+				size += estimateEntrySize(e)
+				continue
+			}
+			if sz := vlogHeaderSize + estimateEntrySize(e); sz > uint64(maxVlogFileSize) {
+				return errors.Errorf("Request size offset %d is bigger than maximum offset %d",
+					sz, maxVlogFileSize)
+			}
+			vlogOffset, size = vlogHeaderSize, 0
+		}
 		estimatedVlogOffset := vlogOffset + size
 		if estimatedVlogOffset > uint64(maxVlogFileSize) {
 			return errors.Errorf("Request size offset %d is bigger than maximum offset %d",
@@ -783,11 +1031,31 @@ func (vlog *valueLog) validateWrites(reqs []*request) error {
 	return nil
 }
 
+// maxValueSize returns the size of the largest value which can be set for key. See
+// Options.AllowLargeValues.
+func (db *DB) maxValueSize(key []byte) int64 {
+	if !db.opt.AllowLargeValues {
+		return db.opt.ValueLogFileSize
+	}
+	// The entry has to fit into a file of its own, with the version appended to the key.
+	return int64(maxVlogFileSize) - vlogHeaderSize - maxHeaderSize - crc32.Size - int64(len(key)) - 8
+}
+
+// isLarge tells whether e is written to a value log file of its own. See Options.AllowLargeValues.
+func (vlog *valueLog) isLarge(e *Entry) bool {
+	return int64(len(e.Value)) > vlog.opt.ValueLogFileSize && vlog.db.isBlob(e) == vlog.isBlob()
+}
+
+// estimateEntrySize returns the size that needed to be written for the given entry.
+func estimateEntrySize(e *Entry) uint64 {
+	return uint64(maxHeaderSize + len(e.Key) + len(e.Value) + crc32.Size)
+}
+
 // estimateRequestSize returns the size that needed to be written for the given request.
 func estimateRequestSize(req *request) uint64 {
 	size := uint64(0)
 	for _, e := range req.Entries {
-		size += uint64(maxHeaderSize + len(e.Key) + len(e.Value) + crc32.Size)
+		size += estimateEntrySize(e)
 	}
 	return size
 }
@@ -838,37 +1106,63 @@ func (vlog *valueLog) write(reqs []*request) error {
 		return nil
 	}
 
+	rotate := func() error {
+		if err := curlf.doneWriting(vlog.woffset()); err != nil {
+			return err
+		}
+
+		newlf, err := vlog.createVlogFile()
+		if err != nil {
+			return err
+		}
+		curlf = newlf
+		return nil
+	}
+
 	toDisk := func() error {
+		if vlog.firstWriteAt.IsZero() && vlog.woffset() > vlogHeaderSize {
+			vlog.firstWriteAt = time.Now()
+		}
+		tooOld := vlog.opt.ValueLogMaxAge > 0 && !vlog.firstWriteAt.IsZero() &&
+			time.Since(vlog.firstWriteAt) >= vlog.opt.ValueLogMaxAge
 		if vlog.woffset() > uint32(vlog.opt.ValueLogFileSize) ||
-			vlog.numEntriesWritten > vlog.opt.ValueLogMaxEntries {
-			if err := curlf.doneWriting(vlog.woffset()); err != nil {
-				return err
-			}
-
-			newlf, err := vlog.createVlogFile()
-			if err != nil {
-				return err
-			}
-			curlf = newlf
+			vlog.numEntriesWritten > vlog.opt.ValueLogMaxEntries || tooOld {
+			return rotate()
 		}
 		return nil
 	}
 
 	buf := new(bytes.Buffer)
+	// The value pointers of the requests are set by DB.writeValueLogs. Each value log only writes
+	// the entries which go to it.
 	for i := range reqs {
 		b := reqs[i]
-		b.Ptrs = b.Ptrs[:0]
+		if len(b.Ptrs) != len(b.Entries) {
+			b.Ptrs = append(b.Ptrs[:0], make([]valuePointer, len(b.Entries))...)
+		}
 		var written, bytesWritten int
 		valueSizes := make([]int64, 0, len(b.Entries))
 		for j := range b.Entries {
 			buf.Reset()
 
 			e := b.Entries[j]
+			if e.vptr != nil {
+				continue
+			}
 			valueSizes = append(valueSizes, int64(len(e.Value)))
-			if e.skipVlogAndSetThreshold(vlog.db.valueThreshold()) {
-				b.Ptrs = append(b.Ptrs, valuePointer{})
+			if e.skipVlogAndSetThreshold(vlog.db.entryValueThreshold(e.Key)) ||
+				vlog.db.isBlob(e) != vlog.isBlob() {
 				continue
 			}
+			// A large value goes to a file of its own, so the file it would share is finished.
+			large := vlog.isLarge(e)
+			if large {
+				if vlog.woffset() > vlogHeaderSize {
+					if err := rotate(); err != nil {
+						return err
+					}
+				}
+			}
 			var p valuePointer
 
 			p.Fid = curlf.fid
@@ -889,19 +1183,26 @@ func (vlog *valueLog) write(reqs []*request) error {
 			e.meta = tmpMeta
 
 			p.Len = uint32(plen)
-			b.Ptrs = append(b.Ptrs, p)
+			b.Ptrs[j] = p
 			if err := write(buf); err != nil {
 				return err
 			}
+			if large {
+				if err := rotate(); err != nil {
+					return err
+				}
+			}
 			written++
-			bytesWritten += buf.Len()
+			bytesWritten += plen
 			// No need to flush anything, we write to file directly via mmap.
 		}
 		y.NumWritesVlogAdd(vlog.opt.MetricsEnabled, int64(written))
 		y.NumBytesWrittenVlogAdd(vlog.opt.MetricsEnabled, int64(bytesWritten))
 
 		vlog.numEntriesWritten += uint32(written)
-		vlog.db.threshold.update(valueSizes)
+		if !vlog.isBlob() {
+			vlog.db.threshold.update(valueSizes)
+		}
 		// We write to disk here so that all entries that are part of the same transaction are
 		// written to the same vlog file.
 		if err := toDisk(); err != nil {
@@ -939,6 +1240,28 @@ func (vlog *valueLog) getFileRLocked(vp valuePointer) (*logFile, error) {
 	return ret, nil
 }
 
+// readAhead asks the OS to load the vp.Len bytes of the value log at vp, so that reading the values
+// stored there doesn't have to wait for the disk. It does nothing if vp is invalid.
+func (vlog *valueLog) readAhead(vp valuePointer) {
+	lf, err := vlog.getFileRLocked(vp)
+	if err != nil {
+		return
+	}
+	defer lf.lock.RUnlock()
+	// madvise takes page aligned addresses, and the files are mapped at a page boundary.
+	start := int64(vp.Offset) &^ int64(os.Getpagesize()-1)
+	end := int64(vp.Offset) + int64(vp.Len)
+	if size := lf.size.Load(); end > int64(size) {
+		end = int64(size)
+	}
+	if end > int64(len(lf.Data)) {
+		end = int64(len(lf.Data))
+	}
+	if start < end {
+		_ = adviseWillNeed(lf.Data[start:end])
+	}
+}
+
 // Read reads the value log at a given location.
 // TODO: Make this read private.
 func (vlog *valueLog) Read(vp valuePointer, _ *y.Slice) ([]byte, func(), error) {
@@ -950,7 +1273,7 @@ func (vlog *valueLog) Read(vp valuePointer, _ *y.Slice) ([]byte, func(), error)
 		return nil, cb, err
 	}
 
-	if vlog.opt.VerifyValueChecksum {
+	if vlog.verifyOnRead() {
 		hash := crc32.New(y.CastagnoliCrcTable)
 		if _, err := hash.Write(buf[:len(buf)-crc32.Size]); err != nil {
 			runCallback(cb)
@@ -980,6 +1303,36 @@ func (vlog *valueLog) Read(vp valuePointer, _ *y.Slice) ([]byte, func(), error)
 	return kv[h.klen : h.klen+h.vlen], cb, nil
 }
 
+// verifyOnRead tells whether the checksum of every entry read from the value log is verified.
+func (vlog *valueLog) verifyOnRead() bool {
+	mode := vlog.opt.VlogChecksumVerification
+	return vlog.opt.VerifyValueChecksum ||
+		mode == options.OnBlockRead || mode == options.OnTableAndBlockRead
+}
+
+// verifyOnOpen tells whether the checksums of the value log files are verified on opening them.
+func (vlog *valueLog) verifyOnOpen() bool {
+	mode := vlog.opt.VlogChecksumVerification
+	return mode == options.OnTableRead || mode == options.OnTableAndBlockRead
+}
+
+// verifyFile checks the checksums of all the entries in a value log file which has been written
+// completely. Such a file ends right after its last entry, so an entry which fails to read before
+// the end of the file is corrupted.
+func (vlog *valueLog) verifyFile(lf *logFile) error {
+	endOff, err := lf.iterate(true, vlogHeaderSize, func(Entry, valuePointer) error {
+		return nil
+	})
+	if err != nil {
+		return y.Wrapf(err, "while verifying value log file: %s", lf.path)
+	}
+	if size := lf.size.Load(); endOff < size {
+		return y.Wrapf(y.ErrChecksumMismatch,
+			"value log file %s corrupted at offset %d of %d", lf.path, endOff, size)
+	}
+	return nil
+}
+
 // getUnlockCallback will returns a function which unlock the logfile if the logfile is mmaped.
 // otherwise, it unlock the logfile and return nil.
 func (vlog *valueLog) getUnlockCallback(lf *logFile) func() {
@@ -992,6 +1345,9 @@ func (vlog *valueLog) getUnlockCallback(lf *logFile) func() {
 // readValueBytes return vlog entry slice and read locked log file. Caller should take care of
 // logFile unlocking.
 func (vlog *valueLog) readValueBytes(vp valuePointer) ([]byte, *logFile, error) {
+	if vlog.db.latency != nil {
+		defer vlog.db.latency.vlogRead.since(time.Now())
+	}
 	lf, err := vlog.getFileRLocked(vp)
 	if err != nil {
 		return nil, nil, err
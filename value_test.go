@@ -23,13 +23,16 @@ import (
 	"math"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"reflect"
 	"sync"
 	"testing"
+	"time"
 
 	humanize "github.com/dustin/go-humanize"
 	"github.com/stretchr/testify/require"
 
+	"github.com/0xEggTart/badger/options"
 	"github.com/0xEggTart/badger/y"
 )
 
@@ -1163,6 +1166,105 @@ func TestValueEntryChecksum(t *testing.T) {
 	})
 }
 
+func TestVlogChecksumVerification(t *testing.T) {
+	dir, err := os.MkdirTemp("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	opt := getTestOptions(dir)
+	opt.ValueThreshold = 32
+	db, err := Open(opt)
+	require.NoError(t, err)
+	txnSet(t, db, []byte("KEY"), []byte(fmt.Sprintf("val%100d", 10)), 0)
+	require.NoError(t, db.Close())
+
+	// Reopen once so that the file holding the value is no longer the one being written.
+	db, err = Open(opt)
+	require.NoError(t, err)
+	path := db.vlog.fpath(1)
+	require.NoError(t, db.Close())
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	require.NoError(t, err)
+	_, err = file.WriteAt([]byte{7}, 50)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	// The SSTable checksum mode doesn't apply to the value log.
+	db, err = Open(opt.WithChecksumVerificationMode(options.OnTableAndBlockRead))
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	_, err = Open(opt.WithVlogChecksumVerification(options.OnTableRead))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), y.ErrChecksumMismatch.Error())
+}
+
+func TestAllowLargeValues(t *testing.T) {
+	dir, err := os.MkdirTemp("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opt := getTestOptions(dir)
+	opt.ValueLogFileSize = 1 << 20
+	opt.ValueThreshold = 1 << 10
+
+	big := make([]byte, 3<<20)
+	rand.Read(big)
+	small := make([]byte, 2<<10)
+
+	db, err := Open(opt)
+	require.NoError(t, err)
+	txn := db.NewTransaction(true)
+	err = txn.Set([]byte("big"), big)
+	require.ErrorIs(t, err, ErrValueTooLarge)
+	var tooLarge *ValueTooLargeError
+	require.ErrorAs(t, err, &tooLarge)
+	require.Equal(t, int64(len(big)), tooLarge.Size)
+	require.Equal(t, opt.ValueLogFileSize, tooLarge.Max)
+	txn.Discard()
+	require.NoError(t, db.Close())
+
+	opt = opt.WithAllowLargeValues(true)
+	db, err = Open(opt)
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		require.NoError(t, txn.Set([]byte("a"), small))
+		require.NoError(t, txn.Set([]byte("big"), big))
+		return txn.Set([]byte("c"), small)
+	}))
+	check := func() {
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for k, v := range map[string][]byte{"a": small, "big": big, "c": small} {
+				item, err := txn.Get([]byte(k))
+				require.NoError(t, err)
+				require.Equal(t, v, getItemValue(t, item))
+			}
+			return nil
+		}))
+	}
+	check()
+	require.NoError(t, db.Close())
+
+	// The large value has a file of its own.
+	files, err := filepath.Glob(filepath.Join(dir, "*.vlog"))
+	require.NoError(t, err)
+	var large []int64
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		require.NoError(t, err)
+		if fi.Size() > opt.ValueLogFileSize {
+			large = append(large, fi.Size())
+		}
+	}
+	require.Len(t, large, 1)
+	require.Less(t, large[0], int64(len(big))+100)
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	check()
+	require.NoError(t, db.Close())
+}
+
 func TestValidateWrite(t *testing.T) {
 	// Mocking the file size, so that we don't allocate big memory while running test.
 	maxVlogFileSize = 400
@@ -1282,3 +1384,552 @@ func TestFirstVlogFile(t *testing.T) {
 	require.NotZero(t, len(fids))
 	require.Equal(t, uint32(1), fids[0])
 }
+
+func TestEntrySpillThreshold(t *testing.T) {
+	dir, err := os.MkdirTemp("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	_, err = Open(getTestOptions(dir).WithEntrySpillThreshold(-1))
+	require.Error(t, err)
+
+	opt := getTestOptions(dir).WithEntrySpillThreshold(100)
+	db, err := Open(opt)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+
+	smallKey := []byte("small")
+	largeKey := bytes.Repeat([]byte("k"), 90)
+	val := bytes.Repeat([]byte("v"), 20)
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		require.NoError(t, txn.Set(smallKey, val))
+		require.NoError(t, txn.Set(largeKey, val))
+		return txn.Set([]byte("empty"), nil)
+	}))
+
+	require.NoError(t, db.View(func(txn *Txn) error {
+		for key, spilled := range map[string]bool{"small": false, string(largeKey): true,
+			"empty": false} {
+			item, err := txn.Get([]byte(key))
+			require.NoError(t, err)
+			require.Equal(t, spilled, item.meta&bitValuePointer > 0, "key: %s", key)
+			if key != "empty" {
+				require.Equal(t, val, getItemValue(t, item))
+			}
+		}
+		return nil
+	}))
+}
+
+func TestBlobDir(t *testing.T) {
+	dir, err := os.MkdirTemp("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	blobDir, err := os.MkdirTemp("", "badger-blob")
+	require.NoError(t, err)
+	defer removeDir(blobDir)
+
+	opt := getTestOptions(dir)
+	opt.ValueThreshold = 32
+	opt.BlobDir = blobDir
+	opt.BlobThreshold = 1 << 10
+
+	small := bytes.Repeat([]byte("s"), 100)
+	large := bytes.Repeat([]byte("l"), 4<<10)
+
+	check := func(db *DB) {
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for i := 0; i < 100; i++ {
+				item, err := txn.Get([]byte(fmt.Sprintf("small%d", i)))
+				require.NoError(t, err)
+				require.Equal(t, small, getItemValue(t, item))
+				item, err = txn.Get([]byte(fmt.Sprintf("large%d", i)))
+				require.NoError(t, err)
+				require.Equal(t, large, getItemValue(t, item))
+			}
+			return nil
+		}))
+	}
+
+	db, err := Open(opt)
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			if err := txn.Set([]byte(fmt.Sprintf("small%d", i)), small); err != nil {
+				return err
+			}
+			return txn.Set([]byte(fmt.Sprintf("large%d", i)), large)
+		}))
+	}
+	check(db)
+	require.NoError(t, db.Close())
+
+	blobSize := func(d string) int64 {
+		var sz int64
+		entries, err := os.ReadDir(d)
+		require.NoError(t, err)
+		for _, e := range entries {
+			if filepath.Ext(e.Name()) == ".vlog" {
+				info, err := e.Info()
+				require.NoError(t, err)
+				sz += info.Size()
+			}
+		}
+		return sz
+	}
+	// All large values live in the blob directory.
+	require.Greater(t, blobSize(blobDir), int64(100*len(large)))
+	require.Less(t, blobSize(dir), int64(100*len(large)))
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+	check(db)
+
+	opt.BlobDir = dir
+	_, err = Open(opt)
+	require.Error(t, err)
+}
+
+func TestMaxValueLogSize(t *testing.T) {
+	dir, err := os.MkdirTemp("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opt := getTestOptions(dir)
+	opt.ValueLogFileSize = 1 << 20
+	opt.ValueThreshold = 1 << 10
+	opt = opt.WithMaxValueLogSize(3 << 20)
+
+	db, err := Open(opt)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+
+	set := func(k string, sz int) error {
+		return db.Update(func(txn *Txn) error {
+			return txn.Set([]byte(k), make([]byte, sz))
+		})
+	}
+	n := 0
+	for ; n < 100; n++ {
+		if err = set(fmt.Sprintf("key%d", n), 128<<10); err != nil {
+			break
+		}
+	}
+	require.ErrorIs(t, err, ErrValueLogFull)
+	require.GreaterOrEqual(t, db.valueLogSize(), opt.MaxValueLogSize)
+
+	// Values which stay in the LSM tree are still accepted.
+	require.NoError(t, set("small", 100))
+
+	// Once the values are deleted and compacted away, GC can rewrite their files and values are
+	// accepted again.
+	for i := 0; i < n; i++ {
+		txnDelete(t, db, []byte(fmt.Sprintf("key%d", i)))
+	}
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	require.NoError(t, db.lc.doCompact(-1, compactionPriority{level: 0, t: db.lc.levelTargets()}))
+	require.ErrorIs(t, set("big", 128<<10), ErrValueLogFull)
+	require.Eventually(t, func() bool { return set("big", 128<<10) == nil }, 10*time.Second,
+		10*time.Millisecond)
+	require.Less(t, db.valueLogSize(), opt.MaxValueLogSize)
+}
+
+func TestAutoValueLogGC(t *testing.T) {
+	dir, err := os.MkdirTemp("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opt := getTestOptions(dir)
+	opt.ValueLogFileSize = 1 << 20
+	opt.ValueThreshold = 1 << 10
+
+	_, err = Open(opt.WithAutoValueLogGC(1.5, time.Second))
+	require.Error(t, err)
+
+	db, err := Open(opt)
+	require.NoError(t, err)
+	for i := 0; i < 40; i++ {
+		txnSet(t, db, []byte(key("key", i)), make([]byte, 64<<10), 0)
+	}
+	for i := 0; i < 40; i++ {
+		txnDelete(t, db, []byte(key("key", i)))
+	}
+	require.NoError(t, db.Close())
+
+	db, err = Open(opt.WithAutoValueLogGC(0.5, 10*time.Millisecond))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+	fid := db.vlog.sortedFids()[0]
+	// The compaction drops the deleted values, which fills up the discard stats.
+	require.NoError(t, db.lc.doCompact(-1, compactionPriority{level: 0, t: db.lc.levelTargets()}))
+	require.Eventually(t, func() bool {
+		db.vlog.filesLock.RLock()
+		defer db.vlog.filesLock.RUnlock()
+		_, ok := db.vlog.filesMap[fid]
+		return !ok
+	}, 10*time.Second, 10*time.Millisecond)
+}
+
+func TestRebuildValueLogFile(t *testing.T) {
+	opt := getTestOptions("")
+	opt.ValueLogFileSize = 1 << 20
+	opt.ValueThreshold = 1 << 10
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		val := func(prefix string) []byte {
+			return append([]byte(prefix), make([]byte, 64<<10)...)
+		}
+		// Each version of "multi" gets its own value from the source.
+		txnSet(t, db, []byte("multi"), val("old"), 0)
+		oldTxn := db.NewTransaction(false)
+		defer oldTxn.Discard()
+		txnSet(t, db, []byte("multi"), val("old"), 0)
+		for i := 0; i < 40; i++ {
+			txnSet(t, db, []byte(key("key", i)), val("old"), 0)
+		}
+		versionVal := func(version uint64) []byte {
+			return val(fmt.Sprintf("new%03d", version))
+		}
+		fids := db.vlog.sortedFids()
+		require.Greater(t, len(fids), 2)
+		fid := fids[0]
+		// The file being written to can't be rebuilt.
+		require.Error(t, db.RebuildValueLogFile(fids[len(fids)-1], nil))
+
+		// The file is kept if the source lacks a value.
+		var keys []string
+		source := func(k []byte, version uint64) ([]byte, bool) {
+			keys = append(keys, string(k))
+			return versionVal(version), string(k) != key("key", 1)
+		}
+		require.Error(t, db.RebuildValueLogFile(fid, source))
+		require.Contains(t, db.vlog.sortedFids(), fid)
+		require.Contains(t, keys, key("key", 1))
+
+		source = func(_ []byte, version uint64) ([]byte, bool) {
+			return versionVal(version), true
+		}
+		require.NoError(t, db.RebuildValueLogFile(fid, source))
+		require.NotContains(t, db.vlog.sortedFids(), fid)
+
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for _, k := range keys {
+				item, err := txn.Get([]byte(k))
+				require.NoError(t, err)
+				require.Equal(t, versionVal(item.Version()), getItemValue(t, item))
+			}
+			// The values in the other files are left alone.
+			item, err := txn.Get([]byte(key("key", 39)))
+			require.NoError(t, err)
+			require.Equal(t, "old", string(getItemValue(t, item)[:3]))
+			return nil
+		}))
+		item, err := oldTxn.Get([]byte("multi"))
+		require.NoError(t, err)
+		require.Equal(t, uint64(1), item.Version())
+		require.Equal(t, versionVal(1), getItemValue(t, item))
+	})
+}
+
+func TestValueGCConflictHook(t *testing.T) {
+	dir, err := os.MkdirTemp("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	var mu sync.Mutex
+	reported := make(map[string]int)
+	opt := getTestOptions(dir)
+	opt.ValueLogFileSize = 1 << 20
+	opt.BaseTableSize = 1 << 15
+	opt.ValueThreshold = 1 << 10
+	opt.ValueLogGCConflictHook = func(key []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		reported[string(key)]++
+	}
+	db, err := OpenManaged(opt)
+	require.NoError(t, err)
+
+	set := func(i int) {
+		txn := db.NewTransactionAt(10, true)
+		require.NoError(t, txn.Set([]byte(fmt.Sprintf("key%d", i)), make([]byte, 32<<10)))
+		require.NoError(t, txn.CommitAt(5, nil))
+	}
+	for i := 0; i < 100; i++ {
+		set(i)
+	}
+	// Writing the even keys again at the same version moves their values to newer files.
+	for i := 0; i < 100; i += 2 {
+		set(i)
+	}
+
+	db.vlog.filesLock.RLock()
+	lf := db.vlog.filesMap[db.vlog.sortedFids()[0]]
+	db.vlog.filesLock.RUnlock()
+	require.NoError(t, db.vlog.rewrite(lf))
+	require.NoError(t, db.Close())
+
+	require.NotEmpty(t, reported)
+	for k, n := range reported {
+		var i int
+		_, err := fmt.Sscanf(k, "key%d", &i)
+		require.NoError(t, err)
+		require.Zero(t, i%2, "key %s didn't move", k)
+		require.Equal(t, 1, n)
+	}
+}
+
+func TestRefreshTTL(t *testing.T) {
+	dir, err := os.MkdirTemp("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	opt := getTestOptions(dir)
+	opt.ValueLogFileSize = 1 << 20
+	opt.BaseTableSize = 1 << 15
+	opt.ValueThreshold = 1 << 10
+	db, err := Open(opt)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+
+	var keys [][]byte
+	wb := db.NewWriteBatch()
+	for i := 0; i < 100; i++ {
+		k := []byte(fmt.Sprintf("key%03d", i))
+		keys = append(keys, k)
+		require.NoError(t, wb.SetEntry(NewEntry(k, bytes.Repeat(k, 4<<10)).WithTTL(2*time.Second)))
+	}
+	require.NoError(t, wb.SetEntry(NewEntry([]byte("small"), []byte("value")).WithTTL(2*time.Second)))
+	require.NoError(t, wb.Flush())
+	keys = append(keys, []byte("small"), []byte("missing"))
+
+	pointers := func() map[string]string {
+		res := make(map[string]string)
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for _, k := range keys[:100] {
+				item, err := txn.Get(k)
+				require.NoError(t, err)
+				res[string(k)] = string(item.vptr)
+			}
+			return nil
+		}))
+		return res
+	}
+	before := pointers()
+	require.Error(t, db.RefreshTTL(keys, -time.Second))
+	require.NoError(t, db.RefreshTTL(keys, time.Hour))
+	// The refreshed keys point at the values already in the value log.
+	require.Equal(t, before, pointers())
+
+	check := func() {
+		require.NoError(t, db.View(func(txn *Txn) error {
+			expiresAt := uint64(time.Now().Add(time.Hour).Unix())
+			for _, k := range keys[:100] {
+				item, err := txn.Get(k)
+				require.NoError(t, err)
+				require.InDelta(t, expiresAt, item.ExpiresAt(), 5)
+				require.Equal(t, bytes.Repeat(k, 4<<10), getItemValue(t, item))
+			}
+			item, err := txn.Get([]byte("small"))
+			require.NoError(t, err)
+			require.Equal(t, []byte("value"), getItemValue(t, item))
+			_, err = txn.Get([]byte("missing"))
+			require.Equal(t, ErrKeyNotFound, err)
+			return nil
+		}))
+	}
+	check()
+
+	// Once the original versions expire, GC must keep the values of the refreshed versions.
+	time.Sleep(2 * time.Second)
+	db.vlog.filesLock.RLock()
+	lf := db.vlog.filesMap[db.vlog.sortedFids()[0]]
+	db.vlog.filesLock.RUnlock()
+	require.NoError(t, db.vlog.rewrite(lf))
+	check()
+}
+
+func TestRefreshTTLVersionsGC(t *testing.T) {
+	opt := getTestOptions("")
+	opt.ValueLogFileSize = 1 << 20
+	opt.ValueThreshold = 1 << 10
+	opt.NumVersionsToKeep = math.MaxInt32
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		k, val := []byte("key"), bytes.Repeat([]byte("v"), 4<<10)
+		txnSet(t, db, k, val, 0)
+		require.NoError(t, db.RefreshTTL([][]byte{k}, time.Hour))
+		// A snapshot reading the first refreshed version.
+		snap := db.NewTransaction(false)
+		defer snap.Discard()
+		require.NoError(t, db.RefreshTTL([][]byte{k}, 2*time.Hour))
+		txnSet(t, db, k, []byte("new"), 0)
+		// Fill the value log file, so that it's no longer the one written to.
+		for i := 0; i < 300; i++ {
+			txnSet(t, db, []byte(key("fill", i)), val, 0)
+		}
+
+		db.vlog.filesLock.RLock()
+		lf := db.vlog.filesMap[db.vlog.sortedFids()[0]]
+		db.vlog.filesLock.RUnlock()
+		require.NoError(t, db.vlog.rewrite(lf))
+
+		item, err := snap.Get(k)
+		require.NoError(t, err)
+		require.Equal(t, val, getItemValue(t, item))
+
+		// All the versions sharing the value still share it, in a single copy.
+		var vptrs [][]byte
+		require.NoError(t, db.View(func(txn *Txn) error {
+			iopt := DefaultIteratorOptions
+			iopt.AllVersions = true
+			it := txn.NewKeyIterator(k, iopt)
+			defer it.Close()
+			for it.Rewind(); it.Valid(); it.Next() {
+				item := it.Item()
+				if item.meta&bitValuePointer == 0 {
+					continue
+				}
+				var vp valuePointer
+				vp.Decode(item.vptr)
+				require.NotEqual(t, lf.fid, vp.Fid)
+				require.Equal(t, val, getItemValue(t, item))
+				vptrs = append(vptrs, y.Copy(item.vptr))
+			}
+			return nil
+		}))
+		require.Len(t, vptrs, 3)
+		require.Equal(t, vptrs[0], vptrs[1])
+		require.Equal(t, vptrs[0], vptrs[2])
+	})
+}
+
+func TestKeysInVlogFile(t *testing.T) {
+	opt := getTestOptions("")
+	opt.ValueLogFileSize = 1 << 20
+	opt.ValueThreshold = 1 << 10
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		val := make([]byte, 32<<10)
+		for i := 0; i < 100; i++ {
+			require.NoError(t, db.Update(func(txn *Txn) error {
+				return txn.Set([]byte(key("key", i)), val)
+			}))
+		}
+		// Overwrite some keys, so that the first file only has a part of its keys left.
+		for i := 0; i < 10; i++ {
+			require.NoError(t, db.Update(func(txn *Txn) error {
+				return txn.Set([]byte(key("key", i)), val)
+			}))
+		}
+
+		db.vlog.filesLock.RLock()
+		fids := db.vlog.sortedFids()
+		db.vlog.filesLock.RUnlock()
+		require.Greater(t, len(fids), 2)
+
+		seen := make(map[string]uint32)
+		for _, fid := range fids {
+			var last string
+			require.NoError(t, db.KeysInVlogFile(fid, func(item *Item) error {
+				k := string(item.Key())
+				require.Less(t, last, k)
+				last = k
+				_, dup := seen[k]
+				require.False(t, dup, "key %s found in files %d and %d", k, seen[k], fid)
+				seen[k] = fid
+				return nil
+			}))
+		}
+		require.Len(t, seen, 100)
+		for i := 0; i < 10; i++ {
+			require.NotEqual(t, fids[0], seen[key("key", i)])
+		}
+
+		errStop := errors.New("stop")
+		var n int
+		require.Equal(t, errStop, db.KeysInVlogFile(fids[0], func(item *Item) error {
+			n++
+			return errStop
+		}))
+		require.Equal(t, 1, n)
+	})
+}
+
+func TestValueLogMaxAge(t *testing.T) {
+	opt := getTestOptions("").WithValueThreshold(32).WithValueLogMaxAge(50 * time.Millisecond)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		numFiles := func() int {
+			db.vlog.filesLock.RLock()
+			defer db.vlog.filesLock.RUnlock()
+			return len(db.vlog.filesMap)
+		}
+		set := func(k string) {
+			require.NoError(t, db.Update(func(txn *Txn) error {
+				return txn.Set([]byte(k), make([]byte, 100))
+			}))
+		}
+
+		// The age of the active file counts from its first write.
+		n := numFiles()
+		time.Sleep(60 * time.Millisecond)
+		set("a")
+		set("b")
+		require.Equal(t, n, numFiles())
+
+		time.Sleep(60 * time.Millisecond)
+		set("c")
+		require.Equal(t, n+1, numFiles())
+		set("d")
+		require.Equal(t, n+1, numFiles())
+
+		for _, k := range []string{"a", "b", "c", "d"} {
+			require.NoError(t, db.View(func(txn *Txn) error {
+				item, err := txn.Get([]byte(k))
+				require.NoError(t, err)
+				require.Len(t, getItemValue(t, item), 100)
+				return nil
+			}))
+		}
+	})
+	_, err := Open(DefaultOptions(t.TempDir()).WithValueLogMaxAge(-time.Second))
+	require.Error(t, err)
+}
+
+func TestVerifyValueLogPointers(t *testing.T) {
+	opt := getTestOptions("")
+	opt.ValueThreshold = 32
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		val := make([]byte, 100)
+		for i := 0; i < 100; i++ {
+			require.NoError(t, db.Update(func(txn *Txn) error {
+				require.NoError(t, txn.Set([]byte(key("small", i)), []byte("v")))
+				return txn.Set([]byte(key("key", i)), val)
+			}))
+		}
+		// A refreshed key points at the entry of an earlier version, which is fine.
+		require.NoError(t, db.RefreshTTL([][]byte{[]byte(key("key", 1))}, time.Hour))
+
+		mismatches, err := db.VerifyValueLogPointers()
+		require.NoError(t, err)
+		require.Empty(t, mismatches)
+
+		// Point key 0 at the entry of key 2.
+		var vptr []byte
+		require.NoError(t, db.View(func(txn *Txn) error {
+			item, err := txn.Get([]byte(key("key", 2)))
+			require.NoError(t, err)
+			vptr = item.vptr
+			return nil
+		}))
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.modify(&Entry{Key: []byte(key("key", 0)), vptr: vptr})
+		}))
+
+		mismatches, err = db.VerifyValueLogPointers()
+		require.NoError(t, err)
+		require.Len(t, mismatches, 1)
+		m := mismatches[0]
+		require.Equal(t, []byte(key("key", 0)), m.Key)
+		require.Equal(t, []byte(key("key", 2)), y.ParseKey(m.Found))
+		require.NoError(t, m.Err)
+	})
+}
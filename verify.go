@@ -0,0 +1,160 @@
+/*
+ * Copyright 2024 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"hash/crc32"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/0xEggTart/badger/y"
+)
+
+// numVerifyWorkers is the number of goroutines reading the value log entries in
+// VerifyValueLogPointers.
+const numVerifyWorkers = 8
+
+// PointerMismatch describes a key whose value pointer in the LSM tree doesn't lead to an entry of
+// the key in the value log.
+type PointerMismatch struct {
+	Key     []byte // The key in the LSM tree.
+	Version uint64 // The version of the key.
+	Fid     uint32 // The value log file the pointer points into.
+	Offset  uint32 // The offset of the entry in the file.
+	Len     uint32 // The length of the entry.
+
+	// Found is the key of the entry stored at the pointer, as written to the value log, that is
+	// with the version appended and before Options.KeyReadTransform. It is nil if the entry couldn't
+	// be read, in which case Err holds the reason.
+	Found []byte
+	Err   error
+}
+
+// VerifyValueLogPointers checks that the value pointer of every key whose value lives in the value
+// log leads to an entry for the same key. The versions may differ, as a version written by
+// Txn.RefreshTTL points at the entry of an earlier version. A mismatch, which could be left behind
+// by a buggy value log GC, would otherwise only surface once the key is read. The latest version of
+// each key visible to a read-only transaction is checked. The value log entries are read by a
+// number of goroutines in parallel, and their checksums are verified as well.
+//
+// It returns the mismatches found, sorted by key. A non-nil error means that the check couldn't be
+// completed, not that mismatches were found.
+func (db *DB) VerifyValueLogPointers() ([]PointerMismatch, error) {
+	if db.IsClosed() {
+		return nil, ErrDBClosed
+	}
+	type pointer struct {
+		key     []byte // The key as returned by Item.Key.
+		raw     []byte // The key as stored.
+		version uint64
+		vp      valuePointer
+	}
+
+	var mismatches []PointerMismatch
+	var mu sync.Mutex
+	verify := func(ch <-chan pointer, wg *sync.WaitGroup) {
+		defer wg.Done()
+		for p := range ch {
+			found, err := db.valueLogFor(p.vp).readKey(p.vp)
+			if err == nil && bytes.Equal(y.ParseKey(found), p.raw) {
+				continue
+			}
+			mu.Lock()
+			mismatches = append(mismatches, PointerMismatch{
+				Key:     p.key,
+				Version: p.version,
+				Fid:     p.vp.Fid,
+				Offset:  p.vp.Offset,
+				Len:     p.vp.Len,
+				Found:   found,
+				Err:     err,
+			})
+			mu.Unlock()
+		}
+	}
+
+	// The entries must be read while the iterator is open, as it keeps the value log files from
+	// being deleted.
+	err := db.View(func(txn *Txn) error {
+		opt := DefaultIteratorOptions
+		opt.PrefetchValues = false
+		opt.internal = true
+		it := txn.NewIterator(opt)
+		defer it.Close()
+
+		ch := make(chan pointer, 1000)
+		var wg sync.WaitGroup
+		for i := 0; i < numVerifyWorkers; i++ {
+			wg.Add(1)
+			go verify(ch, &wg)
+		}
+		defer wg.Wait()
+		defer close(ch)
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			if item.meta&bitValuePointer == 0 {
+				continue
+			}
+			p := pointer{
+				key:     item.KeyCopy(nil),
+				raw:     y.SafeCopy(nil, item.key),
+				version: item.Version(),
+			}
+			p.vp.Decode(item.vptr)
+			ch <- p
+		}
+		return nil
+	})
+	sort.Slice(mismatches, func(i, j int) bool {
+		return bytes.Compare(mismatches[i].Key, mismatches[j].Key) < 0
+	})
+	return mismatches, err
+}
+
+// readKey returns the key, including its version, of the entry at vp. The checksum of the entry is
+// verified.
+func (vlog *valueLog) readKey(vp valuePointer) ([]byte, error) {
+	buf, lf, err := vlog.readValueBytes(vp)
+	if lf != nil {
+		defer lf.lock.RUnlock()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < crc32.Size+2 {
+		return nil, errors.Errorf("Invalid read: Len: %d for vp: %+v", len(buf), vp)
+	}
+	data := buf[:len(buf)-crc32.Size]
+	if crc32.Checksum(data, y.CastagnoliCrcTable) != y.BytesToU32(buf[len(data):]) {
+		return nil, y.Wrapf(y.ErrChecksumMismatch, "value corrupted for vp: %+v", vp)
+	}
+	var h header
+	kv := data[h.Decode(data):]
+	if lf.encryptionEnabled() {
+		if kv, err = lf.decryptKV(kv, vp.Offset); err != nil {
+			return nil, err
+		}
+	}
+	if uint32(len(kv)) < h.klen {
+		return nil, errors.Errorf("Invalid read: Len: %d read at:[0:%d]", len(kv), h.klen)
+	}
+	return y.Copy(kv[:h.klen]), nil
+}
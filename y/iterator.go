@@ -24,14 +24,23 @@ import (
 // ValueStruct represents the value info that can be associated with a key, but also the internal
 // Meta field.
 type ValueStruct struct {
-	Meta      byte
-	UserMeta  byte
-	ExpiresAt uint64
-	Value     []byte
+	Meta         byte
+	UserMeta     byte
+	UserMetaHigh byte // The high byte of a 16 bit user meta. See BitUserMetaHigh.
+	ExpiresAt    uint64
+	Value        []byte
 
 	Version uint64 // This field is not serialized. Only for internal usage.
 }
 
+// BitUserMetaHigh is set in the encoded meta byte of a ValueStruct, or of a value log entry, with a
+// non-zero UserMetaHigh, which is then encoded right after UserMeta. Values without it are encoded
+// as they always were.
+//
+// The bit is reserved in every DB, whether or not it allows 16 bit user metas, so it can't be used
+// by the Meta of a ValueStruct. Encode and EncodeTo panic on a Meta with it set.
+const BitUserMetaHigh byte = 1 << 5
+
 func sizeVarint(x uint64) (n int) {
 	for {
 		n++
@@ -46,34 +55,61 @@ func sizeVarint(x uint64) (n int) {
 // EncodedSize is the size of the ValueStruct when encoded
 func (v *ValueStruct) EncodedSize() uint32 {
 	sz := len(v.Value) + 2 // meta, usermeta.
+	if v.UserMetaHigh != 0 {
+		sz++
+	}
 	enc := sizeVarint(v.ExpiresAt)
 	return uint32(sz + enc)
 }
 
 // Decode uses the length of the slice to infer the length of the Value field.
 func (v *ValueStruct) Decode(b []byte) {
-	v.Meta = b[0]
+	v.Meta = b[0] &^ BitUserMetaHigh
 	v.UserMeta = b[1]
+	v.UserMetaHigh = 0
+	n := 2
+	if b[0]&BitUserMetaHigh > 0 {
+		v.UserMetaHigh = b[2]
+		n++
+	}
 	var sz int
-	v.ExpiresAt, sz = binary.Uvarint(b[2:])
-	v.Value = b[2+sz:]
+	v.ExpiresAt, sz = binary.Uvarint(b[n:])
+	v.Value = b[n+sz:]
 }
 
 // Encode expects a slice of length at least v.EncodedSize().
 func (v *ValueStruct) Encode(b []byte) uint32 {
+	if v.Meta&BitUserMetaHigh != 0 {
+		panic("Meta has the reserved BitUserMetaHigh set")
+	}
 	b[0] = v.Meta
 	b[1] = v.UserMeta
-	sz := binary.PutUvarint(b[2:], v.ExpiresAt)
-	n := copy(b[2+sz:], v.Value)
-	return uint32(2 + sz + n)
+	n := 2
+	if v.UserMetaHigh != 0 {
+		b[0] |= BitUserMetaHigh
+		b[2] = v.UserMetaHigh
+		n++
+	}
+	n += binary.PutUvarint(b[n:], v.ExpiresAt)
+	n += copy(b[n:], v.Value)
+	return uint32(n)
 }
 
 // EncodeTo should be kept in sync with the Encode function above. The reason
 // this function exists is to avoid creating byte arrays per key-value pair in
 // table/builder.go.
 func (v *ValueStruct) EncodeTo(buf *bytes.Buffer) {
-	buf.WriteByte(v.Meta)
-	buf.WriteByte(v.UserMeta)
+	if v.Meta&BitUserMetaHigh != 0 {
+		panic("Meta has the reserved BitUserMetaHigh set")
+	}
+	if v.UserMetaHigh != 0 {
+		buf.WriteByte(v.Meta | BitUserMetaHigh)
+		buf.WriteByte(v.UserMeta)
+		buf.WriteByte(v.UserMetaHigh)
+	} else {
+		buf.WriteByte(v.Meta)
+		buf.WriteByte(v.UserMeta)
+	}
 	var enc [binary.MaxVarintLen64]byte
 	sz := binary.PutUvarint(enc[:], v.ExpiresAt)
 
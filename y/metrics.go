@@ -31,6 +31,10 @@ var (
 	vlogSize *expvar.Map
 	// pendingWrites tracks the number of pending writes.
 	pendingWrites *expvar.Map
+	// oracleMemory has the memory used to detect conflicts between transactions.
+	oracleMemory *expvar.Map
+	// pendingFlushes has the number of memtables waiting to be flushed.
+	pendingFlushes *expvar.Map
 
 	// These are cumulative
 
@@ -103,6 +107,8 @@ func init() {
 	vlogSize = expvar.NewMap(BADGER_METRIC_PREFIX + "size_bytes_vlog")
 
 	pendingWrites = expvar.NewMap(BADGER_METRIC_PREFIX + "write_pending_num_memtable")
+	oracleMemory = expvar.NewMap(BADGER_METRIC_PREFIX + "size_bytes_oracle")
+	pendingFlushes = expvar.NewMap(BADGER_METRIC_PREFIX + "flush_pending_num_memtable")
 	numCompactionTables = expvar.NewInt(BADGER_METRIC_PREFIX + "compaction_current_num_lsm")
 }
 
@@ -174,6 +180,23 @@ func PendingWritesSet(enabled bool, key string, val expvar.Var) {
 	storeToMap(enabled, pendingWrites, key, val)
 }
 
+func OracleMemorySet(enabled bool, key string, val expvar.Var) {
+	storeToMap(enabled, oracleMemory, key, val)
+}
+
+func PendingFlushesSet(enabled bool, key string, val expvar.Var) {
+	storeToMap(enabled, pendingFlushes, key, val)
+}
+
+func PendingFlushesGet(enabled bool, key string) expvar.Var {
+	return getFromMap(enabled, pendingFlushes, key)
+}
+
+// PendingFlushesDelete removes the value of key, if it's still val.
+func PendingFlushesDelete(enabled bool, key string, val expvar.Var) {
+	deleteFromMap(enabled, pendingFlushes, key, val)
+}
+
 func NumLSMBloomHitsAdd(enabled bool, key string, val int64) {
 	addToMap(enabled, numLSMBloomHits, key, val)
 }
@@ -182,6 +205,10 @@ func NumLSMGetsAdd(enabled bool, key string, val int64) {
 	addToMap(enabled, numLSMGets, key, val)
 }
 
+func OracleMemoryGet(enabled bool, key string) expvar.Var {
+	return getFromMap(enabled, oracleMemory, key)
+}
+
 func LSMSizeGet(enabled bool, key string) expvar.Var {
 	return getFromMap(enabled, lsmSize, key)
 }
@@ -214,6 +241,16 @@ func storeToMap(enabled bool, metric *expvar.Map, key string, val expvar.Var) {
 	metric.Set(key, val)
 }
 
+func deleteFromMap(enabled bool, metric *expvar.Map, key string, val expvar.Var) {
+	if !enabled {
+		return
+	}
+
+	if metric.Get(key) == val {
+		metric.Delete(key)
+	}
+}
+
 func getFromMap(enabled bool, metric *expvar.Map, key string) expvar.Var {
 	if !enabled {
 		return nil
@@ -221,3 +258,62 @@ func getFromMap(enabled bool, metric *expvar.Map, key string) expvar.Var {
 
 	return metric.Get(key)
 }
+
+// Metric describes one of the metrics above, so that they can be exported in other formats.
+type Metric struct {
+	Name string // Name of the expvar.
+	Help string
+	// Gauge is set for metrics which can go down. The others are cumulative counters.
+	Gauge bool
+	// Label is the name for the keys of the metric, if it's an *expvar.Map.
+	Label string
+	Var   expvar.Var
+}
+
+// Metrics returns all the metrics above, sorted by name.
+func Metrics() []Metric {
+	return []Metric{
+		{Name: "badger_compaction_current_num_lsm", Gauge: true, Var: numCompactionTables,
+			Help: "Number of tables being compacted."},
+		{Name: "badger_flush_pending_num_memtable", Gauge: true, Label: "dir", Var: pendingFlushes,
+			Help: "Number of memtables waiting to be flushed to level 0."},
+		{Name: "badger_get_num_lsm", Label: "level", Var: numLSMGets,
+			Help: "Number of lookups in the tables of each LSM level."},
+		{Name: "badger_get_num_memtable", Var: numMemtableGets,
+			Help: "Number of lookups in the memtables."},
+		{Name: "badger_get_num_user", Var: numGets,
+			Help: "Number of gets requested by users."},
+		{Name: "badger_get_with_result_num_user", Var: numGetsWithResults,
+			Help: "Number of user gets which found a result."},
+		{Name: "badger_hit_num_lsm_bloom_filter", Label: "level", Var: numLSMBloomHits,
+			Help: "Number of table lookups avoided by bloom filters at each LSM level."},
+		{Name: "badger_iterator_num_user", Var: numIteratorsCreated,
+			Help: "Number of iterators created by users."},
+		{Name: "badger_put_num_user", Var: numPuts,
+			Help: "Number of entries written by users."},
+		{Name: "badger_read_bytes_lsm", Var: numBytesReadLSM,
+			Help: "Bytes of values read from the LSM tree."},
+		{Name: "badger_read_bytes_vlog", Var: numBytesReadVlog,
+			Help: "Bytes read from the value log."},
+		{Name: "badger_read_num_vlog", Var: numReadsVlog,
+			Help: "Number of reads from the value log."},
+		{Name: "badger_size_bytes_lsm", Gauge: true, Label: "dir", Var: lsmSize,
+			Help: "Size of the LSM tree in bytes."},
+		{Name: "badger_size_bytes_oracle", Gauge: true, Label: "dir", Var: oracleMemory,
+			Help: "Estimated memory used to detect conflicts between transactions, in bytes."},
+		{Name: "badger_size_bytes_vlog", Gauge: true, Label: "dir", Var: vlogSize,
+			Help: "Size of the value log in bytes."},
+		{Name: "badger_write_bytes_compaction", Label: "level", Var: numBytesCompactionWritten,
+			Help: "Bytes written by compactions into each LSM level."},
+		{Name: "badger_write_bytes_l0", Var: numBytesWrittenToL0,
+			Help: "Bytes written to level 0 of the LSM tree."},
+		{Name: "badger_write_bytes_user", Var: numBytesWrittenUser,
+			Help: "Bytes written by users."},
+		{Name: "badger_write_bytes_vlog", Var: numBytesVlogWritten,
+			Help: "Bytes written to the value log."},
+		{Name: "badger_write_num_vlog", Var: numWritesVlog,
+			Help: "Number of writes to the value log."},
+		{Name: "badger_write_pending_num_memtable", Gauge: true, Label: "dir", Var: pendingWrites,
+			Help: "Number of writes waiting to be applied to the memtable."},
+	}
+}
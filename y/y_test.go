@@ -3,9 +3,11 @@ package y
 import (
 	"bytes"
 	"encoding/binary"
+	"expvar"
 	"fmt"
 	"io"
 	"math/rand"
+	"strings"
 	"testing"
 	"time"
 
@@ -298,6 +300,26 @@ func TestEncodedSize(t *testing.T) {
 	require.Equal(t, valBufSize+uint32(2)+expVarintSize, valStruct.EncodedSize())
 }
 
+func TestUserMetaHighBit(t *testing.T) {
+	v := ValueStruct{Meta: 0x1b, UserMeta: 0x01, UserMetaHigh: 0xab, ExpiresAt: 7, Value: []byte("v")}
+	b := make([]byte, v.EncodedSize())
+	require.Equal(t, v.EncodedSize(), v.Encode(b))
+	require.Equal(t, 0x1b|BitUserMetaHigh, b[0])
+
+	var buf bytes.Buffer
+	v.EncodeTo(&buf)
+	require.Equal(t, b, buf.Bytes())
+
+	var got ValueStruct
+	got.Decode(b)
+	require.Equal(t, v, got)
+
+	// The bit is reserved, so a Meta which has it set can't be encoded.
+	v.Meta |= BitUserMetaHigh
+	require.Panics(t, func() { v.Encode(b) })
+	require.Panics(t, func() { v.EncodeTo(&buf) })
+}
+
 func TestAllocatorReuse(t *testing.T) {
 	a := z.NewAllocator(1024, "test")
 	defer a.Release()
@@ -323,3 +345,16 @@ func TestAllocatorReuse(t *testing.T) {
 	}
 	t.Logf("Allocator: %s\n", a)
 }
+
+func TestMetricsListsAllExpvars(t *testing.T) {
+	listed := make(map[string]bool)
+	for _, m := range Metrics() {
+		require.Same(t, expvar.Get(m.Name), m.Var, m.Name)
+		listed[m.Name] = true
+	}
+	expvar.Do(func(kv expvar.KeyValue) {
+		if strings.HasPrefix(kv.Key, BADGER_METRIC_PREFIX) {
+			require.True(t, listed[kv.Key], "metric %s is not listed by Metrics", kv.Key)
+		}
+	})
+}